@@ -0,0 +1,102 @@
+package authztraefikgateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AdminConfig enables the internal admin endpoint served by the middleware itself
+type AdminConfig struct {
+	Path  string `json:"path,omitempty"`  // e.g. "/.authz"; requests below it are never forwarded
+	Token string `json:"token,omitempty"` // bearer token required to call the endpoint
+	// SigningKey signs compliance snapshots (HMAC-SHA256); snapshots are unavailable without it
+	SigningKey string `json:"signingKey,omitempty"`
+}
+
+// isAdminRequest reports whether the request targets the admin endpoint
+func (am *AuthMiddleware) isAdminRequest(req *http.Request) bool {
+	return am.admin.Path != "" && (req.URL.Path == am.admin.Path || strings.HasPrefix(req.URL.Path, am.admin.Path+"/"))
+}
+
+// serveAdmin handles the admin endpoint:
+//
+//	POST <path>/invalidate?subject=<subject fingerprint>
+//	GET  <path>/cache (dump of the decision cache, usable as cache.seedFile)
+//	GET  <path>/cache/efficiency (hit ratio, entry age at hit, evictions and coalescing waits)
+//	POST <path>/resources/invalidate (drop cached Protection API resource metadata)
+//	GET  <path>/snapshot (signed compliance snapshot)
+//	GET  <path>/metrics (Prometheus text format)
+//	GET  <path>/version (plugin version and config hash)
+//	GET  <path>/latency (authorization latency percentiles per resource)
+//	GET  <path>/diagnostics/{cache,denials,events,rules}?limit=<n>&cursor=<cursor> (paginated listings)
+func (am *AuthMiddleware) serveAdmin(w http.ResponseWriter, req *http.Request) {
+	presented, ok := BearerTokenExtractor{}.Extract(req)
+	if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(am.admin.Token)) != 1 {
+		am.log(logError, "❌ [ADMIN] Rejected admin request to", req.URL.Path)
+		writeStatus(w, http.StatusUnauthorized)
+		return
+	}
+
+	switch strings.TrimPrefix(req.URL.Path, am.admin.Path) {
+	case "/invalidate":
+		if req.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		subject := req.FormValue("subject")
+		if subject == "" {
+			http.Error(w, "missing subject", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"subject": subject, "invalidated": am.InvalidateSubject(subject)})
+	case "/cache":
+		if req.Method != http.MethodGet {
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, am.DumpCache())
+	case "/cache/efficiency":
+		writeJSON(w, am.CacheEfficiency())
+	case "/resources/invalidate":
+		if req.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"invalidated": am.InvalidateResources()})
+	case "/snapshot":
+		if req.Method != http.MethodGet {
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		signed, err := am.SignedSnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, signed)
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := am.WriteMetrics(w); err != nil {
+			fmt.Println("⚠️  [ADMIN] Could not write metrics:", err)
+		}
+	case "/version":
+		writeJSON(w, am.BuildInfo())
+	case "/latency":
+		writeJSON(w, am.LatencyStats())
+	case "/diagnostics/cache", "/diagnostics/denials", "/diagnostics/events", "/diagnostics/rules":
+		am.serveDiagnostics(w, req, strings.TrimPrefix(req.URL.Path, am.admin.Path+"/diagnostics/"))
+	default:
+		writeStatus(w, http.StatusNotFound)
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("⚠️  [ADMIN] Could not encode response:", err)
+	}
+}
@@ -0,0 +1,70 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminInvalidate(t *testing.T) {
+	nextCalled := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { nextCalled = true })
+	config := &Config{
+		Cache: CacheConfig{Enabled: true},
+		Admin: AdminConfig{Path: "/.authz/", Token: "admin-secret"},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+	subject := SubjectFingerprint("alice")
+	am.cache.set("key", subject, &keycloakResult{status: http.StatusOK})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		token    string
+		expected int
+	}{
+		{"missing token", http.MethodPost, "/.authz/invalidate?subject=" + subject, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "/.authz/invalidate?subject=" + subject, "nope", http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "/.authz/invalidate?subject=" + subject, "admin-secret", http.StatusMethodNotAllowed},
+		{"missing subject", http.MethodPost, "/.authz/invalidate", "admin-secret", http.StatusBadRequest},
+		{"unknown action", http.MethodPost, "/.authz/unknown", "admin-secret", http.StatusNotFound},
+		{"invalidate", http.MethodPost, "/.authz/invalidate?subject=" + subject, "admin-secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "http://gateway"+test.target, nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected == http.StatusOK && !strings.Contains(recorder.Body.String(), `"invalidated":1`) {
+				t.Errorf("unexpected body %q", recorder.Body.String())
+			}
+		})
+	}
+
+	if nextCalled {
+		t.Error("admin requests must never be forwarded")
+	}
+	if _, ok := am.cache.get("key"); ok {
+		t.Error("expected entry to be invalidated")
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := New(context.Background(), next, &Config{Admin: AdminConfig{Path: "/.authz"}}, "AuthMiddleware"); err == nil {
+		t.Error("expected error when admin.path is set without admin.token")
+	}
+}
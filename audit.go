@@ -0,0 +1,109 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one decision as written to auditFile, one JSON object per line. It holds enough of
+// the request to replay it against another rule set, and never the raw token.
+type AuditRecord struct {
+	Time               time.Time `json:"time"`
+	Method             string    `json:"method"`
+	Host               string    `json:"host"`
+	URI                string    `json:"uri"`              // path and query, without token query parameters
+	Accept             string    `json:"accept,omitempty"` // used by acceptScopes
+	ClientIP           string    `json:"clientIP,omitempty"`
+	Allowed            bool      `json:"allowed"`
+	Reason             string    `json:"reason"`
+	Status             int       `json:"status,omitempty"`
+	Rule               string    `json:"rule,omitempty"`
+	Permission         string    `json:"permission,omitempty"` // "resource#scope"
+	InheritedFrom      string    `json:"inheritedFrom,omitempty"`
+	DeniedItems        []string  `json:"deniedItems,omitempty"` // bulk item IDs that were denied
+	Backend            string    `json:"backend,omitempty"`
+	TokenFingerprint   string    `json:"tokenFingerprint,omitempty"`
+	SubjectFingerprint string    `json:"subjectFingerprint,omitempty"`
+}
+
+// auditLog appends AuditRecords to a file
+type auditLog struct {
+	mu          sync.Mutex
+	file        *os.File
+	tokenParams []string // query parameters token sources read, never recorded
+}
+
+// newAuditLog opens the audit file for appending; it returns nil when no file is configured
+func newAuditLog(path string, extractors []TokenExtractor) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("auditFile: %w", err)
+	}
+	return &auditLog{file: file, tokenParams: tokenQueryParams(extractors)}, nil
+}
+
+// uri returns the path and query of req without the query parameters tokens are read from
+func (al *auditLog) uri(req *http.Request) string {
+	if len(al.tokenParams) == 0 || req.URL.RawQuery == "" {
+		return req.URL.RequestURI()
+	}
+	query := req.URL.Query()
+	for _, param := range al.tokenParams {
+		query.Del(param)
+	}
+	u := *req.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// permissionString renders a permission as "resource#scope", or "" when none was derived
+func permissionString(p Permission) string {
+	if p.Scope == "" {
+		return p.Resource
+	}
+	return p.Resource + "#" + p.Scope
+}
+
+// record writes the decision made for req
+func (al *auditLog) record(req *http.Request, d Decision) {
+	line, err := json.Marshal(AuditRecord{
+		Time:               time.Now().UTC(),
+		Method:             req.Method,
+		Host:               req.Host,
+		URI:                al.uri(req),
+		Accept:             req.Header.Get("Accept"),
+		ClientIP:           d.ClientIP,
+		Allowed:            d.Allowed,
+		Reason:             d.Reason,
+		Status:             d.Status,
+		Rule:               d.Rule,
+		Permission:         permissionString(d.Permission),
+		InheritedFrom:      d.InheritedFrom,
+		DeniedItems:        d.DeniedItems,
+		Backend:            d.Backend,
+		TokenFingerprint:   d.TokenFingerprint,
+		SubjectFingerprint: d.SubjectFingerprint,
+	})
+	if err != nil {
+		return
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if _, err := al.file.Write(append(line, '\n')); err != nil {
+		fmt.Println("⚠️  [AUDIT] Could not write audit record:", err)
+	}
+}
+
+// close closes the audit file
+func (al *auditLog) close() {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	_ = al.file.Close()
+}
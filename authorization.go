@@ -2,12 +2,14 @@ package authztraefikgateway
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // StaticPermission maps a path prefix to a specific resource-scope
@@ -19,11 +21,161 @@ type StaticPermission struct {
 
 // Config holds the plugin configuration
 type Config struct {
-	KeycloakURL       string             `json:"keycloakURL,omitempty"`
-	KeycloakClientId  string             `json:"keycloakClientId,omitempty"`
+	// Keycloak, TLS and Logging replace the deprecated flat keys noted below
+	Keycloak KeycloakConfig `json:"keycloak,omitempty"`
+	TLS      TLSConfig      `json:"tls,omitempty"`
+	Logging  LoggingConfig  `json:"logging,omitempty"`
+
+	KeycloakURL       string             `json:"keycloakURL,omitempty"`      // deprecated: keycloak.url
+	KeycloakClientId  string             `json:"keycloakClientId,omitempty"` // deprecated: keycloak.clientId
 	ResourceIndex     int                `json:"resourceIndex,omitempty"`
 	ScopeIndex        int                `json:"scopeIndex,omitempty"`
 	StaticPermissions []StaticPermission `json:"staticPermissions,omitempty"` // New: static prefix -> resource#scope
+	StatusMappings    []StatusMapping    `json:"statusMappings,omitempty"`    // Keycloak status/error -> client status
+	AudienceByHost    map[string]string  `json:"audienceByHost,omitempty"`    // request host -> Keycloak client ID
+	// ResponseMode is the UMA response_mode: "" (RPT), "decision" or "permissions"
+	ResponseMode string `json:"responseMode,omitempty"`
+	// IncludeResourceName requests resource names in the Keycloak response and exposes the granted permissions
+	IncludeResourceName bool `json:"includeResourceName,omitempty"`
+	// PermissionSeparator separates resource and scope in the permission string (default "#")
+	PermissionSeparator string `json:"permissionSeparator,omitempty"`
+	// OmitLeadingSlash sends "user#get" instead of "/user#get"
+	OmitLeadingSlash bool `json:"omitLeadingSlash,omitempty"`
+	// ScopelessPermissions sends only the resource, e.g. "/user"
+	ScopelessPermissions bool `json:"scopelessPermissions,omitempty"`
+	// HonorRequestTimeout derives the Keycloak call deadline from X-Request-Timeout / grpc-timeout
+	HonorRequestTimeout bool `json:"honorRequestTimeout,omitempty"`
+	// RequestTimeoutTrustedIPs lists the callers that may set the deadline (CIDRs or IPs; empty trusts none)
+	RequestTimeoutTrustedIPs []string `json:"requestTimeoutTrustedIPs,omitempty"`
+	// TimeoutBudgetPercent is the share of the caller's budget the Keycloak call may use (default 50)
+	TimeoutBudgetPercent int `json:"timeoutBudgetPercent,omitempty"`
+	// KeycloakClientSecret enables the plugin's own client_credentials service token (deprecated: keycloak.clientSecret)
+	KeycloakClientSecret string `json:"keycloakClientSecret,omitempty"`
+	// UMATicketMode answers denials with a UMA permission ticket challenge (requires keycloakClientSecret)
+	UMATicketMode bool `json:"umaTicketMode,omitempty"`
+	// TicketCacheTTL is how long an issued permission ticket is reused per resource/scope (default "30s")
+	TicketCacheTTL string `json:"ticketCacheTTL,omitempty"`
+	// Rules select a permission resolver per path prefix/method; evaluated after staticPermissions
+	Rules []Rule `json:"rules,omitempty"`
+	// TokenSources lists, in order, where the access token is read from (default: Authorization bearer header)
+	TokenSources []TokenSource `json:"tokenSources,omitempty"`
+	// DenyReasonHeader names a response header carrying the reason code on denials, e.g. "X-Authz-Reason"
+	DenyReasonHeader string `json:"denyReasonHeader,omitempty"`
+	// FingerprintHeader names a header carrying the token's SHA-256 fingerprint, set on the upstream
+	// request and on denials, e.g. "X-Authz-Token-Fingerprint"
+	FingerprintHeader string `json:"fingerprintHeader,omitempty"`
+	// Coalescing deduplicates identical concurrent Keycloak evaluations
+	Coalescing CoalescingConfig `json:"coalescing,omitempty"`
+	// TokenExchange forwards an exchanged token upstream instead of the user token (requires keycloakClientSecret)
+	TokenExchange TokenExchangeConfig `json:"tokenExchange,omitempty"`
+	// Cache caches definitive Keycloak decisions
+	Cache CacheConfig `json:"cache,omitempty"`
+	// Admin enables the internal admin endpoint (e.g. cache invalidation)
+	Admin AdminConfig `json:"admin,omitempty"`
+	// FastPaths answer health probes and bots with a fixed status before any token processing
+	FastPaths []FastPathRule `json:"fastPaths,omitempty"`
+	// ExcludeFromRecords keeps probes and preflights out of metrics and audit while still authorizing them
+	ExcludeFromRecords []RecordExclusion `json:"excludeFromRecords,omitempty"`
+	// ForwardAuth accepts identity headers from a preceding ForwardAuth middleware (requires keycloakClientSecret)
+	ForwardAuth ForwardAuthConfig `json:"forwardAuth,omitempty"`
+	// VerifyTLS verifies the Keycloak server certificate (default false, for self-signed development realms;
+	// deprecated: tls.verify)
+	VerifyTLS bool `json:"verifyTLS,omitempty"`
+	// LogLevel filters per-request logs: debug (default), info, warn, error or off (deprecated: logging.level)
+	LogLevel string `json:"logLevel,omitempty"`
+	// DryRun logs denials but forwards the request anyway
+	DryRun bool `json:"dryRun,omitempty"`
+	// Profiles holds per-environment overrides, e.g. "dev", "staging", "prod"
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Environment selects the profile (default: the AUTHZ_ENVIRONMENT environment variable)
+	Environment string `json:"environment,omitempty"`
+	// StrictPaths rejects paths with encoded slashes, backslashes, null bytes or dot segments with 400
+	StrictPaths bool `json:"strictPaths,omitempty"`
+	// MaxPathLength rejects longer (escaped) paths with 414 (default 4096 bytes)
+	MaxPathLength int `json:"maxPathLength,omitempty"`
+	// MaxPathSegments rejects paths with more segments with 400 (default 128)
+	MaxPathSegments int `json:"maxPathSegments,omitempty"`
+	// Retry retries Keycloak 5xx and network failures within a retry budget shared across requests
+	Retry RetryConfig `json:"retry,omitempty"`
+	// HeadAsGet evaluates HEAD requests with the permission of the same GET request
+	HeadAsGet bool `json:"headAsGet,omitempty"`
+	// OptionsScope evaluates non-CORS OPTIONS requests against this scope of the GET request's resource
+	OptionsScope string `json:"optionsScope,omitempty"`
+	// Tenant rejects tokens of another tenant than the one the request is addressed to
+	Tenant TenantConfig `json:"tenant,omitempty"`
+	// Share reuses the Keycloak HTTP client and decision cache of other instances with identical Keycloak
+	// settings (keycloakURL, keycloakClientId, verifyTLS and cache)
+	Share bool `json:"share,omitempty"`
+	// DenyRules block matching requests with 403 before any other check
+	DenyRules []DenyRule `json:"denyRules,omitempty"`
+	// Bypass forwards matching requests without authorization until each entry's expiry
+	Bypass []BypassEntry `json:"bypass,omitempty"`
+	// ResourceCacheTTL is how long Protection API resource metadata (uri resolver) is reused (default "5m")
+	ResourceCacheTTL string `json:"resourceCacheTTL,omitempty"`
+	// TokenType rejects (or logs) ID tokens, refresh tokens and other non-access JWTs
+	TokenType TokenTypeConfig `json:"tokenType,omitempty"`
+	// EntryPointHeader names a trusted header carrying the Traefik entry point, set by a headers middleware
+	// attached per entry point, e.g. "X-Entrypoint"
+	EntryPointHeader string `json:"entryPointHeader,omitempty"`
+	// EntryPoints overrides the behavior per entry point name
+	EntryPoints map[string]EntryPointConfig `json:"entryPoints,omitempty"`
+	// BodyBuffer buffers bodies read by body-based resolvers so the upstream receives them unchanged
+	BodyBuffer BodyBufferConfig `json:"bodyBuffer,omitempty"`
+	// BuildInfoHeader names a response header carrying the plugin version and config hash,
+	// e.g. "X-Authz-Build"
+	BuildInfoHeader string `json:"buildInfoHeader,omitempty"`
+	// MinimalPayloads omits the audience from Keycloak requests when it equals the token's azp
+	MinimalPayloads bool `json:"minimalPayloads,omitempty"`
+	// Latency tracks p50/p95/p99 authorization latency per resource against optional SLOs
+	Latency LatencyConfig `json:"latency,omitempty"`
+	// BreakGlass accepts single-use, short-lived tokens that bypass Keycloak during IdP outages
+	BreakGlass BreakGlassConfig `json:"breakGlass,omitempty"`
+	// RateLimitTags sets subject and tier headers on authorized requests for downstream rate limiters
+	RateLimitTags RateLimitTagsConfig `json:"rateLimitTags,omitempty"`
+	// DebugErrors includes Keycloak's error description in denial responses, e.g. while rolling out
+	// new resource definitions; it reveals the authorization model and is meant for non-production use
+	DebugErrors bool `json:"debugErrors,omitempty"`
+	// AuthzBackend selects where permissions are evaluated: "keycloak" (default) or "static"
+	AuthzBackend string `json:"authzBackend,omitempty"`
+	// StaticPolicy is the local policy file of the static backend
+	StaticPolicy StaticPolicyConfig `json:"staticPolicy,omitempty"`
+	// PolicyEnforcerFile imports the paths of a Keycloak policy-enforcer configuration (keycloak.json or
+	// its "policy-enforcer" section) as rules, evaluated after rules
+	PolicyEnforcerFile string `json:"policyEnforcerFile,omitempty"`
+	// TrustedProxies lists the proxies (CIDRs or IPs) whose X-Forwarded-For / X-Real-Ip headers are
+	// believed when determining the client IP; empty uses the direct peer
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// ClientIPClaim pushes the client IP to Keycloak as this claim_token claim, e.g. "client_ip"
+	ClientIPClaim string `json:"clientIPClaim,omitempty"`
+	// RequestFlags lets trusted callers enable verbose decision headers, cache bypass or dry-run per request
+	RequestFlags RequestFlagsConfig `json:"requestFlags,omitempty"`
+	// Enrichment pushes subject attributes from an external HTTP source to Keycloak as claims
+	Enrichment EnrichmentConfig `json:"enrichment,omitempty"`
+	// DisableVary stops adding the token source headers (Authorization, Cookie, ...) to the Vary
+	// header of responses
+	DisableVary bool `json:"disableVary,omitempty"`
+	// CacheControlPrivate marks responses "Cache-Control: private", so shared caches never store them
+	CacheControlPrivate bool `json:"cacheControlPrivate,omitempty"`
+	// AuditFile appends every decision as a JSON line (AuditRecord) to this file, e.g. for Replay
+	AuditFile string `json:"auditFile,omitempty"`
+	// SubjectHash records a salted hash of the subject in audit records and recent denials instead of its
+	// plain fingerprint, for data minimization
+	SubjectHash SubjectHashConfig `json:"subjectHash,omitempty"`
+	// Pseudonym forwards an HMAC of the subject, keyed per upstream, instead of any personal identifier
+	Pseudonym PseudonymConfig `json:"pseudonym,omitempty"`
+	// DenialMirror sends the metadata of denied requests to a review endpoint in the background
+	DenialMirror DenialMirrorConfig `json:"denialMirror,omitempty"`
+	// SecurityHeaders adds HSTS, no-store and nosniff headers to the responses the middleware writes
+	SecurityHeaders SecurityHeadersConfig `json:"securityHeaders,omitempty"`
+	// IssuerOverride is the "iss" of the tokens when it differs from the realm of keycloakURL, e.g. the
+	// frontend URL of a Keycloak behind a reverse proxy. Setting it or internalURL rejects JWTs of other issuers.
+	IssuerOverride string `json:"issuerOverride,omitempty"`
+	// InternalURL is the realm URL the gateway calls Keycloak at, e.g. "http://keycloak.auth:8080/realms/demo",
+	// when it differs from the public one of keycloakURL that tokens are issued for
+	InternalURL string `json:"internalURL,omitempty"`
+	// TLSEndpoints sets the CA, client certificate and server name per host of keycloakURL, rule
+	// keycloakURLs and enrichment.url, for backends behind different internal CAs (deprecated: tls.endpoints)
+	TLSEndpoints []EndpointTLS `json:"tlsEndpoints,omitempty"`
 }
 
 // CreateConfig creates an empty config
@@ -33,141 +185,839 @@ func CreateConfig() *Config {
 
 // AuthMiddleware holds the plugin state
 type AuthMiddleware struct {
-	next              http.Handler
-	keycloakClientId  string
-	keycloakUrl       string
-	name              string
-	resourceIndex     int
-	scopeIndex        int
-	staticPermissions []StaticPermission
+	next          http.Handler
+	name          string
+	resourceIndex int
+	scopeIndex    int
+	runtime       atomic.Value // *runtimeConfig: rules, Keycloak endpoints, see Reload
+
+	responseMode        string
+	includeResourceName bool
+	tokenExtractors     []TokenExtractor
+	denyReasonHeader    string
+	fastPaths           []compiledFastPath
+	recordExclusions    []compiledRecordExclusion
+	fingerprintHeader   string
+
+	honorRequestTimeout   bool
+	requestTimeoutTrusted []*net.IPNet
+	trustedProxies        []*net.IPNet
+	clientIPClaim         string
+	timeoutBudgetPercent  int
+
+	client          *http.Client
+	serviceTokens   *serviceTokenManager // nil unless keycloakClientSecret is set
+	tickets         *ticketCache         // nil unless umaTicketMode is set
+	resources       *resourceSetCache    // nil unless a rule uses the uri resolver
+	coalescer       *coalescer           // nil unless coalescing is enabled
+	cache           *decisionCache       // nil unless cache is enabled
+	retrier         *retrier             // nil unless retry.maxRetries is set
+	forwardAuth     *forwardAuth         // nil unless forwardAuth is enabled
+	logLevel        logLevel
+	dryRun          bool
+	strictPaths     bool
+	pathLimits      pathLimits
+	headAsGet       bool
+	optionsScope    string
+	tenantCheck     *tenantCheck    // nil unless a tenant source is configured
+	tokenType       *tokenTypeCheck // nil unless tokenType.policy is warn or enforce
+	buildInfoHeader string
+	minimalPayloads bool
+	latency         *latencyTracker // nil unless latency tracking is enabled
+	breakGlass      *breakGlass     // nil unless break-glass tokens are configured
+	rateLimitTags   *rateLimitTags  // nil unless a rate-limit tag header is configured
+	debugErrors     bool
+	staticPolicy    *staticPolicy      // nil unless authzBackend is static
+	requestFlags    *requestFlagsCheck // nil unless requestFlags.secret or trustedIPs are set
+	enricher        *enricher          // nil unless enrichment.url is set
+	metrics         *metrics
+	diagnostics     *diagnostics     // nil unless admin.path is set
+	auditLog        *auditLog        // nil unless auditFile is set
+	denialMirror    *denialMirror    // nil unless denialMirror.url is set
+	securityHeaders *securityHeaders // nil unless securityHeaders is configured
+	subjectHasher   *subjectHasher   // nil unless subjectHash.salt is set
+	pseudonymizer   *pseudonymizer   // nil unless pseudonym.header is set
+	keycloakAddress *keycloakAddress // nil unless issuerOverride or internalURL is set
+
+	varyHeaders         []string // request headers added to Vary, empty when disableVary is set
+	cacheControlPrivate bool
+
+	entryPointHeader string
+	entryPoints      map[string]*compiledEntryPoint // nil unless entryPoints are configured
+	bodyBuffer       *bodyBuffer                    // nil unless bodyBuffer is enabled
+	ctx              context.Context                // cancelled when Traefik discards this middleware instance
+	shutdownMu       sync.Mutex
+	shutdownHooks    []func()
+
+	keycloakClientSecret string
+	tokenExchange        TokenExchangeConfig
+	admin                AdminConfig
+}
+
+// contextKey is the type of the values this middleware stores in the request context
+type contextKey string
+
+const grantedPermissionsKey contextKey = "grantedPermissions"
+
+// GrantedPermissionsFromContext returns the permissions Keycloak granted for the current request.
+// It is only populated when includeResourceName is enabled or the rule sets grantedScopesHeader.
+func GrantedPermissionsFromContext(ctx context.Context) []GrantedPermission {
+	granted, _ := ctx.Value(grantedPermissionsKey).([]GrantedPermission)
+	return granted
 }
 
 // ServeHTTP handles the incoming request and checks permission via Keycloak
 func (am *AuthMiddleware) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	fmt.Println("🔎 [AUTH] ServeHTTP Called")
+	if status, ok := am.fastPathStatus(req); ok {
+		am.setSecurityHeaders(w, req)
+		w.WriteHeader(status)
+		return
+	}
+
+	am.log(logDebug, "🔎 [AUTH] ServeHTTP Called")
 
-	authorizationHeader := req.Header.Get("Authorization")
-	if authorizationHeader == "" {
-		fmt.Println("❌ [AUTH] Authorization header is missing")
-		http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
+	if am.isAdminRequest(req) {
+		am.setSecurityHeaders(w, req)
+		am.serveAdmin(w, req)
 		return
 	}
-	fmt.Println("🔎 [AUTH] Authorization Header:", authorizationHeader)
 
-	var permission string
+	req = am.withRuntime(req)
+	ctx, cancel := am.requestContext(req.Context())
+	defer cancel()
 
-	// 🔍 First check if path matches any static permission
-	for _, sp := range am.staticPermissions {
-		if strings.HasPrefix(req.URL.Path, sp.Prefix) {
-			permission = "/" + sp.Resource + "#" + sp.Scope
-			fmt.Println("🔁 [STATIC] Matched static prefix. Using static permission:", permission)
-			break
+	w = am.withCachingHeaders(w)
+	am.setBuildInfoHeader(w)
+	unrecorded := am.unrecorded(req)
+	start := time.Now()
+	decision := am.authorize(ctx, req)
+	defer decision.body.release()
+	decision.Latency = time.Since(start)
+	am.logDecision(decision)
+	if !unrecorded {
+		am.metrics.observe(decision)
+		am.observeLatency(decision)
+	}
+	if !unrecorded && (am.diagnostics != nil || am.auditLog != nil || am.denialMirror != nil) {
+		recorded := decision
+		recorded.SubjectFingerprint = am.recordedSubject(decision, start)
+		if am.diagnostics != nil && !decision.Allowed {
+			am.diagnostics.recordDenial(recorded)
+		}
+		if am.auditLog != nil {
+			am.auditLog.record(req, recorded)
 		}
+		// Clients that went away were not blocked by a policy
+		if am.denialMirror != nil && !decision.Allowed && decision.Reason != ReasonCanceled {
+			am.denialMirror.record(am.name, req, recorded, am.dryRun || decision.flags.dryRun)
+		}
+	}
+	if decision.flags.verbose {
+		setDecisionHeader(w, decision)
 	}
 
-	// 🔁 If no static permission matched, use dynamic extraction
-	if permission == "" {
-		pathParts := strings.Split(req.URL.Path, "/")
-		if len(pathParts) <= am.scopeIndex {
-			fmt.Println("❌ [AUTH] Path too short. Must have at least", am.scopeIndex+1, "parts.")
-			http.Error(w, "Invalid path format. Too short.", http.StatusBadRequest)
-			return
+	if decision.Allowed {
+		reqCtx := context.WithValue(req.Context(), decisionKey, decision)
+		if len(decision.Granted) > 0 {
+			reqCtx = context.WithValue(reqCtx, grantedPermissionsKey, decision.Granted)
+		}
+		if am.fingerprintHeader != "" {
+			req.Header.Set(am.fingerprintHeader, decision.TokenFingerprint)
+		}
+		if decision.scopesHeader != "" {
+			setGrantedScopesHeader(w, decision)
+		}
+		if am.rateLimitTags != nil {
+			am.rateLimitTags.apply(req, decision)
 		}
+		if am.pseudonymizer != nil {
+			am.pseudonymizer.apply(req, decision)
+		}
+		if decision.upstreamToken != "" {
+			// The user token must not reach the upstream along with the exchanged one
+			if remover, ok := decision.tokenSource.(TokenRemover); ok {
+				remover.Remove(req)
+			}
+			req.Header.Set("Authorization", "Bearer "+decision.upstreamToken)
+		}
+		am.next.ServeHTTP(w, req.WithContext(reqCtx))
+		return
+	}
+	if decision.Reason == ReasonCanceled && req.Context().Err() != nil {
+		am.log(logWarn, "⚠️  [HTTP] Client disconnected, Keycloak request cancelled")
+		return
+	}
+	if am.dryRun || decision.flags.dryRun {
+		am.logf(logWarn, "⚠️  [DRY-RUN] Forwarding request that would be denied with %d (%s)\n", decision.Status, decision.Reason)
+		am.next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), decisionKey, decision)))
+		return
+	}
+	am.writeDenial(w, req, decision)
+}
 
-		resource := pathParts[am.resourceIndex]
-		scope := pathParts[am.scopeIndex]
-		permission = "/" + resource + "#" + scope
-		fmt.Println("🔎 [AUTH] Derived permission:", permission)
+// authorize derives the permission for the request and asks Keycloak for a decision
+func (am *AuthMiddleware) authorize(ctx context.Context, req *http.Request) Decision {
+	rc := am.runtimeFor(ctx)
+	clientIP := am.clientIP(req)
+	decision := Decision{Backend: backendKeycloak, ClientIP: clientIPString(clientIP)}
+	if am.requestFlags != nil {
+		flags, err := am.requestFlags.flags(req, clientIP)
+		if err != nil {
+			am.log(logWarn, "⚠️  [FLAGS] Ignoring request flags:", err)
+		} else if flags != (requestFlags{}) {
+			am.logf(logWarn, "⚠️  [FLAGS] Request flags from %s: verbose=%t noCache=%t dryRun=%t\n", decision.ClientIP, flags.verbose, flags.noCache, flags.dryRun)
+		}
+		decision.flags = flags
 	}
 
-	// Prepare request payload
-	formData := url.Values{}
-	formData.Set("permission", permission)
-	formData.Set("grant_type", "urn:ietf:params:oauth:grant-type:uma-ticket")
-	formData.Set("audience", am.keycloakClientId)
+	if name, denied := am.matchDenyRule(req, clientIP); denied {
+		am.log(logError, "❌ [DENY-RULE] Request blocked by deny rule", name)
+		decision.Rule = name
+		decision.deny(ReasonDeniedByRule, http.StatusForbidden)
+		return decision
+	}
 
-	if am.keycloakUrl == "" {
-		fmt.Println("❌ [CONFIG] Keycloak URL is empty in middleware. Cannot proceed.")
-		http.Error(w, "Misconfigured Keycloak URL", http.StatusInternalServerError)
-		return
+	if status, err := am.pathLimits.check(req); err != nil {
+		am.log(logError, "❌ [AUTH] Rejected oversized path:", err)
+		decision.deny(ReasonInvalidRequest, status)
+		decision.message = err.Error()
+		return decision
 	}
 
-	// Create request
-	kcReq, err := http.NewRequest("POST", am.keycloakUrl, strings.NewReader(formData.Encode()))
-	if err != nil {
-		fmt.Println("❌ [HTTP] Error creating Keycloak request:", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+	entryPoint := am.entryPointFor(req)
+	if entryPoint != nil {
+		decision.EntryPoint = entryPoint.name
+		if len(entryPoint.allowedIPs) > 0 && !containsIP(entryPoint.allowedIPs, clientIP) {
+			am.log(logError, "❌ [ENTRYPOINT] Caller not allowed on entry point", entryPoint.name)
+			decision.deny(ReasonIPNotAllowed, http.StatusForbidden)
+			return decision
+		}
+	}
+
+	if am.breakGlass != nil {
+		if presented := req.Header.Get(am.breakGlass.header); presented != "" {
+			return am.authorizeBreakGlass(req, presented, decision)
+		}
+	}
+
+	if am.strictPaths {
+		if err := checkStrictPath(req); err != nil {
+			am.log(logError, "❌ [AUTH] Rejected ambiguous path:", err)
+			decision.deny(ReasonInvalidRequest, http.StatusBadRequest)
+			decision.message = err.Error()
+			return decision
+		}
+	}
+
+	if entry, ok := am.bypassFor(req, time.Now()); ok {
+		return am.authorizeBypass(entry, decision)
+	}
+
+	// Policy-enforcer paths may be exempt from authorization altogether, without a token
+	target, _ := am.downgradeMethod(req)
+	if rule := am.ruleFor(target); rule != nil && rule.enforcement != enforcementEnabled {
+		return am.authorizeUnenforced(rule, decision)
+	}
+
+	accessToken, source, ok := am.extractToken(req)
+	if ok {
+		decision.tokenSource = source
+		decision.TokenFingerprint = tokenFingerprint(accessToken)
+		am.log(logDebug, "🔎 [AUTH] Access token fingerprint:", decision.TokenFingerprint)
+		if tokenMalformed(accessToken) {
+			am.log(logError, "❌ [AUTH] Access token is malformed")
+			decision.deny(ReasonMalformedToken, http.StatusUnauthorized)
+			decision.message = "Malformed access token"
+			decision.challenge = bearerChallenge("invalid_token", "The access token is malformed")
+			return decision
+		}
+		if am.keycloakAddress != nil && !am.keycloakAddress.acceptsIssuer(accessToken) {
+			am.log(logError, "❌ [AUTH] Access token was issued by another issuer than", am.keycloakAddress.issuer)
+			decision.deny(ReasonInvalidToken, http.StatusUnauthorized)
+			decision.challenge = bearerChallenge("invalid_token", "The access token issuer is not accepted")
+			return decision
+		}
+		decision.subject = tokenSubject(accessToken)
+		if decision.subject != "" {
+			decision.SubjectFingerprint = SubjectFingerprint(decision.subject)
+		} else {
+			decision.SubjectFingerprint = decision.TokenFingerprint
+		}
+		if am.tokenType != nil {
+			if use := am.tokenType.classify(accessToken); use != tokenUseAccess && use != tokenUseOpaque {
+				am.log(logWarn, "⚠️  [AUTH] Presented token is not an access token:", use)
+				if am.tokenType.enforce {
+					decision.deny(ReasonWrongTokenType, http.StatusUnauthorized)
+					decision.message = "An access token is required"
+					return decision
+				}
+			}
+		}
+	} else if identity, found := am.forwardedIdentity(req); found {
+		serviceToken, err := am.serviceTokens.Token(ctx)
+		if err != nil {
+			am.log(logError, "❌ [FORWARD-AUTH] Could not obtain service token:", err)
+			decision.deny(failureReason(failureMode(err)), http.StatusBadGateway)
+			return decision
+		}
+		accessToken = serviceToken
+		decision.claims = identity.claims()
+		decision.TokenFingerprint = identity.fingerprint()
+		decision.subject = identity.subject()
+		decision.SubjectFingerprint = SubjectFingerprint(decision.subject)
+		am.log(logDebug, "🔎 [FORWARD-AUTH] Using forwarded identity:", decision.TokenFingerprint)
+	} else if am.malformedCredentials(req) {
+		am.log(logError, "❌ [AUTH] Authorization header holds no Bearer token")
+		decision.deny(ReasonMalformedToken, http.StatusUnauthorized)
+		decision.message = "Malformed access token"
+		decision.challenge = bearerChallenge("invalid_request", "The Authorization header must hold a Bearer token")
+		return decision
+	} else {
+		am.log(logError, "❌ [AUTH] Access token is missing")
+		decision.deny(ReasonMissingToken, http.StatusUnauthorized)
+		decision.message = "Missing access token"
+		decision.challenge = bearerChallenge("", "")
+		return decision
+	}
+
+	if am.tenantCheck != nil {
+		tenant, ok := am.tenantCheck.tenant(req)
+		if !ok {
+			am.log(logError, "❌ [TENANT] Could not determine the tenant of the request")
+			decision.deny(ReasonTenantMismatch, http.StatusForbidden)
+			return decision
+		}
+		// Forwarded identities carry no tenant claim and never match
+		if decision.claims != nil || !am.tenantCheck.allows(accessToken, tenant) {
+			am.log(logError, "❌ [TENANT] Token does not belong to tenant", tenant)
+			decision.deny(ReasonTenantMismatch, http.StatusForbidden)
+			return decision
+		}
+	}
+
+	if am.rateLimitTags != nil {
+		decision.Tier = am.rateLimitTags.tier(accessToken, decision.claims)
+	}
+
+	if entryPoint != nil && entryPoint.rbac {
+		decision.Backend = backendRBAC
+		roles := tokenRoles(accessToken)
+		if decision.claims != nil {
+			roles = decision.claims["groups"]
+		}
+		if !entryPoint.hasAnyRole(roles) {
+			am.log(logError, "❌ [RBAC] No required role on entry point", entryPoint.name)
+			decision.deny(ReasonAccessDenied, http.StatusForbidden)
+			return decision
+		}
+		decision.Allowed = true
+		decision.Reason = ReasonGranted
+		return decision
 	}
-	kcReq.Header.Set("Authorization", authorizationHeader)
-	kcReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	fmt.Println("🔄 [REQUEST] Sending request to Keycloak:", am.keycloakUrl)
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	body, err := am.bufferBody(req)
+	decision.body = body
+	if err != nil {
+		am.log(logError, "❌ [AUTH] Could not buffer request body:", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, errBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		decision.deny(ReasonInvalidRequest, status)
+		return decision
 	}
 
-	kcResp, err := client.Do(kcReq)
+	resolved, rule, err := am.resolvePermission(req)
+	// The upstream receives the whole body, however much of it the resolver read
+	body.rewind(req)
+	if rule != nil {
+		decision.Rule = rule.name
+	}
+	var bulkIDs []string
+	if err == nil && rule.bulk != nil {
+		bulkIDs, err = rule.bulk.ids(req)
+		body.rewind(req)
+	}
 	if err != nil {
-		fmt.Println("❌ [HTTP] Error performing Keycloak request:", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		am.log(logError, "❌ [AUTH] Could not derive permission:", err)
+		decision.deny(ReasonInvalidRequest, http.StatusBadRequest)
+		decision.message = err.Error()
+		return decision
+	}
+	decision.Permission = resolved
+	decision.scopesHeader = rule.scopesHeader
+	if len(rule.conditions) > 0 {
+		if !am.applyConditions(req, rule, accessToken, &decision) {
+			return decision
+		}
+		resolved = decision.Permission
+	}
+
+	if rule.maxTokenAge > 0 {
+		// Forwarded identities carry no authentication time and always need a fresh token
+		authTime := tokenAuthTime(accessToken)
+		if decision.claims != nil || authTime.IsZero() || time.Since(authTime) > rule.maxTokenAge {
+			am.logf(logError, "❌ [AUTH] Token older than %s required by rule %s\n", rule.maxTokenAge, rule.name)
+			decision.deny(ReasonTokenTooOld, http.StatusUnauthorized)
+			decision.challenge = stepUpChallenge(rule.maxTokenAge)
+			return decision
+		}
+	}
+
+	if am.staticPolicy != nil {
+		return am.authorizeStatic(accessToken, decision)
 	}
-	defer kcResp.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(kcResp.Body)
-	bodyString := string(bodyBytes)
+	if rule.inheritDepth > 0 && len(ancestorResources(resolved.Resource, rule.inheritDepth)) > maxInheritedAncestors+1 {
+		// Evaluating only some ancestors would skip the ones nearest the root, where grants usually are
+		am.logf(logError, "❌ [HIERARCHY] %s has more than %d ancestors to evaluate\n", resolved.Resource, maxInheritedAncestors)
+		decision.deny(ReasonInvalidRequest, http.StatusBadRequest)
+		decision.message = fmt.Sprintf("resource has more than %d inheritable ancestors", maxInheritedAncestors)
+		return decision
+	}
+
+	permission := rc.permissionFormat.format(resolved.Resource, resolved.Scope)
+	am.logf(logDebug, "🔎 [AUTH] Derived permission: %s (rule: %s)\n", permission, decision.Rule)
+
+	decision.Audience = am.audienceFor(req)
+	if rule.audience != "" {
+		decision.Audience = rule.audience
+	}
+	decision.endpoint = rule.endpoint
+	am.log(logDebug, "🔎 [AUTH] Using audience:", decision.Audience)
 
-	fmt.Println("🔎 [HTTP] Keycloak response status:", kcResp.Status)
-	fmt.Println("📦 [HTTP] Keycloak response body:", bodyString)
+	if rc.keycloakUrl == "" && decision.endpoint == "" {
+		am.log(logError, "❌ [CONFIG] Keycloak URL is empty in middleware. Cannot proceed.")
+		decision.deny(ReasonMisconfigured, http.StatusInternalServerError)
+		decision.message = "Misconfigured Keycloak URL"
+		return decision
+	}
+
+	timeout, hasCallerDeadline := am.callerTimeout(req)
+	if hasCallerDeadline {
+		am.log(logDebug, "🔎 [DEADLINE] Limiting Keycloak call to", timeout)
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
 
-	if kcResp.StatusCode == http.StatusOK {
-		fmt.Println("✅ [AUTHZ] Access granted by Keycloak")
-		am.next.ServeHTTP(w, req)
+	if am.clientIPClaim != "" && decision.ClientIP != "" {
+		decision.pushClaim(am.clientIPClaim, []string{decision.ClientIP})
+	}
+	if am.enricher != nil && decision.subject != "" {
+		attributes, err := am.enricher.attributes(ctx, decision.subject)
+		if err != nil && am.enricher.required {
+			am.log(logError, "❌ [ENRICHMENT] Could not fetch subject attributes:", err)
+			decision.deny(ReasonEnrichmentFailed, http.StatusBadGateway)
+			decision.FailureClass = errorFailureClass(err)
+			return decision
+		}
+		if err != nil {
+			am.log(logWarn, "⚠️  [ENRICHMENT] Could not fetch subject attributes, continuing without them:", err)
+		}
+		for name, values := range attributes {
+			decision.pushClaim(name, values)
+		}
+	}
+
+	var result *keycloakResult
+	if rule.bulk != nil {
+		result, err = am.evaluateBulk(ctx, accessToken, &decision, rule.bulk, resolved.Scope, bulkIDs)
+	} else if rule.inheritDepth > 0 {
+		result, err = am.evaluateHierarchy(ctx, accessToken, &decision, resolved, rule.inheritDepth)
 	} else {
-		fmt.Printf("❌ [AUTHZ] Access denied by Keycloak. Status code: %d\n", kcResp.StatusCode)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		result, err = am.evaluateCached(ctx, accessToken, &decision, permission)
+	}
+	if err != nil {
+		mode := failureMode(err)
+		am.log(logError, "❌ [HTTP] Error performing Keycloak request:", err, "failure mode:", mode)
+		if mode == failureCanceled {
+			decision.deny(ReasonCanceled, http.StatusServiceUnavailable)
+			return decision
+		}
+		fallback := 0
+		if mode == failureTimeout && hasCallerDeadline {
+			fallback = http.StatusGatewayTimeout
+		}
+		decision.deny(failureReason(mode), rc.mapStatus(0, mode, fallback))
+		decision.FailureClass = errorFailureClass(err)
+		if decision.Status == 0 {
+			decision.Status = http.StatusUnauthorized
+			decision.message = err.Error()
+		}
+		return decision
+	}
+
+	decision.KeycloakStatus = result.status
+	if len(decision.DeniedItems) > 0 {
+		decision.KeycloakError, decision.KeycloakErrorInfo = result.errorCode, result.errorDescription
+		decision.deny(ReasonAccessDenied, rc.mapStatus(http.StatusForbidden, "access_denied", http.StatusForbidden))
+		return decision
+	}
+	scopeFallback := result.status != http.StatusOK && am.grantsByScope(rule, accessToken, decision.claims != nil, result)
+	if result.status == http.StatusOK || scopeFallback {
+		decision.Allowed = true
+		decision.Reason = ReasonGranted
+		if scopeFallback {
+			decision.Reason = ReasonScopeFallback
+		}
+		decision.Granted = result.granted
+		decision.GrantedScopes = grantedScopes(result.granted)
+		if am.tokenExchange.Enabled && decision.claims == nil {
+			audience, scopes := am.exchangeTarget(rule)
+			exchanged, err := am.exchangeToken(ctx, accessToken, audience, scopes)
+			if err != nil {
+				am.log(logError, "❌ [EXCHANGE] Token exchange failed:", err)
+				decision.deny(ReasonExchangeFailed, http.StatusBadGateway)
+				decision.FailureClass = errorFailureClass(err)
+				return decision
+			}
+			decision.upstreamToken = exchanged
+		}
+		return decision
+	}
+
+	decision.KeycloakError, decision.KeycloakErrorInfo = result.errorCode, result.errorDescription
+	reason := keycloakReason(result.status, result.errorCode, result.errorDescription)
+	if reason == ReasonInvalidToken && decision.claims == nil {
+		reason = rejectedTokenReason(&decision, accessToken)
+	}
+	decision.deny(reason, rc.mapStatus(result.status, result.errorCode, http.StatusUnauthorized))
+	if am.debugErrors && decision.KeycloakErrorInfo != "" {
+		decision.message = fmt.Sprintf("%s: %s", decision.Reason, decision.KeycloakErrorInfo)
+	}
+	if am.tickets != nil && result.status == http.StatusForbidden {
+		ticket, err := am.permissionTicket(ctx, resolved)
+		if err != nil {
+			am.log(logWarn, "⚠️  [UMA] Could not obtain permission ticket:", err)
+		} else {
+			decision.ticket = ticket
+		}
+	}
+	return decision
+}
+
+// grantsByScope reports whether the rule's fallbackScope grants a request Keycloak rejected because the
+// resource is not registered. Keycloak checks the token before the resource, so the token is valid.
+// Forwarded identities have no scope claim and never fall back.
+func (am *AuthMiddleware) grantsByScope(rule *compiledRule, accessToken string, forwarded bool, result *keycloakResult) bool {
+	if rule.fallbackScope == "" || forwarded || keycloakReason(result.status, result.errorCode, result.errorDescription) != ReasonInvalidResource {
+		return false
+	}
+	if !tokenHasScope(accessToken, rule.fallbackScope) {
+		return false
+	}
+	am.logf(logWarn, "⚠️  [SCOPE-FALLBACK] Resource of rule %s is not registered in Keycloak, granted by OAuth scope %s\n", rule.name, rule.fallbackScope)
+	return true
+}
+
+// forwardedIdentity returns the ForwardAuth principal of the request, if the feature is enabled
+func (am *AuthMiddleware) forwardedIdentity(req *http.Request) (forwardedIdentity, bool) {
+	if am.forwardAuth == nil {
+		return forwardedIdentity{}, false
 	}
+	return am.forwardAuth.identity(req, am.clientIP(req))
+}
+
+// audienceFor returns the Keycloak client ID to evaluate permissions against for the request host
+func (am *AuthMiddleware) audienceFor(req *http.Request) string {
+	rc := am.runtimeFor(req.Context())
+	if len(rc.audienceByHost) == 0 {
+		return rc.keycloakClientId
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if audience, ok := rc.audienceByHost[strings.ToLower(host)]; ok {
+		return audience
+	}
+	return rc.keycloakClientId
 }
 
 // New is called by Traefik to create the middleware instance
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
 	fmt.Println("🔧 [INIT] New Middleware Initialization")
-	fmt.Printf("🔧 [CONFIG] Raw config: %+v\n", config)
-
 	if config == nil {
 		return nil, fmt.Errorf("nil config provided")
 	}
-	if strings.TrimSpace(config.KeycloakURL) == "" {
+	fmt.Printf("🔧 [CONFIG] Raw config (secrets redacted): %+v\n", redactConfig(*config))
+	structured, deprecations, err := config.withStructuredKeys()
+	if err != nil {
+		return nil, err
+	}
+	logDeprecations(name, deprecations, *config)
+	config, err = structured.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(config.KeycloakURL) == "" && !strings.EqualFold(config.AuthzBackend, authzBackendStatic) {
 		fmt.Println("⚠️  [CONFIG] KeycloakURL is empty!")
 	}
 	if strings.TrimSpace(config.KeycloakClientId) == "" {
 		fmt.Println("⚠️  [CONFIG] KeycloakClientId is empty!")
 	}
 
-	resourceIndex := config.ResourceIndex
-	scopeIndex := config.ScopeIndex
-	if resourceIndex <= 0 {
-		resourceIndex = 3
+	resourceIndex, scopeIndex := ruleIndexes(config)
+
+	if errs := config.validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	logLevel, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	requestTimeoutTrusted, err := parseCIDRs(config.RequestTimeoutTrustedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("requestTimeoutTrustedIPs: %w", err)
+	}
+	if config.HonorRequestTimeout && len(requestTimeoutTrusted) == 0 {
+		fmt.Println("⚠️  [CONFIG] honorRequestTimeout trusts no caller until requestTimeoutTrustedIPs is set")
+	}
+	trustedProxies, err := parseCIDRs(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trustedProxies: %w", err)
+	}
+	timeoutBudgetPercent := config.TimeoutBudgetPercent
+	if timeoutBudgetPercent <= 0 || timeoutBudgetPercent > 100 {
+		timeoutBudgetPercent = defaultTimeoutBudgetPercent
+	}
+	ticketCacheTTL, err := parseDurationOrDefault(config.TicketCacheTTL, defaultTicketCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("ticketCacheTTL: %w", err)
+	}
+	resourceCacheTTL, err := parseDurationOrDefault(config.ResourceCacheTTL, defaultResourceCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("resourceCacheTTL: %w", err)
+	}
+
+	runtime, err := newRuntimeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenExtractors, err := newTokenExtractors(config.TokenSources)
+	if err != nil {
+		return nil, fmt.Errorf("tokenSources: %w", err)
+	}
+
+	coalescer, err := newCoalescer(config.Coalescing)
+	if err != nil {
+		return nil, err
+	}
+
+	fastPaths, err := compileFastPaths(config.FastPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	recordExclusions, err := compileRecordExclusions(config.ExcludeFromRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardAuth, err := newForwardAuth(config.ForwardAuth)
+	if err != nil {
+		return nil, fmt.Errorf("forwardAuth: %w", err)
+	}
+
+	cache, err := newDecisionCache(config.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	entryPoints, err := compileEntryPoints(config.EntryPointHeader, config.EntryPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	breakGlass, err := newBreakGlass(config.BreakGlass)
+	if err != nil {
+		return nil, err
+	}
+
+	pathLimits, err := newPathLimits(config.MaxPathLength, config.MaxPathSegments)
+	if err != nil {
+		return nil, err
+	}
+
+	latency, err := newLatencyTracker(config.Latency)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyBuffer, err := newBodyBuffer(config.BodyBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenType, err := newTokenTypeCheck(config.TokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsTransports, err := newEndpointTransports(config.VerifyTLS, config.TLSEndpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := newKeycloakAddress(config)
+	if err != nil {
+		return nil, err
+	}
+
+	enricher, err := newEnricher(config.Enrichment, withEndpointTLS(tlsTransports, http.DefaultTransport))
+	if err != nil {
+		return nil, err
+	}
+
+	requestFlags, err := newRequestFlagsCheck(config.RequestFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	staticPolicy, err := newStaticPolicy(config.AuthzBackend, config.StaticPolicy)
+	if err != nil {
+		return nil, err
 	}
-	if scopeIndex <= 0 {
-		scopeIndex = 4
+	if staticPolicy != nil && staticPolicy.keys == nil {
+		fmt.Println("⚠️  [STATIC-POLICY] Token signatures are not verified (insecureSkipVerify); any token is trusted")
+	}
+
+	retrier, err := newRetrier(config.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectHasher, err := newSubjectHasher(config.SubjectHash)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudonymizer, err := newPseudonymizer(config.Pseudonym)
+	if err != nil {
+		return nil, err
+	}
+
+	securityHeaders, err := newSecurityHeaders(config.SecurityHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	denialMirror, err := newDenialMirror(config.DenialMirror, tokenExtractors, withEndpointTLS(tlsTransports, http.DefaultTransport))
+	if err != nil {
+		return nil, err
+	}
+
+	// Opened last, so a failing configuration never leaves the file open
+	auditLog, err := newAuditLog(config.AuditFile, tokenExtractors)
+	if err != nil {
+		return nil, err
 	}
 
 	mw := &AuthMiddleware{
-		next:              next,
-		name:              name,
-		keycloakUrl:       config.KeycloakURL,
-		keycloakClientId:  config.KeycloakClientId,
-		resourceIndex:     resourceIndex,
-		scopeIndex:        scopeIndex,
-		staticPermissions: config.StaticPermissions,
+		next:          next,
+		name:          name,
+		resourceIndex: resourceIndex,
+		scopeIndex:    scopeIndex,
+
+		responseMode:          config.ResponseMode,
+		includeResourceName:   config.IncludeResourceName,
+		tokenExtractors:       tokenExtractors,
+		fastPaths:             fastPaths,
+		recordExclusions:      recordExclusions,
+		denyReasonHeader:      http.CanonicalHeaderKey(strings.TrimSpace(config.DenyReasonHeader)),
+		fingerprintHeader:     http.CanonicalHeaderKey(strings.TrimSpace(config.FingerprintHeader)),
+		honorRequestTimeout:   config.HonorRequestTimeout,
+		requestTimeoutTrusted: requestTimeoutTrusted,
+		trustedProxies:        trustedProxies,
+		clientIPClaim:         strings.TrimSpace(config.ClientIPClaim),
+		timeoutBudgetPercent:  timeoutBudgetPercent,
+		ctx:                   ctx,
+		coalescer:             coalescer,
+		keycloakClientSecret:  config.KeycloakClientSecret,
+		tokenExchange:         config.TokenExchange,
+		retrier:               retrier,
+		forwardAuth:           forwardAuth,
+		logLevel:              logLevel,
+		dryRun:                config.DryRun,
+		strictPaths:           config.StrictPaths,
+		pathLimits:            pathLimits,
+		headAsGet:             config.HeadAsGet,
+		optionsScope:          strings.TrimSpace(config.OptionsScope),
+		tenantCheck:           newTenantCheck(config.Tenant),
+		tokenType:             tokenType,
+		admin:                 AdminConfig{Path: strings.TrimRight(config.Admin.Path, "/"), Token: config.Admin.Token, SigningKey: config.Admin.SigningKey},
+		buildInfoHeader:       strings.TrimSpace(config.BuildInfoHeader),
+		minimalPayloads:       config.MinimalPayloads,
+		latency:               latency,
+		breakGlass:            breakGlass,
+		rateLimitTags:         newRateLimitTags(config.RateLimitTags),
+		debugErrors:           config.DebugErrors,
+		staticPolicy:          staticPolicy,
+		requestFlags:          requestFlags,
+		enricher:              enricher,
+		metrics:               newMetrics(),
+		diagnostics:           newDiagnostics(config.Admin),
+		auditLog:              auditLog,
+		denialMirror:          denialMirror,
+		securityHeaders:       securityHeaders,
+		subjectHasher:         subjectHasher,
+		pseudonymizer:         pseudonymizer,
+		keycloakAddress:       address,
+		cacheControlPrivate:   config.CacheControlPrivate,
+		entryPointHeader:      http.CanonicalHeaderKey(strings.TrimSpace(config.EntryPointHeader)),
+		entryPoints:           entryPoints,
+		bodyBuffer:            bodyBuffer,
+	}
+	mw.runtime.Store(runtime)
+	if !config.DisableVary {
+		mw.varyHeaders = varyHeaders(tokenExtractors)
+	}
+	if auditLog != nil {
+		mw.onShutdown(auditLog.close)
+	}
+	if denialMirror != nil {
+		denialMirror.start(ctx)
+		mw.onShutdown(denialMirror.wait)
+	}
+
+	var state *sharedState
+	if config.Share {
+		key := sharedKey{keycloakURL: config.KeycloakURL, clientID: config.KeycloakClientId, verifyTLS: config.VerifyTLS, tls: tlsEndpointsKey(config.TLSEndpoints), internalURL: config.InternalURL, cache: config.Cache}
+		state = sharedStates.acquire(key, func() *sharedState { return newSharedState(config, cache, tlsTransports, address) })
+		mw.onShutdown(func() { sharedStates.release(key) })
+	} else {
+		state = newSharedState(config, cache, tlsTransports, address)
+		mw.onShutdown(state.close)
+	}
+	mw.client = state.client
+	mw.cache = state.cache
+	if config.KeycloakClientSecret != "" {
+		mw.serviceTokens = newServiceTokenManager(mw.client, config.KeycloakURL, config.KeycloakClientId, config.KeycloakClientSecret)
+		mw.onShutdown(mw.serviceTokens.stop)
+	}
+	if config.UMATicketMode {
+		mw.tickets = newTicketCache(ticketCacheTTL)
+	}
+	for _, rule := range runtime.rules {
+		if rule.lookup == nil {
+			continue
+		}
+		if mw.resources == nil {
+			mw.resources = newResourceSetCache(resourceCacheTTL)
+		}
+		rule.lookup.find = mw.lookupResource
 	}
+	go mw.watchShutdown()
 
 	fmt.Printf("🔧 [INIT] Middleware initialized with keycloakUrl: [%s], clientId: [%s], rIdx: %d, sIdx: %d\n",
-		mw.keycloakUrl, mw.keycloakClientId, resourceIndex, scopeIndex)
+		runtime.keycloakUrl, runtime.keycloakClientId, resourceIndex, scopeIndex)
+	fmt.Printf("🔧 [INIT] %s running plugin version %s, config %s\n", name, Version, runtime.configHash)
+	logRuleWarnings(name, runtime.ruleWarnings)
+	logBypasses(name, runtime.bypass, time.Now())
 
 	return mw, nil
 }
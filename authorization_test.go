@@ -28,3 +28,40 @@ func TestAuthorization(t *testing.T) {
 		t.Error("expected 200 OK")
 	}
 }
+
+func TestAudienceByHost(t *testing.T) {
+	var audience string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		audience = req.PostForm.Get("audience")
+	}))
+	defer srv.Close()
+
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		KeycloakClientId: "default-client",
+		AudienceByHost: map[string]string{
+			"api.foo.com": "foo-api",
+			"API.bar.com": "bar-api",
+		},
+	}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"http://api.foo.com/api/v1/user/get":      "foo-api",
+		"http://api.bar.com:8443/api/v1/user/get": "bar-api",
+		"http://other.com/api/v1/user/get":        "default-client",
+	}
+	for target, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if audience != expected {
+			t.Errorf("%s: expected audience %q, got %q", target, expected, audience)
+		}
+	}
+}
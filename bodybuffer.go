@@ -0,0 +1,148 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Defaults of the body buffer
+const (
+	defaultBodyMemoryBytes = 1 << 20  // 1 MiB
+	defaultBodyMaxBytes    = 10 << 20 // 10 MiB
+)
+
+// errBodyTooLarge is returned when a body exceeds bodyBuffer.maxBytes
+var errBodyTooLarge = errors.New("request body too large")
+
+// BodyBufferConfig buffers request bodies read by body-based resolvers (graphql) so the upstream
+// receives the body unchanged, however much of it the resolver consumed
+type BodyBufferConfig struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	MemoryBytes int64  `json:"memoryBytes,omitempty"` // kept in memory up to this size (default 1 MiB), then spilled to a temp file
+	MaxBytes    int64  `json:"maxBytes,omitempty"`    // larger bodies are rejected with 413 (default 10 MiB)
+	TempDir     string `json:"tempDir,omitempty"`     // directory of spill files (default: the system temp dir)
+}
+
+// bodyBuffer reads request bodies into memory, spilling to a temp file beyond memoryBytes
+type bodyBuffer struct {
+	memoryBytes int64
+	maxBytes    int64
+	tempDir     string
+}
+
+// newBodyBuffer builds the body buffer from config; it returns nil when buffering is disabled
+func newBodyBuffer(config BodyBufferConfig) (*bodyBuffer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MemoryBytes < 0 || config.MaxBytes < 0 {
+		return nil, fmt.Errorf("bodyBuffer sizes must not be negative")
+	}
+	bb := &bodyBuffer{memoryBytes: config.MemoryBytes, maxBytes: config.MaxBytes, tempDir: config.TempDir}
+	if bb.memoryBytes == 0 {
+		bb.memoryBytes = defaultBodyMemoryBytes
+	}
+	if bb.maxBytes == 0 {
+		bb.maxBytes = defaultBodyMaxBytes
+	}
+	if bb.memoryBytes > bb.maxBytes {
+		return nil, fmt.Errorf("bodyBuffer.memoryBytes must not exceed bodyBuffer.maxBytes")
+	}
+	return bb, nil
+}
+
+// bufferedBody is a fully read request body that can be replayed any number of times
+type bufferedBody struct {
+	data []byte
+	file *os.File // set when the body was spilled
+	size int64
+}
+
+// read buffers body, rejecting it with errBodyTooLarge beyond maxBytes
+func (bb *bodyBuffer) read(body io.Reader, contentLength int64) (*bufferedBody, error) {
+	if contentLength > bb.maxBytes {
+		return nil, errBodyTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(body, bb.memoryBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	if int64(len(data)) <= bb.memoryBytes {
+		return &bufferedBody{data: data, size: int64(len(data))}, nil
+	}
+
+	file, err := os.CreateTemp(bb.tempDir, "authz-body-*")
+	if err != nil {
+		return nil, fmt.Errorf("spilling request body: %w", err)
+	}
+	buffered := &bufferedBody{file: file}
+	rest := io.LimitReader(body, bb.maxBytes-int64(len(data))+1)
+	buffered.size, err = io.Copy(file, io.MultiReader(bytes.NewReader(data), rest))
+	if err != nil {
+		buffered.release()
+		return nil, fmt.Errorf("spilling request body: %w", err)
+	}
+	if buffered.size > bb.maxBytes {
+		buffered.release()
+		return nil, errBodyTooLarge
+	}
+	return buffered, nil
+}
+
+// spilled reports whether the body is stored in a temp file
+func (b *bufferedBody) spilled() bool {
+	return b.file != nil
+}
+
+// rewind sets the request body to a fresh reader over the whole buffered body
+func (b *bufferedBody) rewind(req *http.Request) {
+	if b == nil {
+		return
+	}
+	if b.file != nil {
+		req.Body = io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	} else {
+		req.Body = io.NopCloser(bytes.NewReader(b.data))
+	}
+	req.ContentLength = b.size
+}
+
+// release removes the spill file, if any; the body must not be read afterwards
+func (b *bufferedBody) release() {
+	if b == nil || b.file == nil {
+		return
+	}
+	b.file.Close()
+	if err := os.Remove(b.file.Name()); err != nil {
+		fmt.Println("⚠️  [BODY] Could not remove spill file:", err)
+	}
+}
+
+// bufferBody buffers the request body when the matching rule reads it and buffering is enabled.
+// The returned body must be released once the request has been served.
+func (am *AuthMiddleware) bufferBody(req *http.Request) (*bufferedBody, error) {
+	if am.bodyBuffer == nil || req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	// The rule is chosen like resolvePermission does, for HEAD and OPTIONS resolved as GET
+	target, _ := am.downgradeMethod(req)
+	if rule := am.ruleFor(target); rule == nil || !rule.readsBody {
+		return nil, nil
+	}
+	body, err := am.bodyBuffer.read(req.Body, req.ContentLength)
+	req.Body.Close()
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			am.metrics.observeBodyRejected()
+		}
+		return nil, err
+	}
+	am.metrics.observeBody(body.size, body.spilled())
+	am.logf(logDebug, "📦 [BODY] Buffered %d bytes (spilled: %v)\n", body.size, body.spilled())
+	body.rewind(req)
+	return body, nil
+}
@@ -0,0 +1,112 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBodyBufferReplaysBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var received string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = string(body)
+	})
+	tempDir := t.TempDir()
+	config := &Config{
+		KeycloakURL: srv.URL,
+		Rules:       []Rule{{Prefix: "/graphql", Resolver: "graphql", Resource: "graph"}},
+		BodyBuffer:  BodyBufferConfig{Enabled: true, MemoryBytes: 16, MaxBytes: 256, TempDir: tempDir},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"in memory", `{"query":"{a}"}`, http.StatusOK},
+		{"spilled", `{"query":"{ me { id name email } }","variables":{"padding":"` + strings.Repeat("x", 100) + `"}}`, http.StatusOK},
+		{"too large", `{"query":"{ me }","variables":{"padding":"` + strings.Repeat("x", 300) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest(http.MethodPost, "http://gateway/graphql", strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected == http.StatusOK && received != test.body {
+				t.Errorf("upstream received a different body: %q", received)
+			}
+			if files, _ := os.ReadDir(tempDir); len(files) != 0 {
+				t.Errorf("expected spill files to be removed, found %d", len(files))
+			}
+		})
+	}
+
+	var metrics strings.Builder
+	if err := handler.(*AuthMiddleware).WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []string{"authz_body_buffered_bytes_count 2", "authz_body_spilled_total 1", "authz_body_rejected_total 1"} {
+		if !strings.Contains(metrics.String(), sample) {
+			t.Errorf("expected %q in metrics:\n%s", sample, metrics.String())
+		}
+	}
+}
+
+func TestBodyBufferDowngradedMethod(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		Rules:        []Rule{{Prefix: "/graphql", Methods: []string{"GET"}, Resolver: "graphql", Resource: "graph"}},
+		OptionsScope: "discover",
+		BodyBuffer:   BodyBufferConfig{Enabled: true, MemoryBytes: 256, MaxBytes: 256},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// OPTIONS is resolved with the GET rule, which reads the body, so the body limits apply
+	body := `{"query":"{ me }","variables":{"padding":"` + strings.Repeat("x", 300) + `"}}`
+	req := httptest.NewRequest(http.MethodOptions, "http://gateway/graphql", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized body of a downgraded OPTIONS request, got %d", recorder.Code)
+	}
+}
+
+func TestBodyBufferValidation(t *testing.T) {
+	for _, config := range []BodyBufferConfig{
+		{Enabled: true, MemoryBytes: -1},
+		{Enabled: true, MemoryBytes: 2048, MaxBytes: 1024},
+	} {
+		if _, err := newBodyBuffer(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package authztraefikgateway
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of break-glass access
+const defaultBreakGlassHeader = "X-Break-Glass"
+
+// backendBreakGlass identifies break-glass access in a Decision
+const backendBreakGlass = "break_glass"
+
+// Errors of a presented break-glass token
+var (
+	errBreakGlassUnknown = errors.New("unknown break-glass token")
+	errBreakGlassExpired = errors.New("break-glass token expired")
+	errBreakGlassUsed    = errors.New("break-glass token already used")
+)
+
+// BreakGlassConfig enables single-use tokens that bypass Keycloak, for incident response when the
+// IdP itself is down. Only SHA-256 hashes of the tokens are configured.
+type BreakGlassConfig struct {
+	Header string            `json:"header,omitempty"` // request header carrying the token (default "X-Break-Glass")
+	Tokens []BreakGlassToken `json:"tokens,omitempty"`
+	File   string            `json:"file,omitempty"` // JSON array of tokens, re-read when it changes
+}
+
+// BreakGlassToken is a break-glass token issued out-of-band
+type BreakGlassToken struct {
+	Hash    string `json:"hash"`    // hex SHA-256 of the token
+	Expires string `json:"expires"` // RFC 3339 end of validity, required
+}
+
+// breakGlassToken is a parsed BreakGlassToken
+type breakGlassToken struct {
+	hash    []byte
+	expires time.Time
+}
+
+// breakGlass verifies and consumes break-glass tokens. Used tokens are remembered in the process-wide
+// spentCredentials until they expire, so every middleware instance of a gateway rejects them; with
+// several gateway replicas each token should be issued for a single one or revoked from the file after use.
+type breakGlass struct {
+	header string
+	file   string
+	static []breakGlassToken
+
+	mu       sync.Mutex
+	fromFile []breakGlassToken
+	fileMod  time.Time
+}
+
+// newBreakGlass builds break-glass access from config; it returns nil when no tokens are configured
+func newBreakGlass(config BreakGlassConfig) (*breakGlass, error) {
+	if len(config.Tokens) == 0 && config.File == "" {
+		return nil, nil
+	}
+	static, err := parseBreakGlassTokens(config.Tokens)
+	if err != nil {
+		return nil, fmt.Errorf("breakGlass.tokens: %w", err)
+	}
+	bg := &breakGlass{
+		header: http.CanonicalHeaderKey(strings.TrimSpace(config.Header)),
+		file:   config.File,
+		static: static,
+	}
+	if bg.header == "" {
+		bg.header = defaultBreakGlassHeader
+	}
+	// The file may only be created during an incident
+	if err := bg.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("breakGlass.file: %w", err)
+	}
+	return bg, nil
+}
+
+// parseBreakGlassTokens validates configured tokens
+func parseBreakGlassTokens(tokens []BreakGlassToken) ([]breakGlassToken, error) {
+	parsed := make([]breakGlassToken, 0, len(tokens))
+	for i, token := range tokens {
+		hash, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(token.Hash), "sha256:"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token %d: hash must be a hex SHA-256", i)
+		}
+		expires, err := time.Parse(time.RFC3339, token.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("token %d: expires must be an RFC 3339 time: %w", i, err)
+		}
+		parsed = append(parsed, breakGlassToken{hash: hash, expires: expires})
+	}
+	return parsed, nil
+}
+
+// reload re-reads the token file when it changed. A broken file keeps the previous tokens.
+func (bg *breakGlass) reload() error {
+	if bg.file == "" {
+		return nil
+	}
+	info, err := os.Stat(bg.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(bg.fileMod) {
+		return nil
+	}
+	data, err := os.ReadFile(bg.file)
+	if err != nil {
+		return err
+	}
+	var tokens []BreakGlassToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+	parsed, err := parseBreakGlassTokens(tokens)
+	if err != nil {
+		return err
+	}
+	bg.fromFile = parsed
+	bg.fileMod = info.ModTime()
+	return nil
+}
+
+// consume verifies a presented token and marks it used. It returns a short identifier of the token
+// (prefix of its hash) for audit logs.
+func (bg *breakGlass) consume(presented string) (string, error) {
+	sum := sha256.Sum256([]byte(presented))
+	id := hex.EncodeToString(sum[:])
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	if err := bg.reload(); err != nil && !os.IsNotExist(err) {
+		fmt.Println("⚠️  [BREAK-GLASS] Could not reload token file:", err)
+	}
+
+	var match *breakGlassToken
+	for _, tokens := range [][]breakGlassToken{bg.static, bg.fromFile} {
+		for i := range tokens {
+			if subtle.ConstantTimeCompare(sum[:], tokens[i].hash) == 1 {
+				match = &tokens[i]
+			}
+		}
+	}
+	switch {
+	case match == nil:
+		return id[:12], errBreakGlassUnknown
+	case !time.Now().Before(match.expires):
+		return id[:12], errBreakGlassExpired
+	case !spentCredentials.spend("break-glass:"+id, match.expires):
+		return id[:12], errBreakGlassUsed
+	}
+	return id[:12], nil
+}
+
+// authorizeBreakGlass grants or denies a request carrying a break-glass token, without Keycloak.
+// Every attempt is audited regardless of the log level.
+func (am *AuthMiddleware) authorizeBreakGlass(req *http.Request, presented string, decision Decision) Decision {
+	// The token must never reach the upstream
+	req.Header.Del(am.breakGlass.header)
+	decision.Backend = backendBreakGlass
+	id, err := am.breakGlass.consume(presented)
+	decision.TokenFingerprint = id
+	if err != nil {
+		fmt.Printf("🚨 [BREAK-GLASS] Rejected token %s: %v (method=%s path=%s client=%s ua=%q)\n",
+			id, err, req.Method, req.URL.Path, decision.ClientIP, req.UserAgent())
+		decision.deny(ReasonInvalidToken, http.StatusUnauthorized)
+		return decision
+	}
+	fmt.Printf("🚨 [BREAK-GLASS] Access granted with token %s, bypassing Keycloak (method=%s host=%s path=%s client=%s ua=%q)\n",
+		id, req.Method, req.Host, req.URL.Path, decision.ClientIP, req.UserAgent())
+	decision.Allowed = true
+	decision.Reason = ReasonBreakGlass
+	return decision
+}
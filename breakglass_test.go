@@ -0,0 +1,98 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func breakGlassHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBreakGlass(t *testing.T) {
+	// Keycloak is down
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	srv.Close()
+
+	var forwardedHeader string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwardedHeader = req.Header.Get("X-Break-Glass")
+	})
+	// Used tokens are remembered process-wide, so every run issues its own
+	incident := fmt.Sprintf("incident-%d", time.Now().UnixNano())
+	file := filepath.Join(t.TempDir(), "break-glass.json")
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	config := &Config{
+		KeycloakURL: srv.URL,
+		BreakGlass: BreakGlassConfig{
+			Tokens: []BreakGlassToken{
+				{Hash: breakGlassHash(incident + "-a"), Expires: future},
+				{Hash: "sha256:" + breakGlassHash("stale"), Expires: time.Now().Add(-time.Minute).Format(time.RFC3339)},
+			},
+			File: file,
+		},
+		DenyReasonHeader: "X-Authz-Reason",
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Traefik builds another instance per router and on every configuration change
+	other, err := New(context.Background(), next, config, "OtherRouter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveOn := func(handler http.Handler, breakGlassToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://gateway/api/v1/node/restart", nil)
+		req.Header.Set("X-Break-Glass", breakGlassToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	serve := func(breakGlassToken string) *httptest.ResponseRecorder {
+		return serveOn(handler, breakGlassToken)
+	}
+
+	if recorder := serve(incident + "-a"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a valid token, got %d", recorder.Code)
+	}
+	if forwardedHeader != "" {
+		t.Error("the break-glass token must not be forwarded")
+	}
+	if recorder := serveOn(other, incident+"-a"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected a token used on one instance to be rejected on another, got %d", recorder.Code)
+	}
+	for name, presented := range map[string]string{"reused": incident + "-a", "expired": "stale", "unknown": "guess"} {
+		if recorder := serve(presented); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, recorder.Code)
+		}
+	}
+
+	// Tokens issued during the outage are picked up from the file
+	if err := os.WriteFile(file, []byte(`[{"hash":"`+breakGlassHash(incident+"-b")+`","expires":"`+future+`"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(incident + "-b"); recorder.Code != http.StatusOK {
+		t.Errorf("expected 200 with a token from the file, got %d", recorder.Code)
+	}
+}
+
+func TestBreakGlassValidation(t *testing.T) {
+	for _, config := range []BreakGlassConfig{
+		{Tokens: []BreakGlassToken{{Hash: "not-hex", Expires: "2030-01-01T00:00:00Z"}}},
+		{Tokens: []BreakGlassToken{{Hash: breakGlassHash("x")}}},
+	} {
+		if _, err := newBreakGlass(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package authztraefikgateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// Version is the plugin version, bumped on every release (Traefik loads plugins from source, so it
+// cannot be injected at build time)
+var Version = "dev"
+
+// configHashLength is the number of hex digits of the config hash
+const configHashLength = 12
+
+// BuildInfo identifies the plugin build and configuration revision serving requests
+type BuildInfo struct {
+	Version    string `json:"version"`
+	ConfigHash string `json:"configHash"`
+	Middleware string `json:"middleware"`
+}
+
+// configHash returns a short SHA-256 of the effective configuration. Secrets are redacted first,
+// so the hash can be shown freely; rotating a secret alone does not change it.
+func configHash(config Config) string {
+	raw, err := json.Marshal(redactConfig(config))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:configHashLength]
+}
+
+// BuildInfo returns the plugin version and configuration hash of the middleware
+func (am *AuthMiddleware) BuildInfo() BuildInfo {
+	return BuildInfo{Version: Version, ConfigHash: am.current().configHash, Middleware: am.name}
+}
+
+// setBuildInfoHeader sets the build info response header, when configured
+func (am *AuthMiddleware) setBuildInfoHeader(w http.ResponseWriter) {
+	if am.buildInfoHeader != "" {
+		w.Header().Set(am.buildInfoHeader, Version+"; config="+am.current().configHash)
+	}
+}
@@ -0,0 +1,57 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildInfo(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	newHandler := func(config *Config) *AuthMiddleware {
+		handler, err := New(context.Background(), next, config, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler.(*AuthMiddleware)
+	}
+	config := &Config{
+		KeycloakURL:          "http://keycloak",
+		KeycloakClientSecret: "secret",
+		BuildInfoHeader:      "X-Authz-Build",
+		Admin:                AdminConfig{Path: "/.authz", Token: "admin-secret"},
+	}
+	am := newHandler(config)
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	recorder := httptest.NewRecorder()
+	am.ServeHTTP(recorder, req)
+	if header, expected := recorder.Header().Get("X-Authz-Build"), Version+"; config="+am.current().configHash; header != expected {
+		t.Errorf("expected header %q, got %q", expected, header)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/.authz/version", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	recorder = httptest.NewRecorder()
+	am.ServeHTTP(recorder, req)
+	var info BuildInfo
+	if err := json.NewDecoder(recorder.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info != am.BuildInfo() || info.ConfigHash == "" {
+		t.Errorf("unexpected build info %+v", info)
+	}
+
+	rotated := *config
+	rotated.KeycloakClientSecret = "rotated"
+	if newHandler(&rotated).current().configHash != am.current().configHash {
+		t.Error("config hash must not depend on secrets")
+	}
+	changed := *config
+	changed.DenyReasonHeader = "X-Authz-Reason"
+	if newHandler(&changed).current().configHash == am.current().configHash {
+		t.Error("config hash must change with the configuration")
+	}
+}
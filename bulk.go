@@ -0,0 +1,194 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxBulkBodyBytes    = 1 << 20
+	defaultBulkMaxItems = 100
+	bulkIDPlaceholder   = "{id}"
+)
+
+// BulkConfig authorizes every item of a bulk request, e.g. POST /orders/bulk-delete with
+// {"ids": [1, 2, 3]}, in a single UMA request with one permission per item. The scope is the one the
+// rule's resolver derives; a request any item of which is denied is rejected with the denied IDs.
+type BulkConfig struct {
+	Field    string `json:"field,omitempty"`    // JSON body field holding the ID list, e.g. "ids"; enables the mode
+	Resource string `json:"resource,omitempty"` // resource of each item, e.g. "order:{id}"; default "{id}"
+	MaxItems int    `json:"maxItems,omitempty"` // larger lists are rejected with 400; default 100
+}
+
+// bulkItems extracts the item IDs of bulk requests
+type bulkItems struct {
+	field    string
+	resource string
+	maxItems int
+}
+
+// newBulkItems validates the configuration; it returns nil when no field is configured
+func newBulkItems(config BulkConfig) (*bulkItems, error) {
+	field := strings.TrimSpace(config.Field)
+	if field == "" {
+		if config.Resource != "" || config.MaxItems != 0 {
+			return nil, fmt.Errorf("bulk.resource and bulk.maxItems require bulk.field")
+		}
+		return nil, nil
+	}
+	if config.MaxItems < 0 {
+		return nil, fmt.Errorf("bulk.maxItems must not be negative")
+	}
+	bi := &bulkItems{field: field, resource: config.Resource, maxItems: config.MaxItems}
+	if bi.resource == "" {
+		bi.resource = bulkIDPlaceholder
+	}
+	if !strings.Contains(bi.resource, bulkIDPlaceholder) {
+		return nil, fmt.Errorf("bulk.resource must contain %s", bulkIDPlaceholder)
+	}
+	if bi.maxItems == 0 {
+		bi.maxItems = defaultBulkMaxItems
+	}
+	return bi, nil
+}
+
+// ids returns the distinct item IDs listed in the JSON body, in order. Strings and numbers are accepted.
+// The body is restored so the upstream receives it unchanged.
+func (bi *bulkItems) ids(req *http.Request) ([]string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, errors.New("missing bulk request body")
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBulkBodyBytes+1))
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading bulk body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) > maxBulkBodyBytes {
+		return nil, errors.New("bulk request body too large")
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid bulk request body: %w", err)
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(payload[bi.field], &values); err != nil || len(values) == 0 {
+		return nil, fmt.Errorf("bulk field %q must be a non-empty list", bi.field)
+	}
+	if len(values) > bi.maxItems {
+		return nil, fmt.Errorf("bulk field %q lists %d items, at most %d are allowed", bi.field, len(values), bi.maxItems)
+	}
+	ids := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		id, err := bulkID(value)
+		if err != nil {
+			return nil, fmt.Errorf("bulk field %q: %w", bi.field, err)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// bulkID decodes a string or number item ID. IDs may not span path segments or carry a scope.
+func bulkID(value json.RawMessage) (string, error) {
+	var id string
+	if err := json.Unmarshal(value, &id); err != nil {
+		var number json.Number
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		if err := dec.Decode(&number); err != nil {
+			return "", fmt.Errorf("invalid item %s", value)
+		}
+		id = number.String()
+	}
+	if id == "" || strings.ContainsAny(id, "/#") {
+		return "", fmt.Errorf("invalid item %q", id)
+	}
+	return id, nil
+}
+
+// resourceFor returns the resource of an item
+func (bi *bulkItems) resourceFor(id string) string {
+	return strings.Replace(bi.resource, bulkIDPlaceholder, id, -1)
+}
+
+// evaluateBulk evaluates scope on the resource of every item in a single Keycloak request, in the
+// permissions response mode, and records the items Keycloak did not grant in decision.DeniedItems.
+// Bulk evaluations are neither cached nor coalesced.
+func (am *AuthMiddleware) evaluateBulk(ctx context.Context, accessToken string, decision *Decision, bi *bulkItems, scope string, ids []string) (*keycloakResult, error) {
+	rc := am.runtimeFor(ctx)
+	permissions := make([]string, 0, len(ids))
+	for _, id := range ids {
+		permissions = append(permissions, rc.permissionFormat.format(bi.resourceFor(id), scope))
+	}
+	am.logf(logDebug, "🔎 [BULK] Evaluating %d items (rule: %s)\n", len(ids), decision.Rule)
+	_, claims := decision.pushedClaims()
+	label := fmt.Sprintf("%d bulk items", len(ids))
+	result, err := am.retrying(ctx, label, func() (*keycloakResult, error) {
+		return am.evaluatePermissions(ctx, accessToken, permissions, responseModePermissions, decision.Audience, decision.endpoint, claims)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case result.status == http.StatusOK:
+		granted := make(map[string]bool, len(result.granted))
+		for _, gp := range result.granted {
+			if scope != "" && !grantsScope(gp, scope) {
+				continue
+			}
+			granted[strings.TrimPrefix(gp.ResourceName, "/")] = true
+			granted[strings.TrimPrefix(gp.ResourceID, "/")] = true
+		}
+		for _, id := range ids {
+			if !granted[strings.TrimPrefix(bi.resourceFor(id), "/")] {
+				decision.DeniedItems = append(decision.DeniedItems, id)
+			}
+		}
+	case keycloakReason(result.status, result.errorCode, result.errorDescription) == ReasonAccessDenied:
+		// Keycloak denies the whole request when it grants none of the permissions
+		decision.DeniedItems = append(decision.DeniedItems, ids...)
+	}
+	if len(decision.DeniedItems) > 0 {
+		am.logf(logError, "❌ [BULK] %d of %d items denied (rule: %s)\n", len(decision.DeniedItems), len(ids), decision.Rule)
+	}
+	return result, nil
+}
+
+// grantsScope reports whether the granted permission includes scope
+func grantsScope(gp GrantedPermission, scope string) bool {
+	for _, granted := range gp.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkDenial is the response body of a bulk request with denied items
+type bulkDenial struct {
+	Error  string   `json:"error"`
+	Denied []string `json:"denied"`
+}
+
+// writeBulkDenial writes the denial of a bulk request, listing the denied item IDs
+func writeBulkDenial(w http.ResponseWriter, d Decision) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(d.Status)
+	if err := json.NewEncoder(w).Encode(bulkDenial{Error: d.Reason, Denied: d.DeniedItems}); err != nil {
+		fmt.Println("⚠️  [BULK] Could not encode denial:", err)
+	}
+}
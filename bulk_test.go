@@ -0,0 +1,132 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	allowed := map[string]bool{"order:1": true, "order:3": true}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		_ = req.ParseForm()
+		if req.PostForm.Get("response_mode") != responseModePermissions {
+			t.Errorf("expected the permissions response mode, got %q", req.PostForm.Get("response_mode"))
+		}
+		var granted []GrantedPermission
+		for _, permission := range req.PostForm["permission"] {
+			parts := strings.SplitN(strings.TrimPrefix(permission, "/"), "#", 2)
+			if allowed[parts[0]] {
+				granted = append(granted, GrantedPermission{ResourceName: parts[0], Scopes: []string{parts[1]}})
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if len(granted) == 0 {
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte(`{"error":"access_denied","error_description":"not_authorized"}`))
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(granted)
+	}))
+	defer srv.Close()
+
+	var forwarded string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		forwarded = string(body)
+	})
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		KeycloakClientId: "gateway",
+		Rules: []Rule{{
+			Name:     "orders-bulk",
+			Prefix:   "/orders/bulk-delete",
+			Resolver: "static",
+			Resource: "order",
+			Scope:    "delete",
+			Bulk:     BulkConfig{Field: "ids", Resource: "order:{id}", MaxItems: 3},
+		}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+		denied   []string
+	}{
+		{"all granted", `{"ids":[1,"3",1]}`, http.StatusOK, nil},
+		{"partially denied", `{"ids":[1,2,3]}`, http.StatusForbidden, []string{"2"}},
+		{"all denied", `{"ids":["2","4"]}`, http.StatusForbidden, []string{"2", "4"}},
+		{"too many items", `{"ids":[1,2,3,4]}`, http.StatusBadRequest, nil},
+		{"not a list", `{"ids":"1"}`, http.StatusBadRequest, nil},
+		{"invalid item", `{"ids":["1#admin"]}`, http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls, forwarded = 0, ""
+			req := httptest.NewRequest(http.MethodPost, "http://gateway/orders/bulk-delete", strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice"}`))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d: %s", test.expected, recorder.Code, recorder.Body)
+			}
+			switch test.expected {
+			case http.StatusOK:
+				if calls != 1 || forwarded != test.body {
+					t.Errorf("expected one Keycloak call and the body forwarded, got %d calls and %q", calls, forwarded)
+				}
+			case http.StatusForbidden:
+				var denial bulkDenial
+				if err := json.Unmarshal(recorder.Body.Bytes(), &denial); err != nil {
+					t.Fatal(err)
+				}
+				if denial.Error != ReasonAccessDenied || strings.Join(denial.Denied, ",") != strings.Join(test.denied, ",") {
+					t.Errorf("expected denied items %v, got %+v", test.denied, denial)
+				}
+			default:
+				if calls != 0 {
+					t.Errorf("expected no Keycloak call, got %d", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkValidation(t *testing.T) {
+	tests := []struct {
+		config BulkConfig
+		valid  bool
+	}{
+		{BulkConfig{}, true},
+		{BulkConfig{Field: "ids"}, true},
+		{BulkConfig{Field: "ids", Resource: "order:{id}", MaxItems: 10}, true},
+		{BulkConfig{Resource: "order:{id}"}, false},
+		{BulkConfig{Field: "ids", Resource: "order"}, false},
+		{BulkConfig{Field: "ids", MaxItems: -1}, false},
+	}
+	for _, test := range tests {
+		_, err := newBulkItems(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.config, test.valid, err)
+		}
+	}
+
+	config := &Config{
+		AuthzBackend: authzBackendStatic,
+		Rules:        []Rule{{Prefix: "/orders/bulk", Resolver: "static", Resource: "order", Bulk: BulkConfig{Field: "ids"}}},
+	}
+	if errs := config.validate(); len(errs) == 0 {
+		t.Error("expected bulk rules to be rejected with the static backend")
+	}
+}
@@ -0,0 +1,126 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// bypassTimeLayouts are the accepted formats of BypassEntry.Until; times without a zone are UTC
+var bypassTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02T15:04", "2006-01-02"}
+
+// BypassEntry forwards matching requests without a token or authorization until it expires, for
+// emergency exceptions such as a data migration. Once expired the entry is ignored and a warning is
+// logged, so a temporary exception cannot silently become permanent.
+type BypassEntry struct {
+	Name    string   `json:"name,omitempty"`    // used in logs and Decision.Rule; defaults to the prefix
+	Prefix  string   `json:"prefix"`            // e.g. "/migrations/"
+	Methods []string `json:"methods,omitempty"` // empty matches all methods; SAFE / MUTATING classes allowed
+	Until   string   `json:"until"`             // expiry, e.g. "2025-07-01T00:00Z" or "2025-07-01" (UTC)
+	Reason  string   `json:"reason,omitempty"`  // why the exception exists, e.g. a ticket, logged with every use
+}
+
+// compiledBypass is a BypassEntry with its matchers prepared at load time
+type compiledBypass struct {
+	name    string
+	prefix  string
+	methods *compiledRule // method matcher (empty prefix)
+	until   time.Time
+	reason  string
+	expired *int32 // set once the expiry has been logged
+}
+
+// compileBypasses validates the bypass entries; every entry needs a prefix and an expiry
+func compileBypasses(entries []BypassEntry) ([]compiledBypass, error) {
+	compiled := make([]compiledBypass, 0, len(entries))
+	for i, entry := range entries {
+		if !strings.HasPrefix(entry.Prefix, "/") {
+			return nil, fmt.Errorf("bypass[%d]: prefix must start with \"/\"", i)
+		}
+		until, err := parseBypassTime(entry.Until)
+		if err != nil {
+			return nil, fmt.Errorf("bypass[%d]: %w", i, err)
+		}
+		cb := compiledBypass{
+			name:    entry.Name,
+			prefix:  entry.Prefix,
+			methods: &compiledRule{},
+			until:   until,
+			reason:  entry.Reason,
+			expired: new(int32),
+		}
+		if cb.name == "" {
+			cb.name = entry.Prefix
+		}
+		if len(entry.Methods) > 0 {
+			cb.methods.methods = make(map[string]bool, len(entry.Methods))
+			for _, method := range entry.Methods {
+				cb.methods.methods[strings.ToUpper(method)] = true
+			}
+		}
+		compiled = append(compiled, cb)
+	}
+	return compiled, nil
+}
+
+// parseBypassTime parses the expiry of a bypass entry
+func parseBypassTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("until is required")
+	}
+	for _, layout := range bypassTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid until %q, expected e.g. 2025-07-01T00:00Z", value)
+}
+
+// matches reports whether the entry applies to the request. The prefix must match whole segments of
+// the path both as received and with dot segments resolved, so neither "/migrations/../admin" nor
+// "/migrations-admin" is bypassed by "/migrations".
+func (cb compiledBypass) matches(req *http.Request) bool {
+	return pathWithinPrefix(req.URL.Path, cb.prefix) && pathWithinPrefix(cleanPath(req.URL.Path), cb.prefix) && cb.methods.matches(req)
+}
+
+// bypassFor returns the unexpired bypass entry matching the request at now. Expired entries are
+// skipped, with a warning the first time one matches.
+func (am *AuthMiddleware) bypassFor(req *http.Request, now time.Time) (compiledBypass, bool) {
+	for _, entry := range am.runtimeFor(req.Context()).bypass {
+		if !entry.matches(req) {
+			continue
+		}
+		if now.Before(entry.until) {
+			return entry, true
+		}
+		if atomic.CompareAndSwapInt32(entry.expired, 0, 1) {
+			am.logf(logWarn, "⚠️  [BYPASS] Entry %s expired at %s and is ignored; remove it from the configuration\n", entry.name, entry.until.Format(time.RFC3339))
+		}
+	}
+	return compiledBypass{}, false
+}
+
+// authorizeBypass forwards a request matching an unexpired bypass entry
+func (am *AuthMiddleware) authorizeBypass(entry compiledBypass, decision Decision) Decision {
+	am.logf(logWarn, "⚠️  [BYPASS] Authorization bypassed by %s until %s (%s)\n", entry.name, entry.until.Format(time.RFC3339), entry.reason)
+	decision.Rule = entry.name
+	decision.Backend = backendNone
+	decision.Allowed = true
+	decision.Reason = ReasonBypassed
+	return decision
+}
+
+// logBypasses prints the bypass entries of a newly loaded configuration, so exceptions stay visible
+func logBypasses(name string, entries []compiledBypass, now time.Time) {
+	for _, entry := range entries {
+		if now.Before(entry.until) {
+			fmt.Printf("⚠️  [BYPASS] %s: %s bypasses authorization until %s (%s)\n", name, entry.name, entry.until.Format(time.RFC3339), entry.reason)
+		} else {
+			atomic.StoreInt32(entry.expired, 1)
+			fmt.Printf("⚠️  [BYPASS] %s: %s expired at %s and is ignored; remove it from the configuration\n", name, entry.name, entry.until.Format(time.RFC3339))
+		}
+	}
+}
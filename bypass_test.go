@@ -0,0 +1,90 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBypass(t *testing.T) {
+	var forwarded Decision
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded, _ = DecisionFromContext(req.Context())
+	})
+	config := &Config{
+		KeycloakURL:      "http://keycloak.invalid/realms/test/protocol/openid-connect/token",
+		KeycloakClientId: "gateway",
+		Bypass: []BypassEntry{
+			{Name: "migration", Prefix: "/migrations/", Methods: []string{"POST"}, Until: "2999-07-01T00:00Z", Reason: "OPS-123"},
+			{Prefix: "/legacy/", Until: "2020-01-01"},
+			{Name: "exports", Prefix: "/exports", Until: "2999-07-01T00:00Z"},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method   string
+		path     string
+		expected int
+		reason   string
+	}{
+		{http.MethodPost, "/migrations/run", http.StatusOK, ReasonBypassed},
+		// Requests the entries do not apply to need a token as usual
+		{http.MethodGet, "/migrations/run", http.StatusUnauthorized, ReasonMissingToken},
+		{http.MethodPost, "/migrations/../api/v1/user/delete", http.StatusUnauthorized, ReasonMissingToken},
+		{http.MethodGet, "/legacy/report", http.StatusUnauthorized, ReasonMissingToken},
+		{http.MethodGet, "/exports", http.StatusOK, ReasonBypassed},
+		{http.MethodGet, "/exports/2024.csv", http.StatusOK, ReasonBypassed},
+		// A sibling sharing the prefix is not below it
+		{http.MethodGet, "/exports-admin/users", http.StatusUnauthorized, ReasonMissingToken},
+	}
+	for _, test := range tests {
+		forwarded = Decision{}
+		req := httptest.NewRequest(test.method, "http://gateway/", nil)
+		req.URL.Path = test.path
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.expected {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.expected, recorder.Code)
+		}
+		if test.expected == http.StatusOK && (forwarded.Reason != test.reason || !strings.HasPrefix(test.path, "/"+forwarded.Rule) || forwarded.Backend != backendNone) {
+			t.Errorf("%s %s: unexpected decision %+v", test.method, test.path, forwarded)
+		}
+	}
+
+	// The entry stops applying at its expiry, without a reload
+	am := handler.(*AuthMiddleware)
+	req := httptest.NewRequest(http.MethodPost, "http://gateway/migrations/run", nil)
+	if _, ok := am.bypassFor(req, time.Date(2999, 6, 30, 23, 59, 0, 0, time.UTC)); !ok {
+		t.Error("expected the entry to apply before its expiry")
+	}
+	if _, ok := am.bypassFor(req, time.Date(2999, 7, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("expected the entry to be ignored at its expiry")
+	}
+}
+
+func TestBypassValidation(t *testing.T) {
+	tests := []struct {
+		entry BypassEntry
+		valid bool
+	}{
+		{BypassEntry{Prefix: "/migrations/", Until: "2025-07-01T00:00Z"}, true},
+		{BypassEntry{Prefix: "/migrations/", Until: "2025-07-01T00:00:00+02:00"}, true},
+		{BypassEntry{Prefix: "/migrations/", Until: "2025-07-01"}, true},
+		{BypassEntry{Prefix: "/migrations/"}, false},
+		{BypassEntry{Prefix: "/migrations/", Until: "next week"}, false},
+		{BypassEntry{Prefix: "", Until: "2025-07-01"}, false},
+	}
+	for _, test := range tests {
+		_, err := compileBypasses([]BypassEntry{test.entry})
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.entry, test.valid, err)
+		}
+	}
+}
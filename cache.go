@@ -0,0 +1,235 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of the decision cache
+const (
+	defaultCacheTTL        = 30 * time.Second
+	defaultCacheMaxEntries = 10000
+)
+
+// CacheConfig configures caching of Keycloak decisions per token, permission and audience
+type CacheConfig struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	TTL        string `json:"ttl,omitempty"`        // e.g. "30s" (default)
+	MaxEntries int    `json:"maxEntries,omitempty"` // default 10000
+	SeedFile   string `json:"seedFile,omitempty"`   // decisions loaded at startup, as exported by the admin dump API
+}
+
+type cacheEntry struct {
+	result  *keycloakResult
+	subject string // subject fingerprint, used for invalidation
+	stored  time.Time
+	expires time.Time
+}
+
+// decisionCache stores definitive Keycloak answers (granted or denied) keyed by token fingerprint,
+// permission and audience, and indexes them by subject fingerprint for invalidation
+type decisionCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	bySubject map[string]map[string]struct{}
+	counters  cacheCounters
+}
+
+// newDecisionCache builds the cache from config; it returns nil when caching is disabled
+func newDecisionCache(config CacheConfig) (*decisionCache, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	ttl, err := parseDurationOrDefault(config.TTL, defaultCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("cache.ttl: %w", err)
+	}
+	maxEntries := config.MaxEntries
+	if maxEntries < 0 {
+		return nil, fmt.Errorf("cache.maxEntries must not be negative")
+	}
+	if maxEntries == 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &decisionCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*cacheEntry),
+		bySubject:  make(map[string]map[string]struct{}),
+	}, nil
+}
+
+func (dc *decisionCache) get(key string) (*keycloakResult, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	entry, ok := dc.entries[key]
+	if !ok {
+		dc.counters.misses++
+		return nil, false
+	}
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		dc.removeLocked(key)
+		dc.counters.misses++
+		dc.counters.expirations++
+		return nil, false
+	}
+	dc.counters.hits++
+	dc.counters.hitAge += now.Sub(entry.stored)
+	return entry.result, true
+}
+
+func (dc *decisionCache) set(key, subject string, result *keycloakResult) {
+	dc.setUntil(key, subject, result, time.Now().Add(dc.ttl))
+}
+
+func (dc *decisionCache) setUntil(key, subject string, result *keycloakResult, expires time.Time) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if _, exists := dc.entries[key]; !exists && len(dc.entries) >= dc.maxEntries {
+		dc.evictLocked()
+	}
+	dc.removeLocked(key)
+	dc.entries[key] = &cacheEntry{result: result, subject: subject, stored: time.Now(), expires: expires}
+	keys, ok := dc.bySubject[subject]
+	if !ok {
+		keys = make(map[string]struct{})
+		dc.bySubject[subject] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// invalidateSubject drops every entry of a subject and returns how many were removed
+func (dc *decisionCache) invalidateSubject(subject string) int {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	keys := dc.bySubject[subject]
+	n := len(keys)
+	for key := range keys {
+		dc.removeLocked(key)
+	}
+	return n
+}
+
+// removeLocked deletes one entry and its subject index. dc.mu must be held.
+func (dc *decisionCache) removeLocked(key string) {
+	entry, ok := dc.entries[key]
+	if !ok {
+		return
+	}
+	delete(dc.entries, key)
+	if keys, ok := dc.bySubject[entry.subject]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(dc.bySubject, entry.subject)
+		}
+	}
+}
+
+// evictLocked makes room for a new entry: expired entries first, otherwise an arbitrary one.
+// dc.mu must be held.
+func (dc *decisionCache) evictLocked() {
+	now := time.Now()
+	for key, entry := range dc.entries {
+		if !now.Before(entry.expires) {
+			dc.removeLocked(key)
+			dc.counters.expirations++
+		}
+	}
+	for key := range dc.entries {
+		if len(dc.entries) < dc.maxEntries {
+			return
+		}
+		dc.removeLocked(key)
+		dc.counters.evictions++
+	}
+}
+
+// cacheable reports whether a Keycloak answer is definitive enough to be cached
+func cacheable(result *keycloakResult) bool {
+	return result.status == http.StatusOK || result.status == http.StatusForbidden
+}
+
+// tokenSubject returns the "sub" claim of a JWT access token, or "" for opaque tokens
+func tokenSubject(accessToken string) string {
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// tokenExpiry returns the "exp" claim of a JWT access token, or the zero time for opaque tokens
+func tokenExpiry(accessToken string) time.Time {
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil || claims.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expiry, 0)
+}
+
+// SubjectFingerprint returns the fingerprint identifying a subject ("sub" claim) in the decision cache
+// and the invalidation API
+func SubjectFingerprint(subject string) string {
+	return tokenFingerprint(subject)
+}
+
+// evaluateCached is evaluateCoalesced behind the decision cache, when enabled
+func (am *AuthMiddleware) evaluateCached(ctx context.Context, accessToken string, decision *Decision, permission string) (*keycloakResult, error) {
+	fingerprint, claims := decision.pushedClaims()
+	return am.cached(accessToken, decision, permission, func() (*keycloakResult, error) {
+		return am.evaluateCoalesced(ctx, accessToken, fingerprint, permission, decision.Audience, decision.endpoint, claims)
+	})
+}
+
+// cached returns the cached result of the evaluation identified by permission, or calls evaluate and
+// caches its result, when the decision cache is enabled
+func (am *AuthMiddleware) cached(accessToken string, decision *Decision, permission string, evaluate func() (*keycloakResult, error)) (*keycloakResult, error) {
+	if am.cache == nil {
+		return evaluate()
+	}
+
+	fingerprint, _ := decision.pushedClaims()
+	key := cacheKey(fingerprint, permission, decision.Audience, decision.endpoint)
+	if decision.flags.noCache {
+		am.log(logDebug, "💾 [CACHE] Bypassed by request flag for", permission)
+	} else if result, ok := am.cache.get(key); ok {
+		am.log(logDebug, "💾 [CACHE] Hit for", permission)
+		return result, nil
+	}
+
+	result, err := evaluate()
+	if err == nil && cacheable(result) {
+		// A decision is never served after the token it was made for has expired
+		expires := time.Now().Add(am.cache.ttl)
+		if exp := tokenExpiry(accessToken); !exp.IsZero() && exp.Before(expires) {
+			expires = exp
+		}
+		if time.Now().Before(expires) {
+			am.cache.setUntil(key, decision.SubjectFingerprint, result, expires)
+		}
+	}
+	return result, err
+}
+
+// InvalidateSubject drops every cached decision of the subject with the given fingerprint
+// (see SubjectFingerprint) so that e.g. a suspension takes effect immediately. It returns the
+// number of entries removed.
+func (am *AuthMiddleware) InvalidateSubject(subjectFingerprint string) int {
+	if am.cache == nil {
+		return 0
+	}
+	n := am.cache.invalidateSubject(subjectFingerprint)
+	am.logf(logDebug, "💾 [CACHE] Invalidated %d entries for subject %s\n", n, subjectFingerprint)
+	return n
+}
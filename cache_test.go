@@ -0,0 +1,131 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwtWithClaims builds an unsigned compact JWT carrying the given JSON claims
+func jwtWithClaims(claims string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestDecisionCache(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	config := &Config{KeycloakURL: srv.URL, Cache: CacheConfig{Enabled: true, TTL: "1m"}}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	alice := jwtWithClaims(`{"sub":"alice"}`)
+	bob := jwtWithClaims(`{"sub":"bob"}`)
+	request := func(accessToken string) {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", recorder.Code)
+		}
+	}
+
+	request(alice)
+	request(alice)
+	request(bob)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 Keycloak calls, got %d", n)
+	}
+
+	if n := am.InvalidateSubject(SubjectFingerprint("alice")); n != 1 {
+		t.Errorf("expected 1 invalidated entry, got %d", n)
+	}
+	request(alice)
+	request(bob)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected 3 Keycloak calls after invalidation, got %d", n)
+	}
+}
+
+func TestDecisionCacheExpiryAndEviction(t *testing.T) {
+	dc, err := newDecisionCache(CacheConfig{Enabled: true, TTL: "50ms", MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &keycloakResult{status: http.StatusOK}
+
+	dc.set("a", "s1", result)
+	dc.set("b", "s1", result)
+	dc.set("c", "s2", result)
+	if len(dc.entries) != 2 {
+		t.Errorf("expected eviction to keep 2 entries, got %d", len(dc.entries))
+	}
+	if _, ok := dc.get("c"); !ok {
+		t.Error("expected newest entry to be cached")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := dc.get("c"); ok {
+		t.Error("expected entry to expire")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	for status, expected := range map[int]bool{
+		http.StatusOK:                 true,
+		http.StatusForbidden:          true,
+		http.StatusUnauthorized:       false,
+		http.StatusServiceUnavailable: false,
+	} {
+		if got := cacheable(&keycloakResult{status: status}); got != expected {
+			t.Errorf("cacheable(%d) = %v, expected %v", status, got, expected)
+		}
+	}
+}
+
+func TestCacheTTLBoundedByTokenExpiry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	config := &Config{KeycloakURL: srv.URL, Cache: CacheConfig{Enabled: true, TTL: "1h"}}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	exp := time.Now().Add(time.Minute).Unix()
+	for _, accessToken := range []string{
+		jwtWithClaims(fmt.Sprintf(`{"sub":"alice","exp":%d}`, exp)),
+		jwtWithClaims(fmt.Sprintf(`{"sub":"bob","exp":%d}`, time.Now().Add(-time.Minute).Unix())),
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	dump := am.DumpCache()
+	if len(dump) != 1 {
+		t.Fatalf("expected only the unexpired token's decision to be cached, got %+v", dump)
+	}
+	if !dump[0].Expires.Equal(time.Unix(exp, 0)) {
+		t.Errorf("expected entry to expire with the token at %v, got %v", time.Unix(exp, 0), dump[0].Expires)
+	}
+}
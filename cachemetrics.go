@@ -0,0 +1,165 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// cacheCounters measure the efficiency of the decision cache since startup. They are guarded by the
+// cache's mutex.
+type cacheCounters struct {
+	hits        uint64
+	misses      uint64        // lookups without a live entry, expired ones included
+	expirations uint64        // entries dropped because their TTL had passed
+	evictions   uint64        // live entries dropped to make room for new ones
+	hitAge      time.Duration // summed age of the entries at their hits
+}
+
+// coalesceCounters measure how often coalescing saved a Keycloak call since startup. They are guarded
+// by the coalescer's mutex.
+type coalesceCounters struct {
+	leaders    uint64        // callers that made the Keycloak call for their key
+	waits      uint64        // callers that waited for an identical call in flight
+	waitTime   time.Duration // summed time spent waiting
+	windowHits uint64        // callers served a result finished within the window
+	overflows  uint64        // callers that made their own call because maxWaiters was reached
+}
+
+// observeWait records the time a caller waited for the leader of its call
+func (c *coalescer) observeWait(waited time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters.waitTime += waited
+}
+
+// CacheEfficiency reports how well the decision cache and coalescing spare Keycloak, so operators can
+// tune cache.ttl, cache.maxEntries and coalescing.window with data. Sections of disabled layers are nil.
+type CacheEfficiency struct {
+	Cache      *DecisionCacheEfficiency `json:"cache,omitempty"`
+	Coalescing *CoalescingEfficiency    `json:"coalescing,omitempty"`
+}
+
+// DecisionCacheEfficiency is the efficiency of the decision cache since startup
+type DecisionCacheEfficiency struct {
+	Entries     int           `json:"entries"`
+	MaxEntries  int           `json:"maxEntries"`
+	TTL         time.Duration `json:"ttl"`
+	Hits        uint64        `json:"hits"`
+	Misses      uint64        `json:"misses"`
+	HitRatio    float64       `json:"hitRatio"`  // hits / (hits + misses); 0 before the first lookup
+	AvgHitAge   time.Duration `json:"avgHitAge"` // close to the TTL: a longer TTL would likely raise the hit ratio
+	Expirations uint64        `json:"expirations"`
+	Evictions   uint64        `json:"evictions"` // growing: maxEntries is too small for the working set
+
+	hitAge time.Duration // summed, for the metrics
+}
+
+// CoalescingEfficiency is the efficiency of coalescing since startup
+type CoalescingEfficiency struct {
+	Leaders    uint64        `json:"leaders"`
+	Waits      uint64        `json:"waits"`
+	AvgWait    time.Duration `json:"avgWait"`
+	WindowHits uint64        `json:"windowHits"`
+	Overflows  uint64        `json:"overflows"`
+
+	waitTime time.Duration // summed, for the metrics
+}
+
+// efficiency returns the efficiency counters of the cache
+func (dc *decisionCache) efficiency() DecisionCacheEfficiency {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	c := dc.counters
+	e := DecisionCacheEfficiency{
+		Entries:     len(dc.entries),
+		MaxEntries:  dc.maxEntries,
+		TTL:         dc.ttl,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Expirations: c.expirations,
+		Evictions:   c.evictions,
+		hitAge:      c.hitAge,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		e.HitRatio = float64(c.hits) / float64(lookups)
+	}
+	if c.hits > 0 {
+		e.AvgHitAge = c.hitAge / time.Duration(c.hits)
+	}
+	return e
+}
+
+// efficiency returns the efficiency counters of the coalescer
+func (c *coalescer) efficiency() CoalescingEfficiency {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := CoalescingEfficiency{
+		Leaders:    c.counters.leaders,
+		Waits:      c.counters.waits,
+		WindowHits: c.counters.windowHits,
+		Overflows:  c.counters.overflows,
+		waitTime:   c.counters.waitTime,
+	}
+	if c.counters.waits > 0 {
+		e.AvgWait = c.counters.waitTime / time.Duration(c.counters.waits)
+	}
+	return e
+}
+
+// CacheEfficiency returns the efficiency counters of the decision cache and coalescing
+func (am *AuthMiddleware) CacheEfficiency() CacheEfficiency {
+	var e CacheEfficiency
+	if am.cache != nil {
+		cache := am.cache.efficiency()
+		e.Cache = &cache
+	}
+	if am.coalescer != nil {
+		coalescing := am.coalescer.efficiency()
+		e.Coalescing = &coalescing
+	}
+	return e
+}
+
+// writeCacheEfficiency renders the counters in the Prometheus text exposition format. The hit ratio and
+// average age are left to queries over the totals, e.g. rate(authz_cache_hit_age_seconds_sum) /
+// rate(authz_cache_hits_total).
+func writeCacheEfficiency(w io.Writer, e CacheEfficiency) error {
+	var b strings.Builder
+	if c := e.Cache; c != nil {
+		b.WriteString("# HELP authz_cache_entries Live entries in the decision cache.\n")
+		b.WriteString("# TYPE authz_cache_entries gauge\n")
+		fmt.Fprintf(&b, "authz_cache_entries %d\n", c.Entries)
+		b.WriteString("# HELP authz_cache_hits_total Decision cache lookups served from the cache.\n")
+		b.WriteString("# TYPE authz_cache_hits_total counter\n")
+		fmt.Fprintf(&b, "authz_cache_hits_total %d\n", c.Hits)
+		b.WriteString("# HELP authz_cache_misses_total Decision cache lookups without a live entry.\n")
+		b.WriteString("# TYPE authz_cache_misses_total counter\n")
+		fmt.Fprintf(&b, "authz_cache_misses_total %d\n", c.Misses)
+		b.WriteString("# HELP authz_cache_hit_age_seconds_sum Summed age of decision cache entries at their hits.\n")
+		b.WriteString("# TYPE authz_cache_hit_age_seconds_sum counter\n")
+		fmt.Fprintf(&b, "authz_cache_hit_age_seconds_sum %g\n", c.hitAge.Seconds())
+		b.WriteString("# HELP authz_cache_expirations_total Decision cache entries dropped after their TTL.\n")
+		b.WriteString("# TYPE authz_cache_expirations_total counter\n")
+		fmt.Fprintf(&b, "authz_cache_expirations_total %d\n", c.Expirations)
+		b.WriteString("# HELP authz_cache_evictions_total Live decision cache entries evicted to make room (cache.maxEntries).\n")
+		b.WriteString("# TYPE authz_cache_evictions_total counter\n")
+		fmt.Fprintf(&b, "authz_cache_evictions_total %d\n", c.Evictions)
+	}
+	if c := e.Coalescing; c != nil {
+		b.WriteString("# HELP authz_coalesce_calls_total Callers of coalesced Keycloak evaluations by outcome.\n")
+		b.WriteString("# TYPE authz_coalesce_calls_total counter\n")
+		writeCounters(&b, "authz_coalesce_calls_total", "outcome", map[string]uint64{
+			"leader":   c.Leaders,
+			"waited":   c.Waits,
+			"window":   c.WindowHits,
+			"overflow": c.Overflows,
+		})
+		b.WriteString("# HELP authz_coalesce_wait_seconds_sum Summed time callers waited for an identical call in flight.\n")
+		b.WriteString("# TYPE authz_coalesce_wait_seconds_sum counter\n")
+		fmt.Fprintf(&b, "authz_coalesce_wait_seconds_sum %g\n", c.waitTime.Seconds())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
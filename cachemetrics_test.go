@@ -0,0 +1,96 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheEfficiency(t *testing.T) {
+	dc, _ := newDecisionCache(CacheConfig{Enabled: true, MaxEntries: 2})
+	result := &keycloakResult{status: http.StatusOK}
+	dc.set("a", "alice", result)
+	dc.setUntil("b", "bob", result, time.Now().Add(-time.Second))
+	time.Sleep(10 * time.Millisecond)
+
+	dc.get("a")       // hit
+	dc.get("a")       // hit
+	dc.get("b")       // expired
+	dc.get("missing") // miss
+	dc.set("c", "carol", result)
+	dc.set("d", "dave", result) // full: evicts a live entry
+
+	e := dc.efficiency()
+	if e.Hits != 2 || e.Misses != 2 || e.Expirations != 1 || e.Evictions != 1 || e.HitRatio != 0.5 {
+		t.Errorf("unexpected counters %+v", e)
+	}
+	if e.AvgHitAge < 10*time.Millisecond || e.AvgHitAge > time.Second {
+		t.Errorf("expected the average age at hit to reflect the entry age, got %s", e.AvgHitAge)
+	}
+}
+
+func TestCoalescingEfficiency(t *testing.T) {
+	c, _ := newCoalescer(CoalescingConfig{Enabled: true, Window: "1s"})
+	var calls int32
+	release := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	runConcurrently(c, 5, func() (*keycloakResult, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &keycloakResult{status: http.StatusOK}, nil
+	})
+	c.do("key", func() (*keycloakResult, error) { return &keycloakResult{}, nil })
+
+	e := c.efficiency()
+	if e.Leaders != 1 || e.Waits != 4 || e.WindowHits != 1 || e.Overflows != 0 {
+		t.Errorf("unexpected counters %+v", e)
+	}
+	if e.AvgWait <= 0 {
+		t.Errorf("expected a wait time, got %s", e.AvgWait)
+	}
+}
+
+func TestCacheEfficiencyAdmin(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		Admin:      AdminConfig{Path: "/.authz", Token: "admin-secret"},
+		Cache:      CacheConfig{Enabled: true},
+		Coalescing: CoalescingConfig{Enabled: true},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+	am.cache.set("a", "alice", &keycloakResult{status: http.StatusOK})
+	am.cache.get("a")
+
+	fetch := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/.authz"+path, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, recorder.Code)
+		}
+		return recorder.Body.String()
+	}
+	var e CacheEfficiency
+	if err := json.Unmarshal([]byte(fetch("/cache/efficiency")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Cache == nil || e.Cache.Hits != 1 || e.Coalescing == nil {
+		t.Errorf("unexpected efficiency %+v", e)
+	}
+	metrics := fetch("/metrics")
+	for _, expected := range []string{"authz_cache_hits_total 1\n", "authz_cache_misses_total 0\n", `authz_coalesce_calls_total{outcome="waited"} 0`} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %q in metrics:\n%s", expected, metrics)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CacheSeedEntry is one cached decision as exported by the admin dump API and loaded from a seed file.
+// Raw tokens and RPTs are never part of it; entries are keyed by token fingerprint.
+type CacheSeedEntry struct {
+	TokenFingerprint   string              `json:"tokenFingerprint"`
+	SubjectFingerprint string              `json:"subjectFingerprint"`
+	Permission         string              `json:"permission"`
+	Audience           string              `json:"audience,omitempty"`
+	Endpoint           string              `json:"endpoint,omitempty"` // rule keycloakURL override, if any
+	Status             int                 `json:"status"`             // Keycloak status: 200 granted, 403 denied
+	Error              string              `json:"error,omitempty"`
+	Granted            []GrantedPermission `json:"granted,omitempty"`
+	Expires            time.Time           `json:"expires"`
+}
+
+// cacheKey builds the decision cache key of a token fingerprint, permission, audience and, when
+// overridden by a rule, token endpoint
+func cacheKey(tokenFingerprint, permission, audience, endpoint string) string {
+	key := tokenFingerprint + "\x00" + permission + "\x00" + audience
+	if endpoint != "" {
+		key += "\x00" + endpoint
+	}
+	return key
+}
+
+// dump returns every live entry of the cache
+func (dc *decisionCache) dump() []CacheSeedEntry {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	now := time.Now()
+	entries := make([]CacheSeedEntry, 0, len(dc.entries))
+	for key, entry := range dc.entries {
+		if !now.Before(entry.expires) {
+			continue
+		}
+		parts := strings.SplitN(key, "\x00", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		endpoint := ""
+		if len(parts) == 4 {
+			endpoint = parts[3]
+		}
+		entries = append(entries, CacheSeedEntry{
+			TokenFingerprint:   parts[0],
+			SubjectFingerprint: entry.subject,
+			Permission:         parts[1],
+			Audience:           parts[2],
+			Endpoint:           endpoint,
+			Status:             entry.result.status,
+			Error:              entry.result.errorCode,
+			Granted:            entry.result.granted,
+			Expires:            entry.expires,
+		})
+	}
+	return entries
+}
+
+// seed loads entries into the cache and returns how many were accepted. Expired or non-definitive
+// entries are skipped, and no entry outlives the configured TTL.
+func (dc *decisionCache) seed(entries []CacheSeedEntry) int {
+	now := time.Now()
+	maxExpires := now.Add(dc.ttl)
+	n := 0
+	for _, e := range entries {
+		result := &keycloakResult{status: e.Status, errorCode: e.Error, granted: e.Granted}
+		if e.TokenFingerprint == "" || e.Permission == "" || !cacheable(result) || !now.Before(e.Expires) {
+			continue
+		}
+		expires := e.Expires
+		if expires.After(maxExpires) {
+			expires = maxExpires
+		}
+		dc.setUntil(cacheKey(e.TokenFingerprint, e.Permission, e.Audience, e.Endpoint), e.SubjectFingerprint, result, expires)
+		n++
+	}
+	return n
+}
+
+// loadCacheSeed reads a seed file written from the admin dump API
+func loadCacheSeed(path string) ([]CacheSeedEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheSeedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing cache seed %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// DumpCache returns the live decision cache entries, in the format accepted by cache.seedFile
+func (am *AuthMiddleware) DumpCache() []CacheSeedEntry {
+	if am.cache == nil {
+		return []CacheSeedEntry{}
+	}
+	return am.cache.dump()
+}
@@ -0,0 +1,89 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheDumpSeedsAnotherInstance(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer healthy.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL: healthy.URL,
+		Cache:       CacheConfig{Enabled: true, TTL: "1m"},
+		Admin:       AdminConfig{Path: "/.authz", Token: "admin-secret"},
+	}
+	first, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := jwtWithClaims(`{"sub":"alice"}`)
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	first.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/.authz/cache", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	recorder := httptest.NewRecorder()
+	first.ServeHTTP(recorder, req)
+	var dump []CacheSeedEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if len(dump) != 1 || dump[0].TokenFingerprint != tokenFingerprint(alice) || dump[0].SubjectFingerprint != SubjectFingerprint("alice") {
+		t.Fatalf("unexpected dump: %+v", dump)
+	}
+
+	seedFile := filepath.Join(t.TempDir(), "seed.json")
+	if err := os.WriteFile(seedFile, recorder.Body.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second instance answers from the seed while Keycloak is down
+	down := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	config = &Config{KeycloakURL: down.URL, Cache: CacheConfig{Enabled: true, TTL: "1m", SeedFile: seedFile}}
+	second, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	recorder = httptest.NewRecorder()
+	second.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected seeded decision to be used, got %d", recorder.Code)
+	}
+}
+
+func TestCacheSeedSkipsStaleEntries(t *testing.T) {
+	cache, _ := newDecisionCache(CacheConfig{Enabled: true, TTL: "10s"})
+	now := time.Now()
+	n := cache.seed([]CacheSeedEntry{
+		{TokenFingerprint: "a", Permission: "/user#get", Status: http.StatusOK, Expires: now.Add(time.Hour)},
+		{TokenFingerprint: "b", Permission: "/user#get", Status: http.StatusOK, Expires: now.Add(-time.Second)},
+		{TokenFingerprint: "c", Permission: "/user#get", Status: http.StatusInternalServerError, Expires: now.Add(time.Hour)},
+		{Permission: "/user#get", Status: http.StatusForbidden, Expires: now.Add(time.Hour)},
+	})
+	if n != 1 {
+		t.Fatalf("expected 1 seeded entry, got %d", n)
+	}
+	// Seeded entries never outlive the configured TTL
+	if dump := cache.dump(); len(dump) != 1 || dump[0].Expires.After(now.Add(11*time.Second)) {
+		t.Errorf("unexpected entries: %+v", dump)
+	}
+}
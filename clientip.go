@@ -0,0 +1,78 @@
+package authztraefikgateway
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Headers announcing the client IP through proxies
+const (
+	forwardedForHeader = "X-Forwarded-For"
+	realIPHeader       = "X-Real-Ip"
+)
+
+// clientIP returns the IP of the client that originated the request. Forwarding headers are only
+// believed when the direct peer is a trusted proxy: X-Forwarded-For is then walked from right to
+// left, skipping trusted proxies, and the first other address is the client. If every hop is a
+// trusted proxy the leftmost one is the client, and a malformed hop stops the walk at the last
+// trusted address. X-Real-Ip is used when a trusted peer sends no X-Forwarded-For.
+func (am *AuthMiddleware) clientIP(req *http.Request) net.IP {
+	return resolveClientIP(req, am.trustedProxies)
+}
+
+// resolveClientIP implements clientIP for a list of trusted proxies
+func resolveClientIP(req *http.Request, trustedProxies []*net.IPNet) net.IP {
+	ip := remoteIP(req)
+	if len(trustedProxies) == 0 || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+	hops := forwardedFor(req.Header)
+	if len(hops) == 0 {
+		if realIP := parseHop(req.Header.Get(realIPHeader)); realIP != nil {
+			return realIP
+		}
+		return ip
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !containsIP(trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// forwardedFor returns the hops of every X-Forwarded-For header, in order
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values(forwardedForHeader) {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address of a forwarding header, with or without port
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+// clientIPString renders an IP for logs and headers, "" when unknown
+func clientIPString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
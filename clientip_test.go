@@ -0,0 +1,100 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		expected   string
+	}{
+		{"untrusted peer ignores headers", "192.0.2.1:4000", []string{"198.51.100.7"}, "198.51.100.8", "192.0.2.1"},
+		{"first untrusted hop from the right", "10.0.0.1:4000", []string{"203.0.113.5, 198.51.100.7, 10.0.0.2"}, "", "198.51.100.7"},
+		{"several headers", "10.0.0.1:4000", []string{"203.0.113.5", "198.51.100.7"}, "", "198.51.100.7"},
+		{"all hops trusted", "10.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"malformed hop", "10.0.0.1:4000", []string{"203.0.113.5, bogus, 10.0.0.2"}, "", "10.0.0.2"},
+		{"hop with port", "10.0.0.1:4000", []string{"198.51.100.7:5555"}, "", "198.51.100.7"},
+		{"ipv6 hop", "[2001:db8::1]:4000", []string{"[2001:db8::7]:5555"}, "", "2001:db8::7"},
+		{"real ip", "10.0.0.1:4000", nil, "198.51.100.9", "198.51.100.9"},
+		{"no headers", "10.0.0.1:4000", nil, "", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if test.realIP != "" {
+				req.Header.Set("X-Real-Ip", test.realIP)
+			}
+			if got := clientIPString(resolveClientIP(req, trusted)); got != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesAppliedConsistently(t *testing.T) {
+	var claims map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		raw, _ := base64.RawURLEncoding.DecodeString(req.PostForm.Get("claim_token"))
+		claims = nil
+		_ = json.Unmarshal(raw, &claims)
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var clientHeader string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { clientHeader = req.Header.Get("X-Authz-Client-Ip") })
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		DenyReasonHeader: "X-Authz-Reason",
+		TrustedProxies:   []string{"10.0.0.0/8"},
+		ClientIPClaim:    "client_ip",
+		RateLimitTags:    RateLimitTagsConfig{ClientIPHeader: "X-Authz-Client-Ip"},
+		DenyRules:        []DenyRule{{Prefix: "/admin", ExceptIPs: []string{"198.51.100.0/24"}}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFrom := func(path, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.RemoteAddr = "10.0.0.1:4000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Authz-Client-Ip", "spoofed")
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := serveFrom("/admin/api/user/get", "198.51.100.7"); recorder.Code != http.StatusOK {
+		t.Errorf("expected the forwarded client to be exempt from the deny rule, got %d", recorder.Code)
+	}
+	if clientHeader != "198.51.100.7" {
+		t.Errorf("expected the client IP header to carry the resolved client, got %q", clientHeader)
+	}
+	if got := claims["client_ip"]; len(got) != 1 || got[0] != "198.51.100.7" {
+		t.Errorf("expected the client IP to be pushed as claim, got %v", claims)
+	}
+	if recorder := serveFrom("/admin/api/user/get", "203.0.113.5"); recorder.Header().Get("X-Authz-Reason") != ReasonDeniedByRule {
+		t.Errorf("expected another client to be denied by rule, got %d", recorder.Code)
+	}
+}
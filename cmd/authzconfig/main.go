@@ -0,0 +1,284 @@
+// Command authzconfig prints the JSON Schema of the plugin configuration, validates configuration
+// files before deployment, migrates them off deprecated keys and replays audit logs against them.
+//
+//	authzconfig schema
+//	authzconfig validate [-plugin authztraefikgateway] config.yaml
+//	authzconfig migrate [-plugin authztraefikgateway] config.yaml
+//	authzconfig replay [-plugin authztraefikgateway] [-middleware name] [-tokens tokens.json] [-live] [-all] config.yaml audit.jsonl
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	authz "github.com/momayyez/authztraefikgateway"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "schema":
+		out, err := json.MarshalIndent(authz.ConfigSchema(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	case "validate":
+		os.Exit(validate(os.Args[2:]))
+	case "migrate":
+		os.Exit(migrate(os.Args[2:]))
+	case "replay":
+		os.Exit(replay(os.Args[2:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: authzconfig schema | authzconfig validate [-plugin name] <config.yaml|config.json>")
+	fmt.Fprintln(os.Stderr, "       authzconfig migrate [-plugin name] <config.yaml|config.json>")
+	fmt.Fprintln(os.Stderr, "       authzconfig replay [-plugin name] [-middleware name] [-tokens tokens.json] [-live] [-all] <config.yaml|config.json> <audit.jsonl>")
+}
+
+// validate checks every plugin configuration found in a file and returns the process exit code
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	plugin := flags.String("plugin", "authztraefikgateway", "plugin name used under http.middlewares.<name>.plugin")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	configs, err := loadPluginConfigs(flags.Arg(0), *plugin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := false
+	for _, name := range names {
+		errs := authz.ValidateConfig(configs[name])
+		if len(errs) == 0 {
+			fmt.Printf("✅ %s: valid\n", name)
+			if _, deprecations, err := authz.MigrateConfig(configs[name]); err == nil {
+				for _, deprecation := range deprecations {
+					fmt.Printf("⚠️  %s: %s (see authzconfig migrate)\n", name, deprecation)
+				}
+			}
+			warnings, err := authz.LintConfig(configs[name])
+			if err != nil {
+				failed = true
+				fmt.Printf("❌ %s: %v\n", name, err)
+			}
+			for _, w := range warnings {
+				fmt.Printf("⚠️  %s: rule %q %s (%s)\n", name, w.Rule, w.Detail, w.Kind)
+			}
+			continue
+		}
+		failed = true
+		for _, err := range errs {
+			fmt.Printf("❌ %s: %v\n", name, err)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// migrate prints every plugin configuration found in a file with the deprecated flat keys moved into
+// their blocks, as JSON keyed by middleware name, and returns the process exit code
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	plugin := flags.String("plugin", "authztraefikgateway", "plugin name used under http.middlewares.<name>.plugin")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	configs, err := loadPluginConfigs(flags.Arg(0), *plugin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	migrated := make(map[string]map[string]interface{}, len(configs))
+	for name, config := range configs {
+		doc, deprecations, err := authz.MigrateConfig(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", name, err)
+			return 1
+		}
+		for _, deprecation := range deprecations {
+			fmt.Fprintf(os.Stderr, "⚠️  %s: %s\n", name, deprecation)
+		}
+		migrated[name] = doc
+	}
+	out, err := json.MarshalIndent(migrated, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
+
+// loadPluginConfigs reads the plugin configs of a Traefik dynamic configuration, keyed by middleware
+// name, or a bare plugin config keyed "config"
+func loadPluginConfigs(path, plugin string) (map[string]map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		doc, err = parseYAML(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	configs := findPluginConfigs(doc, plugin)
+	if len(configs) == 0 {
+		// Not a Traefik dynamic config: treat the whole document as the plugin config
+		root, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+		}
+		configs = map[string]map[string]interface{}{"config": root}
+	}
+	return configs, nil
+}
+
+// replay re-evaluates an audit log against a configuration, prints the requests whose decision would
+// change as JSON lines and returns the process exit code: 1 when any decision changed
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	plugin := flags.String("plugin", "authztraefikgateway", "plugin name used under http.middlewares.<name>.plugin")
+	middleware := flags.String("middleware", "", "middleware to replay against, when the file configures several")
+	tokensFile := flags.String("tokens", "", "JSON object mapping subject fingerprints to access tokens")
+	live := flags.Bool("live", false, "evaluate permissions with Keycloak for subjects with a token")
+	all := flags.Bool("all", false, "print every replayed record, not only changed ones")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+		return 2
+	}
+
+	config, err := loadConfig(flags.Arg(0), *plugin, *middleware)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	options := authz.ReplayOptions{Live: *live}
+	if *tokensFile != "" {
+		data, err := os.ReadFile(*tokensFile)
+		if err == nil {
+			err = json.Unmarshal(data, &options.Tokens)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *tokensFile, err)
+			return 2
+		}
+	}
+	file, err := os.Open(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer file.Close()
+	records, err := authz.ReadAuditRecords(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(1), err)
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := authz.New(ctx, http.NotFoundHandler(), config, "replay")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	results := handler.(*authz.AuthMiddleware).Replay(ctx, records, options)
+
+	changed := 0
+	encoder := json.NewEncoder(os.Stdout)
+	for _, result := range results {
+		if result.Changed {
+			changed++
+		}
+		if result.Changed || *all {
+			_ = encoder.Encode(result)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d replayed decisions changed\n", changed, len(results))
+	if changed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadConfig returns the plugin config of one middleware of a configuration file
+func loadConfig(path, plugin, middleware string) (*authz.Config, error) {
+	configs, err := loadPluginConfigs(path, plugin)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := configs[middleware]
+	if middleware == "" && len(configs) == 1 {
+		for _, only := range configs {
+			raw, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: select a middleware with -middleware", path)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	config := authz.CreateConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// findPluginConfigs returns the plugin configs of a Traefik dynamic configuration, keyed by middleware name
+func findPluginConfigs(doc interface{}, plugin string) map[string]map[string]interface{} {
+	configs := map[string]map[string]interface{}{}
+	root, _ := doc.(map[string]interface{})
+	httpSection, _ := root["http"].(map[string]interface{})
+	middlewares, _ := httpSection["middlewares"].(map[string]interface{})
+	for name, middleware := range middlewares {
+		mw, _ := middleware.(map[string]interface{})
+		plugins, _ := mw["plugin"].(map[string]interface{})
+		if config, ok := plugins[plugin].(map[string]interface{}); ok {
+			configs[name] = config
+		}
+	}
+	return configs
+}
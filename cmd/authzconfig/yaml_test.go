@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc := `
+# Traefik dynamic configuration
+http:
+  middlewares:
+    keycloak-authz:
+      plugin:
+        authztraefikgateway:
+          keycloakURL: "https://keycloak.local/realms/demo/protocol/openid-connect/token"
+          keycloakClientId: traefik-gateway-client # inline comment
+          resourceIndex: 3
+          umaTicketMode: false
+          tokenSources: []
+          staticPermissions:
+          - prefix: /health
+            resource: system
+            scope: 'read'
+          rules:
+            - prefix: /orders
+              methods: [GET, POST]
+`
+	value, err := parseYAML(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"keycloakURL":      "https://keycloak.local/realms/demo/protocol/openid-connect/token",
+		"keycloakClientId": "traefik-gateway-client",
+		"resourceIndex":    int64(3),
+		"umaTicketMode":    false,
+		"tokenSources":     []interface{}{},
+		"staticPermissions": []interface{}{
+			map[string]interface{}{"prefix": "/health", "resource": "system", "scope": "read"},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{"prefix": "/orders", "methods": []interface{}{"GET", "POST"}},
+		},
+	}
+	configs := findPluginConfigs(value, "authztraefikgateway")
+	if !reflect.DeepEqual(configs["keycloak-authz"], expected) {
+		t.Errorf("unexpected result:\n%#v", configs["keycloak-authz"])
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"a: 1\n   b: 2\n",
+		"a: 1\na: 2\n",
+		"just text\n",
+		"a: {b: 1}\n",
+	} {
+		if _, err := parseYAML(doc); err == nil {
+			t.Errorf("expected error for %q", doc)
+		}
+	}
+}
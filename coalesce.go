@@ -0,0 +1,144 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Behaviors when the leader of a coalesced Keycloak call fails
+const (
+	leaderFailureShare = "share" // waiters receive the leader's error
+	leaderFailureRetry = "retry" // waiters make their own call
+)
+
+// CoalescingConfig tunes deduplication of identical concurrent Keycloak evaluations
+type CoalescingConfig struct {
+	Enabled         bool   `json:"enabled,omitempty"`
+	Window          string `json:"window,omitempty"`          // how long a finished result is reused, e.g. "200ms" (default 0: in-flight only)
+	MaxWaiters      int    `json:"maxWaiters,omitempty"`      // waiters per key before callers bypass coalescing (0: unlimited)
+	OnLeaderFailure string `json:"onLeaderFailure,omitempty"` // "share" (default) or "retry"
+}
+
+// coalescedCall is a Keycloak evaluation shared by every caller with the same key
+type coalescedCall struct {
+	done    chan struct{}
+	result  *keycloakResult
+	err     error
+	waiters int
+	expires time.Time // zero while in flight
+}
+
+// coalescer deduplicates identical Keycloak evaluations in flight and within a short window
+type coalescer struct {
+	window         time.Duration
+	maxWaiters     int
+	retryOnFailure bool
+
+	mu       sync.Mutex
+	calls    map[string]*coalescedCall
+	counters coalesceCounters
+}
+
+// newCoalescer builds a coalescer from config; it returns nil when coalescing is disabled
+func newCoalescer(config CoalescingConfig) (*coalescer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	window, err := parseDurationOrDefault(config.Window, 0)
+	if err != nil {
+		return nil, fmt.Errorf("coalescing.window: %w", err)
+	}
+	if config.MaxWaiters < 0 {
+		return nil, fmt.Errorf("coalescing.maxWaiters must not be negative")
+	}
+
+	c := &coalescer{window: window, maxWaiters: config.MaxWaiters, calls: make(map[string]*coalescedCall)}
+	switch config.OnLeaderFailure {
+	case "", leaderFailureShare:
+	case leaderFailureRetry:
+		c.retryOnFailure = true
+	default:
+		return nil, fmt.Errorf("coalescing.onLeaderFailure: unknown behavior %q", config.OnLeaderFailure)
+	}
+	return c, nil
+}
+
+// do runs fn once for all concurrent callers with the same key. shared reports whether the
+// result came from another caller's call.
+func (c *coalescer) do(key string, fn func() (*keycloakResult, error)) (result *keycloakResult, shared bool, err error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		if !call.expires.IsZero() {
+			if time.Now().Before(call.expires) {
+				c.counters.windowHits++
+				c.mu.Unlock()
+				return call.result, true, nil
+			}
+			delete(c.calls, key)
+		} else if c.maxWaiters == 0 || call.waiters < c.maxWaiters {
+			call.waiters++
+			c.counters.waits++
+			c.mu.Unlock()
+			start := time.Now()
+			<-call.done
+			c.observeWait(time.Since(start))
+			if call.err != nil && (c.retryOnFailure || errors.Is(call.err, context.Canceled)) {
+				// The leader's failure may be specific to it (e.g. its client went away)
+				result, err = fn()
+				return result, false, err
+			}
+			return call.result, true, call.err
+		} else {
+			c.counters.overflows++
+			c.mu.Unlock()
+			result, err = fn()
+			return result, false, err
+		}
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.counters.leaders++
+	c.mu.Unlock()
+
+	call.result, call.err = fn()
+
+	c.mu.Lock()
+	if call.err == nil && c.window > 0 {
+		call.expires = time.Now().Add(c.window)
+		time.AfterFunc(c.window, func() { c.forget(key, call) })
+	} else {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.result, false, call.err
+}
+
+// forget removes an expired call unless it was already replaced
+func (c *coalescer) forget(key string, call *coalescedCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+}
+
+// evaluateCoalesced is evaluate behind the coalescing layer, when enabled
+func (am *AuthMiddleware) evaluateCoalesced(ctx context.Context, accessToken, fingerprint, permission, audience, endpoint string, claims map[string][]string) (*keycloakResult, error) {
+	if am.coalescer == nil {
+		return am.evaluateRetrying(ctx, accessToken, permission, audience, endpoint, claims)
+	}
+	key := cacheKey(fingerprint, permission, audience, endpoint)
+	result, shared, err := am.coalescer.do(key, func() (*keycloakResult, error) {
+		return am.evaluateRetrying(ctx, accessToken, permission, audience, endpoint, claims)
+	})
+	if shared {
+		am.log(logDebug, "🔁 [COALESCE] Reused Keycloak result for", permission)
+	}
+	return result, err
+}
@@ -0,0 +1,129 @@
+package authztraefikgateway
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runConcurrently starts n callers of c.do for the same key while the leader is blocked on release
+func runConcurrently(c *coalescer, n int, fn func() (*keycloakResult, error)) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = c.do("key", fn)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestCoalescerDeduplicates(t *testing.T) {
+	c, _ := newCoalescer(CoalescingConfig{Enabled: true})
+	var calls int32
+	release := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+	runConcurrently(c, 10, func() (*keycloakResult, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &keycloakResult{status: 200}, nil
+	})
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 call, got %d", n)
+	}
+
+	// Without a window the next call is not shared
+	c.do("key", func() (*keycloakResult, error) { atomic.AddInt32(&calls, 1); return &keycloakResult{}, nil })
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 calls, got %d", n)
+	}
+}
+
+func TestCoalescerWindow(t *testing.T) {
+	c, _ := newCoalescer(CoalescingConfig{Enabled: true, Window: "100ms"})
+	var calls int32
+	fn := func() (*keycloakResult, error) {
+		atomic.AddInt32(&calls, 1)
+		return &keycloakResult{status: 200}, nil
+	}
+
+	c.do("key", fn)
+	if _, shared, _ := c.do("key", fn); !shared {
+		t.Error("expected result within the window to be shared")
+	}
+	time.Sleep(150 * time.Millisecond)
+	c.do("key", fn)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 calls, got %d", n)
+	}
+}
+
+func TestCoalescerMaxWaiters(t *testing.T) {
+	c, _ := newCoalescer(CoalescingConfig{Enabled: true, MaxWaiters: 2})
+	var calls int32
+	release := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+	runConcurrently(c, 6, func() (*keycloakResult, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &keycloakResult{status: 200}, nil
+	})
+	// one leader + two waiters share a call, the three others bypass coalescing
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Errorf("expected 4 calls, got %d", n)
+	}
+}
+
+func TestCoalescerLeaderFailure(t *testing.T) {
+	for _, test := range []struct {
+		behavior string
+		failures int
+	}{
+		{leaderFailureShare, 5},
+		{leaderFailureRetry, 1},
+	} {
+		t.Run(test.behavior, func(t *testing.T) {
+			c, _ := newCoalescer(CoalescingConfig{Enabled: true, OnLeaderFailure: test.behavior})
+			var calls int32
+			release := make(chan struct{})
+			time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+			errs := runConcurrently(c, 5, func() (*keycloakResult, error) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					<-release
+					return nil, errors.New("leader failed")
+				}
+				return &keycloakResult{status: 200}, nil
+			})
+
+			failures := 0
+			for _, err := range errs {
+				if err != nil {
+					failures++
+				}
+			}
+			if failures != test.failures {
+				t.Errorf("expected %d failures, got %d", test.failures, failures)
+			}
+		})
+	}
+}
+
+func TestCoalescerValidation(t *testing.T) {
+	for _, config := range []CoalescingConfig{
+		{Enabled: true, Window: "soon"},
+		{Enabled: true, MaxWaiters: -1},
+		{Enabled: true, OnLeaderFailure: "panic"},
+	} {
+		if _, err := newCoalescer(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
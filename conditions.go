@@ -0,0 +1,78 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RuleCondition applies an expression (see expr.go) to the requests matching a rule, for conditions the
+// declarative matcher fields cannot express. When the expression holds, the request is denied with 403
+// or must also be granted an extra scope on the resolved resource.
+type RuleCondition struct {
+	When  string `json:"when"`            // e.g. `request.method == "DELETE" && token.claims.department != "finance"`
+	Deny  bool   `json:"deny,omitempty"`  // deny the request
+	Scope string `json:"scope,omitempty"` // require this scope too, e.g. "approve"
+}
+
+// compiledCondition is a RuleCondition with its expression parsed
+type compiledCondition struct {
+	when  *expression
+	deny  bool
+	scope string
+}
+
+// compileConditions parses the conditions of a rule
+func compileConditions(conditions []RuleCondition) ([]compiledCondition, error) {
+	compiled := make([]compiledCondition, 0, len(conditions))
+	for i, condition := range conditions {
+		scope := strings.TrimSpace(condition.Scope)
+		if condition.Deny == (scope != "") {
+			return nil, fmt.Errorf("conditions[%d]: exactly one of deny and scope is required", i)
+		}
+		when, err := compileExpression(condition.When)
+		if err != nil {
+			return nil, fmt.Errorf("conditions[%d]: %w", i, err)
+		}
+		compiled = append(compiled, compiledCondition{when: when, deny: condition.Deny, scope: scope})
+	}
+	return compiled, nil
+}
+
+// applyConditions evaluates the conditions of rule against the request: it denies the decision, or adds
+// the extra scopes to its permission. A condition that cannot be evaluated, e.g. comparing a string
+// claim with a number, applies. It returns false when the request is denied.
+func (am *AuthMiddleware) applyConditions(req *http.Request, rule *compiledRule, accessToken string, decision *Decision) bool {
+	env := newExprEnv(req, decision.ClientIP, accessToken, decision.claims)
+	for _, condition := range rule.conditions {
+		holds, err := condition.when.test(env)
+		if err != nil {
+			am.logf(logWarn, "⚠️  [CONDITION] Rule %s: could not evaluate %q, applying it: %v\n", rule.name, condition.when.source, err)
+			holds = true
+		}
+		if !holds {
+			continue
+		}
+		if condition.deny {
+			am.logf(logError, "❌ [CONDITION] Denied by condition %q of rule %s\n", condition.when.source, rule.name)
+			decision.deny(ReasonDeniedByCondition, http.StatusForbidden)
+			return false
+		}
+		am.logf(logDebug, "🔎 [CONDITION] Condition %q of rule %s requires scope %s\n", condition.when.source, rule.name, condition.scope)
+		decision.Permission.Scope = withScope(decision.Permission.Scope, condition.scope)
+	}
+	return true
+}
+
+// withScope adds scope to a comma-separated list of required scopes
+func withScope(scopes, scope string) string {
+	if scopes == "" {
+		return scope
+	}
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope {
+			return scopes
+		}
+	}
+	return scopes + "," + scope
+}
@@ -0,0 +1,190 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// KeycloakConfig groups the Keycloak connection settings, replacing the flat keycloakURL,
+// keycloakClientId and keycloakClientSecret keys
+type KeycloakConfig struct {
+	URL          string `json:"url,omitempty"`          // UMA token endpoint
+	ClientID     string `json:"clientId,omitempty"`     // default audience of permission evaluations
+	ClientSecret string `json:"clientSecret,omitempty"` // enables the plugin's own service token
+}
+
+// TLSConfig groups the TLS settings of outbound calls, replacing the flat verifyTLS and tlsEndpoints keys
+type TLSConfig struct {
+	Verify    *bool         `json:"verify,omitempty"`    // verify the Keycloak server certificate (default false)
+	Endpoints []EndpointTLS `json:"endpoints,omitempty"` // CA, client certificate and server name per host
+}
+
+// LoggingConfig groups the logging settings, replacing the flat logLevel key
+type LoggingConfig struct {
+	Level string `json:"level,omitempty"` // debug (default), info, warn, error or off
+}
+
+// deprecatedKey is a flat configuration key superseded by a key of a block
+type deprecatedKey struct {
+	legacy string
+	block  string
+	key    string
+}
+
+// deprecatedKeys lists the flat keys still accepted, in the order they are reported
+var deprecatedKeys = []deprecatedKey{
+	{"keycloakURL", "keycloak", "url"},
+	{"keycloakClientId", "keycloak", "clientId"},
+	{"keycloakClientSecret", "keycloak", "clientSecret"},
+	{"verifyTLS", "tls", "verify"},
+	{"tlsEndpoints", "tls", "endpoints"},
+	{"logLevel", "logging", "level"},
+}
+
+// message returns the deprecation warning of the key
+func (dk deprecatedKey) message() string {
+	return fmt.Sprintf("%s is deprecated, use %s.%s", dk.legacy, dk.block, dk.key)
+}
+
+// withStructuredKeys returns a copy of the config with the keycloak, tls and logging blocks merged into
+// the flat fields the middleware reads, and the warnings of the deprecated flat keys in use. Setting a
+// key both ways is accepted when the values agree and an error otherwise.
+func (c *Config) withStructuredKeys() (*Config, []string, error) {
+	merged := *c
+	var warnings []string
+	used := map[string]bool{
+		"keycloakURL":          c.KeycloakURL != "",
+		"keycloakClientId":     c.KeycloakClientId != "",
+		"keycloakClientSecret": c.KeycloakClientSecret != "",
+		"verifyTLS":            c.VerifyTLS,
+		"tlsEndpoints":         len(c.TLSEndpoints) > 0,
+		"logLevel":             c.LogLevel != "",
+	}
+	for _, dk := range deprecatedKeys {
+		if used[dk.legacy] {
+			warnings = append(warnings, dk.message())
+		}
+	}
+
+	merge := func(legacy *string, value, legacyName, name string) error {
+		if value == "" {
+			return nil
+		}
+		if *legacy != "" && *legacy != value {
+			return fmt.Errorf("%s and the deprecated %s disagree; remove %s", name, legacyName, legacyName)
+		}
+		*legacy = value
+		return nil
+	}
+	if err := merge(&merged.KeycloakURL, c.Keycloak.URL, "keycloakURL", "keycloak.url"); err != nil {
+		return nil, nil, err
+	}
+	if err := merge(&merged.KeycloakClientId, c.Keycloak.ClientID, "keycloakClientId", "keycloak.clientId"); err != nil {
+		return nil, nil, err
+	}
+	if err := merge(&merged.KeycloakClientSecret, c.Keycloak.ClientSecret, "keycloakClientSecret", "keycloak.clientSecret"); err != nil {
+		return nil, nil, err
+	}
+	if err := merge(&merged.LogLevel, c.Logging.Level, "logLevel", "logging.level"); err != nil {
+		return nil, nil, err
+	}
+	if c.TLS.Verify != nil {
+		if c.VerifyTLS && !*c.TLS.Verify {
+			return nil, nil, fmt.Errorf("tls.verify and the deprecated verifyTLS disagree; remove verifyTLS")
+		}
+		merged.VerifyTLS = *c.TLS.Verify
+	}
+	if len(c.TLS.Endpoints) > 0 {
+		if len(c.TLSEndpoints) > 0 && !reflect.DeepEqual(c.TLSEndpoints, c.TLS.Endpoints) {
+			return nil, nil, fmt.Errorf("tls.endpoints and the deprecated tlsEndpoints disagree; remove tlsEndpoints")
+		}
+		merged.TLSEndpoints = c.TLS.Endpoints
+	}
+	return &merged, warnings, nil
+}
+
+// MigrateConfig returns a copy of a decoded configuration document with the deprecated flat keys moved
+// into their blocks, and the deprecation warnings of the keys it moved. The document is not modified.
+func MigrateConfig(doc map[string]interface{}) (map[string]interface{}, []string, error) {
+	migrated := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		migrated[key] = value
+	}
+	var warnings []string
+	copied := map[string]bool{}
+	for _, dk := range deprecatedKeys {
+		value, ok := migrated[dk.legacy]
+		if !ok {
+			continue
+		}
+		warnings = append(warnings, dk.message())
+		block, _ := migrated[dk.block].(map[string]interface{})
+		if migrated[dk.block] != nil && block == nil {
+			return nil, nil, fmt.Errorf("%s must be an object", dk.block)
+		}
+		if !copied[dk.block] {
+			copiedBlock := make(map[string]interface{}, len(block)+1)
+			for key, v := range block {
+				copiedBlock[key] = v
+			}
+			block = copiedBlock
+			copied[dk.block] = true
+		}
+		if existing, ok := block[dk.key]; ok && !reflect.DeepEqual(existing, value) {
+			return nil, nil, fmt.Errorf("%s.%s and the deprecated %s disagree", dk.block, dk.key, dk.legacy)
+		}
+		block[dk.key] = value
+		migrated[dk.block] = block
+		delete(migrated, dk.legacy)
+	}
+	return migrated, warnings, nil
+}
+
+// migratedConfigJSON renders the config without deprecated keys, secrets redacted and unset blocks
+// omitted, for the migration hint logged at startup
+func migratedConfigJSON(config Config) (string, error) {
+	raw, err := json.Marshal(redactConfig(config))
+	if err != nil {
+		return "", err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return "", err
+	}
+	pruneEmptyObjects(doc)
+	migrated, _, err := MigrateConfig(doc)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(migrated)
+	return string(out), err
+}
+
+// pruneEmptyObjects removes the keys of empty objects, recursively, such as unset config blocks
+func pruneEmptyObjects(doc map[string]interface{}) {
+	for key, value := range doc {
+		if nested, ok := value.(map[string]interface{}); ok {
+			pruneEmptyObjects(nested)
+			if len(nested) == 0 {
+				delete(doc, key)
+			}
+		}
+	}
+}
+
+// logDeprecations prints the deprecated keys of a newly loaded configuration, followed by the
+// equivalent configuration without them
+func logDeprecations(name string, warnings []string, config Config) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Printf("⚠️  [CONFIG] %s: %s\n", name, strings.Join(warnings, "; "))
+	migrated, err := migratedConfigJSON(config)
+	if err != nil {
+		fmt.Printf("⚠️  [CONFIG] %s: could not render the migrated configuration: %v\n", name, err)
+		return
+	}
+	fmt.Printf("🔧 [CONFIG] %s: equivalent configuration without deprecated keys (secrets redacted): %s\n", name, migrated)
+}
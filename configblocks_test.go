@@ -0,0 +1,133 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStructuredKeys(t *testing.T) {
+	verify := true
+	config := &Config{
+		Keycloak: KeycloakConfig{URL: "https://keycloak/token", ClientID: "gateway", ClientSecret: "secret"},
+		TLS:      TLSConfig{Verify: &verify},
+		Logging:  LoggingConfig{Level: "warn"},
+	}
+	merged, warnings, err := config.withStructuredKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no deprecation warnings, got %v", warnings)
+	}
+	if merged.KeycloakURL != "https://keycloak/token" || merged.KeycloakClientId != "gateway" || merged.KeycloakClientSecret != "secret" || !merged.VerifyTLS || merged.LogLevel != "warn" {
+		t.Errorf("expected the blocks merged, got %+v", merged)
+	}
+	if config.KeycloakURL != "" {
+		t.Error("withStructuredKeys must not modify the config")
+	}
+
+	// Legacy keys keep working, with a warning each; agreeing duplicates are accepted
+	legacy := &Config{KeycloakURL: "https://keycloak/token", KeycloakClientId: "gateway", LogLevel: "warn", Keycloak: KeycloakConfig{URL: "https://keycloak/token"}}
+	merged, warnings, err = legacy.withStructuredKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"keycloakURL is deprecated, use keycloak.url", "keycloakClientId is deprecated, use keycloak.clientId", "logLevel is deprecated, use logging.level"}
+	if !reflect.DeepEqual(warnings, expected) || merged.KeycloakURL != "https://keycloak/token" {
+		t.Errorf("unexpected warnings %v for %+v", warnings, merged)
+	}
+
+	disabled := false
+	for _, conflicting := range []*Config{
+		{KeycloakURL: "https://a/token", Keycloak: KeycloakConfig{URL: "https://b/token"}},
+		{VerifyTLS: true, TLS: TLSConfig{Verify: &disabled}},
+		{TLSEndpoints: []EndpointTLS{{Host: "a"}}, TLS: TLSConfig{Endpoints: []EndpointTLS{{Host: "b"}}}},
+	} {
+		if _, _, err := conflicting.withStructuredKeys(); err == nil {
+			t.Errorf("expected conflicting keys to be rejected: %+v", conflicting)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	doc := map[string]interface{}{
+		"keycloakURL":      "https://keycloak/token",
+		"keycloakClientId": "gateway",
+		"verifyTLS":        true,
+		"logging":          map[string]interface{}{"level": "info"},
+		"cache":            map[string]interface{}{"enabled": true},
+	}
+	migrated, warnings, err := MigrateConfig(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"keycloak": map[string]interface{}{"url": "https://keycloak/token", "clientId": "gateway"},
+		"tls":      map[string]interface{}{"verify": true},
+		"logging":  map[string]interface{}{"level": "info"},
+		"cache":    map[string]interface{}{"enabled": true},
+	}
+	if !reflect.DeepEqual(migrated, expected) || len(warnings) != 3 {
+		t.Errorf("unexpected migration %v (%v)", migrated, warnings)
+	}
+	if _, ok := doc["keycloakURL"]; !ok {
+		t.Error("MigrateConfig must not modify the document")
+	}
+	if errs := ValidateConfig(migrated); len(errs) > 0 {
+		t.Errorf("expected the migrated config to be valid, got %v", errs)
+	}
+
+	doc["logLevel"] = "debug"
+	if _, _, err := MigrateConfig(doc); err == nil {
+		t.Error("expected a conflict between logLevel and logging.level")
+	}
+
+	// Secrets the structured keys hold are validated like the flat ones
+	structured := map[string]interface{}{
+		"keycloak": map[string]interface{}{"url": "https://keycloak/token", "clientSecret": "secret"},
+		"rules":    []interface{}{map[string]interface{}{"prefix": "/orders", "resolver": "uri"}},
+	}
+	if errs := ValidateConfig(structured); len(errs) > 0 {
+		t.Errorf("expected keycloak.clientSecret to satisfy the uri resolver, got %v", errs)
+	}
+}
+
+func TestMigratedConfigJSON(t *testing.T) {
+	out, err := migratedConfigJSON(Config{KeycloakURL: "https://keycloak/token", KeycloakClientSecret: "secret", Cache: CacheConfig{Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"keycloak": map[string]interface{}{"url": "https://keycloak/token", "clientSecret": redacted},
+		"cache":    map[string]interface{}{"enabled": true},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("expected %v, got %s", expected, out)
+	}
+}
+
+func TestStructuredKeysNew(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	config := &Config{Keycloak: KeycloakConfig{URL: srv.URL, ClientID: "gateway"}}
+	if recorder := serve(t, config, "/api/v1/user/get"); recorder.Code != http.StatusOK {
+		t.Errorf("expected keycloak.url to be used, got %d", recorder.Code)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicting := &Config{KeycloakURL: "https://other/token", Keycloak: KeycloakConfig{URL: srv.URL}}
+	if err := handler.(*AuthMiddleware).Reload(conflicting); err == nil || !strings.Contains(err.Error(), "keycloakURL") {
+		t.Errorf("expected the conflicting reload to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers a caller may use to announce its remaining time budget
+const (
+	requestTimeoutHeader = "X-Request-Timeout"
+	grpcTimeoutHeader    = "Grpc-Timeout"
+)
+
+// defaultTimeoutBudgetPercent is the share of the caller's budget the Keycloak call may use
+const defaultTimeoutBudgetPercent = 50
+
+// maxCallerTimeout caps a caller's announced budget, so the arithmetic on it cannot overflow
+const maxCallerTimeout = time.Hour
+
+// parseCIDRs parses a list of CIDRs or bare IPs
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// remoteIP returns the IP of the direct peer of the request
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// containsIP reports whether ip belongs to any of nets
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// callerTimeout returns the timeout for the Keycloak call derived from the caller's deadline header.
+// ok is false when the feature is disabled, the caller is not in requestTimeoutTrusted (which trusts
+// nobody when empty) or no valid header is present.
+func (am *AuthMiddleware) callerTimeout(req *http.Request) (time.Duration, bool) {
+	if !am.honorRequestTimeout || !containsIP(am.requestTimeoutTrusted, am.clientIP(req)) {
+		return 0, false
+	}
+
+	var budget time.Duration
+	var err error
+	if value := req.Header.Get(requestTimeoutHeader); value != "" {
+		budget, err = parseRequestTimeout(value)
+	} else if value := req.Header.Get(grpcTimeoutHeader); value != "" {
+		budget, err = parseGRPCTimeout(value)
+	} else {
+		return 0, false
+	}
+	if err != nil {
+		am.log(logWarn, "⚠️  [DEADLINE] Ignoring invalid caller timeout:", err)
+		return 0, false
+	}
+	return budget * time.Duration(am.timeoutBudgetPercent) / 100, true
+}
+
+// parseRequestTimeout parses an X-Request-Timeout value: a Go duration ("1.5s") or integer milliseconds,
+// capped at maxCallerTimeout
+func parseRequestTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if ms <= 0 {
+			return 0, fmt.Errorf("non-positive timeout %q", value)
+		}
+		return scaledTimeout(ms, time.Millisecond), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("non-positive timeout %q", value)
+	}
+	if d > maxCallerTimeout {
+		d = maxCallerTimeout
+	}
+	return d, nil
+}
+
+// scaledTimeout returns amount units, capped at maxCallerTimeout before multiplying
+func scaledTimeout(amount int64, unit time.Duration) time.Duration {
+	if amount > int64(maxCallerTimeout/unit) {
+		return maxCallerTimeout
+	}
+	return time.Duration(amount) * unit
+}
+
+// parseGRPCTimeout parses a grpc-timeout value such as "100m" (up to 8 digits followed by a unit),
+// capped at maxCallerTimeout
+func parseGRPCTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("invalid grpc-timeout unit in %q", value)
+	}
+	return scaledTimeout(amount, unit), nil
+}
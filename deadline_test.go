@@ -0,0 +1,132 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCallerTimeouts(t *testing.T) {
+	requestTimeouts := map[string]time.Duration{
+		"250":  250 * time.Millisecond,
+		"1.5s": 1500 * time.Millisecond,
+		"2m":   2 * time.Minute,
+	}
+	for value, expected := range requestTimeouts {
+		if got, err := parseRequestTimeout(value); err != nil || got != expected {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v; expected %v", value, got, err, expected)
+		}
+	}
+
+	grpcTimeouts := map[string]time.Duration{
+		"100m": 100 * time.Millisecond,
+		"3S":   3 * time.Second,
+		"1H":   time.Hour,
+		"500u": 500 * time.Microsecond,
+	}
+	for value, expected := range grpcTimeouts {
+		if got, err := parseGRPCTimeout(value); err != nil || got != expected {
+			t.Errorf("parseGRPCTimeout(%q) = %v, %v; expected %v", value, got, err, expected)
+		}
+	}
+
+	// Huge budgets are capped instead of overflowing
+	for value, got := range map[string]time.Duration{
+		"grpc":     mustDuration(parseGRPCTimeout("99999999H")),
+		"ms":       mustDuration(parseRequestTimeout("9223372036854775807")),
+		"duration": mustDuration(parseRequestTimeout("2000000h")),
+	} {
+		if got != maxCallerTimeout {
+			t.Errorf("%s: expected the budget capped at %v, got %v", value, maxCallerTimeout, got)
+		}
+	}
+
+	for _, value := range []string{"", "0", "-5", "soon"} {
+		if _, err := parseRequestTimeout(value); err == nil {
+			t.Errorf("parseRequestTimeout(%q): expected error", value)
+		}
+	}
+	for _, value := range []string{"", "m", "10x", "123456789S"} {
+		if _, err := parseGRPCTimeout(value); err == nil {
+			t.Errorf("parseGRPCTimeout(%q): expected error", value)
+		}
+	}
+}
+
+func TestCallerDeadline(t *testing.T) {
+	srv, _ := newBlockingKeycloakStub(t)
+
+	tests := []struct {
+		name     string
+		trusted  []string
+		header   string
+		value    string
+		expected int
+	}{
+		{"request timeout", []string{"192.0.2.0/24"}, requestTimeoutHeader, "100", http.StatusGatewayTimeout},
+		{"grpc timeout", []string{"192.0.2.1"}, grpcTimeoutHeader, "100m", http.StatusGatewayTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{KeycloakURL: srv.URL, HonorRequestTimeout: true, RequestTimeoutTrustedIPs: test.trusted}
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler, err := New(context.Background(), next, config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set(test.header, test.value)
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func mustDuration(d time.Duration, err error) time.Duration {
+	if err != nil {
+		return -1
+	}
+	return d
+}
+
+func TestCallerDeadlineUntrusted(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	// Without trusted callers the header is never honored
+	handler, err := New(context.Background(), next, &Config{HonorRequestTimeout: true}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyone := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	anyone.Header.Set(requestTimeoutHeader, "100")
+	if _, ok := handler.(*AuthMiddleware).callerTimeout(anyone); ok {
+		t.Error("expected an empty trusted list to trust nobody")
+	}
+
+	config := &Config{HonorRequestTimeout: true, RequestTimeoutTrustedIPs: []string{"10.0.0.1"}}
+	handler, err = New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set(requestTimeoutHeader, "100")
+	if _, ok := handler.(*AuthMiddleware).callerTimeout(req); ok {
+		t.Error("expected header from untrusted caller to be ignored")
+	}
+
+	req.RemoteAddr = "10.0.0.1:4321"
+	timeout, ok := handler.(*AuthMiddleware).callerTimeout(req)
+	if !ok || timeout != 50*time.Millisecond {
+		t.Errorf("expected 50ms budget for trusted caller, got %v (%v)", timeout, ok)
+	}
+}
@@ -0,0 +1,203 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Reason codes reported in a Decision. Every subsystem (logs, headers, error responses) uses this taxonomy.
+const (
+	ReasonGranted           = "granted"
+	ReasonBreakGlass        = "break_glass" // granted with a break-glass token, bypassing Keycloak
+	ReasonMissingToken      = "missing_token"
+	ReasonMalformedToken    = "malformed_token" // a JWT that does not decode, or a bearer header without a Bearer token
+	ReasonExpiredToken      = "expired_token"   // the token was rejected and its "exp" has passed
+	ReasonInvalidRequest    = "invalid_request" // no permission could be derived from the request
+	ReasonMisconfigured     = "misconfigured"
+	ReasonInvalidToken      = "invalid_token"    // Keycloak rejected the token (401)
+	ReasonAccessDenied      = "access_denied"    // Keycloak policy denied the permission
+	ReasonInvalidResource   = "invalid_resource" // the resource is not registered in Keycloak
+	ReasonInvalidScope      = "invalid_scope"    // the scope is not registered on the resource
+	ReasonIdPRejected       = "idp_rejected"     // any other Keycloak 4xx
+	ReasonIdPError          = "idp_error"        // Keycloak 5xx
+	ReasonNetworkError      = "network_error"
+	ReasonTimeout           = "timeout"
+	ReasonCanceled          = "canceled" // the client went away or the middleware is shutting down
+	ReasonExchangeFailed    = "token_exchange_failed"
+	ReasonTenantMismatch    = "tenant_mismatch"     // the token belongs to another tenant than the request
+	ReasonDeniedByRule      = "denied_by_rule"      // a denyRules entry blocked the request
+	ReasonTokenTooOld       = "token_too_old"       // the rule requires a more recent authentication
+	ReasonWrongTokenType    = "wrong_token_type"    // an ID, refresh or other non-access token was presented
+	ReasonIPNotAllowed      = "ip_not_allowed"      // the caller is not allowed on the entry point
+	ReasonNotEnforced       = "not_enforced"        // the policy enforcer configuration exempts the path
+	ReasonBypassed          = "bypassed"            // an unexpired bypass entry exempts the path
+	ReasonEnrichmentFailed  = "enrichment_failed"   // required subject attributes could not be fetched
+	ReasonScopeFallback     = "scope_fallback"      // granted by the token's OAuth scope, the resource being unregistered
+	ReasonDeniedByCondition = "denied_by_condition" // a rule condition denied the request
+)
+
+// backendKeycloak identifies the Keycloak UMA backend in a Decision
+const backendKeycloak = "keycloak"
+
+// Decision is the outcome of authorizing a single request
+type Decision struct {
+	Allowed            bool
+	Reason             string
+	Rule               string
+	Backend            string
+	EntryPoint         string // entry point override applied, if any
+	Permission         Permission
+	Audience           string
+	TokenFingerprint   string // base64url SHA-256 of the access token
+	SubjectFingerprint string // SubjectFingerprint of the "sub" claim (TokenFingerprint for opaque tokens)
+	Status             int    // status returned to the client; 0 when the request is forwarded
+	KeycloakStatus     int
+	KeycloakError      string // OAuth2 error code Keycloak returned, e.g. "invalid_scope"
+	KeycloakErrorInfo  string // error_description Keycloak returned, e.g. "One of the given scopes [x] is invalid"
+	Latency            time.Duration
+	Granted            []GrantedPermission
+	GrantedScopes      []string // "resource#scope" for every granted scope
+	FailureClass       string   // Failure* class of a failed authorization; empty for grants and policy denials
+	Tier               string   // rate-limit tier of the subject, when rateLimitTags is configured
+	ClientIP           string   // client IP, resolved through trustedProxies
+	InheritedFrom      string   // ancestor resource whose permission granted a hierarchical resource, if any
+	DeniedItems        []string // item IDs of a bulk request Keycloak did not grant
+
+	message       string              // response body overriding the status text
+	ticket        string              // UMA permission ticket for the challenge, if any
+	challenge     string              // WWW-Authenticate challenge other than UMA, if any
+	upstreamToken string              // exchanged token forwarded instead of the user token, if any
+	tokenSource   TokenExtractor      // extractor the user token was read from
+	body          *bufferedBody       // buffered request body, released once the request is served
+	claims        map[string][]string // claims pushed to Keycloak for a ForwardAuth identity
+	endpoint      string              // Keycloak token endpoint overriding keycloakURL, if any
+	flags         requestFlags        // behaviors toggled for this request by the request flags header
+	subject       string              // subject of the token or forwarded identity, "" for opaque tokens
+	pushed        map[string][]string // claims pushed to Keycloak in addition to claims (client IP, attributes)
+	scopesHeader  string              // response header listing the granted scopes, if the rule sets one
+}
+
+const decisionKey contextKey = "decision"
+
+// DecisionFromContext returns the Decision that allowed the current request, if any
+func DecisionFromContext(ctx context.Context) (Decision, bool) {
+	decision, ok := ctx.Value(decisionKey).(Decision)
+	return decision, ok
+}
+
+// deny fills in a denial with the given reason and client status
+func (d *Decision) deny(reason string, status int) {
+	d.Allowed = false
+	d.Reason = reason
+	d.Status = status
+	d.FailureClass = reasonFailureClass(reason)
+}
+
+// grantedScopes flattens granted permissions into "resource#scope" strings
+func grantedScopes(granted []GrantedPermission) []string {
+	var scopes []string
+	for _, gp := range granted {
+		name := gp.ResourceName
+		if name == "" {
+			name = gp.ResourceID
+		}
+		if len(gp.Scopes) == 0 {
+			scopes = append(scopes, name)
+			continue
+		}
+		for _, scope := range gp.Scopes {
+			scopes = append(scopes, name+"#"+scope)
+		}
+	}
+	return scopes
+}
+
+// setGrantedScopesHeader lists the granted scopes in the rule's response header. Without granted
+// permissions in the Keycloak response (decision mode), the evaluated permission is listed.
+func setGrantedScopesHeader(w http.ResponseWriter, d Decision) {
+	scopes := d.GrantedScopes
+	if len(scopes) == 0 {
+		evaluated := GrantedPermission{ResourceName: d.Permission.Resource}
+		if d.Permission.Scope != "" {
+			evaluated.Scopes = []string{d.Permission.Scope}
+		}
+		scopes = grantedScopes([]GrantedPermission{evaluated})
+	}
+	w.Header().Set(d.scopesHeader, strings.Join(scopes, ", "))
+}
+
+// keycloakReason classifies a non-200 Keycloak answer. Unknown resources and scopes are told apart
+// from policy denials by the error code, or by the description when the code is generic:
+//
+//	400 {"error":"invalid_resource","error_description":"Resource with id [x] does not exist."}
+//	400 {"error":"invalid_scope","error_description":"One of the given scopes [x] is invalid"}
+//	403 {"error":"access_denied","error_description":"not_authorized"}
+func keycloakReason(status int, errorCode, description string) string {
+	generic := errorCode == "" || errorCode == "invalid_request"
+	description = strings.ToLower(description)
+	switch {
+	case status == http.StatusUnauthorized:
+		return ReasonInvalidToken
+	case errorCode == "invalid_resource" || (generic && strings.HasPrefix(description, "resource") && strings.Contains(description, "does not exist")):
+		return ReasonInvalidResource
+	case errorCode == "invalid_scope" || (generic && strings.Contains(description, "scope") && strings.Contains(description, "invalid")):
+		return ReasonInvalidScope
+	case errorCode == "access_denied" || status == http.StatusForbidden:
+		return ReasonAccessDenied
+	case status >= 500:
+		return ReasonIdPError
+	default:
+		return ReasonIdPRejected
+	}
+}
+
+// failureReason classifies a failure mode of the Keycloak call
+func failureReason(mode string) string {
+	switch mode {
+	case failureTimeout:
+		return ReasonTimeout
+	case failureCanceled:
+		return ReasonCanceled
+	default:
+		return ReasonNetworkError
+	}
+}
+
+// logDecision writes a single summary line for a decision
+func (am *AuthMiddleware) logDecision(d Decision) {
+	if d.Allowed {
+		am.logf(logInfo, "✅ [DECISION] allowed reason=%s rule=%s permission=%s#%s backend=%s client=%s latency=%s\n",
+			d.Reason, d.Rule, d.Permission.Resource, d.Permission.Scope, d.Backend, d.ClientIP, d.Latency)
+		return
+	}
+	am.logf(logInfo, "❌ [DECISION] denied reason=%s class=%s status=%d rule=%s permission=%s#%s backend=%s keycloakStatus=%d keycloakError=%s keycloakErrorInfo=%q token=%s client=%s latency=%s\n",
+		d.Reason, d.FailureClass, d.Status, d.Rule, d.Permission.Resource, d.Permission.Scope, d.Backend, d.KeycloakStatus, d.KeycloakError, d.KeycloakErrorInfo, d.TokenFingerprint, d.ClientIP, d.Latency)
+}
+
+// writeDenial writes the error response for a denied decision
+func (am *AuthMiddleware) writeDenial(w http.ResponseWriter, req *http.Request, d Decision) {
+	am.setSecurityHeaders(w, req)
+	if am.denyReasonHeader != "" {
+		w.Header().Set(am.denyReasonHeader, d.Reason)
+	}
+	if am.fingerprintHeader != "" && d.TokenFingerprint != "" {
+		w.Header().Set(am.fingerprintHeader, d.TokenFingerprint)
+	}
+	if d.ticket != "" {
+		realm := am.realmURL(req.Context())
+		w.Header().Set("WWW-Authenticate", umaChallenge(realm, d.ticket))
+	} else if d.challenge != "" {
+		w.Header().Set("WWW-Authenticate", d.challenge)
+	}
+	if len(d.DeniedItems) > 0 {
+		writeBulkDenial(w, d)
+		return
+	}
+	if d.message != "" {
+		http.Error(w, d.message, d.Status)
+		return
+	}
+	writeStatus(w, d.Status)
+}
@@ -0,0 +1,224 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionReasons(t *testing.T) {
+	unexpired := jwtWithClaims(fmt.Sprintf(`{"sub":"alice","exp":%d}`, time.Now().Add(time.Hour).Unix()))
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		reason      string
+		accessToken string
+	}{
+		{"invalid token", http.StatusUnauthorized, `{"error":"invalid_grant"}`, ReasonInvalidToken, unexpired},
+		{"expired token", http.StatusUnauthorized, `{"error":"invalid_grant"}`, ReasonExpiredToken, token},
+		{"access denied", http.StatusForbidden, `{"error":"access_denied"}`, ReasonAccessDenied, token},
+		{"invalid resource", http.StatusBadRequest, `{"error":"invalid_resource"}`, ReasonInvalidResource, token},
+		{"invalid scope", http.StatusBadRequest, `{"error":"invalid_scope"}`, ReasonInvalidScope, token},
+		{"unknown resource by description", http.StatusBadRequest, `{"error":"invalid_request","error_description":"Resource with id [order] does not exist."}`, ReasonInvalidResource, token},
+		{"invalid scope by description", http.StatusBadRequest, `{"error_description":"One of the given scopes [purge] is invalid"}`, ReasonInvalidScope, token},
+		{"policy denial", http.StatusForbidden, `{"error":"access_denied","error_description":"not_authorized"}`, ReasonAccessDenied, token},
+		{"other 4xx", http.StatusBadRequest, `{"error":"invalid_request"}`, ReasonIdPRejected, token},
+		{"5xx", http.StatusBadGateway, ``, ReasonIdPError, token},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newKeycloakStub(t, test.status, test.body)
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL}, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+test.accessToken)
+			decision := handler.(*AuthMiddleware).authorize(context.Background(), req)
+
+			if decision.Allowed || decision.Reason != test.reason || decision.KeycloakStatus != test.status {
+				t.Errorf("expected denial with reason %q, got %+v", test.reason, decision)
+			}
+		})
+	}
+}
+
+func TestDebugErrors(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusBadRequest, `{"error":"invalid_scope","error_description":"One of the given scopes [purge] is invalid"}`)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	for _, debug := range []bool{false, true} {
+		handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL, DebugErrors: debug}, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		detailed := strings.Contains(recorder.Body.String(), "invalid_scope: One of the given scopes [purge] is invalid")
+		if detailed != debug {
+			t.Errorf("debugErrors=%v: unexpected body %q", debug, recorder.Body.String())
+		}
+	}
+}
+
+func TestDecisionLocalReasons(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, &Config{}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	if d := am.authorize(context.Background(), req); d.Reason != ReasonMissingToken || d.Status != http.StatusUnauthorized {
+		t.Errorf("expected missing_token/401, got %+v", d)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/short", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if d := am.authorize(context.Background(), req); d.Reason != ReasonInvalidRequest || d.Status != http.StatusBadRequest {
+		t.Errorf("expected invalid_request/400, got %+v", d)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if d := am.authorize(context.Background(), req); d.Reason != ReasonMisconfigured || d.Status != http.StatusInternalServerError {
+		t.Errorf("expected misconfigured/500, got %+v", d)
+	}
+}
+
+func TestDecisionInContext(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `[{"rsid":"1","rsname":"user","scopes":["get","list"]}]`)
+
+	var decision Decision
+	var found bool
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		decision, found = DecisionFromContext(req.Context())
+	})
+	config := &Config{KeycloakURL: srv.URL, ResponseMode: responseModePermissions}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !found || !decision.Allowed || decision.Reason != ReasonGranted || decision.Rule != "default" {
+		t.Fatalf("unexpected decision %+v", decision)
+	}
+	if expected := []string{"user#get", "user#list"}; !reflect.DeepEqual(decision.GrantedScopes, expected) {
+		t.Errorf("expected granted scopes %v, got %v", expected, decision.GrantedScopes)
+	}
+	if decision.Permission != (Permission{Resource: "user", Scope: "get"}) {
+		t.Errorf("unexpected permission %+v", decision.Permission)
+	}
+}
+
+func TestGrantedScopesHeader(t *testing.T) {
+	rpt := jwtWithClaims(`{"authorization":{"permissions":[{"rsid":"1","rsname":"user","scopes":["get","delete"]},{"rsid":"2","rsname":"order"}]}}`)
+	rptStub := newKeycloakStub(t, http.StatusOK, `{"access_token":"`+rpt+`"}`)
+	decisionStub := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	tests := []struct {
+		name     string
+		config   *Config
+		path     string
+		expected string
+	}{
+		{"rpt permissions", &Config{KeycloakURL: rptStub.URL}, "/api/v1/user/get", "user#get, user#delete, order"},
+		{"decision mode", &Config{KeycloakURL: decisionStub.URL, ResponseMode: responseModeDecision}, "/api/v1/user/get", "user#get"},
+		{"rule without header", &Config{KeycloakURL: rptStub.URL}, "/other/v1/user/get", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.Rules = []Rule{{Prefix: "/api/", GrantedScopesHeader: "X-Granted-Scopes"}}
+			handler, err := New(context.Background(), next, test.config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", recorder.Code)
+			}
+			if got := recorder.Header().Get("X-Granted-Scopes"); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestDenyReasonHeader(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusForbidden, `{"error":"access_denied"}`)
+	recorder := serve(t, &Config{KeycloakURL: srv.URL, DenyReasonHeader: "x-authz-reason"}, "/api/v1/user/get")
+	if got := recorder.Header().Get("X-Authz-Reason"); got != ReasonAccessDenied {
+		t.Errorf("expected reason header %q, got %q", ReasonAccessDenied, got)
+	}
+
+	srv = newKeycloakStub(t, http.StatusOK, `{}`)
+	recorder = serve(t, &Config{KeycloakURL: srv.URL, DenyReasonHeader: "X-Authz-Reason"}, "/api/v1/user/get")
+	if got := recorder.Header().Get("X-Authz-Reason"); got != "" {
+		t.Errorf("expected no reason header on allowed requests, got %q", got)
+	}
+}
+
+func TestScopeFallback(t *testing.T) {
+	unregistered := newKeycloakStub(t, http.StatusBadRequest, `{"error":"invalid_resource","error_description":"Resource with id [orders] does not exist."}`)
+	denied := newKeycloakStub(t, http.StatusForbidden, `{"error":"access_denied"}`)
+	scoped := jwtWithClaims(`{"sub":"alice","scope":"openid orders:read profile"}`)
+	unscoped := jwtWithClaims(`{"sub":"alice","scope":"openid profile"}`)
+
+	var decision Decision
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		decision, _ = DecisionFromContext(req.Context())
+	})
+	tests := []struct {
+		name     string
+		url      string
+		token    string
+		expected int
+		reason   string
+	}{
+		{"unregistered resource with scope", unregistered.URL, scoped, http.StatusOK, ReasonScopeFallback},
+		{"unregistered resource without scope", unregistered.URL, unscoped, http.StatusUnauthorized, ""},
+		{"policy denial", denied.URL, scoped, http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision = Decision{}
+			config := &Config{
+				KeycloakURL: test.url,
+				Rules:       []Rule{{Prefix: "/orders", Resolver: "static", Resource: "orders", Scope: "view", FallbackScope: "orders:read"}},
+			}
+			handler, err := New(context.Background(), next, config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/orders/1", nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if decision.Reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, decision.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DenyRule blocks matching requests with 403 before any token processing or Keycloak call
+type DenyRule struct {
+	Name            string   `json:"name,omitempty"`            // used in logs and Decision.Rule; defaults to the prefix
+	Prefix          string   `json:"prefix,omitempty"`          // e.g. "/internal/", matching "/internal" and below it; empty matches every path
+	CaseInsensitive bool     `json:"caseInsensitive,omitempty"` // match the prefix regardless of case, for upstreams ignoring it
+	Methods         []string `json:"methods,omitempty"`         // empty matches all methods; SAFE / MUTATING classes allowed
+	Hosts           []string `json:"hosts,omitempty"`           // request hosts (port ignored); empty matches all hosts
+	EntryPoints     []string `json:"entryPoints,omitempty"`     // entry points named in entryPointHeader; empty matches all
+	ExceptIPs       []string `json:"exceptIPs,omitempty"`       // callers (CIDRs or IPs) the rule does not apply to, e.g. internal networks
+}
+
+// compiledDenyRule is a DenyRule with its matchers prepared at load time
+type compiledDenyRule struct {
+	name            string
+	prefix          string // without the trailing "/", lower-cased when caseInsensitive
+	caseInsensitive bool
+	methods         *compiledRule // method matcher (empty prefix)
+	hosts           map[string]bool
+	entryPoints     map[string]bool
+	exceptIPs       []*net.IPNet
+}
+
+// compileDenyRules validates the deny rules; entryPointHeader names the entry points they may match
+func compileDenyRules(rules []DenyRule, entryPointHeader string) ([]compiledDenyRule, error) {
+	compiled := make([]compiledDenyRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Prefix == "" && len(rule.Methods) == 0 && len(rule.Hosts) == 0 {
+			return nil, fmt.Errorf("denyRules[%d]: prefix, methods or hosts is required", i)
+		}
+		exceptIPs, err := parseCIDRs(rule.ExceptIPs)
+		if err != nil {
+			return nil, fmt.Errorf("denyRules[%d]: %w", i, err)
+		}
+		dr := compiledDenyRule{
+			name:            rule.Name,
+			prefix:          strings.TrimSuffix(rule.Prefix, "/"),
+			caseInsensitive: rule.CaseInsensitive,
+			methods:         &compiledRule{},
+			exceptIPs:       exceptIPs,
+		}
+		if dr.name == "" {
+			dr.name = rule.Prefix
+		}
+		if dr.caseInsensitive {
+			dr.prefix = strings.ToLower(dr.prefix)
+		}
+		if len(rule.Methods) > 0 {
+			dr.methods.methods = make(map[string]bool, len(rule.Methods))
+			for _, method := range rule.Methods {
+				dr.methods.methods[strings.ToUpper(method)] = true
+			}
+		}
+		if len(rule.Hosts) > 0 {
+			dr.hosts = make(map[string]bool, len(rule.Hosts))
+			for _, host := range rule.Hosts {
+				dr.hosts[strings.ToLower(strings.TrimSpace(host))] = true
+			}
+		}
+		if len(rule.EntryPoints) > 0 {
+			if strings.TrimSpace(entryPointHeader) == "" {
+				return nil, fmt.Errorf("denyRules[%d]: entryPoints requires entryPointHeader", i)
+			}
+			dr.entryPoints = make(map[string]bool, len(rule.EntryPoints))
+			for _, entryPoint := range rule.EntryPoints {
+				dr.entryPoints[strings.TrimSpace(entryPoint)] = true
+			}
+		}
+		compiled = append(compiled, dr)
+	}
+	return compiled, nil
+}
+
+// matches reports whether the deny rule applies to the request from entryPoint. The prefix is matched
+// on whole segments against the path both as received and with dot segments resolved, so
+// "/public/../internal/x" is still blocked and "/internal-docs" is not.
+func (dr compiledDenyRule) matches(req *http.Request, clientIP net.IP, entryPoint string) bool {
+	requestPath := req.URL.Path
+	if dr.caseInsensitive {
+		requestPath = strings.ToLower(requestPath)
+	}
+	if !pathWithinPrefix(requestPath, dr.prefix) && !pathWithinPrefix(cleanPath(requestPath), dr.prefix) {
+		return false
+	}
+	if !dr.methods.matches(req) {
+		return false
+	}
+	if dr.entryPoints != nil && !dr.entryPoints[entryPoint] {
+		return false
+	}
+	if dr.hosts != nil {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !dr.hosts[strings.ToLower(host)] {
+			return false
+		}
+	}
+	return len(dr.exceptIPs) == 0 || !containsIP(dr.exceptIPs, clientIP)
+}
+
+// cleanPath resolves dot segments and duplicate slashes, keeping a trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// matchDenyRule returns the name of the first deny rule matching the request
+func (am *AuthMiddleware) matchDenyRule(req *http.Request, clientIP net.IP) (string, bool) {
+	entryPoint := ""
+	if am.entryPointHeader != "" {
+		entryPoint = strings.TrimSpace(req.Header.Get(am.entryPointHeader))
+	}
+	for _, rule := range am.runtimeFor(req.Context()).denyRules {
+		if rule.matches(req, clientIP, entryPoint) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
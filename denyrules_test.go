@@ -0,0 +1,96 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDenyRules(t *testing.T) {
+	keycloakCalled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keycloakCalled = true
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		DenyReasonHeader: "X-Authz-Reason",
+		EntryPointHeader: "X-Entrypoint",
+		DenyRules: []DenyRule{
+			{Name: "internal", Prefix: "/internal/", ExceptIPs: []string{"10.0.0.0/8"}},
+			{Prefix: "/api/v1/audit", Methods: []string{"mutating"}},
+			{Hosts: []string{"legacy.example.com"}},
+			{Prefix: "/Admin", CaseInsensitive: true},
+			{Prefix: "/metrics", EntryPoints: []string{"web", "websecure"}},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		remoteAddr string
+		entryPoint string
+		expected   int
+	}{
+		{"prefix", http.MethodGet, "http://gateway/internal/v1/user/get", "", "", http.StatusForbidden},
+		{"bare prefix", http.MethodGet, "http://gateway/internal", "", "", http.StatusForbidden},
+		{"sibling of prefix", http.MethodGet, "http://gateway/internal-docs/v1/user/get", "", "", http.StatusOK},
+		{"dot segments", http.MethodGet, "http://gateway/public/../internal/v1/user/get", "", "", http.StatusForbidden},
+		{"excepted caller", http.MethodGet, "http://gateway/internal/v1/user/get", "10.1.2.3:4000", "", http.StatusOK},
+		{"method class", http.MethodDelete, "http://gateway/api/v1/audit/delete", "", "", http.StatusForbidden},
+		{"other method", http.MethodGet, "http://gateway/api/v1/audit/get", "", "", http.StatusOK},
+		{"host", http.MethodGet, "http://legacy.example.com:8080/api/v1/user/get", "", "", http.StatusForbidden},
+		{"case-sensitive prefix", http.MethodGet, "http://gateway/INTERNAL/v1/user/get", "", "", http.StatusOK},
+		{"case-insensitive prefix", http.MethodGet, "http://gateway/ADMIN/v1/user/get", "", "", http.StatusForbidden},
+		{"external entry point", http.MethodGet, "http://gateway/metrics/v1/user/get", "", "websecure", http.StatusForbidden},
+		{"internal entry point", http.MethodGet, "http://gateway/metrics/v1/user/get", "", "internal", http.StatusOK},
+		{"no match", http.MethodGet, "http://gateway/api/v1/user/get", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keycloakCalled = false
+			req := httptest.NewRequest(test.method, test.target, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if test.remoteAddr != "" {
+				req.RemoteAddr = test.remoteAddr
+			}
+			if test.entryPoint != "" {
+				req.Header.Set("X-Entrypoint", test.entryPoint)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected == http.StatusForbidden {
+				if keycloakCalled {
+					t.Error("Keycloak must not be called for a blocked request")
+				}
+				if reason := recorder.Header().Get("X-Authz-Reason"); reason != ReasonDeniedByRule {
+					t.Errorf("expected reason %q, got %q", ReasonDeniedByRule, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestDenyRuleValidation(t *testing.T) {
+	for _, rules := range [][]DenyRule{
+		{{Name: "matches everything"}},
+		{{Prefix: "/internal", ExceptIPs: []string{"not-an-ip"}}},
+		{{Prefix: "/metrics", EntryPoints: []string{"web"}}},
+	} {
+		if _, err := compileDenyRules(rules, ""); err == nil {
+			t.Errorf("expected error for %+v", rules)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package authztraefikgateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the admin diagnostics listings
+const (
+	diagnosticsHistory      = 1000 // recent denials and events kept per instance
+	defaultDiagnosticsLimit = 100
+	maxDiagnosticsLimit     = 1000
+)
+
+// Kinds of resilience events. The middleware has no circuit breaker: the retry budget is what sheds
+// load from Keycloak during a brownout.
+const (
+	eventRetry                = "retry"
+	eventRetryBudgetExhausted = "retry_budget_exhausted"
+)
+
+// errInvalidCursor is returned for a cursor that was not issued by the same listing
+var errInvalidCursor = errors.New("invalid cursor")
+
+// DiagnosticsPage is one page of an admin diagnostics listing. NextCursor is empty on the last page.
+type DiagnosticsPage struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// DenialRecord is a recent denial, without raw tokens
+type DenialRecord struct {
+	Seq                uint64    `json:"seq"`
+	Time               time.Time `json:"time"`
+	Reason             string    `json:"reason"`
+	FailureClass       string    `json:"class,omitempty"`
+	Status             int       `json:"status"`
+	Rule               string    `json:"rule,omitempty"`
+	Permission         string    `json:"permission,omitempty"`
+	Backend            string    `json:"backend,omitempty"`
+	KeycloakStatus     int       `json:"keycloakStatus,omitempty"`
+	KeycloakError      string    `json:"keycloakError,omitempty"`
+	TokenFingerprint   string    `json:"tokenFingerprint,omitempty"`
+	SubjectFingerprint string    `json:"subjectFingerprint,omitempty"`
+	ClientIP           string    `json:"clientIP,omitempty"`
+}
+
+// EventRecord is a resilience event, such as an exhausted retry budget
+type EventRecord struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// diagnostics keeps the recent denials and events listed by the admin endpoint, newest first. Every
+// record gets a sequence number, so cursors stay valid while new records arrive.
+type diagnostics struct {
+	mu      sync.Mutex
+	seq     uint64
+	denials []DenialRecord
+	events  []EventRecord
+}
+
+// newDiagnostics returns the diagnostics history; it returns nil when the admin endpoint is disabled
+func newDiagnostics(admin AdminConfig) *diagnostics {
+	if admin.Path == "" {
+		return nil
+	}
+	return &diagnostics{}
+}
+
+// recordDenial adds a denied decision to the history
+func (dg *diagnostics) recordDenial(d Decision) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	dg.seq++
+	dg.denials = append(dg.denials, DenialRecord{
+		Seq:                dg.seq,
+		Time:               time.Now(),
+		Reason:             d.Reason,
+		FailureClass:       d.FailureClass,
+		Status:             d.Status,
+		Rule:               d.Rule,
+		Permission:         permissionString(d.Permission),
+		Backend:            d.Backend,
+		KeycloakStatus:     d.KeycloakStatus,
+		KeycloakError:      d.KeycloakError,
+		TokenFingerprint:   d.TokenFingerprint,
+		SubjectFingerprint: d.SubjectFingerprint,
+		ClientIP:           d.ClientIP,
+	})
+	if len(dg.denials) > diagnosticsHistory {
+		dg.denials = append([]DenialRecord(nil), dg.denials[len(dg.denials)-diagnosticsHistory:]...)
+	}
+}
+
+// recordEvent adds a resilience event to the history
+func (dg *diagnostics) recordEvent(kind, detail string) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	dg.seq++
+	dg.events = append(dg.events, EventRecord{Seq: dg.seq, Time: time.Now(), Kind: kind, Detail: detail})
+	if len(dg.events) > diagnosticsHistory {
+		dg.events = append([]EventRecord(nil), dg.events[len(dg.events)-diagnosticsHistory:]...)
+	}
+}
+
+// denialPage returns up to limit denials older than the cursor, newest first
+func (dg *diagnostics) denialPage(cursor string, limit int) (DiagnosticsPage, error) {
+	before, err := decodeSeqCursor("denials", cursor)
+	if err != nil {
+		return DiagnosticsPage{}, err
+	}
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	items := []DenialRecord{}
+	i := len(dg.denials) - 1
+	for ; i >= 0 && len(items) < limit; i-- {
+		if before == 0 || dg.denials[i].Seq < before {
+			items = append(items, dg.denials[i])
+		}
+	}
+	page := DiagnosticsPage{Items: items}
+	if i >= 0 && len(items) > 0 {
+		page.NextCursor = encodeCursor("denials", strconv.FormatUint(items[len(items)-1].Seq, 10))
+	}
+	return page, nil
+}
+
+// eventPage returns up to limit events older than the cursor, newest first
+func (dg *diagnostics) eventPage(cursor string, limit int) (DiagnosticsPage, error) {
+	before, err := decodeSeqCursor("events", cursor)
+	if err != nil {
+		return DiagnosticsPage{}, err
+	}
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	items := []EventRecord{}
+	i := len(dg.events) - 1
+	for ; i >= 0 && len(items) < limit; i-- {
+		if before == 0 || dg.events[i].Seq < before {
+			items = append(items, dg.events[i])
+		}
+	}
+	page := DiagnosticsPage{Items: items}
+	if i >= 0 && len(items) > 0 {
+		page.NextCursor = encodeCursor("events", strconv.FormatUint(items[len(items)-1].Seq, 10))
+	}
+	return page, nil
+}
+
+// cachePage returns up to limit live decision cache entries after the cursor, ordered by cache key.
+// Cursors hold the last key returned, so entries added or evicted meanwhile never shift the pages.
+func (am *AuthMiddleware) cachePage(cursor string, limit int) (DiagnosticsPage, error) {
+	after, err := decodeCursor("cache", cursor)
+	if err != nil {
+		return DiagnosticsPage{}, err
+	}
+	entries := am.DumpCache()
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = cacheKey(e.TokenFingerprint, e.Permission, e.Audience, e.Endpoint)
+	}
+	sort.Sort(cacheEntriesByKey{entries: entries, keys: keys})
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && cursor != "" && keys[start] == after {
+		start++
+	}
+	end := start + limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	page := DiagnosticsPage{Items: entries[start:end]}
+	if end < len(entries) && end > start {
+		page.NextCursor = encodeCursor("cache", keys[end-1])
+	}
+	return page, nil
+}
+
+// cacheEntriesByKey sorts dumped cache entries together with their keys
+type cacheEntriesByKey struct {
+	entries []CacheSeedEntry
+	keys    []string
+}
+
+func (s cacheEntriesByKey) Len() int           { return len(s.keys) }
+func (s cacheEntriesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s cacheEntriesByKey) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// encodeCursor returns an opaque cursor of a listing
+func encodeCursor(listing, position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(listing + ":" + position))
+}
+
+// decodeCursor returns the position of a cursor issued by the listing; "" starts from the beginning
+func decodeCursor(listing, cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), listing+":") {
+		return "", errInvalidCursor
+	}
+	return strings.TrimPrefix(string(raw), listing+":"), nil
+}
+
+// decodeSeqCursor returns the sequence number of a cursor issued by the listing; 0 starts from the newest
+func decodeSeqCursor(listing, cursor string) (uint64, error) {
+	position, err := decodeCursor(listing, cursor)
+	if err != nil || position == "" {
+		return 0, err
+	}
+	seq, err := strconv.ParseUint(position, 10, 64)
+	if err != nil || seq == 0 {
+		return 0, errInvalidCursor
+	}
+	return seq, nil
+}
+
+// serveDiagnostics serves a page of the cache, denials, events or rule warnings listing
+func (am *AuthMiddleware) serveDiagnostics(w http.ResponseWriter, req *http.Request, listing string) {
+	if req.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultDiagnosticsLimit
+	if value := req.FormValue("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > maxDiagnosticsLimit {
+			n = maxDiagnosticsLimit
+		}
+		limit = n
+	}
+	cursor := req.FormValue("cursor")
+
+	var page DiagnosticsPage
+	var err error
+	switch listing {
+	case "cache":
+		page, err = am.cachePage(cursor, limit)
+	case "denials":
+		page, err = am.diagnostics.denialPage(cursor, limit)
+	case "events":
+		page, err = am.diagnostics.eventPage(cursor, limit)
+	case "rules":
+		page, err = am.ruleWarningPage(cursor, limit)
+	default:
+		writeStatus(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, page)
+}
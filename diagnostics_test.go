@@ -0,0 +1,114 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsPaging(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		Cache: CacheConfig{Enabled: true},
+		Admin: AdminConfig{Path: "/.authz", Token: "admin-secret"},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+	for i := 0; i < 5; i++ {
+		am.cache.set(cacheKey(fmt.Sprintf("token-%d", i), "/user#get", "", ""), "subject", &keycloakResult{status: http.StatusOK})
+	}
+	// Denials are recorded as requests are served
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil))
+	}
+	am.diagnostics.recordEvent(eventRetryBudgetExhausted, "/user#get")
+
+	fetch := func(listing, cursor string, limit int) (int, []json.RawMessage, string) {
+		query := url.Values{"limit": {fmt.Sprint(limit)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/.authz/diagnostics/"+listing+"?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		var page struct {
+			Items      []json.RawMessage `json:"items"`
+			NextCursor string            `json:"nextCursor"`
+		}
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder.Code, page.Items, page.NextCursor
+	}
+
+	// Pages of the cache cover every entry once, even when an entry is added between pages
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		status, items, nextCursor := fetch("cache", cursor, 2)
+		if status != http.StatusOK || pages > 5 {
+			t.Fatalf("cache page %d: status %d", pages, status)
+		}
+		for _, item := range items {
+			var entry CacheSeedEntry
+			_ = json.Unmarshal(item, &entry)
+			if seen[entry.TokenFingerprint] {
+				t.Errorf("entry %s listed twice", entry.TokenFingerprint)
+			}
+			seen[entry.TokenFingerprint] = true
+		}
+		if pages == 0 {
+			am.cache.set(cacheKey("token-0a", "/user#get", "", ""), "subject", &keycloakResult{status: http.StatusOK})
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected the 5 entries listed before the cursor moved on, got %v", seen)
+	}
+
+	// Denials come newest first
+	_, items, cursor := fetch("denials", "", 2)
+	if len(items) != 2 || cursor == "" {
+		t.Fatalf("expected a first page of 2 denials with a cursor, got %d %q", len(items), cursor)
+	}
+	var denial DenialRecord
+	_ = json.Unmarshal(items[0], &denial)
+	if denial.Reason != ReasonMissingToken || denial.ClientIP != "192.0.2.1" || time.Since(denial.Time) > time.Minute {
+		t.Errorf("unexpected newest denial %+v", denial)
+	}
+	_, items, cursor = fetch("denials", cursor, 2)
+	if len(items) != 1 || cursor != "" {
+		t.Errorf("expected a last page of 1 denial, got %d %q", len(items), cursor)
+	}
+
+	_, items, _ = fetch("events", "", 10)
+	var event EventRecord
+	if len(items) == 1 {
+		_ = json.Unmarshal(items[0], &event)
+	}
+	if event.Kind != eventRetryBudgetExhausted {
+		t.Errorf("expected a retry budget event, got %+v", event)
+	}
+
+	// Cursors belong to their listing
+	if status, _, _ := fetch("events", encodeCursor("cache", "x"), 10); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a foreign cursor, got %d", status)
+	}
+	if status, _, _ := fetch("denials", "", 0); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid limit, got %d", status)
+	}
+}
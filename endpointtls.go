@@ -0,0 +1,122 @@
+package authztraefikgateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// EndpointTLS sets the TLS parameters of the outbound calls to one host, for IdPs and authorizers
+// behind different internal CAs or requiring client certificates
+type EndpointTLS struct {
+	Host       string `json:"host"`                 // host or host:port of the endpoint URLs, e.g. "keycloak.internal:8443"
+	CAFile     string `json:"caFile,omitempty"`     // PEM bundle of the CAs trusted instead of the system pool
+	CertFile   string `json:"certFile,omitempty"`   // PEM client certificate, with keyFile
+	KeyFile    string `json:"keyFile,omitempty"`    // PEM private key of certFile
+	ServerName string `json:"serverName,omitempty"` // SNI and expected certificate name, when it differs from the host
+}
+
+// newEndpointTransports builds a transport per configured host. Endpoints with a CA are always
+// verified; others are verified when verifyTLS is set.
+func newEndpointTransports(verifyTLS bool, endpoints []EndpointTLS) (map[string]*http.Transport, error) {
+	byHost := make(map[string]*http.Transport, len(endpoints))
+	for i, endpoint := range endpoints {
+		host := strings.ToLower(strings.TrimSpace(endpoint.Host))
+		if host == "" {
+			return nil, fmt.Errorf("tlsEndpoints[%d]: host is required", i)
+		}
+		if _, ok := byHost[host]; ok {
+			return nil, fmt.Errorf("tlsEndpoints[%d]: duplicate host %q", i, host)
+		}
+		tlsConfig, err := endpointTLSConfig(verifyTLS, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("tlsEndpoints[%d] (%s): %w", i, host, err)
+		}
+		byHost[host] = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return byHost, nil
+}
+
+// endpointTransport routes requests to the transport with the TLS settings of their host, or to
+// fallback for hosts without settings
+type endpointTransport struct {
+	byHost   map[string]*http.Transport
+	fallback http.RoundTripper
+}
+
+// withEndpointTLS returns fallback, routing the hosts of byHost to their own transports
+func withEndpointTLS(byHost map[string]*http.Transport, fallback http.RoundTripper) http.RoundTripper {
+	if len(byHost) == 0 {
+		return fallback
+	}
+	return &endpointTransport{byHost: byHost, fallback: fallback}
+}
+
+// endpointTLSConfig loads the CA and client certificate of an endpoint
+func endpointTLSConfig(verifyTLS bool, endpoint EndpointTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !verifyTLS && endpoint.CAFile == "",
+		ServerName:         strings.TrimSpace(endpoint.ServerName),
+	}
+	if endpoint.CAFile != "" {
+		pem, err := os.ReadFile(endpoint.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("caFile: no PEM certificate in %s", endpoint.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (endpoint.CertFile == "") != (endpoint.KeyFile == "") {
+		return nil, fmt.Errorf("certFile and keyFile must be set together")
+	}
+	if endpoint.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(endpoint.CertFile, endpoint.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// RoundTrip implements http.RoundTripper. Settings for host:port take precedence over those for host.
+func (et *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	if transport, ok := et.byHost[host]; ok {
+		return transport.RoundTrip(req)
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if transport, ok := et.byHost[host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return et.fallback.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of every transport
+func (et *endpointTransport) CloseIdleConnections() {
+	for _, transport := range et.byHost {
+		transport.CloseIdleConnections()
+	}
+	if closer, ok := et.fallback.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// tlsEndpointsKey renders endpoint TLS settings comparably, for sharedKey
+func tlsEndpointsKey(endpoints []EndpointTLS) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(endpoints)
+	return string(data)
+}
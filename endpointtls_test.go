@@ -0,0 +1,71 @@
+package authztraefikgateway
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"result":true}`))
+	}))
+	t.Cleanup(srv.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	host := mustParseURL(t, srv.URL).Host
+
+	// The test server's certificate is not trusted by the system pool
+	config := &Config{KeycloakURL: srv.URL, VerifyTLS: true}
+	if rec := serve(t, config, "/api/v1/user/get"); rec.Code == http.StatusOK {
+		t.Fatalf("expected the untrusted certificate to fail, got %d", rec.Code)
+	}
+	config.TLSEndpoints = []EndpointTLS{{Host: host, CAFile: caFile}}
+	if rec := serve(t, config, "/api/v1/user/get"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the endpoint CA to be trusted, got %d", rec.Code)
+	}
+
+	// The server name is checked against the certificate, issued for example.com
+	for serverName, ok := range map[string]bool{"example.com": true, "other.internal": false} {
+		transports, err := newEndpointTransports(true, []EndpointTLS{{Host: strings.Split(host, ":")[0], CAFile: caFile, ServerName: serverName}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: withEndpointTLS(transports, http.DefaultTransport)}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != ok {
+			t.Errorf("server name %s: unexpected result %v", serverName, err)
+		}
+	}
+
+	for _, invalid := range [][]EndpointTLS{
+		{{CAFile: caFile}},
+		{{Host: host}, {Host: strings.ToUpper(host)}},
+		{{Host: host, KeyFile: caFile}},
+		{{Host: host, CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+	} {
+		if errs := (&Config{KeycloakURL: srv.URL, TLSEndpoints: invalid}).validate(); len(errs) == 0 {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,255 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of attribute enrichment
+const (
+	defaultEnrichmentTimeout    = 2 * time.Second
+	defaultEnrichmentCacheTTL   = 5 * time.Minute
+	defaultEnrichmentMaxEntries = 10000
+)
+
+// AttributeSource provides extra attributes about a subject, pushed to Keycloak as claims
+type AttributeSource interface {
+	Attributes(ctx context.Context, subject string) (map[string][]string, error)
+}
+
+// HTTPAttributeSource fetches attributes from an HTTP endpoint answering a JSON object. URL may contain
+// a "{subject}" placeholder; otherwise the subject is sent as the "subject" query parameter. String,
+// number and boolean values become single values, arrays of them multiple values; nested objects are
+// ignored.
+type HTTPAttributeSource struct {
+	URL     string
+	Headers map[string]string // e.g. an API key
+	Client  *http.Client
+}
+
+// Attributes implements AttributeSource
+func (s HTTPAttributeSource) Attributes(ctx context.Context, subject string) (map[string][]string, error) {
+	endpoint := strings.Replace(s.URL, "{subject}", url.PathEscape(subject), -1)
+	if endpoint == s.URL {
+		separator := "?"
+		if strings.Contains(endpoint, "?") {
+			separator = "&"
+		}
+		endpoint += separator + "subject=" + url.QueryEscape(subject)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("attribute source answered %s", resp.Status)
+	}
+	// The document is streamed from the capped body; nested objects are skipped without being decoded
+	attributes := make(map[string][]string)
+	err = decodeObject(json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)), func(name string) fieldDecoder {
+		return func(dec *json.Decoder) error {
+			values, err := attributeValues(dec)
+			if len(values) > 0 {
+				attributes[name] = values
+			}
+			return err
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("malformed attributes: %w", err)
+	}
+	return attributes, nil
+}
+
+// attributeValues decodes a scalar JSON value, or the scalars of an array, as strings
+func attributeValues(dec *json.Decoder) ([]string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		return nil, skipNested(dec)
+	case json.Delim('['):
+		var values []string
+		for dec.More() {
+			item, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if d, ok := item.(json.Delim); ok {
+				if d == '{' || d == '[' {
+					if err := skipNested(dec); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if s, ok := attributeValue(item); ok {
+				values = append(values, s)
+			}
+		}
+		_, err = dec.Token()
+		return values, err
+	}
+	if s, ok := attributeValue(token); ok {
+		return []string{s}, nil
+	}
+	return nil, nil
+}
+
+// attributeValue renders a scalar JSON token
+func attributeValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// EnrichmentConfig fetches attributes about the subject, e.g. account status or tenant plan, from an
+// HTTP endpoint and pushes them to Keycloak in the claim_token, so policies can use data Keycloak
+// doesn't hold. Decisions are then cached and coalesced per set of attributes.
+type EnrichmentConfig struct {
+	URL      string            `json:"url,omitempty"`      // e.g. "http://accounts/internal/subjects/{subject}/attributes"
+	Headers  map[string]string `json:"headers,omitempty"`  // sent with every lookup, e.g. an API key
+	Timeout  string            `json:"timeout,omitempty"`  // per lookup (default "2s")
+	CacheTTL string            `json:"cacheTTL,omitempty"` // how long attributes are reused per subject (default "5m")
+	Prefix   string            `json:"prefix,omitempty"`   // prepended to attribute names, e.g. "ext_"
+	Required bool              `json:"required,omitempty"` // deny with 502 when the lookup fails instead of omitting the attributes
+}
+
+// enrichmentEntry is a cached lookup result
+type enrichmentEntry struct {
+	attributes map[string][]string
+	expiresAt  time.Time
+}
+
+// enricher caches the attributes of an AttributeSource per subject
+type enricher struct {
+	source   AttributeSource
+	timeout  time.Duration
+	ttl      time.Duration
+	prefix   string
+	required bool
+
+	mu      sync.Mutex
+	entries map[string]enrichmentEntry
+}
+
+// newEnricher builds attribute enrichment calling the source through transport (nil for the default);
+// it returns nil when no URL is configured
+func newEnricher(config EnrichmentConfig, transport http.RoundTripper) (*enricher, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(strings.Replace(config.URL, "{subject}", "s", -1)); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("enrichment.url must be an absolute http(s) URL")
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultEnrichmentTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment.timeout: %w", err)
+	}
+	ttl, err := parseDurationOrDefault(config.CacheTTL, defaultEnrichmentCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment.cacheTTL: %w", err)
+	}
+	return &enricher{
+		source:   HTTPAttributeSource{URL: config.URL, Headers: config.Headers, Client: &http.Client{Transport: transport}},
+		timeout:  timeout,
+		ttl:      ttl,
+		prefix:   config.Prefix,
+		required: config.Required,
+		entries:  make(map[string]enrichmentEntry),
+	}, nil
+}
+
+// attributes returns the prefixed attributes of subject, from the cache when possible
+func (e *enricher) attributes(ctx context.Context, subject string) (map[string][]string, error) {
+	now := time.Now()
+	e.mu.Lock()
+	entry, ok := e.entries[subject]
+	e.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.attributes, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	fetched, err := e.source.Attributes(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	attributes := make(map[string][]string, len(fetched))
+	for name, values := range fetched {
+		attributes[e.prefix+name] = values
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.entries) >= defaultEnrichmentMaxEntries {
+		for s, entry := range e.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(e.entries, s)
+			}
+		}
+		if len(e.entries) >= defaultEnrichmentMaxEntries {
+			e.entries = make(map[string]enrichmentEntry)
+		}
+	}
+	e.entries[subject] = enrichmentEntry{attributes: attributes, expiresAt: now.Add(e.ttl)}
+	return attributes, nil
+}
+
+// pushClaim adds a claim pushed to Keycloak in addition to those of a ForwardAuth identity
+func (d *Decision) pushClaim(name string, values []string) {
+	if d.pushed == nil {
+		d.pushed = make(map[string][]string)
+	}
+	d.pushed[name] = values
+}
+
+// pushedClaims returns every claim pushed to Keycloak for the decision, and a fingerprint that
+// distinguishes the token together with the additionally pushed claims, since policies may depend on them
+func (d *Decision) pushedClaims() (string, map[string][]string) {
+	if len(d.pushed) == 0 {
+		return d.TokenFingerprint, d.claims
+	}
+	claims := make(map[string][]string, len(d.claims)+len(d.pushed))
+	for name, values := range d.claims {
+		claims[name] = values
+	}
+	names := make([]string, 0, len(d.pushed))
+	for name, values := range d.pushed {
+		claims[name] = values
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := d.TokenFingerprint
+	for _, name := range names {
+		key += "\x00" + name + "=" + strings.Join(d.pushed[name], "\x01")
+	}
+	return tokenFingerprint(key), claims
+}
@@ -0,0 +1,96 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttributeEnrichment(t *testing.T) {
+	var lookups int32
+	attributes := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		if req.URL.Path != "/subjects/alice" || req.Header.Get("X-Api-Key") != "k" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"status":"active","plan":"premium","seats":5,"regions":["eu","us"],"nested":{"x":1}}`))
+	}))
+	defer attributes.Close()
+
+	var claims map[string][]string
+	keycloak := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		raw, _ := base64.RawURLEncoding.DecodeString(req.PostForm.Get("claim_token"))
+		claims = nil
+		_ = json.Unmarshal(raw, &claims)
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer keycloak.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	newHandler := func(url string, required bool) http.Handler {
+		config := &Config{
+			KeycloakURL:      keycloak.URL,
+			DenyReasonHeader: "X-Authz-Reason",
+			Enrichment:       EnrichmentConfig{URL: url, Headers: map[string]string{"X-Api-Key": "k"}, Prefix: "ext_", Required: required},
+		}
+		handler, err := New(context.Background(), next, config, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	serveAs := func(handler http.Handler, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+jwtWithClaims(fmt.Sprintf(`{"sub":%q,"exp":%d}`, subject, exp)))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	handler := newHandler(attributes.URL+"/subjects/{subject}", true)
+	for i := 0; i < 2; i++ {
+		if recorder := serveAs(handler, "alice"); recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", recorder.Code)
+		}
+	}
+	expected := map[string][]string{"ext_status": {"active"}, "ext_plan": {"premium"}, "ext_seats": {"5"}, "ext_regions": {"eu", "us"}}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("expected pushed claims %v, got %v", expected, claims)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("expected attributes to be cached per subject, got %d lookups", n)
+	}
+
+	recorder := serveAs(handler, "bob")
+	if recorder.Code != http.StatusBadGateway || recorder.Header().Get("X-Authz-Reason") != ReasonEnrichmentFailed {
+		t.Errorf("expected a failed required lookup to deny with 502, got %d %s", recorder.Code, recorder.Header().Get("X-Authz-Reason"))
+	}
+	if recorder := serveAs(newHandler(attributes.URL+"/subjects/{subject}", false), "bob"); recorder.Code != http.StatusOK || claims != nil {
+		t.Errorf("expected an optional lookup failure to continue without claims, got %d %v", recorder.Code, claims)
+	}
+}
+
+func TestPushedClaimsFingerprint(t *testing.T) {
+	a := Decision{TokenFingerprint: "t"}
+	a.pushClaim("plan", []string{"premium"})
+	b := Decision{TokenFingerprint: "t"}
+	b.pushClaim("plan", []string{"free"})
+	fa, _ := a.pushedClaims()
+	fb, _ := b.pushedClaims()
+	if fa == fb || fa == "t" {
+		t.Errorf("expected decisions with different pushed claims to have distinct fingerprints, got %q and %q", fa, fb)
+	}
+	if f, claims := (&Decision{TokenFingerprint: "t"}).pushedClaims(); f != "t" || claims != nil {
+		t.Errorf("expected the token fingerprint without pushed claims, got %q %v", f, claims)
+	}
+}
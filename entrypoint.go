@@ -0,0 +1,118 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Entry point modes
+const (
+	entryPointModeUMA  = "uma"  // default: full Keycloak UMA evaluation
+	entryPointModeRBAC = "rbac" // local role check, no Keycloak call
+)
+
+// backendRBAC identifies the local role check in a Decision
+const backendRBAC = "rbac"
+
+// EntryPointConfig overrides the behavior for requests from one Traefik entry point
+type EntryPointConfig struct {
+	Mode       string   `json:"mode,omitempty"`       // "uma" (default) or "rbac"
+	Roles      []string `json:"roles,omitempty"`      // rbac: realm roles or "<client>:<role>" client roles, any of which grants access
+	AllowedIPs []string `json:"allowedIPs,omitempty"` // callers (CIDRs or IPs) allowed on this entry point; required for rbac
+}
+
+// compiledEntryPoint is a prepared EntryPointConfig
+type compiledEntryPoint struct {
+	name       string
+	rbac       bool
+	roles      map[string]bool
+	allowedIPs []*net.IPNet
+}
+
+// compileEntryPoints validates the entry point overrides
+func compileEntryPoints(header string, configs map[string]EntryPointConfig) (map[string]*compiledEntryPoint, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	if strings.TrimSpace(header) == "" {
+		return nil, fmt.Errorf("entryPoints requires entryPointHeader")
+	}
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	compiled := make(map[string]*compiledEntryPoint, len(configs))
+	for _, name := range names {
+		config := configs[name]
+		allowedIPs, err := parseCIDRs(config.AllowedIPs)
+		if err != nil {
+			return nil, fmt.Errorf("entryPoints.%s: %w", name, err)
+		}
+		ep := &compiledEntryPoint{name: name, allowedIPs: allowedIPs}
+		switch strings.ToLower(config.Mode) {
+		case "", entryPointModeUMA:
+		case entryPointModeRBAC:
+			// Tokens are not verified locally, so role checks are only safe for known callers
+			if len(allowedIPs) == 0 {
+				return nil, fmt.Errorf("entryPoints.%s: rbac mode requires allowedIPs", name)
+			}
+			if len(config.Roles) == 0 {
+				return nil, fmt.Errorf("entryPoints.%s: rbac mode requires roles", name)
+			}
+			ep.rbac = true
+			ep.roles = make(map[string]bool, len(config.Roles))
+			for _, role := range config.Roles {
+				ep.roles[role] = true
+			}
+		default:
+			return nil, fmt.Errorf("entryPoints.%s: invalid mode %q (expected uma or rbac)", name, config.Mode)
+		}
+		compiled[name] = ep
+	}
+	return compiled, nil
+}
+
+// entryPointFor returns the override for the entry point named in the trusted header, if any
+func (am *AuthMiddleware) entryPointFor(req *http.Request) *compiledEntryPoint {
+	if am.entryPoints == nil {
+		return nil
+	}
+	return am.entryPoints[strings.TrimSpace(req.Header.Get(am.entryPointHeader))]
+}
+
+// tokenRoles returns the realm roles and "<client>:<role>" client roles of a Keycloak access token
+func tokenRoles(accessToken string) []string {
+	var claims struct {
+		RealmAccess struct {
+			Roles []string `json:"roles"`
+		} `json:"realm_access"`
+		ResourceAccess map[string]struct {
+			Roles []string `json:"roles"`
+		} `json:"resource_access"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return nil
+	}
+	roles := append([]string(nil), claims.RealmAccess.Roles...)
+	for client, access := range claims.ResourceAccess {
+		for _, role := range access.Roles {
+			roles = append(roles, client+":"+role)
+		}
+	}
+	return roles
+}
+
+// hasAnyRole reports whether any of roles grants access on the entry point
+func (ep *compiledEntryPoint) hasAnyRole(roles []string) bool {
+	for _, role := range roles {
+		if ep.roles[role] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEntryPointOverrides(t *testing.T) {
+	keycloakCalled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keycloakCalled = true
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		DenyReasonHeader: "X-Authz-Reason",
+		EntryPointHeader: "X-Entrypoint",
+		EntryPoints: map[string]EntryPointConfig{
+			"internal": {Mode: "rbac", Roles: []string{"ops", "gateway:admin"}, AllowedIPs: []string{"10.0.0.0/8"}},
+			"external": {},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realmRole := jwtWithClaims(`{"sub":"alice","realm_access":{"roles":["ops"]}}`)
+	clientRole := jwtWithClaims(`{"sub":"bob","resource_access":{"gateway":{"roles":["admin"]}}}`)
+	noRole := jwtWithClaims(`{"sub":"carol","realm_access":{"roles":["user"]}}`)
+
+	tests := []struct {
+		name        string
+		entryPoint  string
+		remoteAddr  string
+		accessToken string
+		expected    int
+		reason      string
+		keycloak    bool
+	}{
+		{"rbac realm role", "internal", "10.1.2.3:4000", realmRole, http.StatusOK, "", false},
+		{"rbac client role", "internal", "10.1.2.3:4000", clientRole, http.StatusOK, "", false},
+		{"rbac missing role", "internal", "10.1.2.3:4000", noRole, http.StatusForbidden, ReasonAccessDenied, false},
+		{"caller not allowed", "internal", "192.0.2.1:4000", realmRole, http.StatusForbidden, ReasonIPNotAllowed, false},
+		{"uma entry point", "external", "192.0.2.1:4000", noRole, http.StatusOK, "", true},
+		{"unknown entry point", "other", "192.0.2.1:4000", noRole, http.StatusOK, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keycloakCalled = false
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+test.accessToken)
+			req.Header.Set("X-Entrypoint", test.entryPoint)
+			req.RemoteAddr = test.remoteAddr
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if keycloakCalled != test.keycloak {
+				t.Errorf("expected Keycloak called=%v, got %v", test.keycloak, keycloakCalled)
+			}
+			if reason := recorder.Header().Get("X-Authz-Reason"); reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+		})
+	}
+}
+
+func TestEntryPointValidation(t *testing.T) {
+	tests := []struct {
+		header  string
+		configs map[string]EntryPointConfig
+	}{
+		{"", map[string]EntryPointConfig{"internal": {}}},
+		{"X-Entrypoint", map[string]EntryPointConfig{"internal": {Mode: "rbac", Roles: []string{"ops"}}}},
+		{"X-Entrypoint", map[string]EntryPointConfig{"internal": {Mode: "rbac", AllowedIPs: []string{"10.0.0.0/8"}}}},
+		{"X-Entrypoint", map[string]EntryPointConfig{"internal": {Mode: "abac"}}},
+		{"X-Entrypoint", map[string]EntryPointConfig{"internal": {AllowedIPs: []string{"not-an-ip"}}}},
+	}
+	for _, test := range tests {
+		if _, err := compileEntryPoints(test.header, test.configs); err == nil {
+			t.Errorf("expected error for %+v", test.configs)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newExchangeStub starts a fake Keycloak granting every UMA request and answering token exchanges
+// with a token named after the requested audience and scopes
+func newExchangeStub(t *testing.T, exchangeStatus int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		switch req.PostForm.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:token-exchange":
+			if exchangeStatus != http.StatusOK {
+				rw.WriteHeader(exchangeStatus)
+				_, _ = rw.Write([]byte(`{"error":"access_denied"}`))
+				return
+			}
+			if req.PostForm.Get("subject_token") != token || req.PostForm.Get("client_secret") != "secret" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			exchanged := req.PostForm.Get("audience") + ":" + strings.ReplaceAll(req.PostForm.Get("scope"), " ", ",")
+			_, _ = rw.Write([]byte(`{"access_token":"` + exchanged + `"}`))
+		default:
+			_, _ = rw.Write([]byte(`{"access_token":"rpt"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTokenExchangePerRule(t *testing.T) {
+	srv := newExchangeStub(t, http.StatusOK)
+
+	var forwarded string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Get("Authorization")
+	})
+	config := &Config{
+		KeycloakURL:          srv.URL,
+		KeycloakClientId:     "gateway",
+		KeycloakClientSecret: "secret",
+		TokenExchange:        TokenExchangeConfig{Enabled: true, Audience: "default-api", Scopes: []string{"openid"}},
+		Rules: []Rule{
+			{Prefix: "/billing", Resolver: "static", Resource: "billing", Scope: "view", ExchangeAudience: "billing-api", ExchangeScopes: []string{"billing:read"}},
+			{Prefix: "/catalog", Resolver: "static", Resource: "catalog", Scope: "view", ExchangeAudience: "catalog-api"},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"/billing/invoices": "Bearer billing-api:billing:read",
+		"/catalog/items":    "Bearer catalog-api:openid",
+		"/api/v1/user/get":  "Bearer default-api:openid",
+	}
+	for path, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK || forwarded != expected {
+			t.Errorf("%s: expected %q forwarded, got %d / %q", path, expected, recorder.Code, forwarded)
+		}
+	}
+}
+
+func TestTokenExchangeFailure(t *testing.T) {
+	srv := newExchangeStub(t, http.StatusForbidden)
+	config := &Config{
+		KeycloakURL:          srv.URL,
+		KeycloakClientSecret: "secret",
+		TokenExchange:        TokenExchangeConfig{Enabled: true, Audience: "billing-api"},
+		DenyReasonHeader:     "X-Authz-Reason",
+	}
+	recorder := serve(t, config, "/api/v1/user/get")
+	if recorder.Code != http.StatusBadGateway || recorder.Header().Get("X-Authz-Reason") != ReasonExchangeFailed {
+		t.Errorf("expected 502 %s, got %d %q", ReasonExchangeFailed, recorder.Code, recorder.Header().Get("X-Authz-Reason"))
+	}
+}
+
+func TestTokenExchangeRemovesUserToken(t *testing.T) {
+	srv := newExchangeStub(t, http.StatusOK)
+
+	var forwarded *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req
+	})
+	config := &Config{
+		KeycloakURL:          srv.URL,
+		KeycloakClientSecret: "secret",
+		TokenSources:         []TokenSource{{Type: "cookie", Name: "session"}, {Type: "query", Name: "access_token"}, {Type: "header", Name: "X-Token"}},
+		TokenExchange:        TokenExchangeConfig{Enabled: true, Audience: "api"},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, prepare := range map[string]func(req *http.Request){
+		"cookie": func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+			req.AddCookie(&http.Cookie{Name: "session", Value: token})
+		},
+		"query":  func(req *http.Request) { req.URL.RawQuery = "page=2&access_token=" + token },
+		"header": func(req *http.Request) { req.Header.Set("X-Token", token) },
+	} {
+		forwarded = nil
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		prepare(req)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if forwarded == nil {
+			t.Fatalf("%s: expected the request to be forwarded", name)
+		}
+		if forwarded.Header.Get("Authorization") != "Bearer api:" {
+			t.Errorf("%s: expected the exchanged token, got %q", name, forwarded.Header.Get("Authorization"))
+		}
+		dump := forwarded.URL.String() + fmt.Sprint(forwarded.Header)
+		if strings.Contains(dump, token) {
+			t.Errorf("%s: the user token reached the upstream: %s", name, dump)
+		}
+		if name == "cookie" && forwarded.Header.Get("Cookie") != "theme=dark" || name == "query" && forwarded.URL.RawQuery != "page=2" {
+			t.Errorf("%s: expected the other cookies and parameters to be kept, got %s", name, dump)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Rule conditions are written in a small expression language modeled on CEL:
+//
+//	request.method == "DELETE" && token.claims.department != "finance"
+//	"admin" in token.claims.realm_access.roles || request.path.startsWith("/public/")
+//
+// Values are strings, numbers, booleans, null, lists and objects. The roots are request (method, path,
+// host, query, headers, clientIP) and token (claims). Missing fields, headers and claims are null.
+// Operators: ||, &&, !, ==, !=, <, <=, >, >= (numbers or strings), in (list element or object key),
+// a.b and a["b"] selection, list[0] indexing, and the methods startsWith, endsWith, contains and size.
+// Only the claims an expression selects are decoded from the token.
+
+// exprRequestFields and exprTokenFields are the fields of the request and token roots
+var (
+	exprRequestFields = map[string]bool{"method": true, "path": true, "host": true, "query": true, "headers": true, "clientIP": true}
+	exprTokenFields   = map[string]bool{"claims": true}
+	exprMethods       = map[string]int{"startsWith": 1, "endsWith": 1, "contains": 1, "size": 0} // name -> arity
+)
+
+// expression is a compiled expression
+type expression struct {
+	source string
+	root   exprNode
+}
+
+// compileExpression parses an expression, checking the fields of request and token and the methods
+func compileExpression(source string) (*expression, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != exprEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return &expression{source: source, root: root}, nil
+}
+
+// test evaluates the expression, which must yield a boolean
+func (e *expression) test(env *exprEnv) (bool, error) {
+	value, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression yields %s, not a boolean", exprType(value))
+	}
+	return b, nil
+}
+
+// exprEnv is what an expression is evaluated against
+type exprEnv struct {
+	request *exprRequest
+	claims  *exprClaims
+}
+
+// newExprEnv builds the environment of a request. Claims come from the forwarded identity when set,
+// otherwise from the access token.
+func newExprEnv(req *http.Request, clientIP, accessToken string, forwarded map[string][]string) *exprEnv {
+	claims := &exprClaims{forwarded: forwarded, decoded: map[string]interface{}{}}
+	if forwarded == nil {
+		claims.payload, _ = jwtSegment(accessToken, 1, "payload")
+	}
+	return &exprEnv{request: &exprRequest{req: req, clientIP: clientIP}, claims: claims}
+}
+
+// exprObject is a value whose fields are computed on selection
+type exprObject interface {
+	field(name string) (interface{}, error)
+}
+
+// exprRequest exposes the request
+type exprRequest struct {
+	req      *http.Request
+	clientIP string
+}
+
+func (r *exprRequest) field(name string) (interface{}, error) {
+	switch name {
+	case "method":
+		return r.req.Method, nil
+	case "path":
+		return r.req.URL.Path, nil
+	case "host":
+		host := r.req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.ToLower(host), nil
+	case "query":
+		return exprValues(r.req.URL.Query()), nil
+	case "headers":
+		return exprHeaders(r.req.Header), nil
+	case "clientIP":
+		return r.clientIP, nil
+	}
+	return nil, nil
+}
+
+// exprHeaders exposes the first value of each request header, by case-insensitive name
+type exprHeaders http.Header
+
+func (h exprHeaders) field(name string) (interface{}, error) {
+	if values := http.Header(h).Values(name); len(values) > 0 {
+		return values[0], nil
+	}
+	return nil, nil
+}
+
+// exprValues exposes the first value of each query parameter
+type exprValues url.Values
+
+func (v exprValues) field(name string) (interface{}, error) {
+	if values, ok := v[name]; ok && len(values) > 0 {
+		return values[0], nil
+	}
+	return nil, nil
+}
+
+// exprToken exposes the token
+type exprToken struct {
+	claims *exprClaims
+}
+
+func (t exprToken) field(name string) (interface{}, error) {
+	if name == "claims" {
+		return t.claims, nil
+	}
+	return nil, nil
+}
+
+// exprClaims decodes the claims of a token one by one, as they are selected
+type exprClaims struct {
+	payload   []byte
+	forwarded map[string][]string
+	decoded   map[string]interface{}
+}
+
+func (c *exprClaims) field(name string) (interface{}, error) {
+	if value, ok := c.decoded[name]; ok {
+		return value, nil
+	}
+	var value interface{}
+	if c.forwarded != nil {
+		if values, ok := c.forwarded[name]; ok {
+			list := make([]interface{}, len(values))
+			for i, v := range values {
+				list[i] = v
+			}
+			value = list
+		}
+	} else if c.payload != nil {
+		err := decodeFields(c.payload, map[string]fieldDecoder{name: func(dec *json.Decoder) error { return dec.Decode(&value) }})
+		if err != nil {
+			return nil, fmt.Errorf("claim %q: %w", name, err)
+		}
+	}
+	c.decoded[name] = value
+	return value, nil
+}
+
+// exprNode is a node of a compiled expression
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type (
+	exprLiteral  struct{ value interface{} }
+	exprList     struct{ items []exprNode }
+	exprRoot     struct{ name string } // request or token
+	exprSelector struct {
+		base exprNode
+		name string
+	}
+	exprIndex struct{ base, key exprNode }
+	exprCall  struct {
+		target exprNode
+		method string
+		args   []exprNode
+	}
+	exprNot    struct{ operand exprNode }
+	exprBinary struct {
+		op          string
+		left, right exprNode
+	}
+)
+
+func (n exprLiteral) eval(env *exprEnv) (interface{}, error) { return n.value, nil }
+
+func (n exprList) eval(env *exprEnv) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (n exprRoot) eval(env *exprEnv) (interface{}, error) {
+	if n.name == "request" {
+		return env.request, nil
+	}
+	return exprToken{claims: env.claims}, nil
+}
+
+func (n exprSelector) eval(env *exprEnv) (interface{}, error) {
+	base, err := n.base.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return exprSelect(base, n.name)
+}
+
+func (n exprIndex) eval(env *exprEnv) (interface{}, error) {
+	base, err := n.base.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case string:
+		return exprSelect(base, k)
+	case float64:
+		list, ok := base.([]interface{})
+		if !ok {
+			if base == nil {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("cannot index %s with a number", exprType(base))
+		}
+		if i := int(k); float64(i) == k && i >= 0 && i < len(list) {
+			return list[i], nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid index %s", exprType(key))
+}
+
+// exprSelect returns the field name of base; selecting from null yields null
+func exprSelect(base interface{}, name string) (interface{}, error) {
+	switch b := base.(type) {
+	case nil:
+		return nil, nil
+	case exprObject:
+		return b.field(name)
+	case map[string]interface{}:
+		return b[name], nil
+	}
+	return nil, fmt.Errorf("cannot select %q of %s", name, exprType(base))
+}
+
+func (n exprCall) eval(env *exprEnv) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		if args[i], err = arg.eval(env); err != nil {
+			return nil, err
+		}
+	}
+	switch t := target.(type) {
+	case string:
+		if n.method == "size" {
+			return float64(len(t)), nil
+		}
+		arg, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a string, got %s", n.method, exprType(args[0]))
+		}
+		switch n.method {
+		case "startsWith":
+			return strings.HasPrefix(t, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(t, arg), nil
+		}
+		return strings.Contains(t, arg), nil
+	case []interface{}:
+		switch n.method {
+		case "size":
+			return float64(len(t)), nil
+		case "contains":
+			return exprContains(t, args[0]), nil
+		}
+	case map[string]interface{}:
+		if n.method == "size" {
+			return float64(len(t)), nil
+		}
+	case nil:
+		// Methods of missing values: nothing starts with, ends with or contains anything
+		if n.method == "size" {
+			return float64(0), nil
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("%s has no method %s", exprType(target), n.method)
+}
+
+func (n exprNot) eval(env *exprEnv) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! expects a boolean, got %s", exprType(value))
+	}
+	return !b, nil
+}
+
+func (n exprBinary) eval(env *exprEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans, got %s", n.op, exprType(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans, got %s", n.op, exprType(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case nil:
+			return false, nil
+		case []interface{}:
+			return exprContains(r, left), nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			_, found := r[key]
+			return ok && found, nil
+		case exprObject:
+			key, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			value, err := r.field(key)
+			return value != nil, err
+		}
+		return nil, fmt.Errorf("in expects a list or an object, got %s", exprType(right))
+	}
+
+	// Ordering
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %s with %s", exprType(left), exprType(right))
+		}
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %s with %s", exprType(left), exprType(right))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("cannot order %s", exprType(left))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// exprEqual compares scalars; lists and objects are never equal
+func exprEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, string, float64, bool:
+		switch b.(type) {
+		case nil, string, float64, bool:
+			return a == b
+		}
+	}
+	return false
+}
+
+// exprContains reports whether list holds value
+func exprContains(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if exprEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// exprType names the type of a value in error messages
+func exprType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "list"
+	}
+	return "object"
+}
+
+// Token kinds of the expression lexer
+const (
+	exprEOF = iota
+	exprIdent
+	exprString
+	exprNumber
+	exprOperator
+)
+
+// exprLexeme is a token of an expression
+type exprLexeme struct {
+	kind  int
+	text  string // operator or identifier; decoded value of strings
+	pos   int
+	value float64
+}
+
+// lexExpr splits an expression into tokens
+func lexExpr(src string) ([]exprLexeme, error) {
+	var tokens []exprLexeme
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, exprLexeme{kind: exprIdent, text: src[i:j], pos: i})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			value, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			tokens = append(tokens, exprLexeme{kind: exprNumber, text: src[i:j], pos: i, value: value})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, exprLexeme{kind: exprString, text: b.String(), pos: i})
+			i = j + 1
+		default:
+			op := ""
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if op == "" && strings.IndexByte("<>!()[],.", c) >= 0 {
+				op = string(c)
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, exprLexeme{kind: exprOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprLexeme{kind: exprEOF, pos: len(src)}), nil
+}
+
+// exprParser is a recursive descent parser; each parse method handles one precedence level
+type exprParser struct {
+	tokens []exprLexeme
+	i      int
+}
+
+func (p *exprParser) peek() exprLexeme { return p.tokens[p.i] }
+
+func (p *exprParser) next() exprLexeme {
+	t := p.tokens[p.i]
+	if t.kind != exprEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the operator op if it comes next
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == exprOperator && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d", op, t.pos)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			left = exprBinary{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.accept("&&") {
+		var right exprNode
+		if right, err = p.parseComparison(); err == nil {
+			left = exprBinary{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	op := ""
+	switch {
+	case t.kind == exprOperator && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		op = t.text
+	case t.kind == exprIdent && t.text == "in":
+		op = "in"
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return exprBinary{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != exprIdent {
+				return nil, fmt.Errorf("expected a field name at %d", name.pos)
+			}
+			if p.accept("(") {
+				node, err = p.parseCall(node, name)
+				continue
+			}
+			if root, ok := node.(exprRoot); ok {
+				if fields := map[string]map[string]bool{"request": exprRequestFields, "token": exprTokenFields}[root.name]; !fields[name.text] {
+					return nil, fmt.Errorf("%s has no field %q", root.name, name.text)
+				}
+			}
+			node = exprSelector{base: node, name: name.text}
+		case p.accept("["):
+			var key exprNode
+			if key, err = p.parseOr(); err == nil {
+				if err = p.expect("]"); err == nil {
+					node = exprIndex{base: node, key: key}
+				}
+			}
+		default:
+			return node, nil
+		}
+	}
+	return nil, err
+}
+
+// parseCall parses the arguments of a method call whose "(" was consumed
+func (p *exprParser) parseCall(target exprNode, name exprLexeme) (exprNode, error) {
+	arity, ok := exprMethods[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q at %d", name.text, name.pos)
+	}
+	var args []exprNode
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s expects %d argument(s) at %d", name.text, arity, name.pos)
+	}
+	return exprCall{target: target, method: name.text, args: args}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case exprString:
+		return exprLiteral{value: t.text}, nil
+	case exprNumber:
+		return exprLiteral{value: t.value}, nil
+	case exprIdent:
+		switch t.text {
+		case "true", "false":
+			return exprLiteral{value: t.text == "true"}, nil
+		case "null":
+			return exprLiteral{}, nil
+		case "request", "token":
+			return exprRoot{name: t.text}, nil
+		}
+		return nil, fmt.Errorf("unknown identifier %q at %d", t.text, t.pos)
+	case exprOperator:
+		switch t.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			var items []exprNode
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return exprList{items: items}, nil
+		}
+	case exprEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
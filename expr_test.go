@@ -0,0 +1,127 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "http://Gateway.example:8443/invoices/42?draft=true", nil)
+	req.Header.Set("X-Approval", "ticket-1")
+	accessToken := jwtWithClaims(`{"sub":"alice","department":"sales","limit":500,"realm_access":{"roles":["user","approver"]},"groups":["/eu"]}`)
+	env := newExprEnv(req, "10.0.0.1", accessToken, nil)
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`request.method == "DELETE" && token.claims.department != "finance"`, true},
+		{`request.method == "DELETE" && token.claims.department == "sales" && false`, false},
+		{`request.path.startsWith("/invoices/") && !request.path.endsWith("/")`, true},
+		{`request.host == "gateway.example" && request.clientIP == "10.0.0.1"`, true},
+		{`request.query.draft == "true" && request.query.missing == null`, true},
+		{`request.headers["x-approval"] == 'ticket-1' && "X-Approval" in request.headers`, true},
+		{`"approver" in token.claims.realm_access.roles`, true},
+		{`token.claims["realm_access"].roles[0] == "user" && token.claims.realm_access.roles.size() == 2`, true},
+		{`token.claims.limit >= 500 && token.claims.limit < 1000.5`, true},
+		{`token.claims.missing.nested == null && !token.claims.missing.contains("x")`, true},
+		{`request.method in ["GET", "HEAD"] || (token.claims.groups.contains("/eu") && "realm_access" in token.claims)`, true},
+		{`request.path.contains("draft")`, false},
+	}
+	for _, test := range tests {
+		expression, err := compileExpression(test.expression)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expression, err)
+		}
+		if got, err := expression.test(env); err != nil || got != test.expected {
+			t.Errorf("%s: expected %v, got %v (%v)", test.expression, test.expected, got, err)
+		}
+	}
+
+	for _, invalid := range []string{
+		`request.verb == "GET"`,
+		`user.name == "x"`,
+		`request.path.matches("x")`,
+		`request.path.startsWith()`,
+		`request.method == "GET" &&`,
+		`request.method == "GET`,
+		`request.method = "GET"`,
+		`(request.method == "GET"`,
+	} {
+		if _, err := compileExpression(invalid); err == nil {
+			t.Errorf("%s: expected a compile error", invalid)
+		}
+	}
+
+	for _, failing := range []string{`token.claims.department < 3`, `request.method`, `token.claims.limit && true`} {
+		expression, err := compileExpression(failing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := expression.test(env); err == nil {
+			t.Errorf("%s: expected an evaluation error", failing)
+		}
+	}
+}
+
+func TestRuleConditions(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	var decision Decision
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		decision, _ = DecisionFromContext(req.Context())
+	})
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		ResponseMode: responseModeDecision,
+		Rules: []Rule{{
+			Prefix:       "/invoices",
+			Resolver:     "method",
+			Resource:     "invoice",
+			MethodScopes: map[string]string{"GET": "view", "DELETE": "delete"},
+			Conditions: []RuleCondition{
+				{When: `request.method == "DELETE" && token.claims.department != "finance"`, Deny: true},
+				{When: `token.claims.limit < 1000`, Scope: "approve"},
+			},
+		}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		claims     string
+		expected   int
+		permission string
+	}{
+		{"deleting outside finance", http.MethodDelete, `{"sub":"alice","department":"sales","limit":5000}`, http.StatusForbidden, ""},
+		{"deleting in finance", http.MethodDelete, `{"sub":"bob","department":"finance","limit":5000}`, http.StatusOK, "delete"},
+		{"low limit requires approval", http.MethodGet, `{"sub":"carol","limit":10}`, http.StatusOK, "view,approve"},
+		{"missing limit fails closed", http.MethodGet, `{"sub":"dave"}`, http.StatusOK, "view,approve"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision = Decision{}
+			req := httptest.NewRequest(test.method, "http://gateway/invoices/1", nil)
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(test.claims))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected == http.StatusOK && decision.Permission.Scope != test.permission {
+				t.Errorf("expected scope %q, got %q", test.permission, decision.Permission.Scope)
+			}
+		})
+	}
+
+	for _, invalid := range []RuleCondition{{When: `true`}, {When: `true`, Deny: true, Scope: "x"}, {When: `request.nope`, Deny: true}} {
+		if _, err := compileRule(Rule{Prefix: "/x", Conditions: []RuleCondition{invalid}}, 3, 4); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// FastPathRule answers matching requests (health probes, known bots) with a fixed status without
+// looking at the token, calling Keycloak or logging a decision
+type FastPathRule struct {
+	Name      string `json:"name,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"` // regular expression matched against User-Agent
+	Prefix    string `json:"prefix,omitempty"`    // path prefix
+	Status    int    `json:"status,omitempty"`    // e.g. 200 or 401
+}
+
+type compiledFastPath struct {
+	name      string
+	userAgent *regexp.Regexp
+	prefix    string
+	status    int
+}
+
+// compileFastPaths validates the fast path rules; every rule needs at least one matcher
+func compileFastPaths(rules []FastPathRule) ([]compiledFastPath, error) {
+	compiled := make([]compiledFastPath, 0, len(rules))
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("fastPaths[%d]", i)
+		}
+		if rule.UserAgent == "" && rule.Prefix == "" {
+			return nil, fmt.Errorf("%s: userAgent or prefix is required", name)
+		}
+		if rule.Status < 100 || rule.Status > 599 {
+			return nil, fmt.Errorf("%s: invalid status %d", name, rule.Status)
+		}
+		fp := compiledFastPath{name: name, prefix: rule.Prefix, status: rule.Status}
+		if rule.UserAgent != "" {
+			re, err := regexp.Compile(rule.UserAgent)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid userAgent: %w", name, err)
+			}
+			fp.userAgent = re
+		}
+		compiled = append(compiled, fp)
+	}
+	return compiled, nil
+}
+
+// fastPathStatus returns the fixed status for a request matching a fast path rule
+func (am *AuthMiddleware) fastPathStatus(req *http.Request) (int, bool) {
+	for _, fp := range am.fastPaths {
+		if fp.prefix != "" && !strings.HasPrefix(req.URL.Path, fp.prefix) {
+			continue
+		}
+		if fp.userAgent != nil && !fp.userAgent.MatchString(req.UserAgent()) {
+			continue
+		}
+		return fp.status, true
+	}
+	return 0, false
+}
@@ -0,0 +1,60 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFastPaths(t *testing.T) {
+	nextCalled := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { nextCalled = true })
+	config := &Config{
+		// No Keycloak URL: any request reaching authorization would fail with 500
+		FastPaths: []FastPathRule{
+			{Name: "kube-probe", UserAgent: "^kube-probe/", Status: http.StatusOK},
+			{Name: "bots", UserAgent: "(?i)bot", Prefix: "/api/", Status: http.StatusUnauthorized},
+			{Name: "ping", Prefix: "/ping", Status: http.StatusNoContent},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		userAgent string
+		path      string
+		expected  int
+	}{
+		{"kube-probe/1.29", "/healthz", http.StatusOK},
+		{"Googlebot/2.1", "/api/v1/user/get", http.StatusUnauthorized},
+		{"curl/8.0", "/ping", http.StatusNoContent},
+		{"Googlebot/2.1", "/public/page", http.StatusUnauthorized}, // falls through to the missing token check
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+		req.Header.Set("User-Agent", test.userAgent)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.expected {
+			t.Errorf("%s %s: expected %d, got %d", test.userAgent, test.path, test.expected, recorder.Code)
+		}
+	}
+	if nextCalled {
+		t.Error("fast path requests must not be forwarded")
+	}
+}
+
+func TestFastPathValidation(t *testing.T) {
+	for _, rules := range [][]FastPathRule{
+		{{Status: http.StatusOK}},
+		{{Prefix: "/ping"}},
+		{{UserAgent: "(", Status: http.StatusOK}},
+	} {
+		if _, err := compileFastPaths(rules); err == nil {
+			t.Errorf("expected error for %+v", rules)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package authztraefikgateway
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// tokenFingerprint returns the base64url-encoded SHA-256 of a token. It is used wherever a token
+// must be identified (cache keys, logs, headers) without exposing its value.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
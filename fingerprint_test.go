@@ -0,0 +1,50 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenFingerprint(t *testing.T) {
+	fp := tokenFingerprint("secret-token")
+	if fp != tokenFingerprint("secret-token") {
+		t.Error("fingerprint must be stable")
+	}
+	if fp == tokenFingerprint("other-token") {
+		t.Error("different tokens must have different fingerprints")
+	}
+	if len(fp) != 43 || strings.ContainsAny(fp, "+/=") || strings.Contains(fp, "secret") {
+		t.Errorf("expected a 43 character base64url fingerprint, got %q", fp)
+	}
+}
+
+func TestFingerprintHeader(t *testing.T) {
+	expected := tokenFingerprint(token)
+
+	srv := newKeycloakStub(t, http.StatusOK, `{}`)
+	var upstream string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstream = req.Header.Get("X-Authz-Token-Fingerprint")
+	})
+	config := &Config{KeycloakURL: srv.URL, FingerprintHeader: "X-Authz-Token-Fingerprint"}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if upstream != expected {
+		t.Errorf("expected upstream fingerprint %q, got %q", expected, upstream)
+	}
+
+	srv = newKeycloakStub(t, http.StatusForbidden, `{"error":"access_denied"}`)
+	config.KeycloakURL = srv.URL
+	recorder := serve(t, config, "/api/v1/user/get")
+	if got := recorder.Header().Get("X-Authz-Token-Fingerprint"); got != expected {
+		t.Errorf("expected denial fingerprint %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,163 @@
+package authztraefikgateway
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Default headers set by oauth2-proxy style ForwardAuth servers
+const (
+	defaultForwardUserHeader   = "X-Auth-Request-User"
+	defaultForwardEmailHeader  = "X-Auth-Request-Email"
+	defaultForwardGroupsHeader = "X-Auth-Request-Groups"
+	defaultForwardSecretHeader = "X-Auth-Request-Secret"
+)
+
+// claimTokenFormat is the claim_token_format Keycloak expects for pushed claims
+const claimTokenFormat = "urn:ietf:params:oauth:token-type:jwt"
+
+// ForwardAuthConfig accepts the identity established by a preceding ForwardAuth middleware
+// (e.g. oauth2-proxy) when the request carries no access token. The permission is then evaluated
+// with the plugin's service token, and the identity is pushed to Keycloak as claims.
+//
+// The headers must be authenticated. TrustedIPs only helps when the auth proxy is a separate hop in
+// front of Traefik: with Traefik's ForwardAuth middleware in the same chain, the peer is the end client.
+// There, the auth server adds Secret in SecretHeader to its response headers (authResponseHeaders),
+// which clients cannot do without knowing it.
+type ForwardAuthConfig struct {
+	Enabled      bool     `json:"enabled,omitempty"`
+	UserHeader   string   `json:"userHeader,omitempty"`   // default X-Auth-Request-User
+	EmailHeader  string   `json:"emailHeader,omitempty"`  // default X-Auth-Request-Email
+	GroupsHeader string   `json:"groupsHeader,omitempty"` // default X-Auth-Request-Groups (comma separated)
+	TrustedIPs   []string `json:"trustedIPs,omitempty"`   // direct peers allowed to set the headers
+	Secret       string   `json:"secret,omitempty"`       // shared with the auth server, required in SecretHeader
+	SecretHeader string   `json:"secretHeader,omitempty"` // default X-Auth-Request-Secret
+}
+
+// forwardAuth is the prepared ForwardAuthConfig
+type forwardAuth struct {
+	userHeader   string
+	emailHeader  string
+	groupsHeader string
+	trusted      []*net.IPNet // nil: any peer
+	secret       string       // "": no secret header is required
+	secretHeader string
+}
+
+// forwardedIdentity is the principal described by ForwardAuth headers
+type forwardedIdentity struct {
+	user   string
+	email  string
+	groups []string
+}
+
+// newForwardAuth prepares the ForwardAuth settings; it returns nil when the feature is disabled
+func newForwardAuth(config ForwardAuthConfig) (*forwardAuth, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	// Anyone able to reach the gateway could otherwise claim any identity
+	if len(config.TrustedIPs) == 0 && config.Secret == "" {
+		return nil, fmt.Errorf("trustedIPs or secret must authenticate the identity headers")
+	}
+	fa := &forwardAuth{
+		userHeader:   config.UserHeader,
+		emailHeader:  config.EmailHeader,
+		groupsHeader: config.GroupsHeader,
+		secret:       config.Secret,
+		secretHeader: config.SecretHeader,
+	}
+	if len(config.TrustedIPs) > 0 {
+		trusted, err := parseCIDRs(config.TrustedIPs)
+		if err != nil {
+			return nil, err
+		}
+		fa.trusted = trusted
+	}
+	if fa.userHeader == "" {
+		fa.userHeader = defaultForwardUserHeader
+	}
+	if fa.emailHeader == "" {
+		fa.emailHeader = defaultForwardEmailHeader
+	}
+	if fa.groupsHeader == "" {
+		fa.groupsHeader = defaultForwardGroupsHeader
+	}
+	if fa.secretHeader == "" {
+		fa.secretHeader = defaultForwardSecretHeader
+	}
+	return fa, nil
+}
+
+// identity returns the forwarded principal, if the request carries one from a trusted client and with
+// the secret. The secret header is removed, so the upstream never sees it.
+func (fa *forwardAuth) identity(req *http.Request, clientIP net.IP) (forwardedIdentity, bool) {
+	presented := req.Header.Get(fa.secretHeader)
+	if fa.secret != "" {
+		req.Header.Del(fa.secretHeader)
+	}
+	if fa.trusted != nil && !containsIP(fa.trusted, clientIP) {
+		return forwardedIdentity{}, false
+	}
+	if fa.secret != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(fa.secret)) != 1 {
+		return forwardedIdentity{}, false
+	}
+	id := forwardedIdentity{
+		user:  strings.TrimSpace(req.Header.Get(fa.userHeader)),
+		email: strings.TrimSpace(req.Header.Get(fa.emailHeader)),
+	}
+	for _, group := range strings.Split(req.Header.Get(fa.groupsHeader), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			id.groups = append(id.groups, group)
+		}
+	}
+	if id.user == "" && id.email == "" {
+		return forwardedIdentity{}, false
+	}
+	return id, true
+}
+
+// subject identifies the principal: the user name, or the email if no user is forwarded
+func (id forwardedIdentity) subject() string {
+	if id.user != "" {
+		return id.user
+	}
+	return id.email
+}
+
+// claims returns the identity as Keycloak pushed claims
+func (id forwardedIdentity) claims() map[string][]string {
+	claims := map[string][]string{}
+	if id.user != "" {
+		claims["user"] = []string{id.user}
+	}
+	if id.email != "" {
+		claims["email"] = []string{id.email}
+	}
+	if len(id.groups) > 0 {
+		claims["groups"] = id.groups
+	}
+	return claims
+}
+
+// fingerprint identifies the whole identity (user, email and groups) for cache keys and logs
+func (id forwardedIdentity) fingerprint() string {
+	groups := append([]string(nil), id.groups...)
+	sort.Strings(groups)
+	return tokenFingerprint("forwardauth\x00" + id.user + "\x00" + id.email + "\x00" + strings.Join(groups, ","))
+}
+
+// encodeClaimToken encodes pushed claims as the base64url JSON document Keycloak expects in claim_token
+func encodeClaimToken(claims map[string][]string) (string, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
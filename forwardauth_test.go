@@ -0,0 +1,142 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForwardAuthIdentity(t *testing.T) {
+	var authorization string
+	var claims map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") == "client_credentials" {
+			_, _ = rw.Write([]byte(`{"access_token":"service-token","expires_in":300}`))
+			return
+		}
+		authorization = req.Header.Get("Authorization")
+		raw, _ := base64.RawURLEncoding.DecodeString(req.PostForm.Get("claim_token"))
+		_ = json.Unmarshal(raw, &claims)
+		if req.PostForm.Get("claim_token_format") != claimTokenFormat {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"rpt"}`))
+	}))
+	defer srv.Close()
+
+	nextCalled := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { nextCalled = true })
+	config := &Config{
+		KeycloakURL:          srv.URL,
+		KeycloakClientId:     "gateway",
+		KeycloakClientSecret: "secret",
+		ForwardAuth:          ForwardAuthConfig{Enabled: true, TrustedIPs: []string{"192.0.2.0/24"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("X-Auth-Request-User", "alice")
+	req.Header.Set("X-Auth-Request-Email", "alice@example.com")
+	req.Header.Set("X-Auth-Request-Groups", "admins, devs")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || !nextCalled {
+		t.Fatalf("expected forwarded identity to be granted, got %d", recorder.Code)
+	}
+	if authorization != "Bearer service-token" {
+		t.Errorf("expected the service token to be used, got %q", authorization)
+	}
+	expected := map[string][]string{"user": {"alice"}, "email": {"alice@example.com"}, "groups": {"admins", "devs"}}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("expected claims %v, got %v", expected, claims)
+	}
+
+	// Identity headers from an untrusted peer are ignored
+	req = httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("X-Auth-Request-User", "alice")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for untrusted peer, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestForwardAuthRequiresSecret(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{ForwardAuth: ForwardAuthConfig{Enabled: true, TrustedIPs: []string{"10.0.0.1"}}}
+	if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil {
+		t.Error("expected error when forwardAuth is enabled without keycloakClientSecret")
+	}
+
+	config = &Config{KeycloakClientSecret: "secret", ForwardAuth: ForwardAuthConfig{Enabled: true}}
+	if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil || !strings.Contains(err.Error(), "trustedIPs") {
+		t.Errorf("expected error when forwardAuth is enabled without trustedIPs or secret, got %v", err)
+	}
+}
+
+func TestForwardAuthSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") == "client_credentials" {
+			_, _ = rw.Write([]byte(`{"access_token":"service-token","expires_in":300}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"rpt"}`))
+	}))
+	defer srv.Close()
+
+	var upstreamSecret string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamSecret = req.Header.Get("X-Auth-Request-Secret")
+	})
+	// In the same chain as Traefik's ForwardAuth, the peer is the end client and only the secret,
+	// added by the auth server, authenticates the headers
+	config := &Config{
+		KeycloakURL:          srv.URL,
+		KeycloakClientId:     "gateway",
+		KeycloakClientSecret: "secret",
+		ForwardAuth:          ForwardAuthConfig{Enabled: true, Secret: "proxy-secret"},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		secret   string
+		expected int
+	}{
+		{"proxy-secret", http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"guessed", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		upstreamSecret = ""
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+		req.Header.Set("X-Auth-Request-User", "alice")
+		if test.secret != "" {
+			req.Header.Set("X-Auth-Request-Secret", test.secret)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.expected {
+			t.Errorf("secret %q: expected %d, got %d", test.secret, test.expected, recorder.Code)
+		}
+		if upstreamSecret != "" {
+			t.Errorf("secret %q: expected the secret header to be removed, upstream got %q", test.secret, upstreamSecret)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// ancestorResources returns resource followed by its ancestors with at least depth "/"-separated
+// segments, nearest first: "projects/acme/repos/api" with depth 2 gives "projects/acme/repos/api",
+// "projects/acme/repos" and "projects/acme". A leading "/" is kept on every ancestor.
+func ancestorResources(resource string, depth int) []string {
+	lead := ""
+	if strings.HasPrefix(resource, "/") {
+		lead, resource = "/", resource[1:]
+	}
+	resource = strings.TrimRight(resource, "/")
+	candidates := []string{lead + resource}
+	for segments := strings.Count(resource, "/") + 1; segments > depth; segments-- {
+		resource = resource[:strings.LastIndexByte(resource, '/')]
+		candidates = append(candidates, lead+resource)
+	}
+	return candidates
+}
+
+// inheritable reports whether a Keycloak answer lets the parent resource decide: the resource is not
+// registered (leaves need not be). A denial of a registered resource is final, so an explicit deny on
+// a leaf is never overridden by a grant on an ancestor. An invalid_scope answer names the scope but
+// not the resource lacking it, so it ends the evaluation as well.
+func inheritable(result *keycloakResult) bool {
+	return keycloakReason(result.status, result.errorCode, result.errorDescription) == ReasonInvalidResource
+}
+
+// maxInheritedAncestors bounds how many ancestors of a resource are evaluated. Deeper resources are
+// denied before Keycloak is called rather than evaluated without their outermost ancestors.
+const maxInheritedAncestors = 8
+
+// evaluateHierarchy evaluates the resolved permission and the same scope on the ancestors of the
+// resource in a single UMA request answered in the permissions response
+// mode. The nearest resource registered with the scope decides, so a denied leaf is not granted by
+// its ancestor. Keycloak rejects the whole request when one of its resources is not registered; that
+// resource is dropped and the rest evaluated again. Combined evaluations are cached, not coalesced.
+func (am *AuthMiddleware) evaluateHierarchy(ctx context.Context, accessToken string, decision *Decision, resolved Permission, depth int) (*keycloakResult, error) {
+	rc := am.runtimeFor(ctx)
+	candidates := ancestorResources(resolved.Resource, depth)
+	leaf := candidates[0]
+	inherited := func(resource string) {
+		if resource != leaf {
+			am.logf(logDebug, "🌳 [HIERARCHY] %s granted through ancestor %s\n", resolved.Resource, resource)
+			decision.InheritedFrom = resource
+		}
+	}
+
+	for {
+		if len(candidates) == 1 {
+			result, err := am.evaluateCached(ctx, accessToken, decision, rc.permissionFormat.format(candidates[0], resolved.Scope))
+			if err == nil && result.status == http.StatusOK {
+				inherited(candidates[0])
+			}
+			return result, err
+		}
+
+		permissions := make([]string, len(candidates))
+		for i, resource := range candidates {
+			permissions[i] = rc.permissionFormat.format(resource, resolved.Scope)
+		}
+		_, claims := decision.pushedClaims()
+		result, err := am.cached(accessToken, decision, strings.Join(permissions, "\n"), func() (*keycloakResult, error) {
+			return am.retrying(ctx, permissions[0], func() (*keycloakResult, error) {
+				return am.evaluatePermissions(ctx, accessToken, permissions, responseModePermissions, decision.Audience, decision.endpoint, claims)
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if inheritable(result) {
+			i := unregisteredCandidate(result, candidates, rc.permissionFormat)
+			if i < 0 {
+				return result, nil
+			}
+			candidates = append(candidates[:i:i], candidates[i+1:]...)
+			continue
+		}
+		if result.status != http.StatusOK {
+			return result, nil
+		}
+		// Keycloak grants the request when any resource is granted, only the nearest one counts
+		if !grantsResource(result.granted, candidates[0], resolved.Scope, rc.permissionFormat) {
+			return &keycloakResult{status: http.StatusForbidden, errorCode: "access_denied", errorDescription: "not_authorized"}, nil
+		}
+		inherited(candidates[0])
+		return result, nil
+	}
+}
+
+// unregisteredCandidate returns the index of the candidate Keycloak reported as not registered, from
+// an error_description like "Resource with id [x] does not exist.", or -1
+func unregisteredCandidate(result *keycloakResult, candidates []string, pf permissionFormat) int {
+	if keycloakReason(result.status, result.errorCode, result.errorDescription) != ReasonInvalidResource {
+		return -1
+	}
+	start, end := strings.Index(result.errorDescription, "["), strings.LastIndex(result.errorDescription, "]")
+	if start < 0 || end < start {
+		return -1
+	}
+	reported := strings.TrimPrefix(result.errorDescription[start+1:end], "/")
+	for i, resource := range candidates {
+		if strings.TrimPrefix(pf.format(resource, ""), "/") == reported {
+			return i
+		}
+	}
+	return -1
+}
+
+// grantsResource reports whether the granted permissions include scope on resource
+func grantsResource(granted []GrantedPermission, resource, scope string, pf permissionFormat) bool {
+	name := strings.TrimPrefix(pf.format(resource, ""), "/")
+	for _, gp := range granted {
+		if strings.TrimPrefix(gp.ResourceName, "/") != name && strings.TrimPrefix(gp.ResourceID, "/") != name {
+			continue
+		}
+		if scope == "" || pf.scopeless || grantsScope(gp, scope) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAncestorResources(t *testing.T) {
+	tests := []struct {
+		resource string
+		depth    int
+		expected []string
+	}{
+		{"projects/acme/repos/api", 2, []string{"projects/acme/repos/api", "projects/acme/repos", "projects/acme"}},
+		{"projects/acme/repos/api", 1, []string{"projects/acme/repos/api", "projects/acme/repos", "projects/acme", "projects"}},
+		{"/projects/acme/", 1, []string{"/projects/acme", "/projects"}},
+		{"projects/acme", 3, []string{"projects/acme"}},
+		{"user", 1, []string{"user"}},
+	}
+	for _, test := range tests {
+		if got := ancestorResources(test.resource, test.depth); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q depth %d: expected %q, got %q", test.resource, test.depth, test.expected, got)
+		}
+	}
+}
+
+func TestResourceHierarchy(t *testing.T) {
+	// Registered resources and the scopes granted on them. Like Keycloak, the stub rejects a request
+	// naming any unregistered resource and otherwise answers with the granted subset.
+	registered := map[string][]string{
+		"/projects/acme":           {"get"},
+		"/projects/acme/secrets":   nil,
+		"/projects/acme/repos/api": {"delete"},
+		"/projects/globex":         nil,
+	}
+	var mu sync.Mutex
+	var evaluated []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		permissions := req.PostForm["permission"]
+		mu.Lock()
+		evaluated = append(evaluated, strings.Join(permissions, ","))
+		mu.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		var granted []GrantedPermission
+		for _, permission := range permissions {
+			parts := strings.SplitN(permission, "#", 2)
+			scopes, ok := registered[parts[0]]
+			if !ok {
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte(`{"error":"invalid_resource","error_description":"Resource with id [` + parts[0] + `] does not exist."}`))
+				return
+			}
+			for _, scope := range scopes {
+				if scope == parts[1] {
+					granted = append(granted, GrantedPermission{ResourceName: parts[0], Scopes: []string{scope}})
+				}
+			}
+		}
+		switch {
+		case len(granted) == 0:
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte(`{"error":"access_denied","error_description":"not_authorized"}`))
+		case req.PostForm.Get("response_mode") == responseModePermissions:
+			_ = json.NewEncoder(rw).Encode(granted)
+		default:
+			_, _ = rw.Write([]byte(`{"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	var forwarded Decision
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded, _ = DecisionFromContext(req.Context())
+	})
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		ResponseMode: responseModeDecision,
+		Cache:        CacheConfig{Enabled: true},
+		Rules:        []Rule{{Prefix: "/projects/", Resolver: "path", InheritDepth: 2}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method    string
+		path      string
+		status    int
+		inherited string
+		evaluated []string
+	}{
+		// Unregistered resources are dropped until the nearest registered one decides
+		{http.MethodGet, "/projects/acme/repos/web", http.StatusOK, "projects/acme", []string{
+			"/projects/acme/repos/web#get,/projects/acme/repos#get,/projects/acme#get",
+			"/projects/acme/repos#get,/projects/acme#get",
+			"/projects/acme#get",
+		}},
+		{http.MethodDelete, "/projects/acme/repos/api", http.StatusOK, "", []string{
+			"/projects/acme/repos/api#delete,/projects/acme/repos#delete,/projects/acme#delete",
+			"/projects/acme/repos/api#delete,/projects/acme#delete",
+		}},
+		// An explicit deny on a registered resource is not overridden by a grant on its ancestor
+		{http.MethodGet, "/projects/acme/secrets", http.StatusUnauthorized, "", []string{
+			"/projects/acme/secrets#get,/projects/acme#get",
+		}},
+		// Cached: the same request needs no Keycloak call
+		{http.MethodGet, "/projects/acme/secrets", http.StatusUnauthorized, "", nil},
+		// Ancestors above inheritDepth are never evaluated
+		{http.MethodGet, "/projects/globex/repos", http.StatusUnauthorized, "", []string{
+			"/projects/globex/repos#get,/projects/globex#get",
+			"/projects/globex#get",
+		}},
+	}
+	for _, test := range tests {
+		evaluated, forwarded = nil, Decision{}
+		req := httptest.NewRequest(test.method, "http://gateway"+test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice"}`))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.status, recorder.Code)
+		}
+		if forwarded.InheritedFrom != test.inherited {
+			t.Errorf("%s %s: expected inherited from %q, got %q", test.method, test.path, test.inherited, forwarded.InheritedFrom)
+		}
+		if !reflect.DeepEqual(evaluated, test.evaluated) {
+			t.Errorf("%s %s: expected evaluations %q, got %q", test.method, test.path, test.evaluated, evaluated)
+		}
+	}
+
+	// Paths with more than maxInheritedAncestors ancestors are denied without evaluating any of them,
+	// as a subset would leave out the ancestors nearest the root
+	for _, test := range []struct {
+		segments int
+		status   int
+	}{
+		{maxInheritedAncestors, http.StatusOK},
+		{maxInheritedAncestors + 1, http.StatusBadRequest},
+		{40, http.StatusBadRequest},
+	} {
+		evaluated, forwarded = nil, Decision{}
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/projects/acme"+strings.Repeat("/x", test.segments), nil)
+		req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice"}`))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%d segments below /projects/acme: expected %d, got %d", test.segments, test.status, recorder.Code)
+		}
+		if test.status != http.StatusOK && len(evaluated) > 0 {
+			t.Errorf("%d segments below /projects/acme: expected no evaluations, got %q", test.segments, evaluated)
+		}
+	}
+}
+
+func TestHierarchyInvalidScope(t *testing.T) {
+	// Keycloak names the scope but not the resource lacking it, so no ancestor is dropped
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(`{"error":"invalid_scope","error_description":"One of the given scopes [purge] is invalid"}`))
+	}))
+	defer srv.Close()
+
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		ResponseMode: responseModeDecision,
+		Rules:        []Rule{{Prefix: "/projects/", Resolver: "path", InheritDepth: 2}},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("PURGE", "http://gateway/projects/acme/repos/api", nil)
+	req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice"}`))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code == http.StatusOK || calls != 1 {
+		t.Errorf("expected a denial after one evaluation, got %d after %d", recorder.Code, calls)
+	}
+	if inheritable(&keycloakResult{status: http.StatusBadRequest, errorCode: "invalid_scope"}) {
+		t.Error("expected invalid_scope not to be inheritable")
+	}
+}
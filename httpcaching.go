@@ -0,0 +1,135 @@
+package authztraefikgateway
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// varyHeaders returns the request headers a response depends on through the token sources, for the
+// Vary response header. Cookie tokens vary on the whole Cookie header; query tokens are part of the URL.
+func varyHeaders(extractors []TokenExtractor) []string {
+	var headers []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			headers = append(headers, name)
+		}
+	}
+	for _, extractor := range extractors {
+		switch e := extractor.(type) {
+		case BearerTokenExtractor:
+			if e.Header == "" {
+				add("Authorization")
+			} else {
+				add(e.Header)
+			}
+		case HeaderTokenExtractor:
+			add(e.Header)
+		case CookieTokenExtractor:
+			add("Cookie")
+		}
+	}
+	return headers
+}
+
+// cachingWriter adds Vary and, optionally, Cache-Control: private to a response once its headers are
+// final, so shared caches never serve one user's authorized response to another
+type cachingWriter struct {
+	http.ResponseWriter
+	vary        []string
+	private     bool
+	wroteHeader bool
+}
+
+// withCachingHeaders wraps w when any caching header is to be set
+func (am *AuthMiddleware) withCachingHeaders(w http.ResponseWriter) http.ResponseWriter {
+	if len(am.varyHeaders) == 0 && !am.cacheControlPrivate {
+		return w
+	}
+	return &cachingWriter{ResponseWriter: w, vary: am.varyHeaders, private: am.cacheControlPrivate}
+}
+
+// WriteHeader implements http.ResponseWriter
+func (cw *cachingWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.setHeaders()
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (cw *cachingWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, for streamed responses
+func (cw *cachingWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, for WebSocket upgrades
+func (cw *cachingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", cw.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+// setHeaders merges the Vary headers with those of the upstream and makes the response private
+func (cw *cachingWriter) setHeaders() {
+	header := cw.Header()
+	if len(cw.vary) > 0 {
+		present := map[string]bool{}
+		for _, value := range header.Values("Vary") {
+			for _, name := range strings.Split(value, ",") {
+				present[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+			}
+		}
+		if !present["*"] {
+			for _, name := range cw.vary {
+				if !present[name] {
+					header.Add("Vary", name)
+				}
+			}
+		}
+	}
+	if cw.private {
+		header.Set("Cache-Control", privateCacheControl(header.Get("Cache-Control")))
+	}
+}
+
+// privateCacheControl turns a Cache-Control value into one that forbids shared caches, dropping the
+// public and s-maxage directives and keeping everything else
+func privateCacheControl(value string) string {
+	directives := []string{"private"}
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		name := strings.ToLower(directive)
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		switch name {
+		case "", "private", "public", "s-maxage":
+			continue
+		case "no-store":
+			return value
+		}
+		directives = append(directives, directive)
+	}
+	return strings.Join(directives, ", ")
+}
@@ -0,0 +1,98 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestVaryHeaders(t *testing.T) {
+	extractors, err := newTokenExtractors([]TokenSource{
+		{Type: "bearer"},
+		{Type: "header", Name: "x-access-token"},
+		{Type: "cookie", Name: "session"},
+		{Type: "cookie", Name: "other"},
+		{Type: "query", Name: "access_token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Authorization", "X-Access-Token", "Cookie"}
+	if got := varyHeaders(extractors); !reflect.DeepEqual(got, want) {
+		t.Errorf("varyHeaders = %v, want %v", got, want)
+	}
+}
+
+func TestPrivateCacheControl(t *testing.T) {
+	cases := map[string]string{
+		"":                                 "private",
+		"public, max-age=60, s-maxage=600": "private, max-age=60",
+		"private, no-cache":                "private, no-cache",
+		"no-store":                         "no-store",
+	}
+	for value, want := range cases {
+		if got := privateCacheControl(value); got != want {
+			t.Errorf("privateCacheControl(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestCachingHeaders(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Vary", "Accept-Encoding, authorization")
+		rw.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = rw.Write([]byte("ok"))
+	})
+	serve := func(config *Config, authorization string) *httptest.ResponseRecorder {
+		handler, err := New(context.Background(), next, config, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	config := &Config{
+		KeycloakURL:         srv.URL,
+		TokenSources:        []TokenSource{{Type: "bearer"}, {Type: "cookie", Name: "session"}},
+		CacheControlPrivate: true,
+	}
+	recorder := serve(config, "Bearer "+token)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+	if got, want := recorder.Header().Values("Vary"), []string{"Accept-Encoding, authorization", "Cookie"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Vary = %v, want %v", got, want)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Cache-Control = %q, want private, max-age=60", got)
+	}
+
+	// Denials vary on the token sources too
+	recorder = serve(config, "")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", recorder.Code)
+	}
+	if got, want := recorder.Header().Values("Vary"), []string{"Authorization", "Cookie"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("denial Vary = %v, want %v", got, want)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "private" {
+		t.Errorf("denial Cache-Control = %q, want private", got)
+	}
+
+	recorder = serve(&Config{KeycloakURL: srv.URL, DisableVary: true}, "Bearer "+token)
+	if got, want := recorder.Header().Values("Vary"), []string{"Accept-Encoding, authorization"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("disabled Vary = %v, want %v", got, want)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q, want it untouched", got)
+	}
+}
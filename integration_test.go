@@ -0,0 +1,268 @@
+//go:build integration
+// +build integration
+
+package authztraefikgateway
+
+// Integration tests against a real Keycloak. Run with:
+//
+//	go test -tags integration -run Integration -v .
+//
+// Keycloak is started with docker (image from KEYCLOAK_IMAGE) unless KEYCLOAK_INTEGRATION_URL points to a
+// running instance with the admin/admin master account. The suite only uses the standard library, like
+// the plugin itself, so it drives docker and the Keycloak admin REST API directly.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	defaultKeycloakImage = "quay.io/keycloak/keycloak:24.0"
+	integrationRealm     = "authz-it"
+	integrationClient    = "gateway"
+	integrationSecret    = "gateway-secret"
+)
+
+// startKeycloak returns the base URL of a Keycloak instance, starting a container when needed
+func startKeycloak(t *testing.T) string {
+	t.Helper()
+	if base := os.Getenv("KEYCLOAK_INTEGRATION_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	image := os.Getenv("KEYCLOAK_IMAGE")
+	if image == "" {
+		image = defaultKeycloakImage
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-p", "127.0.0.1::8080",
+		"-e", "KEYCLOAK_ADMIN=admin", "-e", "KEYCLOAK_ADMIN_PASSWORD=admin", image, "start-dev").Output()
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+	container := strings.TrimSpace(string(out))
+	t.Cleanup(func() { _ = exec.Command("docker", "rm", "-f", container).Run() })
+
+	out, err = exec.Command("docker", "port", container, "8080/tcp").Output()
+	if err != nil {
+		t.Fatalf("docker port: %v", err)
+	}
+	base := "http://" + strings.TrimSpace(strings.Split(string(out), "\n")[0])
+
+	deadline := time.Now().Add(3 * time.Minute)
+	for {
+		resp, err := http.Get(base + "/realms/master")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return base
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Keycloak did not start within 3 minutes")
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// keycloakAdmin calls the Keycloak admin REST API
+type keycloakAdmin struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+func newKeycloakAdmin(t *testing.T, base string) *keycloakAdmin {
+	form := url.Values{"grant_type": {"password"}, "client_id": {"admin-cli"}, "username": {"admin"}, "password": {"admin"}}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	postForm(t, base+"/realms/master/protocol/openid-connect/token", form, &tokens)
+	return &keycloakAdmin{t: t, base: base, token: tokens.AccessToken}
+}
+
+// create POSTs a representation and returns the ID from the Location header, if any
+func (ka *keycloakAdmin) create(path string, representation interface{}) string {
+	ka.t.Helper()
+	body, _ := json.Marshal(representation)
+	req, _ := http.NewRequest(http.MethodPost, ka.base+"/admin/realms"+path, strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+ka.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ka.t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		ka.t.Fatalf("POST %s: %s %s", path, resp.Status, message)
+	}
+	location := resp.Header.Get("Location")
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+// postForm posts a form and decodes the JSON answer into v
+func postForm(t *testing.T, endpoint string, form url.Values, v interface{}) {
+	t.Helper()
+	resp, err := http.PostForm(endpoint, form)
+	if err != nil {
+		t.Fatalf("POST %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST %s: %s %s", endpoint, resp.Status, message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("POST %s: %v", endpoint, err)
+	}
+}
+
+// provisionRealm creates a realm with users alice and bob and a resource server client where only
+// alice may "get" the resource "user", and nobody may "delete" it
+func provisionRealm(t *testing.T, base string) {
+	admin := newKeycloakAdmin(t, base)
+	admin.create("", map[string]interface{}{"realm": integrationRealm, "enabled": true})
+	realm := "/" + integrationRealm
+
+	users := map[string]string{}
+	for _, name := range []string{"alice", "bob"} {
+		users[name] = admin.create(realm+"/users", map[string]interface{}{
+			"username": name, "enabled": true, "emailVerified": true,
+			"firstName": name, "lastName": "Test", "email": name + "@example.com",
+			"credentials": []map[string]interface{}{{"type": "password", "value": name, "temporary": false}},
+		})
+	}
+
+	client := admin.create(realm+"/clients", map[string]interface{}{
+		"clientId": integrationClient, "secret": integrationSecret, "publicClient": false,
+		"serviceAccountsEnabled": true, "authorizationServicesEnabled": true,
+		"directAccessGrantsEnabled": true, "standardFlowEnabled": false,
+	})
+	authz := realm + "/clients/" + client + "/authz/resource-server"
+	for _, scope := range []string{"get", "delete"} {
+		admin.create(authz+"/scope", map[string]interface{}{"name": scope})
+	}
+	admin.create(authz+"/resource", map[string]interface{}{
+		"name": "user", "uris": []string{"/api/v1/user/*"},
+		"scopes": []map[string]string{{"name": "get"}, {"name": "delete"}},
+	})
+	admin.create(authz+"/policy/user", map[string]interface{}{"name": "only-alice", "users": []string{users["alice"]}})
+	admin.create(authz+"/permission/scope", map[string]interface{}{
+		"name": "user-get", "resources": []string{"user"}, "scopes": []string{"get"}, "policies": []string{"only-alice"},
+	})
+}
+
+// userToken logs a user in with the resource owner password grant
+func userToken(t *testing.T, tokenEndpoint, username string) string {
+	form := url.Values{
+		"grant_type": {"password"}, "client_id": {integrationClient}, "client_secret": {integrationSecret},
+		"username": {username}, "password": {username},
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	postForm(t, tokenEndpoint, form, &tokens)
+	return tokens.AccessToken
+}
+
+func TestIntegrationKeycloak(t *testing.T) {
+	base := startKeycloak(t)
+	provisionRealm(t, base)
+	tokenEndpoint := base + "/realms/" + integrationRealm + "/protocol/openid-connect/token"
+	alice, bob := userToken(t, tokenEndpoint, "alice"), userToken(t, tokenEndpoint, "bob")
+
+	// Keycloak is reached through a proxy counting the UMA calls, to observe caching
+	target, _ := url.Parse(base)
+	var calls int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		httputil.NewSingleHostReverseProxy(target).ServeHTTP(rw, req)
+	}))
+	defer proxy.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	newHandler := func(config *Config) http.Handler {
+		handler, err := New(context.Background(), next, config, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	serveWith := func(handler http.Handler, accessToken, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	config := func() *Config {
+		return &Config{
+			KeycloakURL:      proxy.URL + "/realms/" + integrationRealm + "/protocol/openid-connect/token",
+			KeycloakClientId: integrationClient,
+			DenyReasonHeader: "X-Authz-Reason",
+		}
+	}
+
+	t.Run("allow and deny", func(t *testing.T) {
+		handler := newHandler(config())
+		tests := []struct {
+			name        string
+			accessToken string
+			path        string
+			status      int
+			reason      string
+		}{
+			{"granted", alice, "/api/v1/user/get", http.StatusOK, ""},
+			{"policy denial", bob, "/api/v1/user/get", http.StatusForbidden, ReasonAccessDenied},
+			{"no permission for scope", alice, "/api/v1/user/delete", http.StatusForbidden, ReasonAccessDenied},
+			{"unknown resource", alice, "/api/v1/order/get", http.StatusUnauthorized, ReasonInvalidResource},
+			{"invalid token", alice[:len(alice)-4] + "AAAA", "/api/v1/user/get", http.StatusUnauthorized, ReasonInvalidToken},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				recorder := serveWith(handler, test.accessToken, test.path)
+				if recorder.Code != test.status {
+					t.Errorf("expected %d, got %d", test.status, recorder.Code)
+				}
+				if reason := recorder.Header().Get("X-Authz-Reason"); reason != test.reason {
+					t.Errorf("expected reason %q, got %q", test.reason, reason)
+				}
+			})
+		}
+	})
+
+	t.Run("caching", func(t *testing.T) {
+		cached := config()
+		cached.Cache = CacheConfig{Enabled: true, TTL: "1m"}
+		handler := newHandler(cached)
+		before := atomic.LoadInt64(&calls)
+		for i := 0; i < 3; i++ {
+			if recorder := serveWith(handler, alice, "/api/v1/user/get"); recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", recorder.Code)
+			}
+		}
+		if n := atomic.LoadInt64(&calls) - before; n != 1 {
+			t.Errorf("expected 1 Keycloak call for 3 identical requests, got %d", n)
+		}
+	})
+
+	t.Run("keycloak unreachable", func(t *testing.T) {
+		unreachable := config()
+		unreachable.KeycloakURL = fmt.Sprintf("http://127.0.0.1:%d/token", 1)
+		recorder := serveWith(newHandler(unreachable), alice, "/api/v1/user/get")
+		if reason := recorder.Header().Get("X-Authz-Reason"); reason != ReasonNetworkError {
+			t.Errorf("expected reason %q, got %q (status %d)", ReasonNetworkError, reason, recorder.Code)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// maxResponseBytes caps the bodies read from Keycloak and other backends, after decompression
+	maxResponseBytes = 1 << 20
+	// maxJSONDepth caps the nesting of the JSON values skipped while decoding
+	maxJSONDepth = 32
+)
+
+var errResponseTooLarge = fmt.Errorf("response body exceeds %d bytes", maxResponseBytes)
+
+// readLimited reads r up to maxResponseBytes
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseBytes {
+		return nil, errResponseTooLarge
+	}
+	return data, nil
+}
+
+// fieldDecoder decodes the value of an object field from dec
+type fieldDecoder func(dec *json.Decoder) error
+
+// decodeFields decodes the listed fields of the JSON object in data and skips every other one without
+// materializing it, so memory depends on the fields used rather than on the size of the document
+func decodeFields(data []byte, fields map[string]fieldDecoder) error {
+	return decodeObject(json.NewDecoder(bytes.NewReader(data)), func(name string) fieldDecoder { return fields[name] })
+}
+
+// decodeObject streams a JSON object from dec, decoding each field with the decoder returned by field
+// for its name, or skipping it when that is nil
+func decodeObject(dec *json.Decoder, field func(name string) fieldDecoder) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	return decodeMembers(dec, field)
+}
+
+// decodeMembers decodes the fields of an object whose opening delimiter was just read, like decodeObject
+func decodeMembers(dec *json.Decoder, field func(name string) fieldDecoder) error {
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := token.(string)
+		if decode := field(name); decode != nil {
+			err = decode(dec)
+		} else {
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// decodeArray streams a JSON array from dec, decoding each element with element
+func decodeArray(dec *json.Decoder, element fieldDecoder) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := element(dec); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// decodeString is a fieldDecoder storing a string value in dst
+func decodeString(dst *string) fieldDecoder {
+	return func(dec *json.Decoder) error { return dec.Decode(dst) }
+}
+
+// expectDelim consumes the opening delimiter of an object or array
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue consumes the next value of dec, token by token
+func skipValue(dec *json.Decoder) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); ok && (d == '{' || d == '[') {
+		return skipNested(dec)
+	}
+	return nil
+}
+
+// skipNested consumes the rest of an object or array whose opening delimiter was just read
+func skipNested(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			if depth++; depth > maxJSONDepth {
+				return errors.New("JSON nested too deeply")
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
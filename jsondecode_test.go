@@ -0,0 +1,75 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFields(t *testing.T) {
+	var code string
+	body := []byte(`{"details":{"nested":[1,{"a":[true,null]}]},"error":"invalid_resource","extra":"x"}`)
+	if err := decodeFields(body, map[string]fieldDecoder{"error": decodeString(&code)}); err != nil || code != "invalid_resource" {
+		t.Fatalf("expected invalid_resource, got %q (%v)", code, err)
+	}
+
+	deep := []byte(`{"x":` + strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1) + `}`)
+	if err := decodeFields(deep, nil); err == nil {
+		t.Error("expected an error for a too deeply nested value")
+	}
+	if err := decodeFields([]byte(`[]`), nil); err == nil {
+		t.Error("expected an error for a non-object document")
+	}
+}
+
+func TestReadResponseBodyLimit(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write(bytes.Repeat([]byte(" "), maxResponseBytes+1))
+	_ = zw.Close()
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: io.NopCloser(&compressed)}
+	if _, err := readResponseBody(resp); err != errResponseTooLarge {
+		t.Errorf("expected the decompressed body to be capped, got %v", err)
+	}
+}
+
+func TestTokenClaimValuesStreaming(t *testing.T) {
+	accessToken := jwtWithClaims(`{"big":{"deep":[[[{"x":1}]]]},"realm_access":{"roles":["admin",{"ignored":true},"user"]},"resource_access":{"orders":{},"billing":{"roles":["x"]}},"tenant":"acme"}`)
+	roles := tokenClaimValues(accessToken, []string{"realm_access", "roles"})
+	if !reflect.DeepEqual(roles, []string{"admin", "user"}) {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	clients := tokenClaimValues(accessToken, []string{"resource_access"})
+	sort.Strings(clients)
+	if !reflect.DeepEqual(clients, []string{"billing", "orders"}) {
+		t.Errorf("unexpected clients %v", clients)
+	}
+	if tenant := tokenClaimValues(accessToken, []string{"tenant"}); !reflect.DeepEqual(tenant, []string{"acme"}) {
+		t.Errorf("unexpected tenant %v", tenant)
+	}
+	if missing := tokenClaimValues(accessToken, []string{"tenant", "id"}); missing != nil {
+		t.Errorf("expected no values below a string claim, got %v", missing)
+	}
+}
+
+func TestParseGrantedStreaming(t *testing.T) {
+	am := &AuthMiddleware{responseMode: responseModePermissions}
+	granted, err := am.parseGranted([]byte(`[{"rsid":"1","rsname":"orders","scopes":["read"],"claims":{"x":[1]}}]`), false)
+	if err != nil || !reflect.DeepEqual(granted, []GrantedPermission{{ResourceID: "1", ResourceName: "orders", Scopes: []string{"read"}}}) {
+		t.Errorf("unexpected permissions %+v (%v)", granted, err)
+	}
+
+	am.responseMode = responseModeRPT
+	rpt := jwtWithClaims(`{"sub":"alice","authorization":{"permissions":[{"rsname":"orders","scopes":["write"]}]},"realm_access":{"roles":["a"]}}`)
+	body, _ := json.Marshal(map[string]string{"access_token": rpt, "refresh_token": "r"})
+	granted, err = am.parseGranted(body, true)
+	if err != nil || len(granted) != 1 || granted[0].ResourceName != "orders" || granted[0].Scopes[0] != "write" {
+		t.Errorf("unexpected RPT permissions %+v (%v)", granted, err)
+	}
+}
@@ -0,0 +1,231 @@
+package authztraefikgateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// decodeJWTPayload decodes the (unverified) payload segment of a compact JWT into v
+func decodeJWTPayload(raw string, v interface{}) error {
+	return decodeJWTSegment(raw, 1, "payload", v)
+}
+
+// decodeJWTHeader decodes the (unverified) JOSE header of a compact JWT into v
+func decodeJWTHeader(raw string, v interface{}) error {
+	return decodeJWTSegment(raw, 0, "header", v)
+}
+
+// decodeJWTSegment decodes segment i (named for errors) of a compact JWT into v
+func decodeJWTSegment(raw string, i int, name string, v interface{}) error {
+	segment, err := jwtSegment(raw, i, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(segment, v); err != nil {
+		return fmt.Errorf("malformed JWT %s: %w", name, err)
+	}
+	return nil
+}
+
+// jwtSegment returns the decoded JSON of segment i (named for errors) of a compact JWT
+func jwtSegment(raw string, i int, name string) ([]byte, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT: expected 3 segments, got %d", len(parts))
+	}
+	segment, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[i], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT %s: %w", name, err)
+	}
+	return segment, nil
+}
+
+// tokenClaimValues returns the string values of a (dotted, split) claim path of a JWT access token. The
+// claim may be a string, an array of strings, or an object whose keys are returned. The payload is
+// streamed and only the claims along path are decoded.
+func tokenClaimValues(accessToken string, path []string) []string {
+	payload, err := jwtSegment(accessToken, 1, "payload")
+	if err != nil || len(path) == 0 {
+		return nil
+	}
+	var values []string
+	if err := decodeFields(payload, map[string]fieldDecoder{path[0]: claimValues(path[1:], &values)}); err != nil {
+		return nil
+	}
+	return values
+}
+
+// claimValues is a field decoder following the rest of a claim path and appending the values found:
+// a string, the strings of an array, or the keys of an object. Anything else is skipped.
+func claimValues(path []string, values *[]string) fieldDecoder {
+	return func(dec *json.Decoder) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if len(path) > 0 {
+			switch token {
+			case json.Delim('{'):
+				return decodeMembers(dec, func(name string) fieldDecoder {
+					if name == path[0] {
+						return claimValues(path[1:], values)
+					}
+					return nil
+				})
+			case json.Delim('['):
+				return skipNested(dec)
+			}
+			return nil
+		}
+
+		switch token {
+		case json.Delim('['):
+			for dec.More() {
+				item, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if s, ok := item.(string); ok {
+					*values = append(*values, s)
+				} else if d, ok := item.(json.Delim); ok && (d == '{' || d == '[') {
+					if err := skipNested(dec); err != nil {
+						return err
+					}
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('{'):
+			return decodeMembers(dec, func(name string) fieldDecoder {
+				*values = append(*values, name)
+				return nil
+			})
+		}
+		if s, ok := token.(string); ok {
+			*values = append(*values, s)
+		}
+		return nil
+	}
+}
+
+// tokenHasScope reports whether the space-separated "scope" claim of a JWT access token contains scope
+func tokenHasScope(accessToken, scope string) bool {
+	for _, value := range tokenClaimValues(accessToken, []string{"scope"}) {
+		for _, s := range strings.Fields(value) {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyJWTSignature verifies the signature of a compact JWT with any of keys. RSA (RS*, PS*), ECDSA
+// (ES*) and Ed25519 (EdDSA) signatures are supported; "none" and HMAC algorithms are rejected.
+func verifyJWTSignature(raw string, keys []crypto.PublicKey) error {
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTHeader(raw, &header); err != nil {
+		return err
+	}
+	i := strings.LastIndex(raw, ".")
+	signed := raw[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(raw[i+1:], "="))
+	if err != nil {
+		return fmt.Errorf("malformed JWT signature: %w", err)
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest = h.Sum(nil)
+	}
+
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			switch header.Alg[:2] {
+			case "RS":
+				if rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+					return nil
+				}
+			case "PS":
+				if rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+					return nil
+				}
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if header.Alg[:2] == "ES" && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return nil
+				}
+			}
+		case ed25519.PublicKey:
+			if header.Alg == "EdDSA" && ed25519.Verify(key, []byte(signed), signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("invalid JWT signature")
+}
+
+// parsePublicKeys parses the PEM public keys ("PUBLIC KEY", "RSA PUBLIC KEY") and certificates of data
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	return keys, nil
+}
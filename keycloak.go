@@ -0,0 +1,201 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Response modes supported by the Keycloak UMA grant
+const (
+	responseModeRPT         = ""            // default: Keycloak issues an RPT
+	responseModeDecision    = "decision"    // Keycloak answers {"result": true}
+	responseModePermissions = "permissions" // Keycloak answers with the list of granted permissions
+)
+
+// GrantedPermission is a resource and the scopes Keycloak granted on it
+type GrantedPermission struct {
+	ResourceID   string   `json:"rsid,omitempty"`
+	ResourceName string   `json:"rsname,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// keycloakResult holds the outcome of a single UMA evaluation
+type keycloakResult struct {
+	status    int
+	body      []byte
+	errorCode string
+	// errorDescription is Keycloak's error_description, e.g. "Resource with id [x] does not exist."
+	errorDescription string
+	granted          []GrantedPermission
+}
+
+// evaluate asks Keycloak whether the bearer of accessToken holds permission for audience, at endpoint
+// or else keycloakURL. claims, if any, are pushed to Keycloak as a claim_token.
+func (am *AuthMiddleware) evaluate(ctx context.Context, accessToken, permission, audience, endpoint string, claims map[string][]string) (*keycloakResult, error) {
+	return am.evaluatePermissions(ctx, accessToken, []string{permission}, am.responseMode, audience, endpoint, claims)
+}
+
+// evaluatePermissions is evaluate for several permissions in a single UMA request, answered in the
+// given response mode
+func (am *AuthMiddleware) evaluatePermissions(ctx context.Context, accessToken string, permissions []string, responseMode, audience, endpoint string, claims map[string][]string) (*keycloakResult, error) {
+	rc := am.runtimeFor(ctx)
+	if endpoint == "" {
+		endpoint = rc.keycloakUrl
+	}
+	formData := url.Values{}
+	for _, permission := range permissions {
+		formData.Add("permission", permission)
+	}
+	formData.Set("grant_type", "urn:ietf:params:oauth:grant-type:uma-ticket")
+	// Keycloak evaluates against the token's azp when audience is omitted
+	if !am.minimalPayloads || audience != tokenAuthorizedParty(accessToken) {
+		formData.Set("audience", audience)
+	}
+	if responseMode != responseModeRPT {
+		formData.Set("response_mode", responseMode)
+	}
+	if am.includeResourceName {
+		formData.Set("response_include_resource_name", "true")
+	}
+	if len(claims) > 0 {
+		claimToken, err := encodeClaimToken(claims)
+		if err != nil {
+			return nil, fmt.Errorf("encoding claim_token: %w", err)
+		}
+		formData.Set("claim_token", claimToken)
+		formData.Set("claim_token_format", claimTokenFormat)
+	}
+
+	kcReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating Keycloak request: %w", err)
+	}
+	kcReq.Header.Set("Authorization", "Bearer "+accessToken)
+	kcReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	kcReq.Header.Set("Accept-Encoding", "gzip")
+	am.log(logDebug, "🔄 [REQUEST] Sending request to Keycloak:", endpoint)
+
+	kcResp, err := am.client.Do(kcReq)
+	if err != nil {
+		return nil, err
+	}
+	defer kcResp.Body.Close()
+
+	bodyBytes, err := readResponseBody(kcResp)
+	if err != nil {
+		return nil, fmt.Errorf("reading Keycloak response: %w", err)
+	}
+	am.log(logDebug, "🔎 [HTTP] Keycloak response status:", kcResp.Status)
+
+	result := &keycloakResult{status: kcResp.StatusCode, body: bodyBytes}
+	if kcResp.StatusCode != http.StatusOK {
+		// Error bodies carry no tokens; successful ones may contain an RPT and are never logged
+		am.log(logDebug, "📦 [HTTP] Keycloak response body:", string(bodyBytes))
+		kcErr := parseKeycloakError(bodyBytes)
+		result.errorCode, result.errorDescription = kcErr.Error, kcErr.ErrorDescription
+		return result, nil
+	}
+
+	granted, err := parseGrantedMode(bodyBytes, responseMode, rc.parseRPTGrants)
+	if err != nil {
+		am.log(logWarn, "⚠️  [HTTP] Could not parse granted permissions:", err)
+	}
+	result.granted = granted
+	return result, nil
+}
+
+// readResponseBody reads a response body up to maxResponseBytes, decompressing it when gzip-encoded.
+// Setting Accept-Encoding explicitly disables the transport's transparent decompression.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return readLimited(resp.Body)
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readLimited(reader)
+}
+
+// tokenAuthorizedParty returns the "azp" claim of a JWT access token, or "" for opaque tokens
+func tokenAuthorizedParty(accessToken string) string {
+	var claims struct {
+		AuthorizedParty string `json:"azp"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return ""
+	}
+	return claims.AuthorizedParty
+}
+
+// parseGranted extracts the granted permissions from a successful Keycloak response. RPTs are only
+// decoded when parseRPT is set. Only the permission fields are decoded; the rest of the response and
+// of the RPT claims is skipped.
+func (am *AuthMiddleware) parseGranted(body []byte, parseRPT bool) ([]GrantedPermission, error) {
+	return parseGrantedMode(body, am.responseMode, parseRPT)
+}
+
+// parseGrantedMode is parseGranted for a response in the given response mode
+func parseGrantedMode(body []byte, responseMode string, parseRPT bool) ([]GrantedPermission, error) {
+	switch responseMode {
+	case responseModePermissions:
+		var granted []GrantedPermission
+		err := decodeArray(json.NewDecoder(bytes.NewReader(body)), decodeGrantedPermission(&granted))
+		return granted, err
+	case responseModeRPT:
+		if !parseRPT {
+			return nil, nil
+		}
+		var rpt string
+		if err := decodeFields(body, map[string]fieldDecoder{"access_token": decodeString(&rpt)}); err != nil {
+			return nil, err
+		}
+		payload, err := jwtSegment(rpt, 1, "payload")
+		if err != nil {
+			return nil, err
+		}
+		var granted []GrantedPermission
+		err = decodeFields(payload, map[string]fieldDecoder{
+			"authorization": func(dec *json.Decoder) error {
+				return decodeObject(dec, func(name string) fieldDecoder {
+					if name != "permissions" {
+						return nil
+					}
+					return func(dec *json.Decoder) error { return decodeArray(dec, decodeGrantedPermission(&granted)) }
+				})
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("malformed JWT payload: %w", err)
+		}
+		return granted, nil
+	}
+	return nil, nil
+}
+
+// decodeGrantedPermission is an array element decoder appending the permission fields to granted
+func decodeGrantedPermission(granted *[]GrantedPermission) fieldDecoder {
+	return func(dec *json.Decoder) error {
+		var p GrantedPermission
+		err := decodeObject(dec, func(name string) fieldDecoder {
+			switch name {
+			case "rsid":
+				return decodeString(&p.ResourceID)
+			case "rsname":
+				return decodeString(&p.ResourceName)
+			case "scopes":
+				return func(dec *json.Decoder) error { return dec.Decode(&p.Scopes) }
+			}
+			return nil
+		})
+		*granted = append(*granted, p)
+		return err
+	}
+}
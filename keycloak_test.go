@@ -0,0 +1,163 @@
+package authztraefikgateway
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGrantedPermissions(t *testing.T) {
+	expected := []GrantedPermission{{ResourceID: "1234", ResourceName: "user", Scopes: []string{"get"}}}
+	claims := `{"authorization":{"permissions":[{"rsid":"1234","rsname":"user","scopes":["get"]}]}}`
+	rpt := "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+
+	tests := []struct {
+		name string
+		mode string
+		body string
+	}{
+		{"permissions", responseModePermissions, `[{"rsid":"1234","rsname":"user","scopes":["get"]}]`},
+		{"rpt", responseModeRPT, `{"access_token":"` + rpt + `"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var form map[string][]string
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_ = req.ParseForm()
+				form = req.PostForm
+				_, _ = rw.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			var granted []GrantedPermission
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				granted = GrantedPermissionsFromContext(req.Context())
+			})
+			config := &Config{KeycloakURL: srv.URL, ResponseMode: test.mode, IncludeResourceName: true}
+			handler, err := New(context.Background(), next, config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := form["response_include_resource_name"]; len(got) != 1 || got[0] != "true" {
+				t.Errorf("expected response_include_resource_name=true, got %v", got)
+			}
+			if !reflect.DeepEqual(granted, expected) {
+				t.Errorf("expected %+v, got %+v", expected, granted)
+			}
+		})
+	}
+}
+
+func TestMinimalPayloads(t *testing.T) {
+	var form map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		form = req.PostForm
+		if req.Header.Get("Accept-Encoding") != "gzip" {
+			t.Error("expected a gzip Accept-Encoding")
+		}
+		rw.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(rw)
+		_, _ = gz.Write([]byte(`{"result":true}`))
+		_ = gz.Close()
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:      srv.URL,
+		KeycloakClientId: "gateway",
+		ResponseMode:     responseModeDecision,
+		MinimalPayloads:  true,
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		azp      string
+		audience bool
+	}{
+		{"audience is azp", "gateway", false},
+		{"other azp", "mobile-app", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice","azp":"`+test.azp+`"}`))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200 from the gzip-encoded decision, got %d", recorder.Code)
+			}
+			if _, ok := form["audience"]; ok != test.audience {
+				t.Errorf("expected audience sent=%v, got %v", test.audience, form["audience"])
+			}
+		})
+	}
+}
+
+func TestRuleKeycloakOverride(t *testing.T) {
+	newStub := func(audiences *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_ = req.ParseForm()
+			*audiences = append(*audiences, req.PostForm.Get("audience"))
+			_, _ = rw.Write([]byte(`{}`))
+		}))
+	}
+	var defaultAudiences, ordersAudiences []string
+	defaultServer := newStub(&defaultAudiences)
+	defer defaultServer.Close()
+	ordersServer := newStub(&ordersAudiences)
+	defer ordersServer.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:      defaultServer.URL,
+		KeycloakClientId: "gateway",
+		Rules: []Rule{
+			{Prefix: "/orders/", Resolver: "method", Resource: "order", KeycloakClientId: "orders-service", KeycloakURL: ordersServer.URL},
+			{Prefix: "/billing/", Resolver: "method", Resource: "invoice", KeycloakClientId: "billing-service"},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/orders/1", "/billing/1", "/api/v1/user/get"} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if expected := []string{"orders-service"}; !reflect.DeepEqual(ordersAudiences, expected) {
+		t.Errorf("expected %v at the overridden endpoint, got %v", expected, ordersAudiences)
+	}
+	if expected := []string{"billing-service", "gateway"}; !reflect.DeepEqual(defaultAudiences, expected) {
+		t.Errorf("expected %v at keycloakURL, got %v", expected, defaultAudiences)
+	}
+}
+
+func TestResponseModeValidation(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	for _, config := range []*Config{
+		{ResponseMode: "bogus"},
+		{ResponseMode: responseModeDecision, IncludeResourceName: true},
+	} {
+		if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
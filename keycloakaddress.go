@@ -0,0 +1,98 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// keycloakAddress separates the public Keycloak URL, which tokens are issued for and clients are sent
+// to, from the internal address the gateway calls, for Keycloak deployments behind a reverse proxy or
+// path prefix
+type keycloakAddress struct {
+	public   *url.URL // realm URL derived from keycloakURL
+	internal *url.URL // replaces public in outbound calls; nil keeps them on public
+	issuer   string   // "iss" accepted from JWTs; "" leaves the check to Keycloak
+}
+
+// newKeycloakAddress builds the address mapping; it returns nil unless issuerOverride or internalURL is set
+func newKeycloakAddress(config *Config) (*keycloakAddress, error) {
+	issuerOverride, internalURL := strings.TrimSpace(config.IssuerOverride), strings.TrimSpace(config.InternalURL)
+	if issuerOverride == "" && internalURL == "" {
+		return nil, nil
+	}
+	realm := strings.TrimSuffix(strings.TrimRight(config.KeycloakURL, "/"), tokenEndpointSuffix)
+	public, err := url.Parse(realm)
+	if err != nil || (public.Scheme != "http" && public.Scheme != "https") || public.Host == "" {
+		return nil, fmt.Errorf("issuerOverride and internalURL require an absolute http(s) keycloakURL")
+	}
+	ka := &keycloakAddress{public: public, issuer: realm}
+	if issuerOverride != "" {
+		if u, err := url.Parse(issuerOverride); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("issuerOverride must be an absolute URL")
+		}
+		ka.issuer = strings.TrimRight(issuerOverride, "/")
+	}
+	if internalURL != "" {
+		internal, err := url.Parse(strings.TrimRight(internalURL, "/"))
+		if err != nil || (internal.Scheme != "http" && internal.Scheme != "https") || internal.Host == "" {
+			return nil, fmt.Errorf("internalURL must be an absolute http(s) URL")
+		}
+		ka.internal = internal
+	}
+	return ka, nil
+}
+
+// acceptsIssuer reports whether the "iss" claim of a JWT access token is the expected issuer. Opaque
+// tokens and JWTs without "iss" are left to Keycloak.
+func (ka *keycloakAddress) acceptsIssuer(accessToken string) bool {
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil || claims.Issuer == "" {
+		return true
+	}
+	return strings.TrimRight(claims.Issuer, "/") == ka.issuer
+}
+
+// transport returns next, sending requests for URLs below the public realm URL to the internal address
+func (ka *keycloakAddress) transport(next http.RoundTripper) http.RoundTripper {
+	if ka == nil || ka.internal == nil {
+		return next
+	}
+	return &internalURLTransport{address: ka, next: next}
+}
+
+// internalURLTransport rewrites public Keycloak URLs to the internal address
+type internalURLTransport struct {
+	address *keycloakAddress
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The public host and scheme are passed in X-Forwarded-Host and
+// X-Forwarded-Proto, so a Keycloak trusting proxy headers keeps issuing tokens for the public URL.
+func (t *internalURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	public, internal := t.address.public, t.address.internal
+	if req.URL.Scheme != public.Scheme || !strings.EqualFold(req.URL.Host, public.Host) || !strings.HasPrefix(req.URL.Path, public.Path) {
+		return t.next.RoundTrip(req)
+	}
+	rest := req.URL.Path[len(public.Path):]
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return t.next.RoundTrip(req)
+	}
+	rewritten := req.Clone(req.Context())
+	rewritten.URL.Scheme, rewritten.URL.Host = internal.Scheme, internal.Host
+	rewritten.URL.Path, rewritten.URL.RawPath = internal.Path+rest, ""
+	rewritten.Host = ""
+	rewritten.Header.Set("X-Forwarded-Host", public.Host)
+	rewritten.Header.Set("X-Forwarded-Proto", public.Scheme)
+	return t.next.RoundTrip(rewritten)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport
+func (t *internalURLTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
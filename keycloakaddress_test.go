@@ -0,0 +1,70 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeycloakAddress(t *testing.T) {
+	var path, forwardedHost string
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		path, forwardedHost = req.URL.Path, req.Header.Get("X-Forwarded-Host")
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	t.Cleanup(internal.Close)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	tests := []struct {
+		name           string
+		issuerOverride string
+		issuer         string
+		expected       int
+	}{
+		{"public issuer", "", "https://sso.example.com/auth/realms/demo", http.StatusOK},
+		{"other issuer", "", "https://evil.example.com/realms/demo", http.StatusUnauthorized},
+		{"overridden issuer", "https://login.example.com/realms/demo/", "https://login.example.com/realms/demo", http.StatusOK},
+		{"public issuer when overridden", "https://login.example.com/realms/demo", "https://sso.example.com/auth/realms/demo", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, forwardedHost = "", ""
+			config := &Config{
+				KeycloakURL:    "https://sso.example.com/auth/realms/demo/protocol/openid-connect/token",
+				InternalURL:    internal.URL + "/realms/demo",
+				IssuerOverride: test.issuerOverride,
+			}
+			handler, err := New(context.Background(), next, config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"alice","iss":"`+test.issuer+`"}`))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected != http.StatusOK {
+				if path != "" {
+					t.Error("expected a token of another issuer to be rejected without calling Keycloak")
+				}
+				return
+			}
+			if path != "/realms/demo/protocol/openid-connect/token" || forwardedHost != "sso.example.com" {
+				t.Errorf("expected the call on the internal address, got path %q, X-Forwarded-Host %q", path, forwardedHost)
+			}
+		})
+	}
+
+	for _, invalid := range []*Config{
+		{KeycloakURL: "https://sso.example.com/realms/demo/protocol/openid-connect/token", InternalURL: "keycloak:8080"},
+		{KeycloakURL: "/realms/demo/protocol/openid-connect/token", InternalURL: "http://keycloak:8080/realms/demo"},
+		{KeycloakURL: "https://sso.example.com/realms/demo/protocol/openid-connect/token", IssuerOverride: "demo"},
+	} {
+		if errs := invalid.validate(); len(errs) == 0 {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
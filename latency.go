@@ -0,0 +1,227 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of latency tracking
+const (
+	defaultLatencyWindow       = 1024
+	defaultLatencyMaxResources = 200
+	latencySLOCheckInterval    = 10 * time.Second
+	latencySLOMinSamples       = 20 // percentiles of fewer samples are not checked against the SLO
+)
+
+// latencyOtherResource collects the samples of resources beyond latency.maxResources
+const latencyOtherResource = "_other"
+
+// LatencyConfig tracks authorization latency percentiles per resource
+type LatencyConfig struct {
+	Enabled      bool              `json:"enabled,omitempty"`
+	Window       int               `json:"window,omitempty"`       // samples kept per resource (default 1024)
+	MaxResources int               `json:"maxResources,omitempty"` // resources tracked individually (default 200)
+	SLO          string            `json:"slo,omitempty"`          // p95 target for every resource, e.g. "250ms"
+	ResourceSLOs map[string]string `json:"resourceSLOs,omitempty"` // p95 targets per resource, overriding slo
+}
+
+// ResourceLatency holds the latency percentiles of one resource over the tracking window
+type ResourceLatency struct {
+	Resource string        `json:"resource"`
+	Count    int           `json:"count"` // samples in the window
+	P50      time.Duration `json:"p50"`
+	P95      time.Duration `json:"p95"`
+	P99      time.Duration `json:"p99"`
+	SLO      time.Duration `json:"slo,omitempty"`
+	Breached bool          `json:"breached,omitempty"` // p95 above the SLO
+}
+
+// latencyRing is a fixed-size ring buffer of latency samples
+type latencyRing struct {
+	samples   []time.Duration
+	next      int
+	full      bool
+	lastCheck time.Time
+}
+
+func (r *latencyRing) add(d time.Duration) {
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// sorted returns a sorted copy of the samples in the window
+func (r *latencyRing) sorted() []time.Duration {
+	n := r.next
+	if r.full {
+		n = len(r.samples)
+	}
+	sorted := append([]time.Duration(nil), r.samples[:n]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// percentile returns the p-th percentile (0-100) of sorted samples (nearest rank)
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// latencyTracker keeps a ring of authorization latencies per resource
+type latencyTracker struct {
+	window        int
+	maxResources  int
+	slo           time.Duration
+	sloByResource map[string]time.Duration
+	checkInterval time.Duration // minimum time between SLO checks of a resource
+
+	mu    sync.Mutex
+	rings map[string]*latencyRing
+}
+
+// newLatencyTracker builds the tracker from config; it returns nil when tracking is disabled
+func newLatencyTracker(config LatencyConfig) (*latencyTracker, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Window < 0 || config.MaxResources < 0 {
+		return nil, fmt.Errorf("latency.window and latency.maxResources must not be negative")
+	}
+	lt := &latencyTracker{
+		window:        config.Window,
+		maxResources:  config.MaxResources,
+		sloByResource: make(map[string]time.Duration, len(config.ResourceSLOs)),
+		checkInterval: latencySLOCheckInterval,
+		rings:         make(map[string]*latencyRing),
+	}
+	if lt.window == 0 {
+		lt.window = defaultLatencyWindow
+	}
+	if lt.maxResources == 0 {
+		lt.maxResources = defaultLatencyMaxResources
+	}
+	slo, err := parseDurationOrDefault(config.SLO, 0)
+	if err != nil {
+		return nil, fmt.Errorf("latency.slo: %w", err)
+	}
+	lt.slo = slo
+	for resource, value := range config.ResourceSLOs {
+		slo, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("latency.resourceSLOs.%s: %w", resource, err)
+		}
+		lt.sloByResource[resource] = slo
+	}
+	return lt, nil
+}
+
+// sloFor returns the p95 target of a resource, 0 if none
+func (lt *latencyTracker) sloFor(resource string) time.Duration {
+	if slo, ok := lt.sloByResource[resource]; ok {
+		return slo
+	}
+	return lt.slo
+}
+
+// observe records a sample. At most every checkInterval per resource, it returns the resource's
+// latency when its p95 breaches the SLO.
+func (lt *latencyTracker) observe(resource string, d time.Duration) (ResourceLatency, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	ring, ok := lt.rings[resource]
+	if !ok {
+		if len(lt.rings) >= lt.maxResources {
+			resource = latencyOtherResource
+			ring = lt.rings[resource]
+		}
+		if ring == nil {
+			ring = &latencyRing{samples: make([]time.Duration, lt.window)}
+			lt.rings[resource] = ring
+		}
+	}
+	ring.add(d)
+
+	slo := lt.sloFor(resource)
+	if slo == 0 || (!ring.full && ring.next < latencySLOMinSamples) || time.Since(ring.lastCheck) < lt.checkInterval {
+		return ResourceLatency{}, false
+	}
+	ring.lastCheck = time.Now()
+	stats := lt.statsLocked(resource, ring)
+	return stats, stats.Breached
+}
+
+// statsLocked computes the percentiles of one ring. lt.mu must be held.
+func (lt *latencyTracker) statsLocked(resource string, ring *latencyRing) ResourceLatency {
+	sorted := ring.sorted()
+	stats := ResourceLatency{
+		Resource: resource,
+		Count:    len(sorted),
+		P50:      percentile(sorted, 50),
+		P95:      percentile(sorted, 95),
+		P99:      percentile(sorted, 99),
+		SLO:      lt.sloFor(resource),
+	}
+	stats.Breached = stats.SLO > 0 && stats.P95 > stats.SLO
+	return stats
+}
+
+// stats returns the percentiles of every tracked resource, sorted by resource
+func (lt *latencyTracker) stats() []ResourceLatency {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	stats := make([]ResourceLatency, 0, len(lt.rings))
+	for resource, ring := range lt.rings {
+		stats = append(stats, lt.statsLocked(resource, ring))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Resource < stats[j].Resource })
+	return stats
+}
+
+// write renders the percentiles as a Prometheus summary
+func (lt *latencyTracker) write(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# HELP authz_latency_seconds Authorization latency per resource over the tracking window.\n")
+	b.WriteString("# TYPE authz_latency_seconds summary\n")
+	for _, stats := range lt.stats() {
+		for _, q := range []struct {
+			quantile string
+			value    time.Duration
+		}{{"0.5", stats.P50}, {"0.95", stats.P95}, {"0.99", stats.P99}} {
+			fmt.Fprintf(&b, "authz_latency_seconds{resource=%q,quantile=%q} %g\n", stats.Resource, q.quantile, q.value.Seconds())
+		}
+		fmt.Fprintf(&b, "authz_latency_seconds_count{resource=%q} %d\n", stats.Resource, stats.Count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// observeLatency records the latency of a decision evaluated for a resource
+func (am *AuthMiddleware) observeLatency(d Decision) {
+	if am.latency == nil || d.Permission.Resource == "" {
+		return
+	}
+	if stats, breached := am.latency.observe(d.Permission.Resource, d.Latency); breached {
+		am.logf(logWarn, "🐢 [LATENCY] Resource %s breaches its SLO: p95=%s > %s (p50=%s, p99=%s, %d samples)\n",
+			stats.Resource, stats.P95, stats.SLO, stats.P50, stats.P99, stats.Count)
+	}
+}
+
+// LatencyStats returns the authorization latency percentiles per resource, or nil when tracking is disabled
+func (am *AuthMiddleware) LatencyStats() []ResourceLatency {
+	if am.latency == nil {
+		return nil
+	}
+	return am.latency.stats()
+}
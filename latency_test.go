@@ -0,0 +1,85 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	lt, err := newLatencyTracker(LatencyConfig{Enabled: true, Window: 100, MaxResources: 2, SLO: "50ms", ResourceSLOs: map[string]string{"report": "500ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt.checkInterval = 0
+	var breaches []string
+	for i := 1; i <= 200; i++ {
+		// The window keeps the last 100 samples: 101ms..200ms
+		for _, resource := range []string{"order", "report"} {
+			if stats, breached := lt.observe(resource, time.Duration(i)*time.Millisecond); breached {
+				breaches = append(breaches, stats.Resource)
+			}
+		}
+	}
+	lt.observe("invoice", time.Millisecond)
+	lt.observe("payment", time.Millisecond)
+
+	stats := lt.stats()
+	if len(stats) != 3 || stats[0].Resource != latencyOtherResource || stats[0].Count != 2 {
+		t.Fatalf("expected order, report and %s, got %+v", latencyOtherResource, stats)
+	}
+	order := stats[1]
+	if order.Count != 100 || order.P50 != 150*time.Millisecond || order.P95 != 195*time.Millisecond || order.P99 != 199*time.Millisecond {
+		t.Errorf("unexpected percentiles %+v", order)
+	}
+	if !order.Breached || stats[2].Breached {
+		t.Errorf("expected only order to breach its SLO: %+v", stats)
+	}
+	// order breaches once its p95 exceeds 50ms; report never reaches 500ms
+	for _, resource := range breaches {
+		if resource != "order" {
+			t.Errorf("unexpected breach of %s", resource)
+		}
+	}
+	if len(breaches) == 0 {
+		t.Error("expected order to be reported")
+	}
+
+	lt.checkInterval = time.Hour
+	lt.rings["order"].lastCheck = time.Now()
+	if _, breached := lt.observe("order", time.Second); breached {
+		t.Error("expected SLO checks to be rate limited")
+	}
+}
+
+func TestLatencyMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, Latency: LatencyConfig{Enabled: true}}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	am := handler.(*AuthMiddleware)
+	if stats := am.LatencyStats(); len(stats) != 1 || stats[0].Resource != "user" || stats[0].Count != 1 {
+		t.Errorf("unexpected latency stats %+v", stats)
+	}
+	var metrics strings.Builder
+	if err := am.WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if sample := `authz_latency_seconds_count{resource="user"} 1`; !strings.Contains(metrics.String(), sample) {
+		t.Errorf("expected %q in metrics:\n%s", sample, metrics.String())
+	}
+}
@@ -0,0 +1,289 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of rule warnings
+const (
+	ruleWarningShadowed      = "shadowed"       // an earlier rule matches every request of the rule
+	ruleWarningOverlap       = "overlap"        // an earlier, not narrower rule takes some of the rule's requests
+	ruleWarningNeverResolves = "never_resolves" // the segment indexes are beyond any path the rule matches
+)
+
+// maxLoggedRuleWarnings bounds the warnings logged per load; the admin listing has them all
+const maxLoggedRuleWarnings = 20
+
+// RuleWarning is a likely policy bug found in the rules when the configuration is loaded. Rules are
+// never rejected for it: a shadowed rule may be deliberate while a rule set is migrated.
+type RuleWarning struct {
+	Kind   string `json:"kind"`
+	Rule   string `json:"rule"`
+	Other  string `json:"other,omitempty"` // the earlier rule taking precedence
+	Detail string `json:"detail"`
+}
+
+// lintRules analyzes the rules in evaluation order. maxSegments is the largest number of path
+// segments a request may have. The default rule is only checked for its segment indexes.
+func lintRules(rules []*compiledRule, maxSegments int) []RuleWarning {
+	var warnings []RuleWarning
+	literals := make([]string, len(rules))
+	for i, rule := range rules {
+		literals[i] = rule.literalPrefix()
+	}
+	for j, rule := range rules {
+		if w, ok := lintSegments(rule, maxSegments); ok {
+			warnings = append(warnings, w)
+		}
+		if j == len(rules)-1 {
+			break
+		}
+		for i := 0; i < j; i++ {
+			if !strings.HasPrefix(literals[i], literals[j]) && !strings.HasPrefix(literals[j], literals[i]) {
+				// Rules whose literal prefixes diverge never match a common path
+				continue
+			}
+			earlier := rules[i]
+			if pathsCover(earlier, rule, literals[j]) && methodsCover(earlier.methods, rule.methods) {
+				warnings = append(warnings, RuleWarning{
+					Kind:   ruleWarningShadowed,
+					Rule:   rule.name,
+					Other:  earlier.name,
+					Detail: fmt.Sprintf("unreachable: every request it matches is taken by rule %q", earlier.name),
+				})
+				break
+			}
+			// Specific rules before general ones are the norm; only flag the general rule coming first
+			broader := pathsCover(rule, earlier, literals[i]) && methodsCover(rule.methods, earlier.methods)
+			if !broader && pathsOverlap(earlier, literals[i], rule, literals[j]) && methodsIntersect(earlier.methods, rule.methods) {
+				warnings = append(warnings, RuleWarning{
+					Kind:   ruleWarningOverlap,
+					Rule:   rule.name,
+					Other:  earlier.name,
+					Detail: fmt.Sprintf("some of its requests are taken by rule %q", earlier.name),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// lintSegments reports a segments resolver whose indexes point beyond every path the rule matches
+func lintSegments(rule *compiledRule, maxSegments int) (RuleWarning, bool) {
+	resolver, ok := rule.resolver.(SegmentResolver)
+	if !ok {
+		return RuleWarning{}, false
+	}
+	index := resolver.ResourceIndex
+	if resolver.ScopeIndex > index {
+		index = resolver.ScopeIndex
+	}
+	segments, limit := maxSegments, "maxPathSegments"
+	if pp := rule.pattern; pp != nil && pp.suffix == "" && !pp.wildcard && len(pp.segments) < segments {
+		segments, limit = len(pp.segments), "its path"
+	}
+	if index <= segments {
+		return RuleWarning{}, false
+	}
+	return RuleWarning{
+		Kind:   ruleWarningNeverResolves,
+		Rule:   rule.name,
+		Detail: fmt.Sprintf("segment index %d is beyond the %d segments of %s, so its requests are rejected", index, segments, limit),
+	}, true
+}
+
+// pathsCover reports whether every path inner matches is matched by outer. A pattern only covers other
+// patterns, and a suffix pattern only suffix patterns.
+func pathsCover(outer, inner *compiledRule, innerLiteral string) bool {
+	if outer.pattern == nil {
+		return strings.HasPrefix(innerLiteral, outer.prefix)
+	}
+	op, ip := outer.pattern, inner.pattern
+	if ip == nil {
+		return false
+	}
+	if op.suffix != "" || ip.suffix != "" {
+		return op.suffix != "" && strings.HasSuffix(ip.suffix, op.suffix)
+	}
+	if len(ip.segments) < len(op.segments) || (!op.wildcard && (ip.wildcard || len(ip.segments) != len(op.segments))) {
+		return false
+	}
+	for k, segment := range op.segments {
+		if isPatternVariable(segment) {
+			if ip.segments[k] == "" {
+				return false
+			}
+		} else if ip.segments[k] != segment {
+			return false
+		}
+	}
+	return true
+}
+
+// pathsOverlap reports whether a and b may match a common path. Suffix patterns are not compared with
+// other matchers, which would flag nearly every pair.
+func pathsOverlap(a *compiledRule, aLiteral string, b *compiledRule, bLiteral string) bool {
+	if (a.pattern != nil && a.pattern.suffix != "") || (b.pattern != nil && b.pattern.suffix != "") {
+		return a.pattern != nil && b.pattern != nil && a.pattern.suffix != "" && b.pattern.suffix != ""
+	}
+	if a.pattern != nil && b.pattern != nil {
+		return segmentsOverlap(a.pattern, b.pattern)
+	}
+	if a.pattern == nil && b.pattern == nil {
+		return strings.HasPrefix(a.prefix, b.prefix) || strings.HasPrefix(b.prefix, a.prefix)
+	}
+	prefix, literal := a.prefix, bLiteral
+	if a.pattern != nil {
+		prefix, literal = b.prefix, aLiteral
+	}
+	return prefix == literal || strings.HasPrefix(prefix, literal+"/") || strings.HasPrefix(literal, prefix)
+}
+
+// segmentsOverlap reports whether two segment patterns match a common path
+func segmentsOverlap(a, b *pathPattern) bool {
+	if (!a.wildcard && len(b.segments) > len(a.segments)) || (!b.wildcard && len(a.segments) > len(b.segments)) {
+		return false
+	}
+	for k := 0; k < len(a.segments) && k < len(b.segments); k++ {
+		sa, sb := a.segments[k], b.segments[k]
+		switch {
+		case isPatternVariable(sa) && isPatternVariable(sb):
+		case isPatternVariable(sa):
+			if sb == "" {
+				return false
+			}
+		case isPatternVariable(sb):
+			if sa == "" {
+				return false
+			}
+		case sa != sb:
+			return false
+		}
+	}
+	return true
+}
+
+// isPatternVariable reports whether a policy-enforcer path segment is a "{...}" placeholder
+func isPatternVariable(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// methodClass returns the class of an HTTP method, SAFE or MUTATING
+func methodClass(method string) string {
+	if isSafeMethod(method) {
+		return methodClassSafe
+	}
+	return methodClassMutating
+}
+
+// methodsCover reports whether every method inner matches is matched by outer; empty sets match all
+func methodsCover(outer, inner map[string]bool) bool {
+	if len(outer) == 0 {
+		return true
+	}
+	if len(inner) == 0 {
+		return outer[methodClassSafe] && outer[methodClassMutating]
+	}
+	for method := range inner {
+		if method == methodClassSafe || method == methodClassMutating {
+			if !outer[method] {
+				return false
+			}
+		} else if !outer[method] && !outer[methodClass(method)] {
+			return false
+		}
+	}
+	return true
+}
+
+// methodsIntersect reports whether a and b match a common method
+func methodsIntersect(a, b map[string]bool) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for method := range a {
+		if method != methodClassSafe && method != methodClassMutating {
+			if b[method] || b[methodClass(method)] {
+				return true
+			}
+			continue
+		}
+		if b[method] {
+			return true
+		}
+		for other := range b {
+			if other != methodClassSafe && other != methodClassMutating && methodClass(other) == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LintConfig returns the rule warnings of a decoded configuration document that passes ValidateConfig,
+// as New would log them for the selected environment
+func LintConfig(doc map[string]interface{}) ([]RuleWarning, error) {
+	config, err := decodeConfig(doc)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err = config.withStructuredKeys()
+	if err != nil {
+		return nil, err
+	}
+	config, err = config.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := newRuntimeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return rc.ruleWarnings, nil
+}
+
+// logRuleWarnings prints the warnings of a newly loaded rule set
+func logRuleWarnings(name string, warnings []RuleWarning) {
+	for i, w := range warnings {
+		if i == maxLoggedRuleWarnings {
+			fmt.Printf("⚠️  [RULES] %s: %d more rule warnings, listed by the admin endpoint\n", name, len(warnings)-i)
+			return
+		}
+		fmt.Printf("⚠️  [RULES] %s: rule %q %s (%s)\n", name, w.Rule, w.Detail, w.Kind)
+	}
+}
+
+// RuleWarnings returns the warnings found in the current rules when they were loaded
+func (am *AuthMiddleware) RuleWarnings() []RuleWarning {
+	return am.current().ruleWarnings
+}
+
+// ruleWarningPage returns up to limit rule warnings after the cursor, in rule order. Cursors hold a
+// position in the warnings of one configuration and are rejected once it is reloaded.
+func (am *AuthMiddleware) ruleWarningPage(cursor string, limit int) (DiagnosticsPage, error) {
+	rc := am.current()
+	position, err := decodeCursor("rules", cursor)
+	if err != nil {
+		return DiagnosticsPage{}, err
+	}
+	start := 0
+	if position != "" {
+		parts := strings.SplitN(position, ":", 2)
+		n, err := strconv.Atoi(parts[len(parts)-1])
+		if len(parts) != 2 || parts[0] != rc.configHash || err != nil || n < 0 || n > len(rc.ruleWarnings) {
+			return DiagnosticsPage{}, errInvalidCursor
+		}
+		start = n
+	}
+	end := start + limit
+	if end > len(rc.ruleWarnings) {
+		end = len(rc.ruleWarnings)
+	}
+	page := DiagnosticsPage{Items: append([]RuleWarning{}, rc.ruleWarnings[start:end]...)}
+	if end < len(rc.ruleWarnings) {
+		page.NextCursor = encodeCursor("rules", rc.configHash+":"+strconv.Itoa(end))
+	}
+	return page, nil
+}
@@ -0,0 +1,146 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	enforcer := filepath.Join(t.TempDir(), "keycloak.json")
+	err := os.WriteFile(enforcer, []byte(`{"paths": [
+		{"path": "/items/{id}", "name": "item"},
+		{"path": "/items/{id}", "name": "item-copy"},
+		{"path": "/items/{id}/tags", "name": "tags"},
+		{"path": "/items/*", "name": "items"}
+	]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		StaticPermissions: []StaticPermission{{Prefix: "/health", Resource: "system", Scope: "read"}},
+		Rules: []Rule{
+			{Name: "health-live", Prefix: "/health/live", Resolver: "static", Resource: "system", Scope: "live"},
+			{Name: "orders-write", Prefix: "/orders", Methods: []string{"post", "put"}, Resolver: "static", Resource: "order", Scope: "manage"},
+			{Name: "orders-create", Prefix: "/orders/new", Methods: []string{"POST"}, Resolver: "static", Resource: "order", Scope: "create"},
+			{Name: "orders", Prefix: "/orders", Resolver: "static", Resource: "order", Scope: "view"},
+			{Name: "orders-get", Prefix: "/orders/", Methods: []string{"GET"}, Resolver: "static", Resource: "order", Scope: "get"},
+			{Name: "reports-safe", Prefix: "/reports", Methods: []string{"SAFE"}, Resolver: "static", Resource: "report", Scope: "view"},
+			{Name: "reports-delete", Prefix: "/reports", Methods: []string{"DELETE"}, Resolver: "static", Resource: "report", Scope: "delete"},
+			{Name: "reports-admin", Prefix: "/reports/admin", Resolver: "static", Resource: "report", Scope: "admin"},
+			{Name: "grpc", Prefix: "/grpc/", Resolver: "segments", ResourceIndex: 2, ScopeIndex: 9},
+		},
+		PolicyEnforcerFile: enforcer,
+		MaxPathSegments:    8,
+	}
+	rc, err := newRuntimeConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"shadowed health-live static:/health",
+		"shadowed orders-create orders-write",
+		"shadowed orders-get orders",
+		"overlap reports-admin reports-safe",
+		"overlap reports-admin reports-delete",
+		"never_resolves grpc ",
+		"shadowed enforcer:item-copy enforcer:item",
+	}
+	var got []string
+	for _, w := range rc.ruleWarnings {
+		got = append(got, w.Kind+" "+w.Rule+" "+w.Other)
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected warnings\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestMethodSets(t *testing.T) {
+	set := func(methods ...string) map[string]bool {
+		m := map[string]bool{}
+		for _, method := range methods {
+			m[method] = true
+		}
+		return m
+	}
+	tests := []struct {
+		a, b             map[string]bool
+		covers, overlaps bool
+	}{
+		{nil, set("GET"), true, true},
+		{set("GET"), nil, false, true},
+		{set(methodClassSafe), set("GET", "HEAD"), true, true},
+		{set("GET"), set(methodClassSafe), false, true},
+		{set(methodClassSafe, methodClassMutating), nil, true, true},
+		{set(methodClassMutating), set("GET"), false, false},
+		{set("POST"), set("PUT"), false, false},
+	}
+	for i, test := range tests {
+		if got := methodsCover(test.a, test.b); got != test.covers {
+			t.Errorf("%d: expected cover %v, got %v", i, test.covers, got)
+		}
+		if got := methodsIntersect(test.a, test.b); got != test.overlaps {
+			t.Errorf("%d: expected overlap %v, got %v", i, test.overlaps, got)
+		}
+	}
+}
+
+func TestRuleWarningsDiagnostics(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		Admin: AdminConfig{Path: "/.authz", Token: "admin-secret"},
+		Rules: []Rule{
+			{Name: "a", Prefix: "/a", Resolver: "static", Resource: "a", Scope: "x"},
+			{Name: "a1", Prefix: "/a/1", Resolver: "static", Resource: "a", Scope: "x"},
+			{Name: "a2", Prefix: "/a/2", Resolver: "static", Resource: "a", Scope: "x"},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+	if len(am.RuleWarnings()) != 2 {
+		t.Fatalf("expected 2 warnings, got %+v", am.RuleWarnings())
+	}
+
+	fetch := func(cursor string) (int, []RuleWarning, string) {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/.authz/diagnostics/rules?limit=1&cursor="+cursor, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		var page struct {
+			Items      []RuleWarning `json:"items"`
+			NextCursor string        `json:"nextCursor"`
+		}
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder.Code, page.Items, page.NextCursor
+	}
+	_, items, cursor := fetch("")
+	if len(items) != 1 || items[0].Rule != "a1" || cursor == "" {
+		t.Fatalf("unexpected first page %+v %q", items, cursor)
+	}
+	_, items, lastCursor := fetch(cursor)
+	if len(items) != 1 || items[0].Rule != "a2" || lastCursor != "" {
+		t.Errorf("unexpected last page %+v %q", items, lastCursor)
+	}
+
+	// A reload invalidates the cursors of the previous rules
+	config.Rules = config.Rules[:2]
+	if err := am.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := fetch(cursor); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a cursor of a previous configuration, got %d", status)
+	}
+}
@@ -0,0 +1,50 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logLevel filters the per-request log output of the middleware
+type logLevel int
+
+// Log levels, from most to least verbose
+const (
+	logDebug logLevel = iota
+	logInfo
+	logWarn
+	logError
+	logOff
+)
+
+var logLevels = map[string]logLevel{
+	"":      logDebug, // default: log everything, as before log levels existed
+	"debug": logDebug,
+	"info":  logInfo,
+	"warn":  logWarn,
+	"error": logError,
+	"off":   logOff,
+}
+
+// parseLogLevel parses a logLevel option (debug, info, warn, error or off)
+func parseLogLevel(s string) (logLevel, error) {
+	level, ok := logLevels[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return logOff, fmt.Errorf("invalid logLevel %q (expected debug, info, warn, error or off)", s)
+	}
+	return level, nil
+}
+
+// log prints args like fmt.Println if level is enabled
+func (am *AuthMiddleware) log(level logLevel, args ...interface{}) {
+	if level >= am.logLevel {
+		fmt.Println(args...)
+	}
+}
+
+// logf prints like fmt.Printf if level is enabled
+func (am *AuthMiddleware) logf(level logLevel, format string, args ...interface{}) {
+	if level >= am.logLevel {
+		fmt.Printf(format, args...)
+	}
+}
@@ -0,0 +1,194 @@
+package authztraefikgateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Failure classes reported in Decision.FailureClass, metrics and audit logs. They separate
+// "Keycloak is down" (network, tls, timeout, idp_5xx) from "clients send bad tokens" (token_invalid).
+const (
+	FailureNetwork      = "network"
+	FailureTLS          = "tls"
+	FailureTimeout      = "timeout"
+	FailureIdP5xx       = "idp_5xx"
+	FailureIdP4xx       = "idp_4xx"
+	FailureTokenInvalid = "token_invalid"
+	FailureConfig       = "config"
+)
+
+// reasonFailureClass returns the failure class of a reason code; grants, policy denials and
+// cancellations are not failures
+func reasonFailureClass(reason string) string {
+	switch reason {
+	case ReasonNetworkError:
+		return FailureNetwork
+	case ReasonTimeout:
+		return FailureTimeout
+	case ReasonIdPError:
+		return FailureIdP5xx
+	case ReasonIdPRejected, ReasonInvalidResource, ReasonInvalidScope, ReasonExchangeFailed:
+		return FailureIdP4xx
+	case ReasonMissingToken, ReasonMalformedToken, ReasonExpiredToken, ReasonInvalidToken, ReasonWrongTokenType:
+		return FailureTokenInvalid
+	case ReasonMisconfigured:
+		return FailureConfig
+	}
+	return ""
+}
+
+// errorFailureClass classifies an error returned by a call to Keycloak
+func errorFailureClass(err error) string {
+	var exchangeErr *exchangeError
+	if errors.As(err, &exchangeErr) {
+		if exchangeErr.status >= 500 {
+			return FailureIdP5xx
+		}
+		return FailureIdP4xx
+	}
+	if isTLSError(err) {
+		return FailureTLS
+	}
+	switch failureMode(err) {
+	case failureTimeout:
+		return FailureTimeout
+	case failureCanceled:
+		return ""
+	}
+	return FailureNetwork
+}
+
+// isTLSError reports whether err comes from the TLS handshake or certificate verification
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &hostname) || errors.As(err, &recordHeader) {
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// bodySizeBuckets are the upper bounds (bytes) of the buffered body size histogram
+var bodySizeBuckets = []int64{1 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// metrics counts decisions per reason and failures per class, and buffered body sizes
+type metrics struct {
+	mu        sync.Mutex
+	decisions map[string]uint64
+	failures  map[string]uint64
+
+	bodyBuckets  []uint64 // cumulative counts per bodySizeBuckets bound
+	bodyCount    uint64
+	bodySum      int64
+	bodySpilled  uint64
+	bodyRejected uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		decisions:   make(map[string]uint64),
+		failures:    make(map[string]uint64),
+		bodyBuckets: make([]uint64, len(bodySizeBuckets)),
+	}
+}
+
+// observe records a decision
+func (m *metrics) observe(d Decision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decisions[d.Reason]++
+	if d.FailureClass != "" {
+		m.failures[d.FailureClass]++
+	}
+}
+
+// observeBody records a buffered request body
+func (m *metrics) observeBody(size int64, spilled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range bodySizeBuckets {
+		if size <= bound {
+			m.bodyBuckets[i]++
+		}
+	}
+	m.bodyCount++
+	m.bodySum += size
+	if spilled {
+		m.bodySpilled++
+	}
+}
+
+// observeBodyRejected records a body rejected for exceeding the buffer limit
+func (m *metrics) observeBodyRejected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bodyRejected++
+}
+
+// write renders the counters in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("# HELP authz_decisions_total Authorization decisions by reason code.\n")
+	b.WriteString("# TYPE authz_decisions_total counter\n")
+	writeCounters(&b, "authz_decisions_total", "reason", m.decisions)
+	b.WriteString("# HELP authz_failures_total Failed authorizations by failure class.\n")
+	b.WriteString("# TYPE authz_failures_total counter\n")
+	writeCounters(&b, "authz_failures_total", "class", m.failures)
+	b.WriteString("# HELP authz_body_buffered_bytes Sizes of buffered request bodies.\n")
+	b.WriteString("# TYPE authz_body_buffered_bytes histogram\n")
+	for i, bound := range bodySizeBuckets {
+		fmt.Fprintf(&b, "authz_body_buffered_bytes_bucket{le=\"%d\"} %d\n", bound, m.bodyBuckets[i])
+	}
+	fmt.Fprintf(&b, "authz_body_buffered_bytes_bucket{le=\"+Inf\"} %d\n", m.bodyCount)
+	fmt.Fprintf(&b, "authz_body_buffered_bytes_sum %d\n", m.bodySum)
+	fmt.Fprintf(&b, "authz_body_buffered_bytes_count %d\n", m.bodyCount)
+	b.WriteString("# HELP authz_body_spilled_total Buffered request bodies spilled to a temp file.\n")
+	b.WriteString("# TYPE authz_body_spilled_total counter\n")
+	fmt.Fprintf(&b, "authz_body_spilled_total %d\n", m.bodySpilled)
+	b.WriteString("# HELP authz_body_rejected_total Request bodies rejected for exceeding bodyBuffer.maxBytes.\n")
+	b.WriteString("# TYPE authz_body_rejected_total counter\n")
+	fmt.Fprintf(&b, "authz_body_rejected_total %d\n", m.bodyRejected)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeCounters writes one sample per label value, sorted for stable output
+func writeCounters(b *strings.Builder, name, label string, counts map[string]uint64) {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", name, label, value, counts[value])
+	}
+}
+
+// WriteMetrics writes the middleware's counters in the Prometheus text exposition format
+func (am *AuthMiddleware) WriteMetrics(w io.Writer) error {
+	if err := am.metrics.write(w); err != nil {
+		return err
+	}
+	if err := writeCacheEfficiency(w, am.CacheEfficiency()); err != nil {
+		return err
+	}
+	if am.denialMirror != nil {
+		if err := am.denialMirror.write(w); err != nil {
+			return err
+		}
+	}
+	if am.latency != nil {
+		return am.latency.write(w)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFailureClasses(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer tlsServer.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	closed.Close()
+	idpDown := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer idpDown.Close()
+	badToken := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer badToken.Close()
+
+	tests := []struct {
+		name     string
+		config   *Config
+		expected string
+	}{
+		{"tls", &Config{KeycloakURL: tlsServer.URL, VerifyTLS: true}, FailureTLS},
+		{"network", &Config{KeycloakURL: closed.URL}, FailureNetwork},
+		{"idp 5xx", &Config{KeycloakURL: idpDown.URL}, FailureIdP5xx},
+		{"token invalid", &Config{KeycloakURL: badToken.URL}, FailureTokenInvalid},
+		{"config", &Config{}, FailureConfig},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler, err := New(context.Background(), next, test.config, "AuthMiddleware")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var metrics strings.Builder
+			if err := handler.(*AuthMiddleware).WriteMetrics(&metrics); err != nil {
+				t.Fatal(err)
+			}
+			if sample := `authz_failures_total{class="` + test.expected + `"} 1`; !strings.Contains(metrics.String(), sample) {
+				t.Errorf("expected %q in metrics:\n%s", sample, metrics.String())
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, Admin: AdminConfig{Path: "/.authz", Token: "admin-secret"}}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, accessToken := range []string{token, ""} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/.authz/metrics", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	body := recorder.Body.String()
+	for _, sample := range []string{
+		`authz_decisions_total{reason="access_denied"} 1`,
+		`authz_decisions_total{reason="missing_token"} 1`,
+		`authz_failures_total{class="token_invalid"} 1`,
+	} {
+		if !strings.Contains(body, sample) {
+			t.Errorf("expected %q in metrics:\n%s", sample, body)
+		}
+	}
+	if strings.Contains(body, `class="access_denied"`) {
+		t.Error("policy denials must not be counted as failures")
+	}
+}
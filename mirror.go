@@ -0,0 +1,281 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of denial mirroring
+const (
+	defaultMirrorQueueSize     = 1000
+	defaultMirrorBatchSize     = 50
+	defaultMirrorFlushInterval = 5 * time.Second
+	defaultMirrorTimeout       = 5 * time.Second
+)
+
+// mirrorRedactedHeaders carry credentials and are always redacted in mirrored denials
+var mirrorRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token"}
+
+// DenialMirrorConfig mirrors the metadata of denied requests to a review endpoint, so security teams
+// can see what was blocked and spot false positives after policy changes. Denials are queued and sent
+// in batches in the background; a full queue drops records rather than slowing requests down.
+type DenialMirrorConfig struct {
+	URL           string            `json:"url,omitempty"`           // receives POSTs of JSON arrays of DenialMirrorRecord; enables mirroring
+	Headers       map[string]string `json:"headers,omitempty"`       // sent with every batch, e.g. an API key
+	RedactHeaders []string          `json:"redactHeaders,omitempty"` // request headers redacted in addition to the credential headers
+	QueueSize     int               `json:"queueSize,omitempty"`     // denials waiting to be sent (default 1000)
+	BatchSize     int               `json:"batchSize,omitempty"`     // denials per POST (default 50)
+	FlushInterval string            `json:"flushInterval,omitempty"` // longest a denial waits for a full batch (default "5s")
+	Timeout       string            `json:"timeout,omitempty"`       // per POST (default "5s")
+}
+
+// DenialMirrorRecord is the metadata of a denied request sent to the review endpoint. It holds no
+// body, no query string (which may carry tokens) and no credential header values.
+type DenialMirrorRecord struct {
+	Time               time.Time           `json:"time"`
+	Middleware         string              `json:"middleware"`
+	Method             string              `json:"method"`
+	Host               string              `json:"host"`
+	Path               string              `json:"path"`
+	Headers            map[string][]string `json:"headers,omitempty"`
+	ClientIP           string              `json:"clientIP,omitempty"`
+	Reason             string              `json:"reason"`
+	FailureClass       string              `json:"class,omitempty"`
+	Status             int                 `json:"status"`
+	Rule               string              `json:"rule,omitempty"`
+	Permission         string              `json:"permission,omitempty"`
+	Backend            string              `json:"backend,omitempty"`
+	KeycloakStatus     int                 `json:"keycloakStatus,omitempty"`
+	KeycloakError      string              `json:"keycloakError,omitempty"`
+	DeniedItems        []string            `json:"deniedItems,omitempty"`
+	TokenFingerprint   string              `json:"tokenFingerprint,omitempty"`
+	SubjectFingerprint string              `json:"subjectFingerprint,omitempty"`
+	DryRun             bool                `json:"dryRun,omitempty"` // the request was forwarded anyway
+}
+
+// denialMirror queues denial records and sends them to the review endpoint from a background goroutine
+type denialMirror struct {
+	url           string
+	headers       map[string]string
+	redact        map[string]bool
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+	client        *http.Client
+
+	queue chan DenialMirrorRecord
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	sent    uint64
+	dropped uint64
+	failed  uint64
+}
+
+// newDenialMirror validates the configuration and builds a mirror sending through transport (nil for
+// the default); it returns nil when no URL is configured. The headers the extractors read tokens from
+// are redacted too. The mirror does not send until started.
+func newDenialMirror(config DenialMirrorConfig, extractors []TokenExtractor, transport http.RoundTripper) (*denialMirror, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("denialMirror.url must be an absolute http(s) URL")
+	}
+	if config.QueueSize < 0 || config.BatchSize < 0 {
+		return nil, fmt.Errorf("denialMirror.queueSize and denialMirror.batchSize must not be negative")
+	}
+	flushInterval, err := parseDurationOrDefault(config.FlushInterval, defaultMirrorFlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("denialMirror.flushInterval: %w", err)
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultMirrorTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("denialMirror.timeout: %w", err)
+	}
+	dm := &denialMirror{
+		url:           config.URL,
+		headers:       config.Headers,
+		redact:        make(map[string]bool),
+		batchSize:     config.BatchSize,
+		flushInterval: flushInterval,
+		timeout:       timeout,
+		client:        &http.Client{Transport: transport},
+		done:          make(chan struct{}),
+	}
+	for _, names := range [][]string{mirrorRedactedHeaders, config.RedactHeaders, tokenHeaders(extractors)} {
+		for _, name := range names {
+			dm.redact[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	if dm.batchSize == 0 {
+		dm.batchSize = defaultMirrorBatchSize
+	}
+	queueSize := config.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultMirrorQueueSize
+	}
+	dm.queue = make(chan DenialMirrorRecord, queueSize)
+	return dm, nil
+}
+
+// record queues the denial of a request without blocking; it is dropped when the queue is full
+func (dm *denialMirror) record(name string, req *http.Request, d Decision, dryRun bool) {
+	record := DenialMirrorRecord{
+		Time:               time.Now().UTC(),
+		Middleware:         name,
+		Method:             req.Method,
+		Host:               req.Host,
+		Path:               req.URL.Path,
+		Headers:            dm.redactedHeaders(req.Header),
+		ClientIP:           d.ClientIP,
+		Reason:             d.Reason,
+		FailureClass:       d.FailureClass,
+		Status:             d.Status,
+		Rule:               d.Rule,
+		Permission:         permissionString(d.Permission),
+		Backend:            d.Backend,
+		KeycloakStatus:     d.KeycloakStatus,
+		KeycloakError:      d.KeycloakError,
+		DeniedItems:        d.DeniedItems,
+		TokenFingerprint:   d.TokenFingerprint,
+		SubjectFingerprint: d.SubjectFingerprint,
+		DryRun:             dryRun,
+	}
+	select {
+	case dm.queue <- record:
+	default:
+		dm.mu.Lock()
+		dm.dropped++
+		dm.mu.Unlock()
+	}
+}
+
+// redactedHeaders copies the request headers, replacing the values of credential headers
+func (dm *denialMirror) redactedHeaders(header http.Header) map[string][]string {
+	if len(header) == 0 {
+		return nil
+	}
+	copied := make(map[string][]string, len(header))
+	for name, values := range header {
+		if dm.redact[name] {
+			copied[name] = []string{redacted}
+			continue
+		}
+		copied[name] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+// run sends batches until ctx is done, then flushes what is still queued
+func (dm *denialMirror) run(ctx context.Context) {
+	defer close(dm.done)
+	ticker := time.NewTicker(dm.flushInterval)
+	defer ticker.Stop()
+	batch := make([]DenialMirrorRecord, 0, dm.batchSize)
+	for {
+		select {
+		case record := <-dm.queue:
+			batch = append(batch, record)
+			if len(batch) >= dm.batchSize {
+				batch = dm.send(batch)
+			}
+		case <-ticker.C:
+			batch = dm.send(batch)
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-dm.queue:
+					batch = append(batch, record)
+					if len(batch) >= dm.batchSize {
+						batch = dm.send(batch)
+					}
+				default:
+					dm.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// start sends the queued denials in the background until ctx is done
+func (dm *denialMirror) start(ctx context.Context) {
+	dm.once.Do(func() { go dm.run(ctx) })
+}
+
+// wait blocks until the background sender has flushed the queue after its context ended
+func (dm *denialMirror) wait() {
+	<-dm.done
+}
+
+// send POSTs a batch and returns the emptied batch for reuse. Failed batches are logged and dropped:
+// the mirror is a review aid, not an audit trail.
+func (dm *denialMirror) send(batch []DenialMirrorRecord) []DenialMirrorRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+	err := dm.post(batch)
+	dm.mu.Lock()
+	if err != nil {
+		dm.failed += uint64(len(batch))
+	} else {
+		dm.sent += uint64(len(batch))
+	}
+	dm.mu.Unlock()
+	if err != nil {
+		fmt.Printf("⚠️  [MIRROR] Could not mirror %d denials: %v\n", len(batch), err)
+	}
+	return batch[:0]
+}
+
+// post sends one batch to the review endpoint. Its context is independent of the middleware's, so
+// the final flush at shutdown is not cancelled.
+func (dm *denialMirror) post(batch []DenialMirrorRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dm.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dm.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range dm.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := dm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("review endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// write renders the mirror counters in the Prometheus text exposition format
+func (dm *denialMirror) write(w io.Writer) error {
+	dm.mu.Lock()
+	counts := map[string]uint64{"sent": dm.sent, "dropped": dm.dropped, "failed": dm.failed}
+	dm.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("# HELP authz_denial_mirror_records_total Mirrored denials by outcome (dropped: queue full, failed: endpoint error).\n")
+	b.WriteString("# TYPE authz_denial_mirror_records_total counter\n")
+	writeCounters(&b, "authz_denial_mirror_records_total", "outcome", counts)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,128 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDenialMirror(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]DenialMirrorRecord
+	review := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Api-Key") != "review-key" {
+			t.Errorf("expected the configured header, got %q", req.Header.Get("X-Api-Key"))
+		}
+		var batch []DenialMirrorRecord
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer review.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:  "http://keycloak.invalid/token",
+		TokenSources: []TokenSource{{Type: "bearer"}, {Type: "header", Name: "X-Access-Token"}},
+		DenialMirror: DenialMirrorConfig{
+			URL:           review.URL,
+			Headers:       map[string]string{"X-Api-Key": "review-key"},
+			RedactHeaders: []string{"X-Session"},
+			BatchSize:     2,
+			FlushInterval: "1h",
+		},
+	}
+	handler, err := New(ctx, next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/api/v1/user/get?access_token=secret", "/api/v1/order/delete", "/api/v1/report/view"} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Session", "secret")
+		if strings.HasSuffix(path, "/view") {
+			req.Header.Set("X-Access-Token", "secret")
+		}
+		req.Header.Set("User-Agent", "review-test")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The first two denials fill a batch; the third is flushed at shutdown
+	cancel()
+	handler.(*AuthMiddleware).denialMirror.wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("expected batches of 2 and 1 denials, got %+v", batches)
+	}
+	record := batches[0][0]
+	if record.Reason != ReasonMissingToken || record.Status != http.StatusUnauthorized || record.Path != "/api/v1/user/get" || record.Middleware != "AuthMiddleware" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	raw, _ := json.Marshal(batches)
+	if strings.Contains(string(raw), "secret") {
+		t.Errorf("expected credentials and query strings to be left out, got %s", raw)
+	}
+	if got := record.Headers["User-Agent"]; len(got) != 1 || got[0] != "review-test" {
+		t.Errorf("expected other headers to be kept, got %v", record.Headers)
+	}
+	if snapshot := redactConfig(*config); snapshot.DenialMirror.Headers["X-Api-Key"] != redacted {
+		t.Errorf("expected mirror headers redacted in snapshots, got %v", snapshot.DenialMirror.Headers)
+	}
+}
+
+func TestDenialMirrorDropsWhenFull(t *testing.T) {
+	dm, err := newDenialMirror(DenialMirrorConfig{URL: "http://review.invalid/denials", QueueSize: 1}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api", nil)
+	for i := 0; i < 3; i++ {
+		dm.record("AuthMiddleware", req, Decision{Reason: ReasonAccessDenied, Status: http.StatusForbidden}, false)
+	}
+	var metrics strings.Builder
+	if err := dm.write(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(metrics.String(), `authz_denial_mirror_records_total{outcome="dropped"} 2`) {
+		t.Errorf("expected 2 dropped denials, got\n%s", metrics.String())
+	}
+
+	// A failing endpoint is counted; the batch is not retried
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	dm.timeout = 100 * time.Millisecond
+	dm.start(ctx)
+	cancel()
+	dm.wait()
+	if dm.failed != 1 || dm.sent != 0 {
+		t.Errorf("expected 1 failed denial, got failed=%d sent=%d", dm.failed, dm.sent)
+	}
+}
+
+func TestDenialMirrorValidation(t *testing.T) {
+	tests := []struct {
+		config DenialMirrorConfig
+		valid  bool
+	}{
+		{DenialMirrorConfig{}, true},
+		{DenialMirrorConfig{URL: "https://review.example.com/denials", FlushInterval: "1s"}, true},
+		{DenialMirrorConfig{URL: "review.example.com"}, false},
+		{DenialMirrorConfig{URL: "https://review.example.com", QueueSize: -1}, false},
+		{DenialMirrorConfig{URL: "https://review.example.com", Timeout: "soon"}, false},
+	}
+	for _, test := range tests {
+		_, err := newDenialMirror(test.config, nil, nil)
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.config, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// defaultMultipartMaxBytes bounds how much of a multipart body is read to find the form field
+const defaultMultipartMaxBytes = 64 << 10
+
+// MultipartResolver takes the permission from a form field of a multipart/form-data body, e.g. the
+// "projectId" of an upload. Only the first MaxBytes of the body are read, and the field must precede
+// the file parts; the body is passed on unchanged and file parts are streamed, never buffered.
+// "{field}" in Resource and Scope is replaced by the field value, which may not span path segments or
+// carry a scope or another permission; without Scope the lower-cased method is used. As the upstream
+// may read another value, a field sent twice is rejected: within the first MaxBytes by Resolve, and
+// beyond them by failing the upstream's read of the body once the second one streams past.
+type MultipartResolver struct {
+	Field    string
+	Resource string
+	Scope    string
+	MaxBytes int64
+}
+
+// Resolve implements PermissionResolver
+func (r MultipartResolver) Resolve(req *http.Request) (Permission, error) {
+	value, err := r.fieldValue(req)
+	if err != nil {
+		return Permission{}, err
+	}
+	if value == "" || strings.ContainsAny(value, "/#,") {
+		return Permission{}, fmt.Errorf("invalid form field %q", r.Field)
+	}
+	permission := Permission{
+		Resource: strings.Replace(r.Resource, "{field}", value, -1),
+		Scope:    strings.Replace(r.Scope, "{field}", value, -1),
+	}
+	if permission.Scope == "" {
+		permission.Scope = strings.ToLower(req.Method)
+	}
+	return permission, nil
+}
+
+// readCloser reads from reader and closes closer. Methods are spelled out because Yaegi does not
+// reliably expose the methods of embedded interfaces through interface values.
+type readCloser struct {
+	reader io.Reader
+	closer io.Closer
+}
+
+func (rc readCloser) Read(p []byte) (int, error) {
+	return rc.reader.Read(p)
+}
+
+func (rc readCloser) Close() error {
+	return rc.closer.Close()
+}
+
+// fieldValue reads the form field from the head of the body and restores the body
+func (r MultipartResolver) fieldValue(req *http.Request) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return "", errors.New("expected a multipart/form-data body")
+	}
+	if req.Body == nil {
+		return "", errors.New("missing multipart request body")
+	}
+	maxBytes := r.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMultipartMaxBytes
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, maxBytes))
+	// The upstream receives the head followed by the unread rest of the body
+	req.Body = readCloser{reader: io.MultiReader(bytes.NewReader(head), req.Body), closer: req.Body}
+	if err != nil {
+		return "", fmt.Errorf("reading multipart body: %w", err)
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(head), params["boundary"])
+	var value []byte
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FormName() != r.Field {
+			if part.FileName() != "" && !found {
+				return "", fmt.Errorf("form field %q must precede file parts", r.Field)
+			}
+			continue
+		}
+		if found {
+			return "", fmt.Errorf("form field %q is sent more than once", r.Field)
+		}
+		if part.FileName() != "" {
+			return "", fmt.Errorf("form field %q must precede file parts", r.Field)
+		}
+		if value, err = io.ReadAll(part); err != nil {
+			break
+		}
+		found = true
+	}
+	if !found {
+		return "", fmt.Errorf("form field %q not found in the first %d bytes", r.Field, maxBytes)
+	}
+	if int64(len(head)) == maxBytes {
+		// The rest of the body is only seen by the upstream
+		req.Body = &fieldGuard{body: req.Body, boundary: params["boundary"], field: r.Field}
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// fieldGuard passes a multipart body on while parsing it, and fails the read when the form field
+// occurs more than once. File contents are skipped, never kept. The parser starts with the first
+// read, so bodies of denied requests cost nothing.
+type fieldGuard struct {
+	body     io.ReadCloser
+	boundary string
+	field    string
+
+	pipe *io.PipeWriter
+	done chan struct{}
+	err  error // set by the parser before done is closed
+}
+
+func (g *fieldGuard) Read(p []byte) (int, error) {
+	if g.pipe == nil {
+		pr, pw := io.Pipe()
+		g.pipe, g.done = pw, make(chan struct{})
+		go g.parse(pr)
+	}
+	n, err := g.body.Read(p)
+	if n > 0 {
+		if _, werr := g.pipe.Write(p[:n]); werr != nil {
+			<-g.done
+			return 0, g.err
+		}
+	}
+	if err == io.EOF {
+		// The end of the body is only delivered once all of it was checked
+		g.pipe.Close()
+		<-g.done
+		if g.err != nil {
+			return 0, g.err
+		}
+	}
+	return n, err
+}
+
+func (g *fieldGuard) Close() error {
+	if g.pipe != nil {
+		g.pipe.Close()
+	}
+	return g.body.Close()
+}
+
+// parse counts the parts named like the field until the body ends or a second one is found
+func (g *fieldGuard) parse(pr *io.PipeReader) {
+	defer close(g.done)
+	reader := multipart.NewReader(pr, g.boundary)
+	seen := 0
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FormName() != g.field {
+			continue
+		}
+		if seen++; seen > 1 {
+			g.err = fmt.Errorf("form field %q is sent more than once", g.field)
+			pr.CloseWithError(g.err)
+			return
+		}
+	}
+	// Malformed bodies are left to the upstream
+	_, _ = io.Copy(io.Discard, pr)
+}
@@ -0,0 +1,61 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Defaults of the path limits
+const (
+	defaultMaxPathLength   = 4096
+	defaultMaxPathSegments = 128
+)
+
+// pathLimits bounds the size of request paths before any of them is parsed
+type pathLimits struct {
+	maxLength   int
+	maxSegments int
+}
+
+// newPathLimits validates the configured limits; 0 selects the defaults
+func newPathLimits(maxLength, maxSegments int) (pathLimits, error) {
+	if maxLength < 0 || maxSegments < 0 {
+		return pathLimits{}, fmt.Errorf("maxPathLength and maxPathSegments must not be negative")
+	}
+	if maxLength == 0 {
+		maxLength = defaultMaxPathLength
+	}
+	if maxSegments == 0 {
+		maxSegments = defaultMaxPathSegments
+	}
+	return pathLimits{maxLength: maxLength, maxSegments: maxSegments}, nil
+}
+
+// check returns the status and error of a request exceeding the limits: 414 for a long path,
+// 400 for too many segments
+func (l pathLimits) check(req *http.Request) (int, error) {
+	if n := len(req.URL.EscapedPath()); n > l.maxLength {
+		return http.StatusRequestURITooLong, fmt.Errorf("path of %d bytes exceeds %d", n, l.maxLength)
+	}
+	if n := strings.Count(req.URL.Path, "/"); n > l.maxSegments {
+		return http.StatusBadRequest, fmt.Errorf("path of %d segments exceeds %d", n, l.maxSegments)
+	}
+	return 0, nil
+}
+
+// pathSegment returns the i-th "/"-separated segment of path (0 is the part before the first "/"),
+// scanning only as far as needed instead of splitting the whole path
+func pathSegment(path string, i int) (string, bool) {
+	for ; i > 0; i-- {
+		j := strings.IndexByte(path, '/')
+		if j < 0 {
+			return "", false
+		}
+		path = path[j+1:]
+	}
+	if j := strings.IndexByte(path, '/'); j >= 0 {
+		path = path[:j]
+	}
+	return path, true
+}
@@ -0,0 +1,77 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPathLimits(t *testing.T) {
+	keycloakCalled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keycloakCalled = true
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, MaxPathLength: 64, MaxPathSegments: 8}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected int
+	}{
+		{"within limits", "/api/v1/user/get", http.StatusOK},
+		{"too long", "/api/v1/user/" + strings.Repeat("a", 64), http.StatusRequestURITooLong},
+		{"too many segments", "/api/v1/user/get" + strings.Repeat("/x", 5), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keycloakCalled = false
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected != http.StatusOK && keycloakCalled {
+				t.Error("Keycloak must not be called for an oversized path")
+			}
+		})
+	}
+
+	if _, err := newPathLimits(-1, 0); err == nil {
+		t.Error("expected error for a negative limit")
+	}
+}
+
+func TestPathSegment(t *testing.T) {
+	path := "/api/v1/user/get"
+	for i, expected := range strings.Split(path, "/") {
+		if got, ok := pathSegment(path, i); !ok || got != expected {
+			t.Errorf("segment %d: expected %q, got %q", i, expected, got)
+		}
+	}
+	if _, ok := pathSegment(path, 5); ok {
+		t.Error("expected no segment beyond the path")
+	}
+}
+
+func BenchmarkSegmentResolverLongPath(b *testing.B) {
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get"+strings.Repeat("/x", 1000), nil)
+	resolver := SegmentResolver{ResourceIndex: 3, ScopeIndex: 4}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := resolver.Resolve(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
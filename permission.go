@@ -0,0 +1,26 @@
+package authztraefikgateway
+
+// Permission is a resource/scope pair evaluated by Keycloak
+type Permission struct {
+	Resource string
+	Scope    string
+}
+
+// permissionFormat controls how a resource/scope pair is rendered for the UMA "permission" parameter
+type permissionFormat struct {
+	separator        string
+	omitLeadingSlash bool
+	scopeless        bool
+}
+
+// format renders resource and scope, e.g. "/user#get", "user:get" or "user"
+func (pf permissionFormat) format(resource, scope string) string {
+	permission := resource
+	if !pf.omitLeadingSlash {
+		permission = "/" + permission
+	}
+	if pf.scopeless || scope == "" {
+		return permission
+	}
+	return permission + pf.separator + scope
+}
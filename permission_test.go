@@ -0,0 +1,38 @@
+package authztraefikgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPermissionFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{"default", Config{}, "/user#get"},
+		{"colon separator", Config{PermissionSeparator: ":", OmitLeadingSlash: true}, "user:get"},
+		{"scopeless", Config{ScopelessPermissions: true}, "/user"},
+		{"scopeless without slash", Config{ScopelessPermissions: true, OmitLeadingSlash: true}, "user"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var permission string
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_ = req.ParseForm()
+				permission = req.PostForm.Get("permission")
+			}))
+			defer srv.Close()
+
+			config := test.config
+			config.KeycloakURL = srv.URL
+			serve(t, &config, "/api/v1/user/get")
+			if permission != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, permission)
+			}
+		})
+	}
+}
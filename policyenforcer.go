@@ -0,0 +1,228 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Enforcement modes of a Keycloak policy-enforcer configuration
+const (
+	enforcerEnforcing  = "ENFORCING"
+	enforcerPermissive = "PERMISSIVE"
+	enforcerDisabled   = "DISABLED"
+	enforcerScopesAll  = "ALL"
+	enforcerScopesAny  = "ANY"
+)
+
+// backendNone identifies requests the policy enforcer configuration exempts from authorization
+const backendNone = "none"
+
+// ruleEnforcement tells how requests matching a rule are authorized
+type ruleEnforcement int
+
+const (
+	enforcementEnabled  ruleEnforcement = iota // the resolved permission is evaluated
+	enforcementDisabled                        // the request is forwarded without any check
+	enforcementDenied                          // the request is denied, the path is not configured
+)
+
+// policyEnforcerConfig is the "policy-enforcer" section of a Keycloak adapter configuration
+type policyEnforcerConfig struct {
+	EnforcementMode string               `json:"enforcement-mode"`
+	Paths           []policyEnforcerPath `json:"paths"`
+}
+
+// policyEnforcerPath is an entry of policyEnforcerConfig.Paths
+type policyEnforcerPath struct {
+	Name            string                 `json:"name"`
+	Path            string                 `json:"path"`
+	Methods         []policyEnforcerMethod `json:"methods"`
+	EnforcementMode string                 `json:"enforcement-mode"`
+}
+
+// policyEnforcerMethod is an entry of policyEnforcerPath.Methods
+type policyEnforcerMethod struct {
+	Method                string   `json:"method"`
+	Scopes                []string `json:"scopes"`
+	ScopesEnforcementMode string   `json:"scopes-enforcement-mode"`
+}
+
+// pathPattern matches request paths against a policy-enforcer path: "{name}" matches one segment,
+// a trailing "*" any remainder, and "/*.ext" any path with that extension
+type pathPattern struct {
+	segments []string
+	wildcard bool   // the last segment was "*"
+	suffix   string // set for "/*.ext" patterns
+}
+
+// newPathPattern parses a policy-enforcer path
+func newPathPattern(path string) *pathPattern {
+	if strings.HasPrefix(path, "/*.") {
+		return &pathPattern{suffix: path[2:]}
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	pp := &pathPattern{segments: segments}
+	if segments[len(segments)-1] == "*" {
+		pp.segments, pp.wildcard = segments[:len(segments)-1], true
+	}
+	return pp
+}
+
+// exact reports whether the pattern matches a single path, which takes precedence over patterns
+func (pp *pathPattern) exact() bool {
+	if pp.suffix != "" || pp.wildcard {
+		return false
+	}
+	for _, segment := range pp.segments {
+		if strings.HasPrefix(segment, "{") {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether path matches the pattern
+func (pp *pathPattern) match(path string) bool {
+	if pp.suffix != "" {
+		return strings.HasSuffix(path, pp.suffix)
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) < len(pp.segments) || (!pp.wildcard && len(segments) != len(pp.segments)) {
+		return false
+	}
+	for i, segment := range pp.segments {
+		if isPatternVariable(segment) {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPolicyEnforcer reads a Keycloak adapter configuration (keycloak.json) or its bare
+// "policy-enforcer" section and converts it to rules; it returns nil when file is empty
+func loadPolicyEnforcer(file string) ([]*compiledRule, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("policyEnforcerFile: %w", err)
+	}
+	var adapter struct {
+		PolicyEnforcer *policyEnforcerConfig `json:"policy-enforcer"`
+	}
+	if err := json.Unmarshal(data, &adapter); err != nil {
+		return nil, fmt.Errorf("policyEnforcerFile: %w", err)
+	}
+	config := adapter.PolicyEnforcer
+	if config == nil {
+		config = &policyEnforcerConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("policyEnforcerFile: %w", err)
+		}
+	}
+	rules, err := compilePolicyEnforcer(*config)
+	if err != nil {
+		return nil, fmt.Errorf("policyEnforcerFile: %w", err)
+	}
+	return rules, nil
+}
+
+// compilePolicyEnforcer converts a policy-enforcer configuration to rules. Exact paths come first,
+// like in Keycloak, and a final catch-all rule applies the enforcement mode to unconfigured paths.
+func compilePolicyEnforcer(config policyEnforcerConfig) ([]*compiledRule, error) {
+	catchAll := &compiledRule{name: "enforcer:unconfigured", resolver: StaticResolver{}}
+	switch strings.ToUpper(config.EnforcementMode) {
+	case "", enforcerEnforcing:
+		catchAll.enforcement = enforcementDenied
+	case enforcerPermissive:
+		catchAll.enforcement = enforcementDisabled
+	case enforcerDisabled:
+		catchAll.enforcement = enforcementDisabled
+		return []*compiledRule{catchAll}, nil
+	default:
+		return nil, fmt.Errorf("invalid enforcement-mode %q", config.EnforcementMode)
+	}
+
+	var exact, patterns []*compiledRule
+	for _, path := range config.Paths {
+		rules, err := compileEnforcerPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if rules[0].pattern.exact() {
+			exact = append(exact, rules...)
+		} else {
+			patterns = append(patterns, rules...)
+		}
+	}
+	return append(append(exact, patterns...), catchAll), nil
+}
+
+// compileEnforcerPath converts a policy-enforcer path to one rule per configured method, followed by
+// a rule checking the resource without scopes for every other method
+func compileEnforcerPath(path policyEnforcerPath) ([]*compiledRule, error) {
+	if !strings.HasPrefix(path.Path, "/") {
+		return nil, fmt.Errorf("path %q must start with \"/\"", path.Path)
+	}
+	if path.Name == "" {
+		return nil, fmt.Errorf("path %q: name is required, lookup by path is not supported", path.Path)
+	}
+	pattern := newPathPattern(path.Path)
+	name := "enforcer:" + path.Name
+	pathRule := &compiledRule{name: name, pattern: pattern, resolver: StaticResolver{Permission: Permission{Resource: path.Name}}}
+	switch strings.ToUpper(path.EnforcementMode) {
+	case "", enforcerEnforcing:
+	case enforcerDisabled:
+		pathRule.enforcement = enforcementDisabled
+		return []*compiledRule{pathRule}, nil
+	default:
+		return nil, fmt.Errorf("path %q: invalid enforcement-mode %q", path.Path, path.EnforcementMode)
+	}
+
+	rules := make([]*compiledRule, 0, len(path.Methods)+1)
+	for _, method := range path.Methods {
+		rule := &compiledRule{
+			name:     name + ":" + strings.ToUpper(method.Method),
+			pattern:  pattern,
+			methods:  map[string]bool{strings.ToUpper(method.Method): true},
+			resolver: StaticResolver{Permission: Permission{Resource: path.Name, Scope: strings.Join(method.Scopes, ",")}},
+		}
+		switch strings.ToUpper(method.ScopesEnforcementMode) {
+		case "", enforcerScopesAll:
+		case enforcerScopesAny:
+			if len(method.Scopes) > 1 {
+				return nil, fmt.Errorf("path %q: scopes-enforcement-mode %s with several scopes is not supported", path.Path, enforcerScopesAny)
+			}
+		case enforcerDisabled:
+			rule.enforcement = enforcementDisabled
+		default:
+			return nil, fmt.Errorf("path %q: invalid scopes-enforcement-mode %q", path.Path, method.ScopesEnforcementMode)
+		}
+		rules = append(rules, rule)
+	}
+	return append(rules, pathRule), nil
+}
+
+// authorizeUnenforced handles requests whose rule is not enforced: exempt paths are forwarded
+// without a token, and paths missing from an enforcing policy-enforcer configuration are denied
+func (am *AuthMiddleware) authorizeUnenforced(rule *compiledRule, decision Decision) Decision {
+	decision.Rule = rule.name
+	if rule.enforcement == enforcementDenied {
+		am.log(logError, "❌ [ENFORCER] Path is not configured in the policy enforcer")
+		decision.deny(ReasonAccessDenied, http.StatusForbidden)
+		return decision
+	}
+	am.log(logDebug, "🔎 [ENFORCER] Enforcement disabled for rule", rule.name)
+	decision.Backend = backendNone
+	decision.Allowed = true
+	decision.Reason = ReasonNotEnforced
+	return decision
+}
@@ -0,0 +1,133 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const keycloakAdapterConfig = `{
+  "realm": "demo",
+  "resource": "gateway",
+  "policy-enforcer": {
+    "enforcement-mode": "ENFORCING",
+    "paths": [
+      {"name": "Order Resource", "path": "/orders/*", "methods": [
+        {"method": "GET", "scopes": ["view"]},
+        {"method": "DELETE", "scopes": ["delete", "audit"]},
+        {"method": "OPTIONS", "scopes": [], "scopes-enforcement-mode": "DISABLED"}
+      ]},
+      {"name": "Order Report", "path": "/orders/report"},
+      {"name": "User Resource", "path": "/users/{id}/profile"},
+      {"name": "Public", "path": "/public/*", "enforcement-mode": "DISABLED"}
+    ]
+  }
+}`
+
+func TestPolicyEnforcerImport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keycloak.json")
+	if err := os.WriteFile(file, []byte(keycloakAdapterConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var permission string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		permission = req.PostForm.Get("permission")
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:        srv.URL,
+		OmitLeadingSlash:   true,
+		PolicyEnforcerFile: file,
+		DenyReasonHeader:   "X-Authz-Reason",
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      bool
+		expected   int
+		reason     string
+		permission string
+	}{
+		{"method scope", http.MethodGet, "/orders/42", true, http.StatusOK, "", "Order Resource#view"},
+		{"several scopes", http.MethodDelete, "/orders/42", true, http.StatusOK, "", "Order Resource#delete,audit"},
+		{"unlisted method", http.MethodPost, "/orders/42", true, http.StatusOK, "", "Order Resource"},
+		{"exact path first", http.MethodGet, "/orders/report", true, http.StatusOK, "", "Order Report"},
+		{"placeholder", http.MethodGet, "/users/alice/profile", true, http.StatusOK, "", "User Resource"},
+		{"scopes disabled", http.MethodOptions, "/orders/42", false, http.StatusOK, "", ""},
+		{"path disabled", http.MethodGet, "/public/logo.png", false, http.StatusOK, "", ""},
+		{"unconfigured", http.MethodGet, "/api/v1/user/get", true, http.StatusForbidden, ReasonAccessDenied, ""},
+		{"placeholder needs a segment", http.MethodGet, "/users//profile", true, http.StatusForbidden, ReasonAccessDenied, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permission = ""
+			req := httptest.NewRequest(test.method, "http://gateway"+(&url.URL{Path: test.path}).EscapedPath(), nil)
+			if test.token {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if reason := recorder.Header().Get("X-Authz-Reason"); reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+			if permission != test.permission {
+				t.Errorf("expected permission %q, got %q", test.permission, permission)
+			}
+		})
+	}
+}
+
+func TestPolicyEnforcerModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   policyEnforcerConfig
+		path     string
+		expected ruleEnforcement
+	}{
+		{"permissive", policyEnforcerConfig{EnforcementMode: "PERMISSIVE", Paths: []policyEnforcerPath{{Name: "a", Path: "/a"}}}, "/b", enforcementDisabled},
+		{"permissive configured", policyEnforcerConfig{EnforcementMode: "PERMISSIVE", Paths: []policyEnforcerPath{{Name: "a", Path: "/a"}}}, "/a", enforcementEnabled},
+		{"disabled", policyEnforcerConfig{EnforcementMode: "DISABLED", Paths: []policyEnforcerPath{{Name: "a", Path: "/a"}}}, "/a", enforcementDisabled},
+		{"extension", policyEnforcerConfig{Paths: []policyEnforcerPath{{Name: "html", Path: "/*.html"}}}, "/docs/index.html", enforcementEnabled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := compilePolicyEnforcer(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			am := middlewareWithRules(rules)
+			rule := am.ruleFor(httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil))
+			if rule.enforcement != test.expected {
+				t.Errorf("expected enforcement %d, got %d (rule %s)", test.expected, rule.enforcement, rule.name)
+			}
+		})
+	}
+
+	for _, config := range []policyEnforcerConfig{
+		{EnforcementMode: "STRICT"},
+		{Paths: []policyEnforcerPath{{Path: "/a"}}},
+		{Paths: []policyEnforcerPath{{Name: "a", Path: "a"}}},
+		{Paths: []policyEnforcerPath{{Name: "a", Path: "/a", Methods: []policyEnforcerMethod{{Method: "GET", Scopes: []string{"x", "y"}, ScopesEnforcementMode: "ANY"}}}}},
+	} {
+		if _, err := compilePolicyEnforcer(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
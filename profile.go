@@ -0,0 +1,70 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// environmentVariable selects the profile when Config.Environment is empty
+const environmentVariable = "AUTHZ_ENVIRONMENT"
+
+// Profile overrides environment-specific settings of the base config. Unset fields keep the base value.
+type Profile struct {
+	KeycloakURL string `json:"keycloakURL,omitempty"`
+	VerifyTLS   *bool  `json:"verifyTLS,omitempty"`
+	LogLevel    string `json:"logLevel,omitempty"`
+	DryRun      *bool  `json:"dryRun,omitempty"`
+}
+
+// environment returns the selected environment key, if any
+func (c *Config) environment() string {
+	if env := strings.TrimSpace(c.Environment); env != "" {
+		return env
+	}
+	return strings.TrimSpace(os.Getenv(environmentVariable))
+}
+
+// withProfile returns a copy of the config with the selected profile applied. Without a selected
+// environment the config is returned unchanged; selecting an unknown profile is an error.
+func (c *Config) withProfile() (*Config, error) {
+	env := c.environment()
+	if env == "" {
+		return c, nil
+	}
+	profile, ok := c.Profiles[env]
+	if !ok {
+		if len(c.Profiles) == 0 && c.Environment == "" {
+			// The variable is set for the whole process; configs without profiles ignore it
+			return c, nil
+		}
+		return nil, fmt.Errorf("unknown environment %q (profiles: %s)", env, strings.Join(profileNames(c.Profiles), ", "))
+	}
+
+	applied := *c
+	if profile.KeycloakURL != "" {
+		applied.KeycloakURL = profile.KeycloakURL
+	}
+	if profile.VerifyTLS != nil {
+		applied.VerifyTLS = *profile.VerifyTLS
+	}
+	if profile.LogLevel != "" {
+		applied.LogLevel = profile.LogLevel
+	}
+	if profile.DryRun != nil {
+		applied.DryRun = *profile.DryRun
+	}
+	fmt.Println("🔧 [CONFIG] Using profile:", env)
+	return &applied, nil
+}
+
+// profileNames returns the sorted profile names
+func profileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,101 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProfileOverrides(t *testing.T) {
+	enabled := true
+	config := &Config{
+		KeycloakURL: "https://keycloak.prod.example.com/realms/app/protocol/openid-connect/token",
+		VerifyTLS:   true,
+		Profiles: map[string]Profile{
+			"dev":  {KeycloakURL: "http://keycloak.dev.local/token", VerifyTLS: new(bool), LogLevel: "debug", DryRun: &enabled},
+			"prod": {LogLevel: "warn"},
+		},
+		Environment: "dev",
+	}
+
+	applied, err := config.withProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.KeycloakURL != "http://keycloak.dev.local/token" || applied.VerifyTLS || !applied.DryRun || applied.LogLevel != "debug" {
+		t.Errorf("dev profile not applied: %+v", applied)
+	}
+	if config.KeycloakURL == applied.KeycloakURL {
+		t.Error("withProfile must not modify the base config")
+	}
+
+	config.Environment = "prod"
+	applied, err = config.withProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.KeycloakURL != config.KeycloakURL || !applied.VerifyTLS || applied.DryRun || applied.LogLevel != "warn" {
+		t.Errorf("prod profile not applied: %+v", applied)
+	}
+
+	config.Environment = "qa"
+	if _, err := config.withProfile(); err == nil {
+		t.Error("expected error for unknown environment")
+	}
+}
+
+func TestProfileFromEnvironmentVariable(t *testing.T) {
+	t.Setenv(environmentVariable, "staging")
+	config := &Config{Profiles: map[string]Profile{"staging": {KeycloakURL: "http://keycloak.staging/token"}}}
+	applied, err := config.withProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.KeycloakURL != "http://keycloak.staging/token" {
+		t.Errorf("expected staging profile, got %q", applied.KeycloakURL)
+	}
+
+	// Configs without profiles ignore the process-wide variable
+	if _, err := (&Config{}).withProfile(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDryRunForwardsDenials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+		_, _ = rw.Write([]byte(`{"error":"access_denied"}`))
+	}))
+	defer srv.Close()
+
+	var decision Decision
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		decision, _ = DecisionFromContext(req.Context())
+	})
+	handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL, DryRun: true, LogLevel: "off"}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || decision.Allowed || decision.Reason != ReasonAccessDenied {
+		t.Errorf("expected denied decision forwarded in dry-run, got %d / %+v", recorder.Code, decision)
+	}
+}
+
+func TestInvalidLogLevel(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	for _, config := range []*Config{
+		{LogLevel: "verbose"},
+		{Profiles: map[string]Profile{"dev": {LogLevel: "chatty"}}},
+	} {
+		if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
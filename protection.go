@@ -0,0 +1,206 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTicketCacheTTL is how long a permission ticket is reused when ticketCacheTTL is not set
+const defaultTicketCacheTTL = 30 * time.Second
+
+// tokenEndpointSuffix is the path of the Keycloak token endpoint below the realm URL
+const tokenEndpointSuffix = "/protocol/openid-connect/token"
+
+// parseDurationOrDefault parses a Go duration string, returning fallback for an empty value
+func parseDurationOrDefault(value string, fallback time.Duration) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", value)
+	}
+	return d, nil
+}
+
+// realmURL derives the realm base URL (the UMA as_uri) from the configured token endpoint
+func (am *AuthMiddleware) realmURL(ctx context.Context) string {
+	return strings.TrimSuffix(strings.TrimRight(am.runtimeFor(ctx).keycloakUrl, "/"), tokenEndpointSuffix)
+}
+
+// protectionAPITimeout bounds a Protection API call shared by concurrent requests
+const protectionAPITimeout = 10 * time.Second
+
+// defaultTicketCacheMaxEntries bounds the cached permission tickets
+const defaultTicketCacheMaxEntries = 10000
+
+// detachedContext returns a context with the runtime configuration of ctx but without its deadline
+// and cancellation, bounded by protectionAPITimeout, for Protection API calls shared by several requests
+func (am *AuthMiddleware) detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(context.Background(), runtimeKey, am.runtimeFor(ctx)), protectionAPITimeout)
+}
+
+// ticketCall is an in-flight Protection API request shared by concurrent denials
+type ticketCall struct {
+	done   chan struct{}
+	ticket string
+	err    error
+}
+
+type cachedTicket struct {
+	ticket    string
+	expiresAt time.Time
+}
+
+// ticketCache reuses permission tickets per resource/scope and coalesces concurrent requests for the
+// same key. As resources may come from unauthenticated requests, it holds at most maxEntries.
+type ticketCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu        sync.Mutex
+	entries   map[string]cachedTicket
+	inflight  map[string]*ticketCall
+	nextSweep time.Time
+}
+
+func newTicketCache(ttl time.Duration) *ticketCache {
+	return &ticketCache{
+		ttl:        ttl,
+		maxEntries: defaultTicketCacheMaxEntries,
+		entries:    make(map[string]cachedTicket),
+		inflight:   make(map[string]*ticketCall),
+	}
+}
+
+// get returns a cached ticket for key or calls fetch once for all concurrent callers. The fetch
+// outlives the caller that started it; each caller stops waiting when its own ctx is done.
+func (tc *ticketCache) get(ctx context.Context, key string, fetch func() (string, error)) (string, error) {
+	tc.mu.Lock()
+	if entry, ok := tc.entries[key]; ok {
+		if time.Now().Before(entry.expiresAt) {
+			tc.mu.Unlock()
+			return entry.ticket, nil
+		}
+		delete(tc.entries, key)
+	}
+	call, ok := tc.inflight[key]
+	if !ok {
+		call = &ticketCall{done: make(chan struct{})}
+		tc.inflight[key] = call
+		go tc.fetch(key, call, fetch)
+	}
+	tc.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.ticket, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// fetch runs a coalesced ticket request and caches its result
+func (tc *ticketCache) fetch(key string, call *ticketCall, fetch func() (string, error)) {
+	call.ticket, call.err = fetch()
+
+	tc.mu.Lock()
+	delete(tc.inflight, key)
+	if call.err == nil && tc.ttl > 0 {
+		tc.storeLocked(key, cachedTicket{ticket: call.ticket, expiresAt: time.Now().Add(tc.ttl)})
+	}
+	tc.mu.Unlock()
+	close(call.done)
+}
+
+// storeLocked caches a ticket, sweeping expired tickets once per TTL and dropping arbitrary ones when
+// the cache is full. tc.mu must be held.
+func (tc *ticketCache) storeLocked(key string, entry cachedTicket) {
+	now := time.Now()
+	if !now.Before(tc.nextSweep) {
+		tc.nextSweep = now.Add(tc.ttl)
+		for k, e := range tc.entries {
+			if !now.Before(e.expiresAt) {
+				delete(tc.entries, k)
+			}
+		}
+	}
+	if _, exists := tc.entries[key]; !exists {
+		for k := range tc.entries {
+			if len(tc.entries) < tc.maxEntries {
+				break
+			}
+			delete(tc.entries, k)
+		}
+	}
+	tc.entries[key] = entry
+}
+
+// requestPermissionTicket asks the Keycloak Protection API for a permission ticket on resource/scope
+func (am *AuthMiddleware) requestPermissionTicket(ctx context.Context, resource, scope string) (string, error) {
+	pat, err := am.serviceTokens.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Keycloak resolves resource_id by ID first and falls back to the resource name
+	permission := map[string]interface{}{"resource_id": resource}
+	if scope != "" {
+		permission["resource_scopes"] = []string{scope}
+	}
+	payload, err := json.Marshal([]interface{}{permission})
+	if err != nil {
+		return "", err
+	}
+
+	ticketReq, err := http.NewRequestWithContext(ctx, http.MethodPost, am.realmURL(ctx)+"/authz/protection/permission", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("creating permission ticket request: %w", err)
+	}
+	ticketReq.Header.Set("Authorization", "Bearer "+pat)
+	ticketReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := am.client.Do(ticketReq)
+	if err != nil {
+		return "", fmt.Errorf("requesting permission ticket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := readLimited(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("permission ticket request failed with status %d: %s", resp.StatusCode, parseKeycloakError(body).Error)
+	}
+
+	var ticketResp struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := json.Unmarshal(body, &ticketResp); err != nil || ticketResp.Ticket == "" {
+		return "", fmt.Errorf("permission ticket response has no ticket")
+	}
+	return ticketResp.Ticket, nil
+}
+
+// permissionTicket returns a (possibly cached) permission ticket for the UMA challenge
+func (am *AuthMiddleware) permissionTicket(ctx context.Context, permission Permission) (string, error) {
+	key := permission.Resource + "#" + permission.Scope
+	return am.tickets.get(ctx, key, func() (string, error) {
+		fetchCtx, cancel := am.detachedContext(ctx)
+		defer cancel()
+		return am.requestPermissionTicket(fetchCtx, permission.Resource, permission.Scope)
+	})
+}
+
+// umaChallenge renders the UMA WWW-Authenticate challenge for a realm URL and ticket
+func umaChallenge(realm, ticket string) string {
+	realmName := realm[strings.LastIndex(realm, "/")+1:]
+	return fmt.Sprintf(`UMA realm=%q, as_uri=%q, ticket=%q`, realmName, realm, ticket)
+}
@@ -0,0 +1,123 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRealmStub starts a fake Keycloak realm that denies every UMA request and issues permission tickets
+func newRealmStub(t *testing.T, ticketCalls *int32) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/demo/protocol/openid-connect/token", func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") == "client_credentials" {
+			_, _ = rw.Write([]byte(`{"access_token":"pat","expires_in":300}`))
+			return
+		}
+		rw.WriteHeader(http.StatusForbidden)
+		_, _ = rw.Write([]byte(`{"error":"access_denied"}`))
+	})
+	mux.HandleFunc("/realms/demo/authz/protection/permission", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer pat" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var permissions []struct {
+			ResourceID     string   `json:"resource_id"`
+			ResourceScopes []string `json:"resource_scopes"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&permissions); err != nil || len(permissions) != 1 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(ticketCalls, 1)
+		fmt.Fprintf(rw, `{"ticket":"ticket-%s-%d"}`, permissions[0].ResourceID, n)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUMATicketChallenge(t *testing.T) {
+	var ticketCalls int32
+	srv := newRealmStub(t, &ticketCalls)
+
+	config := &Config{
+		KeycloakURL:          srv.URL + "/realms/demo/protocol/openid-connect/token",
+		KeycloakClientId:     "gateway",
+		KeycloakClientSecret: "secret",
+		UMATicketMode:        true,
+	}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`UMA realm="demo", as_uri="%s/realms/demo", ticket="ticket-user-1"`, srv.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusUnauthorized {
+				t.Errorf("expected %d, got %d", http.StatusUnauthorized, recorder.Code)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate"); got != expected {
+				t.Errorf("expected challenge %q, got %q", expected, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&ticketCalls); n != 1 {
+		t.Errorf("expected a single permission ticket request, got %d", n)
+	}
+}
+
+func TestUMATicketModeRequiresSecret(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := New(context.Background(), next, &Config{UMATicketMode: true}, "AuthMiddleware"); err == nil {
+		t.Error("expected error when umaTicketMode is set without keycloakClientSecret")
+	}
+}
+
+func TestTicketCacheBoundedAndDetached(t *testing.T) {
+	tc := newTicketCache(time.Minute)
+	tc.maxEntries = 3
+	for i := 0; i < 10; i++ {
+		_, _ = tc.get(context.Background(), fmt.Sprintf("res-%d#view", i), func() (string, error) { return "ticket", nil })
+	}
+	if n := len(tc.entries); n > tc.maxEntries {
+		t.Errorf("expected at most %d tickets, got %d", tc.maxEntries, n)
+	}
+
+	// A caller giving up does not fail the request shared with the others
+	release := make(chan struct{})
+	fetch := func() (string, error) {
+		<-release
+		return "shared", nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tc.get(ctx, "orders#view", fetch); err != context.Canceled {
+		t.Errorf("expected the cancelled caller to stop waiting, got %v", err)
+	}
+	close(release)
+	if ticket, err := tc.get(context.Background(), "orders#view", fetch); err != nil || ticket != "shared" {
+		t.Errorf("expected the shared ticket, got %q (%v)", ticket, err)
+	}
+}
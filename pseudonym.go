@@ -0,0 +1,110 @@
+package authztraefikgateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// PseudonymConfig forwards a stable pseudonymous user ID to the upstream instead of the subject, so
+// analytics backends can count users without receiving personal identifiers. The ID is the HMAC of the
+// "sub" claim under a key that can differ per upstream, so IDs cannot be joined across upstreams.
+type PseudonymConfig struct {
+	Header string           `json:"header,omitempty"` // e.g. "X-Authz-Pseudonym"; enables the mode
+	Key    string           `json:"key,omitempty"`    // HMAC key of requests no route matches; none: no header
+	Routes []PseudonymRoute `json:"routes,omitempty"` // per-route keys, first match wins
+}
+
+// PseudonymRoute selects the pseudonym key of the requests to one upstream
+type PseudonymRoute struct {
+	Host   string `json:"host,omitempty"`   // request host (port ignored); empty matches all hosts
+	Prefix string `json:"prefix,omitempty"` // path prefix, matched with dot segments resolved
+	Key    string `json:"key"`
+}
+
+// pseudonymizer computes the pseudonym header of authorized requests
+type pseudonymizer struct {
+	header string
+	key    []byte
+	routes []pseudonymRoute
+}
+
+// pseudonymRoute is a PseudonymRoute with its host normalized
+type pseudonymRoute struct {
+	host   string
+	prefix string
+	key    []byte
+}
+
+// newPseudonymizer validates the configuration; it returns nil when no header is configured
+func newPseudonymizer(config PseudonymConfig) (*pseudonymizer, error) {
+	header := http.CanonicalHeaderKey(strings.TrimSpace(config.Header))
+	if header == "" {
+		if config.Key != "" || len(config.Routes) > 0 {
+			return nil, fmt.Errorf("pseudonym.key and pseudonym.routes require pseudonym.header")
+		}
+		return nil, nil
+	}
+	if config.Key == "" && len(config.Routes) == 0 {
+		return nil, fmt.Errorf("pseudonym.header requires pseudonym.key or pseudonym.routes")
+	}
+	ps := &pseudonymizer{header: header, routes: make([]pseudonymRoute, 0, len(config.Routes))}
+	if config.Key != "" {
+		ps.key = []byte(config.Key)
+	}
+	for i, route := range config.Routes {
+		if route.Key == "" {
+			return nil, fmt.Errorf("pseudonym.routes[%d]: key is required", i)
+		}
+		if route.Host == "" && route.Prefix == "" {
+			return nil, fmt.Errorf("pseudonym.routes[%d]: host or prefix is required", i)
+		}
+		ps.routes = append(ps.routes, pseudonymRoute{
+			host:   strings.ToLower(strings.TrimSpace(route.Host)),
+			prefix: route.Prefix,
+			key:    []byte(route.Key),
+		})
+	}
+	return ps, nil
+}
+
+// keyFor returns the key of the route the request is sent to, or nil if it gets no pseudonym
+func (ps *pseudonymizer) keyFor(req *http.Request) []byte {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	path := cleanPath(req.URL.Path)
+	for _, route := range ps.routes {
+		if route.host != "" && route.host != strings.ToLower(host) {
+			continue
+		}
+		if !strings.HasPrefix(path, route.prefix) {
+			continue
+		}
+		return route.key
+	}
+	return ps.key
+}
+
+// pseudonym returns the base64url HMAC-SHA256 of subject under key
+func pseudonym(key []byte, subject string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// apply replaces any client-supplied pseudonym header with the pseudonym of the decision's subject
+func (ps *pseudonymizer) apply(req *http.Request, decision Decision) {
+	req.Header.Del(ps.header)
+	if decision.subject == "" {
+		return
+	}
+	if key := ps.keyFor(req); key != nil {
+		req.Header.Set(ps.header, pseudonym(key, decision.subject))
+	}
+}
@@ -0,0 +1,93 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPseudonym(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var forwarded http.Header
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Clone()
+	})
+	config := &Config{
+		KeycloakURL: srv.URL,
+		Pseudonym: PseudonymConfig{
+			Header: "X-Authz-Pseudonym",
+			Key:    "default-key",
+			Routes: []PseudonymRoute{
+				{Prefix: "/api/v1/analytics/", Key: "analytics-key"},
+				{Host: "reports.example.com", Key: "reports-key"},
+			},
+		},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		claims   string
+		expected string
+	}{
+		{"default key", "http://gateway/api/v1/user/get", `{"sub":"alice"}`, pseudonym([]byte("default-key"), "alice")},
+		{"route by prefix", "http://gateway/api/v1/analytics/get", `{"sub":"alice"}`, pseudonym([]byte("analytics-key"), "alice")},
+		{"route by host", "http://reports.example.com:8443/api/v1/report/get", `{"sub":"alice"}`, pseudonym([]byte("reports-key"), "alice")},
+		{"no subject", "http://gateway/api/v1/user/get", `{"azp":"batch"}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwarded = nil
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(test.claims))
+			// Client-supplied pseudonyms are never trusted
+			req.Header.Set("X-Authz-Pseudonym", "spoofed")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if forwarded == nil {
+				t.Fatal("expected the request to be forwarded")
+			}
+			if got := forwarded.Get("X-Authz-Pseudonym"); got != test.expected {
+				t.Errorf("expected pseudonym %q, got %q", test.expected, got)
+			}
+		})
+	}
+
+	// Pseudonyms are stable, differ per key and never contain the subject
+	a, b := pseudonym([]byte("k1"), "alice"), pseudonym([]byte("k2"), "alice")
+	if a != pseudonym([]byte("k1"), "alice") || a == b || a == "alice" {
+		t.Errorf("unexpected pseudonyms %q and %q", a, b)
+	}
+	if snapshot := redactConfig(*config); snapshot.Pseudonym.Key != redacted || snapshot.Pseudonym.Routes[0].Key != redacted || config.Pseudonym.Routes[0].Key != "analytics-key" {
+		t.Errorf("expected pseudonym keys redacted in snapshots only, got %+v", snapshot.Pseudonym)
+	}
+}
+
+func TestPseudonymValidation(t *testing.T) {
+	tests := []struct {
+		config PseudonymConfig
+		valid  bool
+	}{
+		{PseudonymConfig{}, true},
+		{PseudonymConfig{Header: "X-Pseudonym", Key: "k"}, true},
+		{PseudonymConfig{Header: "X-Pseudonym", Routes: []PseudonymRoute{{Prefix: "/a/", Key: "k"}}}, true},
+		{PseudonymConfig{Header: "X-Pseudonym"}, false},
+		{PseudonymConfig{Key: "k"}, false},
+		{PseudonymConfig{Header: "X-Pseudonym", Routes: []PseudonymRoute{{Prefix: "/a/"}}}, false},
+		{PseudonymConfig{Header: "X-Pseudonym", Routes: []PseudonymRoute{{Key: "k"}}}, false},
+	}
+	for _, test := range tests {
+		_, err := newPseudonymizer(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.config, test.valid, err)
+		}
+	}
+}
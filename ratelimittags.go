@@ -0,0 +1,111 @@
+package authztraefikgateway
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Defaults of rate-limit tagging
+const (
+	defaultTierClaim = "plan"
+	defaultTier      = "default"
+)
+
+// RateLimitTagsConfig sets headers identifying the authenticated subject and its tier on authorized
+// requests, so downstream rate limiters (e.g. Traefik's sourceCriterion.requestHeaderName) can key their
+// buckets on identity instead of IP
+type RateLimitTagsConfig struct {
+	SubjectHeader  string        `json:"subjectHeader,omitempty"`  // e.g. "X-Authz-Subject": the subject fingerprint
+	TierHeader     string        `json:"tierHeader,omitempty"`     // e.g. "X-Authz-Tier"
+	TierClaim      string        `json:"tierClaim,omitempty"`      // claim deriving the tier, dotted for nested claims (default "plan")
+	Tiers          []TierMapping `json:"tiers,omitempty"`          // claim value -> tier, first match wins; without it the first claim value is the tier
+	DefaultTier    string        `json:"defaultTier,omitempty"`    // tier of subjects without a matching claim value (default "default")
+	ClientIPHeader string        `json:"clientIPHeader,omitempty"` // e.g. "X-Authz-Client-Ip": the client IP resolved through trustedProxies
+}
+
+// TierMapping maps a claim value, e.g. the group "/customers/premium", to a tier
+type TierMapping struct {
+	Value string `json:"value"`
+	Tier  string `json:"tier"`
+}
+
+// rateLimitTags is the prepared RateLimitTagsConfig
+type rateLimitTags struct {
+	subjectHeader  string
+	tierHeader     string
+	clientIPHeader string
+	tierClaim      []string
+	tiers          []TierMapping
+	defaultTier    string
+}
+
+// newRateLimitTags prepares rate-limit tagging; it returns nil when no header is configured
+func newRateLimitTags(config RateLimitTagsConfig) *rateLimitTags {
+	subjectHeader := http.CanonicalHeaderKey(strings.TrimSpace(config.SubjectHeader))
+	tierHeader := http.CanonicalHeaderKey(strings.TrimSpace(config.TierHeader))
+	clientIPHeader := http.CanonicalHeaderKey(strings.TrimSpace(config.ClientIPHeader))
+	if subjectHeader == "" && tierHeader == "" && clientIPHeader == "" {
+		return nil
+	}
+	rt := &rateLimitTags{
+		subjectHeader:  subjectHeader,
+		tierHeader:     tierHeader,
+		clientIPHeader: clientIPHeader,
+		tierClaim:      strings.Split(defaultTierClaim, "."),
+		tiers:          config.Tiers,
+		defaultTier:    strings.TrimSpace(config.DefaultTier),
+	}
+	if claim := strings.TrimSpace(config.TierClaim); claim != "" {
+		rt.tierClaim = strings.Split(claim, ".")
+	}
+	if rt.defaultTier == "" {
+		rt.defaultTier = defaultTier
+	}
+	return rt
+}
+
+// tier derives the tier of a token, or of a forwarded identity's claims
+func (rt *rateLimitTags) tier(accessToken string, claims map[string][]string) string {
+	var values []string
+	if claims != nil {
+		values = claims[strings.Join(rt.tierClaim, ".")]
+	} else {
+		values = tokenClaimValues(accessToken, rt.tierClaim)
+	}
+	if len(rt.tiers) == 0 {
+		if len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+		return rt.defaultTier
+	}
+	for _, mapping := range rt.tiers {
+		for _, value := range values {
+			if value == mapping.Value {
+				return mapping.Tier
+			}
+		}
+	}
+	return rt.defaultTier
+}
+
+// apply replaces any client-supplied tag headers with those of the decision
+func (rt *rateLimitTags) apply(req *http.Request, decision Decision) {
+	if rt.subjectHeader != "" {
+		req.Header.Del(rt.subjectHeader)
+		if decision.SubjectFingerprint != "" {
+			req.Header.Set(rt.subjectHeader, decision.SubjectFingerprint)
+		}
+	}
+	if rt.tierHeader != "" {
+		req.Header.Del(rt.tierHeader)
+		if decision.Tier != "" {
+			req.Header.Set(rt.tierHeader, decision.Tier)
+		}
+	}
+	if rt.clientIPHeader != "" {
+		req.Header.Del(rt.clientIPHeader)
+		if decision.ClientIP != "" {
+			req.Header.Set(rt.clientIPHeader, decision.ClientIP)
+		}
+	}
+}
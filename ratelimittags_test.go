@@ -0,0 +1,63 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitTags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var subject, tier string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		subject, tier = req.Header.Get("X-Authz-Subject"), req.Header.Get("X-Authz-Tier")
+	})
+	newHandler := func(tags RateLimitTagsConfig) http.Handler {
+		handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL, RateLimitTags: tags}, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	byPlan := newHandler(RateLimitTagsConfig{SubjectHeader: "X-Authz-Subject", TierHeader: "X-Authz-Tier"})
+	byGroup := newHandler(RateLimitTagsConfig{
+		TierHeader:  "X-Authz-Tier",
+		TierClaim:   "groups",
+		Tiers:       []TierMapping{{Value: "/customers/premium", Tier: "premium"}, {Value: "/customers", Tier: "standard"}},
+		DefaultTier: "anonymous",
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		claims   string
+		subject  string
+		expected string
+	}{
+		{"plan claim", byPlan, `{"sub":"alice","plan":"gold"}`, SubjectFingerprint("alice"), "gold"},
+		{"no plan", byPlan, `{"sub":"bob"}`, SubjectFingerprint("bob"), "default"},
+		{"first mapped group", byGroup, `{"sub":"carol","groups":["/staff","/customers","/customers/premium"]}`, "", "premium"},
+		{"unmapped groups", byGroup, `{"sub":"dave","groups":["/staff"]}`, "", "anonymous"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+jwtWithClaims(test.claims))
+			// Client-supplied tags are never trusted
+			req.Header.Set("X-Authz-Subject", "spoofed")
+			req.Header.Set("X-Authz-Tier", "unlimited")
+			test.handler.ServeHTTP(httptest.NewRecorder(), req)
+			if tier != test.expected {
+				t.Errorf("expected tier %q, got %q", test.expected, tier)
+			}
+			if test.subject != "" && subject != test.subject {
+				t.Errorf("expected subject %q, got %q", test.subject, subject)
+			}
+		})
+	}
+}
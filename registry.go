@@ -0,0 +1,119 @@
+package authztraefikgateway
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sharedKey identifies the Keycloak settings under which middleware instances may share state
+type sharedKey struct {
+	keycloakURL string
+	clientID    string
+	verifyTLS   bool
+	tls         string // tlsEndpointsKey of tlsEndpoints
+	internalURL string
+	cache       CacheConfig
+}
+
+// sharedState is the per-Keycloak state of a middleware instance, shared between instances with
+// identical settings when Config.Share is set
+type sharedState struct {
+	refs   int
+	client *http.Client
+	cache  *decisionCache // nil unless cache is enabled
+}
+
+// registry holds the shared state of live middleware instances, reference counted
+type registry struct {
+	mu     sync.Mutex
+	states map[sharedKey]*sharedState
+}
+
+// sharedStates is the process-wide registry used by New
+var sharedStates = &registry{states: make(map[sharedKey]*sharedState)}
+
+// spentSet remembers single-use credentials until they expire. It is process-wide, as Traefik builds
+// a middleware instance per router and rebuilds them on every configuration change, so a credential
+// spent on one instance cannot be replayed on another.
+type spentSet struct {
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	nextSweep time.Time
+}
+
+// spentCredentials is the process-wide set of spent credentials, keyed by kind and hash
+var spentCredentials = &spentSet{expires: make(map[string]time.Time)}
+
+// spend marks key spent until expires and reports whether it was not spent yet. Expired keys are
+// swept at most once a minute.
+func (s *spentSet) spend(key string, expires time.Time) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.nextSweep) {
+		for spent, until := range s.expires {
+			if now.After(until) {
+				delete(s.expires, spent)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	if until, ok := s.expires[key]; ok && !now.After(until) {
+		return false
+	}
+	s.expires[key] = expires
+	return true
+}
+
+// acquire returns the state registered under key, creating it with build for the first instance
+func (r *registry) acquire(key sharedKey, build func() *sharedState) *sharedState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[key]
+	if !ok {
+		state = build()
+		r.states[key] = state
+	}
+	state.refs++
+	return state
+}
+
+// release drops one reference to the state under key and closes it with the last one
+func (r *registry) release(key sharedKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[key]
+	if !ok {
+		return
+	}
+	state.refs--
+	if state.refs <= 0 {
+		delete(r.states, key)
+		state.close()
+	}
+}
+
+// close releases the resources of a state
+func (s *sharedState) close() {
+	s.client.CloseIdleConnections()
+}
+
+// newSharedState builds the HTTP client for Keycloak and the seeded decision cache of a config.
+// Hosts of tlsTransports use their own TLS settings; address, if set, sends calls to internalURL.
+func newSharedState(config *Config, cache *decisionCache, tlsTransports map[string]*http.Transport, address *keycloakAddress) *sharedState {
+	transport := address.transport(withEndpointTLS(tlsTransports, &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: !config.VerifyTLS},
+	}))
+	if cache != nil && config.Cache.SeedFile != "" {
+		if entries, err := loadCacheSeed(config.Cache.SeedFile); err != nil {
+			// A missing or broken seed only means a cold start
+			fmt.Println("⚠️  [CACHE] Could not load seed file:", err)
+		} else {
+			fmt.Printf("💾 [CACHE] Seeded %d of %d entries from %s\n", cache.seed(entries), len(entries), config.Cache.SeedFile)
+		}
+	}
+	return &sharedState{client: &http.Client{Transport: transport}, cache: cache}
+}
@@ -0,0 +1,79 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSharedStateRegistry(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := func(url string) *Config {
+		return &Config{KeycloakURL: url, Share: true, Cache: CacheConfig{Enabled: true}}
+	}
+	newInstance := func(ctx context.Context, config *Config) *AuthMiddleware {
+		handler, err := New(ctx, next, config, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler.(*AuthMiddleware)
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	first := newInstance(ctx1, config("http://keycloak.registry.test/token"))
+	second := newInstance(ctx2, config("http://keycloak.registry.test/token"))
+	other := newInstance(ctx3, config("http://other.registry.test/token"))
+
+	if first.client != second.client || first.cache != second.cache {
+		t.Error("instances with identical Keycloak settings must share the client and cache")
+	}
+	if first.client == other.client || first.cache == other.cache {
+		t.Error("instances with different Keycloak settings must not share state")
+	}
+
+	key := sharedKey{keycloakURL: "http://keycloak.registry.test/token", cache: CacheConfig{Enabled: true}}
+	registered := func() bool {
+		sharedStates.mu.Lock()
+		defer sharedStates.mu.Unlock()
+		_, ok := sharedStates.states[key]
+		return ok
+	}
+
+	cancel1()
+	time.Sleep(50 * time.Millisecond)
+	if !registered() {
+		t.Fatal("state must stay registered while an instance still uses it")
+	}
+	cancel2()
+	deadline := time.Now().Add(5 * time.Second)
+	for registered() {
+		if time.Now().After(deadline) {
+			t.Fatal("state was not released after the last instance shut down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpentSet(t *testing.T) {
+	spent := &spentSet{expires: make(map[string]time.Time)}
+	if !spent.spend("a", time.Now().Add(time.Hour)) {
+		t.Fatal("expected the first use to be accepted")
+	}
+	if spent.spend("a", time.Now().Add(time.Hour)) {
+		t.Error("expected a replay to be rejected")
+	}
+	spent.spend("b", time.Now().Add(-time.Second))
+	if !spent.spend("b", time.Now().Add(time.Hour)) {
+		t.Error("expected an expired key to be accepted again")
+	}
+	spent.nextSweep = time.Time{}
+	spent.expires["c"] = time.Now().Add(-time.Second)
+	spent.spend("d", time.Now().Add(time.Hour))
+	if _, ok := spent.expires["c"]; ok {
+		t.Error("expected expired keys to be swept")
+	}
+}
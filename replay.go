@@ -0,0 +1,168 @@
+package authztraefikgateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ReplayOptions control how audit records are re-evaluated
+type ReplayOptions struct {
+	// Live evaluates permissions with Keycloak, for records whose subject has a token in Tokens
+	Live bool
+	// Tokens maps subject fingerprints to access tokens used to re-evaluate their requests, e.g. tokens
+	// of test users mirroring production ones. Audit records never contain tokens.
+	Tokens map[string]string
+}
+
+// ReplayResult is the outcome of re-evaluating one audit record against the current configuration.
+// Without a token for the subject only the local part of the decision (deny rules, enforcement, rule
+// and permission) is re-evaluated and Evaluated is false unless that part decides alone.
+type ReplayResult struct {
+	Record     AuditRecord `json:"record"`
+	Rule       string      `json:"rule,omitempty"`
+	Permission string      `json:"permission,omitempty"`
+	Evaluated  bool        `json:"evaluated"` // Allowed and Reason hold the new decision
+	Allowed    bool        `json:"allowed"`
+	Reason     string      `json:"reason,omitempty"`
+	Error      string      `json:"error,omitempty"` // the live evaluation failed
+	Changed    bool        `json:"changed"`
+	Changes    []string    `json:"changes,omitempty"`
+}
+
+// ReadAuditRecords parses audit records written to auditFile, one JSON object per line
+func ReadAuditRecords(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("audit record %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Replay re-evaluates recorded decisions against the current rules and, optionally, live Keycloak, and
+// reports which requests would now get a different rule, permission or decision. It is meant to
+// validate policy changes before rollout; no request reaches the upstream and nothing is cached.
+func (am *AuthMiddleware) Replay(ctx context.Context, records []AuditRecord, options ReplayOptions) []ReplayResult {
+	results := make([]ReplayResult, 0, len(records))
+	for _, record := range records {
+		result := am.replay(ctx, record, options)
+		result.compare()
+		results = append(results, result)
+	}
+	return results
+}
+
+// replay re-evaluates a single record
+func (am *AuthMiddleware) replay(ctx context.Context, record AuditRecord, options ReplayOptions) ReplayResult {
+	result := ReplayResult{Record: record}
+	decided := func(d Decision) ReplayResult {
+		result.Evaluated, result.Allowed, result.Reason = true, d.Allowed, d.Reason
+		if d.Rule != "" {
+			result.Rule = d.Rule
+		}
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, record.Method, "http://"+record.Host+record.URI, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Host = record.Host
+	if record.Accept != "" {
+		req.Header.Set("Accept", record.Accept)
+	}
+	clientIP := net.ParseIP(record.ClientIP)
+	if clientIP != nil {
+		req.RemoteAddr = net.JoinHostPort(record.ClientIP, "0")
+	}
+
+	if name, denied := am.matchDenyRule(req, clientIP); denied {
+		return decided(Decision{Rule: name, Reason: ReasonDeniedByRule})
+	}
+	target, _ := am.downgradeMethod(req)
+	if rule := am.ruleFor(target); rule != nil && rule.enforcement != enforcementEnabled {
+		return decided(am.authorizeUnenforced(rule, Decision{Rule: rule.name}))
+	}
+	if record.Reason == ReasonMissingToken {
+		// The request carried no token, so it is still denied before any rule is looked at
+		return decided(Decision{Reason: ReasonMissingToken})
+	}
+
+	permission, rule, err := am.resolvePermission(req)
+	if rule != nil {
+		result.Rule = rule.name
+	}
+	if err != nil {
+		return decided(Decision{Reason: ReasonInvalidRequest})
+	}
+	result.Permission = permissionString(permission)
+
+	accessToken := options.Tokens[record.SubjectFingerprint]
+	if accessToken == "" {
+		return result
+	}
+	if len(rule.conditions) > 0 {
+		// Conditions may select claims, so they are only evaluated with the subject's token
+		d := Decision{Rule: rule.name, Permission: permission, ClientIP: record.ClientIP}
+		if !am.applyConditions(req, rule, accessToken, &d) {
+			return decided(d)
+		}
+		permission = d.Permission
+		result.Permission = permissionString(permission)
+	}
+	if am.staticPolicy != nil {
+		return decided(am.authorizeStatic(accessToken, Decision{Permission: permission}))
+	}
+	if !options.Live {
+		return result
+	}
+	audience := am.audienceFor(req)
+	if rule.audience != "" {
+		audience = rule.audience
+	}
+	evaluated, err := am.evaluateRetrying(ctx, accessToken, am.runtimeFor(ctx).permissionFormat.format(permission.Resource, permission.Scope), audience, rule.endpoint, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if evaluated.status == http.StatusOK {
+		return decided(Decision{Allowed: true, Reason: ReasonGranted})
+	}
+	return decided(Decision{Reason: keycloakReason(evaluated.status, evaluated.errorCode, evaluated.errorDescription)})
+}
+
+// compare lists the differences between the recorded and the replayed decision
+func (r *ReplayResult) compare() {
+	if r.Rule != r.Record.Rule {
+		r.Changes = append(r.Changes, fmt.Sprintf("rule %q -> %q", r.Record.Rule, r.Rule))
+	}
+	if r.Permission != r.Record.Permission && r.Permission != "" {
+		r.Changes = append(r.Changes, fmt.Sprintf("permission %q -> %q", r.Record.Permission, r.Permission))
+	}
+	if r.Evaluated && (r.Allowed != r.Record.Allowed || r.Reason != r.Record.Reason) {
+		r.Changes = append(r.Changes, fmt.Sprintf("decision %s -> %s", replayOutcome(r.Record.Allowed, r.Record.Reason), replayOutcome(r.Allowed, r.Reason)))
+	}
+	r.Changed = len(r.Changes) > 0
+}
+
+// replayOutcome renders a decision as "allowed (granted)" or "denied (access_denied)"
+func replayOutcome(allowed bool, reason string) string {
+	if allowed {
+		return "allowed (" + reason + ")"
+	}
+	return "denied (" + reason + ")"
+}
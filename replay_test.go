@@ -0,0 +1,118 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditAndReplay(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	recorded := &Config{
+		KeycloakURL: srv.URL,
+		AuditFile:   auditFile,
+		Rules:       []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "view"}},
+	}
+	handler, err := New(context.Background(), next, recorded, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := jwtWithClaims(`{"sub":"alice"}`)
+	for _, path := range []string{"/reports/monthly", "/api/v1/user/get", "/admin/v1/user/get"} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+alice)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil))
+
+	file, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := ReadAuditRecords(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[0].Permission != "report#view" || !records[0].Allowed || records[0].SubjectFingerprint != SubjectFingerprint("alice") {
+		t.Fatalf("unexpected audit records %+v", records)
+	}
+	if records[3].Reason != ReasonMissingToken {
+		t.Errorf("expected the last record to be a missing token denial, got %+v", records[3])
+	}
+
+	// The new configuration requires #export on reports and blocks /admin
+	denied := newKeycloakStub(t, http.StatusForbidden, `{"error":"access_denied"}`)
+	candidate := &Config{
+		KeycloakURL: denied.URL,
+		Rules:       []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "export"}},
+		DenyRules:   []DenyRule{{Name: "no-admin", Prefix: "/admin"}},
+	}
+	handler, err = New(context.Background(), next, candidate, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	results := am.Replay(context.Background(), records, ReplayOptions{})
+	expected := []struct {
+		changed   bool
+		evaluated bool
+		reason    string
+	}{
+		{true, false, ""},                 // permission changed, outcome unknown without a token
+		{false, false, ""},                // same rule and permission
+		{true, true, ReasonDeniedByRule},  // decided locally by the deny rule
+		{false, true, ReasonMissingToken}, // still no token
+	}
+	for i, want := range expected {
+		got := results[i]
+		if got.Changed != want.changed || got.Evaluated != want.evaluated || got.Reason != want.reason {
+			t.Errorf("record %d: unexpected replay %+v", i, got)
+		}
+	}
+	if results[0].Permission != "report#export" {
+		t.Errorf("expected the new permission report#export, got %q", results[0].Permission)
+	}
+
+	// Live, the subject's token is denied by Keycloak
+	results = am.Replay(context.Background(), records[1:2], ReplayOptions{Live: true, Tokens: map[string]string{SubjectFingerprint("alice"): alice}})
+	if !results[0].Changed || !results[0].Evaluated || results[0].Allowed || results[0].Reason != ReasonAccessDenied {
+		t.Errorf("expected a live access denial, got %+v", results[0])
+	}
+}
+
+func TestAuditOmitsQueryTokens(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		AuditFile:    auditFile,
+		TokenSources: []TokenSource{{Type: "query", Name: "access_token"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken := jwtWithClaims(`{"sub":"alice"}`)
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get?page=2&access_token="+accessToken, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), accessToken) {
+		t.Fatalf("audit record holds the raw token: %s", data)
+	}
+	if !strings.Contains(string(data), `"uri":"/api/v1/user/get?page=2"`) {
+		t.Errorf("expected the other query parameters to be kept: %s", data)
+	}
+}
@@ -0,0 +1,171 @@
+package authztraefikgateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of request flags
+const (
+	defaultRequestFlagsHeader = "X-Authz-Flags"
+	defaultRequestFlagsMaxAge = 5 * time.Minute
+)
+
+// decisionHeader carries the decision on responses to requests with the verbose flag
+const decisionHeader = "X-Authz-Decision"
+
+// Flags usable in the request flags header
+const (
+	flagVerbose = "verbose"
+	flagNoCache = "no-cache"
+	flagDryRun  = "dry-run"
+)
+
+// RequestFlagsConfig lets trusted callers toggle behaviors for a single request, to debug it in
+// production without changing the configuration. The header holds comma-separated flags, either
+// plain from TrustedIPs or signed as "<flags>;ts=<unix seconds>;sig=<signature>" (see SignRequestFlags).
+// A signature is only valid for the method, host and path it was issued for, and only once.
+type RequestFlagsConfig struct {
+	Header     string   `json:"header,omitempty"`     // default "X-Authz-Flags"
+	Secret     string   `json:"secret,omitempty"`     // HMAC key of signed flags
+	TrustedIPs []string `json:"trustedIPs,omitempty"` // clients (CIDRs or IPs) whose flags need no signature
+	MaxAge     string   `json:"maxAge,omitempty"`     // validity of signed flags (default "5m")
+}
+
+// requestFlags are the behaviors toggled for one request
+type requestFlags struct {
+	verbose bool // the decision is returned in the X-Authz-Decision response header
+	noCache bool // the decision cache is bypassed, and refreshed with the new decision
+	dryRun  bool // a denied request is forwarded anyway
+}
+
+// requestFlagsCheck is the prepared RequestFlagsConfig
+type requestFlagsCheck struct {
+	header  string
+	secret  []byte
+	trusted []*net.IPNet
+	maxAge  time.Duration
+}
+
+// newRequestFlagsCheck prepares request flags; it returns nil unless a secret or trusted IPs are configured
+func newRequestFlagsCheck(config RequestFlagsConfig) (*requestFlagsCheck, error) {
+	if config.Secret == "" && len(config.TrustedIPs) == 0 {
+		return nil, nil
+	}
+	trusted, err := parseCIDRs(config.TrustedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("requestFlags.trustedIPs: %w", err)
+	}
+	maxAge, err := parseDurationOrDefault(config.MaxAge, defaultRequestFlagsMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("requestFlags.maxAge: %w", err)
+	}
+	rf := &requestFlagsCheck{
+		header:  http.CanonicalHeaderKey(strings.TrimSpace(config.Header)),
+		secret:  []byte(config.Secret),
+		trusted: trusted,
+		maxAge:  maxAge,
+	}
+	if rf.header == "" {
+		rf.header = defaultRequestFlagsHeader
+	}
+	return rf, nil
+}
+
+// flags returns the flags of the request and removes the header, so it never reaches the upstream.
+// Flags that are neither signed nor sent by a trusted client are ignored.
+func (rf *requestFlagsCheck) flags(req *http.Request, clientIP net.IP) (requestFlags, error) {
+	value := strings.TrimSpace(req.Header.Get(rf.header))
+	req.Header.Del(rf.header)
+	if value == "" {
+		return requestFlags{}, nil
+	}
+
+	list := value
+	if i := strings.Index(value, ";"); i >= 0 {
+		list = value[:i]
+		if err := rf.verify(req, value[:i], value[i+1:]); err != nil {
+			return requestFlags{}, err
+		}
+	} else if !containsIP(rf.trusted, clientIP) {
+		return requestFlags{}, fmt.Errorf("unsigned flags from untrusted client %s", clientIPString(clientIP))
+	}
+
+	var flags requestFlags
+	for _, flag := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(flag)) {
+		case flagVerbose:
+			flags.verbose = true
+		case flagNoCache:
+			flags.noCache = true
+		case flagDryRun:
+			flags.dryRun = true
+		default:
+			return requestFlags{}, fmt.Errorf("unknown flag %q", flag)
+		}
+	}
+	return flags, nil
+}
+
+// verify checks the "ts=<unix seconds>;sig=<signature>" parameters of flags signed for req
+func (rf *requestFlagsCheck) verify(req *http.Request, list, params string) error {
+	if len(rf.secret) == 0 {
+		return fmt.Errorf("signed flags are not enabled")
+	}
+	var ts, sig string
+	for _, param := range strings.Split(params, ";") {
+		name, value := param, ""
+		if i := strings.Index(param, "="); i >= 0 {
+			name, value = param[:i], param[i+1:]
+		}
+		switch strings.TrimSpace(name) {
+		case "ts":
+			ts = strings.TrimSpace(value)
+		case "sig":
+			sig = strings.TrimSpace(value)
+		}
+	}
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid flags timestamp %q", ts)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > rf.maxAge || age < -rf.maxAge {
+		return fmt.Errorf("flags signed %s ago, outside the allowed %s", age.Truncate(time.Second), rf.maxAge)
+	}
+	if !hmac.Equal([]byte(sig), []byte(SignRequestFlags(req, list, seconds, string(rf.secret)))) {
+		return fmt.Errorf("invalid flags signature")
+	}
+	// A captured header replayed with another token must not reuse the flags
+	if !spentCredentials.spend("flags:"+sig, time.Unix(seconds, 0).Add(rf.maxAge)) {
+		return fmt.Errorf("flags signature already used")
+	}
+	return nil
+}
+
+// SignRequestFlags returns the signature of comma-separated flags issued at the given unix time for
+// req, for the "<flags>;ts=<unix seconds>;sig=<signature>" request flags header: the base64url
+// HMAC-SHA256 with secret of "<flags>;ts=<unix seconds>;<METHOD> <host><escaped path>". Binding the
+// signature to the request keeps a captured header from being replayed on other requests.
+func SignRequestFlags(req *http.Request, flags string, unix int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(flags + ";ts=" + strconv.FormatInt(unix, 10) + ";" +
+		strings.ToUpper(req.Method) + " " + strings.ToLower(req.Host) + req.URL.EscapedPath()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setDecisionHeader describes the decision in the X-Authz-Decision response header
+func setDecisionHeader(w http.ResponseWriter, d Decision) {
+	outcome := "denied"
+	if d.Allowed {
+		outcome = "allowed"
+	}
+	w.Header().Set(decisionHeader, fmt.Sprintf("%s reason=%s rule=%s permission=%s#%s backend=%s status=%d keycloakStatus=%d latency=%s",
+		outcome, d.Reason, d.Rule, d.Permission.Resource, d.Permission.Scope, d.Backend, d.Status, d.KeycloakStatus, d.Latency))
+}
@@ -0,0 +1,100 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestFlags(t *testing.T) {
+	var calls int32
+	var status int32 = http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(int(atomic.LoadInt32(&status)))
+		_, _ = rw.Write([]byte(`{"error":"access_denied"}`))
+	}))
+	defer srv.Close()
+
+	var forwardedFlags string
+	nextCalled := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		nextCalled = true
+		forwardedFlags = req.Header.Get("X-Authz-Flags")
+	})
+	config := &Config{
+		KeycloakURL:  srv.URL,
+		Cache:        CacheConfig{Enabled: true, TTL: "1m"},
+		RequestFlags: RequestFlagsConfig{Secret: "flag-secret", TrustedIPs: []string{"10.0.0.0/8"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken := jwtWithClaims(fmt.Sprintf(`{"sub":"alice","exp":%d}`, time.Now().Add(time.Hour).Unix()))
+	serveFlags := func(remoteAddr, path, flags string) *httptest.ResponseRecorder {
+		nextCalled = false
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		if flags != "" {
+			req.Header.Set("X-Authz-Flags", flags)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	signed := func(method, path, flags string, issued time.Time) string {
+		unix := issued.Unix()
+		req := httptest.NewRequest(method, "http://gateway"+path, nil)
+		return flags + ";ts=" + strconv.FormatInt(unix, 10) + ";sig=" + SignRequestFlags(req, flags, unix, "flag-secret")
+	}
+
+	// Warm the cache, then bypass it from a trusted client with verbose output
+	serveFlags("192.0.2.1:4000", "/api/v1/user/get", "")
+	recorder := serveFlags("10.1.1.1:4000", "/api/v1/user/get", "verbose,no-cache")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected no-cache to bypass the cache, got %d Keycloak calls", n)
+	}
+	if got := recorder.Header().Get("X-Authz-Decision"); !strings.HasPrefix(got, "allowed reason=granted") {
+		t.Errorf("expected a verbose decision header, got %q", got)
+	}
+	if forwardedFlags != "" {
+		t.Errorf("the flags header must not be forwarded, got %q", forwardedFlags)
+	}
+
+	// Untrusted clients need a valid, fresh signature
+	atomic.StoreInt32(&status, http.StatusForbidden)
+	tests := []struct {
+		name      string
+		flags     string
+		forwarded bool
+	}{
+		{"unsigned from untrusted client", "dry-run,no-cache", false},
+		{"signed", signed(http.MethodGet, "/api/v1/order/get", "dry-run,no-cache", time.Now()), true},
+		{"replayed signature", "", false},
+		{"signed for another path", signed(http.MethodGet, "/api/v1/order/list", "dry-run,no-cache", time.Now()), false},
+		{"signed for another method", signed(http.MethodDelete, "/api/v1/order/get", "dry-run,no-cache", time.Now()), false},
+		{"stale signature", signed(http.MethodGet, "/api/v1/order/get", "dry-run,no-cache", time.Now().Add(-time.Hour)), false},
+		{"wrong signature", "dry-run,no-cache;ts=" + strconv.FormatInt(time.Now().Unix(), 10) + ";sig=AAAA", false},
+		{"unknown flag", signed(http.MethodGet, "/api/v1/order/get", "dry-run,no-cache,god-mode", time.Now()), false},
+	}
+	tests[2].flags = tests[1].flags
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveFlags("192.0.2.1:4000", "/api/v1/order/get", test.flags)
+			if nextCalled != test.forwarded {
+				t.Errorf("expected forwarded=%t, got %t (status %d)", test.forwarded, nextCalled, recorder.Code)
+			}
+			if recorder.Header().Get("X-Authz-Decision") != "" {
+				t.Error("expected no decision header without the verbose flag")
+			}
+		})
+	}
+}
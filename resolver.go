@@ -0,0 +1,474 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxGraphQLBodyBytes bounds how much of a GraphQL request body is read to find the operation type
+const maxGraphQLBodyBytes = 1 << 20
+
+// errPathTooShort is returned when the path has fewer segments than a resolver needs
+var errPathTooShort = errors.New("Invalid path format. Too short.")
+
+// PermissionResolver derives the resource/scope to evaluate for a request
+type PermissionResolver interface {
+	Resolve(req *http.Request) (Permission, error)
+}
+
+// StaticResolver always resolves to the same permission
+type StaticResolver struct {
+	Permission Permission
+}
+
+// Resolve implements PermissionResolver
+func (r StaticResolver) Resolve(req *http.Request) (Permission, error) {
+	return r.Permission, nil
+}
+
+// SegmentResolver takes resource and scope from fixed path segment indexes (the historical behavior)
+type SegmentResolver struct {
+	ResourceIndex int
+	ScopeIndex    int
+}
+
+// Resolve implements PermissionResolver
+func (r SegmentResolver) Resolve(req *http.Request) (Permission, error) {
+	resource, ok := pathSegment(req.URL.Path, r.ResourceIndex)
+	if !ok {
+		return Permission{}, errPathTooShort
+	}
+	scope, ok := pathSegment(req.URL.Path, r.ScopeIndex)
+	if !ok {
+		return Permission{}, errPathTooShort
+	}
+	return Permission{Resource: resource, Scope: scope}, nil
+}
+
+// PathTemplateResolver matches the path against a template such as "/api/{version}/{resource}/{scope}".
+// "{resource}" and "{scope}" capture the permission; other "{...}" placeholders and "*" match any segment.
+// Resource and Scope are used when the template does not capture them.
+type PathTemplateResolver struct {
+	Template string
+	Resource string
+	Scope    string
+}
+
+// Resolve implements PermissionResolver
+func (r PathTemplateResolver) Resolve(req *http.Request) (Permission, error) {
+	templateParts := strings.Split(strings.Trim(r.Template, "/"), "/")
+	// Segments beyond the template are never looked at, so they are not split
+	pathParts := strings.SplitN(strings.Trim(req.URL.Path, "/"), "/", len(templateParts)+1)
+	if len(pathParts) < len(templateParts) {
+		return Permission{}, errPathTooShort
+	}
+
+	permission := Permission{Resource: r.Resource, Scope: r.Scope}
+	for i, part := range templateParts {
+		switch {
+		case part == "{resource}":
+			permission.Resource = pathParts[i]
+		case part == "{scope}":
+			permission.Scope = pathParts[i]
+		case part == "*" || (strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")):
+		case part != pathParts[i]:
+			return Permission{}, fmt.Errorf("path does not match template %q", r.Template)
+		}
+	}
+	return permission, nil
+}
+
+// MethodResolver uses a fixed resource and derives the scope from the HTTP method.
+// Methods missing from MethodScopes use the lower-cased method name.
+type MethodResolver struct {
+	Resource     string
+	MethodScopes map[string]string
+}
+
+// Resolve implements PermissionResolver
+func (r MethodResolver) Resolve(req *http.Request) (Permission, error) {
+	if scope, ok := r.MethodScopes[req.Method]; ok {
+		return Permission{Resource: r.Resource, Scope: scope}, nil
+	}
+	return Permission{Resource: r.Resource, Scope: strings.ToLower(req.Method)}, nil
+}
+
+// PathResolver uses the request path, with dot segments resolved and without the surrounding "/", as
+// the resource, e.g. "projects/acme/repos/api", and derives the scope from the HTTP method like
+// MethodResolver. With Rule.InheritDepth, permissions on ancestors such as "projects/acme" grant it.
+type PathResolver struct {
+	MethodScopes map[string]string
+}
+
+// Resolve implements PermissionResolver
+func (r PathResolver) Resolve(req *http.Request) (Permission, error) {
+	resource := strings.Trim(cleanPath(req.URL.Path), "/")
+	if resource == "" {
+		return Permission{}, errPathTooShort
+	}
+	if scope, ok := r.MethodScopes[req.Method]; ok {
+		return Permission{Resource: resource, Scope: scope}, nil
+	}
+	return Permission{Resource: resource, Scope: strings.ToLower(req.Method)}, nil
+}
+
+// GraphQLResolver uses a fixed resource and derives the scope from the GraphQL operation type
+// ("query", "mutation" or "subscription"), optionally renamed through OperationScopes
+type GraphQLResolver struct {
+	Resource        string
+	OperationScopes map[string]string
+}
+
+// Resolve implements PermissionResolver
+func (r GraphQLResolver) Resolve(req *http.Request) (Permission, error) {
+	document, operationName, err := graphQLDocument(req)
+	if err != nil {
+		return Permission{}, err
+	}
+	operation, err := graphQLOperationType(document, operationName)
+	if err != nil {
+		return Permission{}, err
+	}
+	if scope, ok := r.OperationScopes[operation]; ok {
+		return Permission{Resource: r.Resource, Scope: scope}, nil
+	}
+	return Permission{Resource: r.Resource, Scope: operation}, nil
+}
+
+// graphQLDocument returns the GraphQL document and operation name from the query string (GET) or the
+// JSON body (POST). The body is restored so the upstream receives it unchanged.
+func graphQLDocument(req *http.Request) (string, string, error) {
+	if req.Method == http.MethodGet {
+		query := req.URL.Query()
+		return query.Get("query"), query.Get("operationName"), nil
+	}
+	if req.Body == nil {
+		return "", "", errors.New("missing GraphQL request body")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxGraphQLBodyBytes+1))
+	req.Body.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("reading GraphQL body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) > maxGraphQLBodyBytes {
+		return "", "", errors.New("GraphQL request body too large")
+	}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/graphql") {
+		return string(body), req.URL.Query().Get("operationName"), nil
+	}
+	var payload struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", fmt.Errorf("invalid GraphQL request body: %w", err)
+	}
+	return payload.Query, payload.OperationName, nil
+}
+
+// graphQLOperation is an operation defined in a GraphQL document
+type graphQLOperation struct {
+	kind string // query, mutation or subscription
+	name string // empty for anonymous operations
+}
+
+// graphQLOperationType returns the type of the operation a GraphQL server executes for the document:
+// the one named operationName, or the only operation when it is empty. Fragments and descriptions are
+// skipped. Documents whose operation is missing, ambiguous or not executable are rejected, so the scope
+// is never taken from another operation than the one executed.
+func graphQLOperationType(document, operationName string) (string, error) {
+	operations, err := graphQLOperations(document)
+	if err != nil {
+		return "", err
+	}
+	if operationName == "" {
+		if len(operations) != 1 {
+			return "", fmt.Errorf("GraphQL document defines %d operations, operationName is required", len(operations))
+		}
+		return operations[0].kind, nil
+	}
+	kind := ""
+	for _, operation := range operations {
+		if operation.name != operationName {
+			continue
+		}
+		if kind != "" {
+			return "", fmt.Errorf("GraphQL operation %q is defined more than once", operationName)
+		}
+		kind = operation.kind
+	}
+	if kind == "" {
+		return "", fmt.Errorf("unknown GraphQL operation %q", operationName)
+	}
+	return kind, nil
+}
+
+// graphQLOperations lists the operations of a GraphQL document. The shorthand form "{ ... }" is an
+// anonymous query; fragments are skipped and any other definition (e.g. type system definitions)
+// is rejected.
+func graphQLOperations(document string) ([]graphQLOperation, error) {
+	tokens, err := graphQLTokens(document)
+	if err != nil {
+		return nil, err
+	}
+	var operations []graphQLOperation
+	for i := 0; i < len(tokens); {
+		keyword := tokens[i]
+		switch keyword {
+		case "{":
+			operations = append(operations, graphQLOperation{kind: "query"})
+		case "query", "mutation", "subscription":
+			operation := graphQLOperation{kind: keyword}
+			if i+1 < len(tokens) && isGraphQLName(tokens[i+1]) {
+				operation.name = tokens[i+1]
+			}
+			operations = append(operations, operation)
+		case "fragment":
+		default:
+			return nil, fmt.Errorf("unsupported GraphQL definition %q", keyword)
+		}
+		next, err := graphQLDefinitionEnd(tokens, i)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+	}
+	return operations, nil
+}
+
+// graphQLDefinitionEnd returns the index of the token following the definition starting at start: the
+// definition ends with the selection set closing the first "{" outside arguments and default values
+func graphQLDefinitionEnd(tokens []string, start int) (int, error) {
+	depth := 0
+	selection := false
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "{":
+			if depth == 0 {
+				selection = true
+			}
+			depth++
+		case "(", "[":
+			depth++
+		case "}", ")", "]":
+			depth--
+			if depth < 0 {
+				return 0, errors.New("unbalanced GraphQL document")
+			}
+			if depth == 0 && selection && tokens[i] == "}" {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("incomplete GraphQL definition")
+}
+
+// graphQLTokens splits a GraphQL document into names and punctuators, dropping comments, commas,
+// string values (including block strings and descriptions) and other literals
+func graphQLTokens(document string) ([]string, error) {
+	var tokens []string
+	document = strings.TrimPrefix(document, "\ufeff")
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case strings.HasPrefix(document[i:], `"""`):
+			end := strings.Index(strings.Replace(document[i+3:], `\"""`, "xxxx", -1), `"""`)
+			if end < 0 {
+				return nil, errors.New("unterminated GraphQL block string")
+			}
+			i += 3 + end + 3
+		case c == '"':
+			i++
+			for i < len(document) && document[i] != '"' {
+				if document[i] == '\\' {
+					i++
+				}
+				if i < len(document) && (document[i] == '\n' || document[i] == '\r') {
+					return nil, errors.New("unterminated GraphQL string")
+				}
+				i++
+			}
+			if i >= len(document) {
+				return nil, errors.New("unterminated GraphQL string")
+			}
+			i++
+		case strings.IndexByte("{}()[]@", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(document) && isGraphQLNameByte(document[j]) {
+				j++
+			}
+			tokens = append(tokens, document[i:j])
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			// numbers, including exponents such as 1e5
+			i++
+			for i < len(document) && (isGraphQLNameByte(document[i]) || strings.IndexByte(".+-", document[i]) >= 0) {
+				i++
+			}
+		default:
+			// "$", ":", "!", "=", "|", "&" and "..." do not affect the structure
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// isGraphQLName reports whether a token is a name rather than a punctuator
+func isGraphQLName(token string) bool {
+	return token != "" && (token[0] == '_' || token[0] >= 'a' && token[0] <= 'z' || token[0] >= 'A' && token[0] <= 'Z')
+}
+
+// isGraphQLNameByte reports whether c may continue a GraphQL name
+func isGraphQLNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// GRPCResolver maps a gRPC path "/package.Service/Method" to resource "package.Service" and scope "Method"
+type GRPCResolver struct{}
+
+// Resolve implements PermissionResolver
+func (r GRPCResolver) Resolve(req *http.Request) (Permission, error) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 3)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Permission{}, fmt.Errorf("invalid gRPC path %q", req.URL.Path)
+	}
+	return Permission{Resource: parts[0], Scope: parts[1]}, nil
+}
+
+// MethodClassResolver overrides the scope resolved by Base depending on whether the method is
+// safe (GET, HEAD, OPTIONS) or mutating. An empty scope for a class keeps Base's scope.
+type MethodClassResolver struct {
+	Base          PermissionResolver
+	SafeScope     string
+	MutatingScope string
+}
+
+// Resolve implements PermissionResolver
+func (r MethodClassResolver) Resolve(req *http.Request) (Permission, error) {
+	permission, err := r.Base.Resolve(req)
+	if err != nil {
+		return permission, err
+	}
+	if isSafeMethod(req.Method) {
+		if r.SafeScope != "" {
+			permission.Scope = r.SafeScope
+		}
+	} else if r.MutatingScope != "" {
+		permission.Scope = r.MutatingScope
+	}
+	return permission, nil
+}
+
+// AcceptScopeResolver overrides the scope resolved by Base depending on the representations the
+// client accepts, e.g. text/csv -> export, for APIs whose sensitivity depends on the response format.
+// Every media type of the Accept header with a non-zero quality found in MediaScopes contributes its
+// scope; several scopes are all required (comma-joined). As the upstream may answer a range such as
+// */* or text/* with any type it covers, a range contributes the scopes of every MediaScopes entry
+// within it that the client does not refuse with q=0. A missing Accept header, one accepting nothing
+// and unparseable entries count as */*.
+type AcceptScopeResolver struct {
+	Base        PermissionResolver
+	MediaScopes map[string]string // lower-case media type -> scope
+}
+
+// Resolve implements PermissionResolver
+func (r AcceptScopeResolver) Resolve(req *http.Request) (Permission, error) {
+	permission, err := r.Base.Resolve(req)
+	if err != nil {
+		return permission, err
+	}
+	configured := make([]string, 0, len(r.MediaScopes))
+	for mediaType := range r.MediaScopes {
+		configured = append(configured, mediaType)
+	}
+	sort.Strings(configured)
+
+	accepted, refused := acceptedMediaTypes(req.Header.Values("Accept"))
+	if len(accepted) == 0 {
+		accepted = []string{"*/*"}
+	}
+	var scopes []string
+	seen := map[string]bool{}
+	add := func(mediaType string) {
+		if scope, ok := r.MediaScopes[mediaType]; ok && !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, mediaType := range accepted {
+		add(mediaType)
+		if !strings.HasSuffix(mediaType, "/*") {
+			continue
+		}
+		for _, candidate := range configured {
+			if mediaRangeCovers(mediaType, candidate) && !refused[candidate] {
+				add(candidate)
+			}
+		}
+	}
+	if len(scopes) > 0 {
+		permission.Scope = strings.Join(scopes, ",")
+	}
+	return permission, nil
+}
+
+// mediaRangeCovers reports whether a media range such as */* or text/* includes mediaType
+func mediaRangeCovers(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+}
+
+// acceptedMediaTypes returns the lower-case media types (or ranges) of Accept header values, in order,
+// and those refused with a zero quality. Entries that cannot be parsed count as */*, as the upstream
+// may read them as anything.
+func acceptedMediaTypes(values []string) ([]string, map[string]bool) {
+	var mediaTypes []string
+	refused := map[string]bool{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				mediaTypes = append(mediaTypes, "*/*")
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				quality, err := strconv.ParseFloat(q, 64)
+				if err != nil {
+					mediaTypes = append(mediaTypes, "*/*")
+					continue
+				}
+				if quality <= 0 {
+					refused[mediaType] = true
+					continue
+				}
+			}
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	return mediaTypes, refused
+}
@@ -0,0 +1,389 @@
+package authztraefikgateway
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// middlewareWithRules returns a bare middleware running the given rules
+func middlewareWithRules(rules []*compiledRule) *AuthMiddleware {
+	am := &AuthMiddleware{}
+	am.runtime.Store(&runtimeConfig{rules: rules})
+	return am
+}
+
+func TestResolvers(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver PermissionResolver
+		method   string
+		path     string
+		body     string
+		expected Permission
+	}{
+		{"static", StaticResolver{Permission: Permission{"system", "read"}}, http.MethodGet, "/health", "", Permission{"system", "read"}},
+		{"segments", SegmentResolver{ResourceIndex: 3, ScopeIndex: 4}, http.MethodGet, "/api/v1/user/get", "", Permission{"user", "get"}},
+		{"template", PathTemplateResolver{Template: "/api/{version}/{resource}/{scope}"}, http.MethodGet, "/api/v2/order/list/extra", "", Permission{"order", "list"}},
+		{"template with fixed scope", PathTemplateResolver{Template: "/files/*/{resource}", Scope: "download"}, http.MethodGet, "/files/tenant/report", "", Permission{"report", "download"}},
+		{"method", MethodResolver{Resource: "order", MethodScopes: map[string]string{"GET": "view"}}, http.MethodGet, "/orders/1", "", Permission{"order", "view"}},
+		{"method fallback", MethodResolver{Resource: "order"}, http.MethodDelete, "/orders/1", "", Permission{"order", "delete"}},
+		{"graphql query", GraphQLResolver{Resource: "graph"}, http.MethodPost, "/graphql", `{"query":"{ me { id } }"}`, Permission{"graph", "query"}},
+		{"graphql mutation", GraphQLResolver{Resource: "graph", OperationScopes: map[string]string{"mutation": "manage"}}, http.MethodPost, "/graphql", `{"query":"# comment\nmutation Add($x: Int) { add(x: $x) }"}`, Permission{"graph", "manage"}},
+		{"graphql get", GraphQLResolver{Resource: "graph"}, http.MethodGet, "/graphql?query=subscription%7Bx%7D", "", Permission{"graph", "subscription"}},
+		{"grpc", GRPCResolver{}, http.MethodPost, "/shop.v1.OrderService/CreateOrder", "", Permission{"shop.v1.OrderService", "CreateOrder"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "http://gateway"+test.path, strings.NewReader(test.body))
+			got, err := test.resolver.Resolve(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, got)
+			}
+			if test.body != "" {
+				if body, _ := io.ReadAll(req.Body); string(body) != test.body {
+					t.Errorf("body was not restored: %q", body)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipartResolver(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("projectId", "apollo")
+	file, _ := form.CreateFormFile("file", "large.bin")
+	_, _ = file.Write(bytes.Repeat([]byte("x"), 4096))
+	_ = form.Close()
+	upload := body.String()
+
+	resolver := MultipartResolver{Field: "projectId", Resource: "project:{field}", MaxBytes: 512}
+	req := httptest.NewRequest(http.MethodPost, "http://gateway/upload", strings.NewReader(upload))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	got, err := resolver.Resolve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (Permission{"project:apollo", "post"}); got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if forwarded, err := io.ReadAll(req.Body); err != nil || string(forwarded) != upload {
+		t.Errorf("body was not passed on unchanged (%d of %d bytes, %v)", len(forwarded), len(upload), err)
+	}
+
+	// A second field beyond MaxBytes fails the upstream's read of the body
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	_ = form.WriteField("projectId", "apollo")
+	file, _ = form.CreateFormFile("file", "large.bin")
+	_, _ = file.Write(bytes.Repeat([]byte("x"), 4096))
+	_ = form.WriteField("projectId", "gemini")
+	_ = form.Close()
+	req = httptest.NewRequest(http.MethodPost, "http://gateway/upload", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if _, err := resolver.Resolve(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := io.ReadAll(req.Body); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("expected the duplicate field after MaxBytes to fail the body, got %v", err)
+	}
+	_ = req.Body.Close()
+
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	file, _ = form.CreateFormFile("file", "large.bin")
+	_, _ = file.Write([]byte("data"))
+	_ = form.WriteField("projectId", "apollo")
+	_ = form.Close()
+	for name, contentType := range map[string]string{
+		"field after file": form.FormDataContentType(),
+		"not multipart":    "application/json",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://gateway/upload", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", contentType)
+		if _, err := resolver.Resolve(req); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Values that change the permission, and fields the upstream may read differently, are rejected
+	for name, values := range map[string][]string{
+		"path":      {"apollo/admin"},
+		"scope":     {"apollo#delete"},
+		"list":      {"apollo,gemini"},
+		"duplicate": {"apollo", "gemini"},
+	} {
+		body.Reset()
+		form = multipart.NewWriter(&body)
+		for _, value := range values {
+			_ = form.WriteField("projectId", value)
+		}
+		_ = form.Close()
+		req := httptest.NewRequest(http.MethodPost, "http://gateway/upload", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if got, err := resolver.Resolve(req); err == nil {
+			t.Errorf("%s: expected error, got %+v", name, got)
+		}
+	}
+}
+
+func TestGraphQLOperationSelection(t *testing.T) {
+	resolver := GraphQLResolver{Resource: "graph"}
+	tests := []struct {
+		name     string
+		body     string
+		expected string // empty when the request must be rejected
+	}{
+		{"named among several", `{"query":"query Read { me { id } } mutation Drop { drop }","operationName":"Drop"}`, "mutation"},
+		{"after a fragment", `{"query":"fragment F on User { id } mutation { drop { ...F } }"}`, "mutation"},
+		{"after a description", `{"query":"\"\"\"\nquery\n\"\"\"\nmutation { drop }"}`, "mutation"},
+		{"after a string description", `{"query":"\"query\" mutation { drop }"}`, "mutation"},
+		{"object default value", `{"query":"query Q($f: In = {a: {b: 1}}) { me } mutation M { drop }","operationName":"Q"}`, "query"},
+		{"directive on anonymous operation", `{"query":"mutation @live { drop }","operationName":""}`, "mutation"},
+		{"several without operationName", `{"query":"query Read { me } mutation Drop { drop }"}`, ""},
+		{"unknown operationName", `{"query":"query Read { me }","operationName":"Drop"}`, ""},
+		{"duplicated operationName", `{"query":"query Drop { me } mutation Drop { drop }","operationName":"Drop"}`, ""},
+		{"only fragments", `{"query":"fragment F on User { id }"}`, ""},
+		{"type system definition", `{"query":"type Query { me: User } mutation { drop }"}`, ""},
+		{"unterminated", `{"query":"mutation { drop"}`, ""},
+		{"unterminated block string", `{"query":"\"\"\" query { me }"}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://gateway/graphql", strings.NewReader(test.body))
+			got, err := resolver.Resolve(req)
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Scope != test.expected {
+				t.Errorf("expected scope %q, got %q", test.expected, got.Scope)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/graphql?query=query+A+%7Bme%7D+mutation+B+%7Bdrop%7D&operationName=B", nil)
+	if got, err := resolver.Resolve(req); err != nil || got.Scope != "mutation" {
+		t.Errorf("expected the mutation named in the query string, got %+v, %v", got, err)
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver PermissionResolver
+		path     string
+	}{
+		{"segments too short", SegmentResolver{ResourceIndex: 3, ScopeIndex: 4}, "/api/v1"},
+		{"template mismatch", PathTemplateResolver{Template: "/api/{resource}"}, "/other/user"},
+		{"grpc invalid", GRPCResolver{}, "/not/a/grpc/path"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+			if _, err := test.resolver.Resolve(req); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRuleSelection(t *testing.T) {
+	config := &Config{
+		StaticPermissions: []StaticPermission{{Prefix: "/health", Resource: "system", Scope: "read"}},
+		Rules: []Rule{
+			{Name: "orders-write", Prefix: "/orders", Methods: []string{"post", "put"}, Resolver: "static", Resource: "order", Scope: "manage"},
+			{Prefix: "/orders", Resolver: "method", Resource: "order", MethodScopes: map[string]string{"get": "view"}},
+			{Prefix: "/grpc/", Resolver: "segments", ResourceIndex: 2, ScopeIndex: 3},
+		},
+	}
+	rules, err := compileRules(config, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := middlewareWithRules(rules)
+
+	tests := []struct {
+		method   string
+		path     string
+		expected Permission
+		rule     string
+	}{
+		{http.MethodGet, "/health/live", Permission{"system", "read"}, "static:/health"},
+		{http.MethodPost, "/orders/1", Permission{"order", "manage"}, "orders-write"},
+		{http.MethodGet, "/orders/1", Permission{"order", "view"}, "/orders"},
+		{http.MethodGet, "/grpc/svc/call", Permission{"svc", "call"}, "/grpc/"},
+		{http.MethodGet, "/api/v1/user/get", Permission{"user", "get"}, "default"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://gateway"+test.path, nil)
+		got, rule, err := am.resolvePermission(req)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", test.method, test.path, err)
+			continue
+		}
+		if got != test.expected || rule.name != test.rule {
+			t.Errorf("%s %s: expected %+v via %q, got %+v via %q", test.method, test.path, test.expected, test.rule, got, rule.name)
+		}
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	for _, rule := range []Rule{
+		{Prefix: "/a", Resolver: "static"},
+		{Prefix: "/a", Resolver: "template"},
+		{Prefix: "/a", Resolver: "template", Template: "/a/{scope}"},
+		{Prefix: "/a", Resolver: "method"},
+		{Prefix: "/a", Resolver: "graphql"},
+		{Prefix: "/a", Resolver: "multipart", Resource: "project:{field}"},
+		{Prefix: "/a", KeycloakURL: "keycloak/token"},
+		{Prefix: "/a", Resolver: "magic"},
+	} {
+		if _, err := compileRule(rule, 3, 4); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+}
+
+func TestMethodClassification(t *testing.T) {
+	config := &Config{
+		Rules: []Rule{
+			{Prefix: "/admin", Methods: []string{"mutating"}, Resolver: "static", Resource: "admin", Scope: "write"},
+			{Prefix: "/docs", Resolver: "static", Resource: "docs", SafeScope: "view", MutatingScope: "manage"},
+		},
+	}
+	rules, err := compileRules(config, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := middlewareWithRules(rules)
+
+	tests := []struct {
+		method   string
+		path     string
+		expected Permission
+	}{
+		{http.MethodGet, "/docs/a", Permission{"docs", "view"}},
+		{http.MethodHead, "/docs/a", Permission{"docs", "view"}},
+		{http.MethodPatch, "/docs/a", Permission{"docs", "manage"}},
+		{http.MethodDelete, "/admin/x/y/z", Permission{"admin", "write"}},
+		{http.MethodGet, "/admin/x/y/z", Permission{"y", "z"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://gateway"+test.path, nil)
+		got, _, err := am.resolvePermission(req)
+		if err != nil || got != test.expected {
+			t.Errorf("%s %s: expected %+v, got %+v (%v)", test.method, test.path, test.expected, got, err)
+		}
+	}
+}
+
+func TestAcceptScopes(t *testing.T) {
+	config := &Config{
+		Rules: []Rule{
+			{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "view", AcceptScopes: map[string]string{
+				"Text/CSV":        "export",
+				"application/pdf": "export",
+				"application/zip": "archive",
+			}},
+		},
+	}
+	rules, err := compileRules(config, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := middlewareWithRules(rules)
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", "export,archive"},
+		{"application/json", "view"},
+		{"*/*", "export,archive"},
+		{"application/json, */*;q=0.1", "export,archive"},
+		{"text/*", "export"},
+		{"*/*, text/csv;q=0, application/pdf;q=0", "archive"},
+		{"text/csv;q=0", "export,archive"},
+		{"not a media type", "export,archive"},
+		{"text/csv", "export"},
+		{"application/json, text/csv;q=0.5", "export"},
+		{"text/csv;q=0, application/json", "view"},
+		{"text/csv, application/pdf", "export"},
+		{"application/zip, text/csv", "archive,export"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/reports/monthly", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		got, _, err := am.resolvePermission(req)
+		if err != nil || got != (Permission{"report", test.expected}) {
+			t.Errorf("Accept %q: expected scope %q, got %+v (%v)", test.accept, test.expected, got, err)
+		}
+	}
+
+	if _, err := compileRule(Rule{Prefix: "/x", AcceptScopes: map[string]string{"text/csv": ""}}, 3, 4); err == nil {
+		t.Error("expected an error for an empty accept scope")
+	}
+	static := &Config{
+		AuthzBackend: authzBackendStatic,
+		Rules:        []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", AcceptScopes: map[string]string{"text/csv": "export"}}},
+	}
+	if errs := static.validate(); len(errs) == 0 {
+		t.Error("expected acceptScopes rules to be rejected with the static backend")
+	}
+}
+
+func TestHeadAndOptionsDowngrade(t *testing.T) {
+	config := &Config{
+		Rules: []Rule{
+			{Prefix: "/orders", Methods: []string{"get"}, Resolver: "method", Resource: "order", MethodScopes: map[string]string{"GET": "view"}},
+		},
+	}
+	rules, err := compileRules(config, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := middlewareWithRules(rules)
+	am.headAsGet, am.optionsScope = true, "discover"
+
+	tests := []struct {
+		method    string
+		path      string
+		preflight bool
+		expected  Permission
+	}{
+		{http.MethodHead, "/orders/1", false, Permission{"order", "view"}},
+		{http.MethodOptions, "/orders/1", false, Permission{"order", "discover"}},
+		{http.MethodOptions, "/api/v1/user/get", false, Permission{"user", "discover"}},
+		{http.MethodOptions, "/api/v1/user/get", true, Permission{"user", "get"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://gateway"+test.path, nil)
+		if test.preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		got, _, err := am.resolvePermission(req)
+		if err != nil || got != test.expected {
+			t.Errorf("%s %s: expected %+v, got %+v (%v)", test.method, test.path, test.expected, got, err)
+		}
+		if req.Method != test.method {
+			t.Errorf("the request method must not be changed, got %s", req.Method)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultResourceCacheTTL is how long Protection API resource metadata is reused when resourceCacheTTL is not set
+const defaultResourceCacheTTL = 5 * time.Minute
+
+// defaultResourceCacheMaxEntries bounds the cached Protection API resource lookups
+const defaultResourceCacheMaxEntries = 10000
+
+// resolverURI resolves the resource registered in Keycloak for the request path
+const resolverURI = "uri"
+
+// resourceSet is the metadata of a resource registered in Keycloak
+type resourceSet struct {
+	ID     string   `json:"_id"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	URIs   []string `json:"uris"`
+	Scopes []string `json:"-"`
+}
+
+// resourceLookup finds the resource registered for a URI, optionally of a given type. It is bound to
+// the middleware once built.
+type resourceLookup struct {
+	find func(ctx context.Context, uri, resourceType string) (resourceSet, bool, error)
+}
+
+// uriResolver resolves the Keycloak resource by request path through the Protection API; the scope
+// comes from the method, like MethodResolver. With a resourceType, only instances of that type match,
+// so dynamically created resources are authorized by their type policies.
+type uriResolver struct {
+	lookup       *resourceLookup
+	resourceType string
+	methodScopes map[string]string
+}
+
+func (r uriResolver) Resolve(req *http.Request) (Permission, error) {
+	rs, found, err := r.lookup.find(req.Context(), req.URL.Path, r.resourceType)
+	if err != nil {
+		return Permission{}, fmt.Errorf("resource lookup failed: %w", err)
+	}
+	if !found && r.resourceType != "" {
+		return Permission{}, fmt.Errorf("no resource of type %q registered for %q", r.resourceType, req.URL.Path)
+	}
+	if !found {
+		return Permission{}, fmt.Errorf("no resource registered for %q", req.URL.Path)
+	}
+	return MethodResolver{Resource: rs.ID, MethodScopes: r.methodScopes}.Resolve(req)
+}
+
+// resourceSetEntry is a cached lookup result, including "not found"
+type resourceSetEntry struct {
+	resource  resourceSet
+	found     bool
+	expiresAt time.Time
+}
+
+// resourceSetCall is an in-flight lookup shared by concurrent requests for the same URI
+type resourceSetCall struct {
+	done  chan struct{}
+	entry resourceSetEntry
+	err   error
+}
+
+// resourceSetCache caches resource metadata per type and URI and coalesces concurrent lookups. As the
+// URIs come from unauthenticated requests, it holds at most maxEntries, preferring registered ones.
+type resourceSetCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu        sync.Mutex
+	entries   map[string]resourceSetEntry
+	inflight  map[string]*resourceSetCall
+	nextSweep time.Time
+}
+
+func newResourceSetCache(ttl time.Duration) *resourceSetCache {
+	return &resourceSetCache{
+		ttl:        ttl,
+		maxEntries: defaultResourceCacheMaxEntries,
+		entries:    make(map[string]resourceSetEntry),
+		inflight:   make(map[string]*resourceSetCall),
+	}
+}
+
+// get returns the cached metadata for key or calls fetch once for all concurrent callers. The fetch
+// outlives the caller that started it; each caller stops waiting when its own ctx is done.
+func (rc *resourceSetCache) get(ctx context.Context, key string, fetch func() (resourceSet, bool, error)) (resourceSet, bool, error) {
+	rc.mu.Lock()
+	if entry, ok := rc.entries[key]; ok {
+		if time.Now().Before(entry.expiresAt) {
+			rc.mu.Unlock()
+			return entry.resource, entry.found, nil
+		}
+		delete(rc.entries, key)
+	}
+	call, ok := rc.inflight[key]
+	if !ok {
+		call = &resourceSetCall{done: make(chan struct{})}
+		rc.inflight[key] = call
+		go rc.fetch(key, call, fetch)
+	}
+	rc.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.entry.resource, call.entry.found, call.err
+	case <-ctx.Done():
+		return resourceSet{}, false, ctx.Err()
+	}
+}
+
+// fetch runs a coalesced lookup and caches its result
+func (rc *resourceSetCache) fetch(key string, call *resourceSetCall, fetch func() (resourceSet, bool, error)) {
+	call.entry.resource, call.entry.found, call.err = fetch()
+
+	rc.mu.Lock()
+	delete(rc.inflight, key)
+	if call.err == nil && rc.ttl > 0 {
+		call.entry.expiresAt = time.Now().Add(rc.ttl)
+		rc.storeLocked(key, call.entry)
+	}
+	rc.mu.Unlock()
+	close(call.done)
+}
+
+// storeLocked caches entry, sweeping expired entries once per TTL and making room when the cache is
+// full. rc.mu must be held.
+func (rc *resourceSetCache) storeLocked(key string, entry resourceSetEntry) {
+	now := time.Now()
+	if !now.Before(rc.nextSweep) {
+		rc.nextSweep = now.Add(rc.ttl)
+		for k, e := range rc.entries {
+			if !now.Before(e.expiresAt) {
+				delete(rc.entries, k)
+			}
+		}
+	}
+	if _, exists := rc.entries[key]; !exists && len(rc.entries) >= rc.maxEntries {
+		rc.evictLocked(now)
+	}
+	rc.entries[key] = entry
+}
+
+// evictLocked makes room for a new entry: expired entries first, then unregistered URIs, otherwise
+// arbitrary ones. rc.mu must be held.
+func (rc *resourceSetCache) evictLocked(now time.Time) {
+	for key, entry := range rc.entries {
+		if !now.Before(entry.expiresAt) || !entry.found {
+			delete(rc.entries, key)
+		}
+	}
+	for key := range rc.entries {
+		if len(rc.entries) < rc.maxEntries {
+			return
+		}
+		delete(rc.entries, key)
+	}
+}
+
+// invalidate drops every cached entry and returns how many were removed
+func (rc *resourceSetCache) invalidate() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	n := len(rc.entries)
+	rc.entries = make(map[string]resourceSetEntry)
+	return n
+}
+
+// fetchResourceSet asks the Keycloak Protection API for the resource registered for uri, of
+// resourceType if set
+func (am *AuthMiddleware) fetchResourceSet(ctx context.Context, uri, resourceType string) (resourceSet, bool, error) {
+	pat, err := am.serviceTokens.Token(ctx)
+	if err != nil {
+		return resourceSet{}, false, err
+	}
+
+	query := url.Values{}
+	query.Set("uri", uri)
+	if resourceType != "" {
+		query.Set("type", resourceType)
+	}
+	query.Set("matchingUri", "true")
+	query.Set("deep", "true")
+	query.Set("max", "1")
+	lookupReq, err := http.NewRequestWithContext(ctx, http.MethodGet, am.realmURL(ctx)+"/authz/protection/resource_set?"+query.Encode(), nil)
+	if err != nil {
+		return resourceSet{}, false, fmt.Errorf("creating resource lookup request: %w", err)
+	}
+	lookupReq.Header.Set("Authorization", "Bearer "+pat)
+
+	resp, err := am.client.Do(lookupReq)
+	if err != nil {
+		return resourceSet{}, false, fmt.Errorf("looking up resource: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := readLimited(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return resourceSet{}, false, fmt.Errorf("resource lookup failed with status %d: %s", resp.StatusCode, parseKeycloakError(body).Error)
+	}
+
+	var resources []struct {
+		resourceSet
+		ResourceScopes []struct {
+			Name string `json:"name"`
+		} `json:"resource_scopes"`
+	}
+	if err := json.Unmarshal(body, &resources); err != nil {
+		return resourceSet{}, false, fmt.Errorf("parsing resource lookup response: %w", err)
+	}
+	if len(resources) == 0 {
+		return resourceSet{}, false, nil
+	}
+	rs := resources[0].resourceSet
+	for _, scope := range resources[0].ResourceScopes {
+		rs.Scopes = append(rs.Scopes, scope.Name)
+	}
+	return rs, true, nil
+}
+
+// lookupResource returns the (possibly cached) resource registered for uri, of resourceType if set
+func (am *AuthMiddleware) lookupResource(ctx context.Context, uri, resourceType string) (resourceSet, bool, error) {
+	return am.resources.get(ctx, resourceType+"\x00"+uri, func() (resourceSet, bool, error) {
+		am.log(logDebug, "🔄 [PROTECTION] Looking up resource for", uri, resourceType)
+		fetchCtx, cancel := am.detachedContext(ctx)
+		defer cancel()
+		return am.fetchResourceSet(fetchCtx, uri, resourceType)
+	})
+}
+
+// InvalidateResources drops the cached Protection API resource metadata, e.g. after resources were
+// registered or changed in Keycloak. It returns the number of entries removed.
+func (am *AuthMiddleware) InvalidateResources() int {
+	if am.resources == nil {
+		return 0
+	}
+	n := am.resources.invalidate()
+	am.logf(logDebug, "💾 [PROTECTION] Invalidated %d cached resources\n", n)
+	return n
+}
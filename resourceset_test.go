@@ -0,0 +1,190 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestURIResolverCachesResourceMetadata(t *testing.T) {
+	var lookups int32
+	var permission string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/authz/protection/resource_set"):
+			atomic.AddInt32(&lookups, 1)
+			if req.Header.Get("Authorization") != "Bearer pat" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Query().Get("uri") != "/orders/42" {
+				_, _ = rw.Write([]byte(`[]`))
+				return
+			}
+			_, _ = rw.Write([]byte(`[{"_id":"res-1","name":"orders","uris":["/orders/*"],"resource_scopes":[{"name":"view"}]}]`))
+		default:
+			_ = req.ParseForm()
+			if req.PostForm.Get("grant_type") == "client_credentials" {
+				_, _ = rw.Write([]byte(`{"access_token":"pat","expires_in":300}`))
+				return
+			}
+			permission = req.PostForm.Get("permission")
+			_, _ = rw.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:          srv.URL + "/realms/demo" + tokenEndpointSuffix,
+		KeycloakClientSecret: "secret",
+		OmitLeadingSlash:     true,
+		Rules:                []Rule{{Prefix: "/orders", Resolver: "uri", MethodScopes: map[string]string{"get": "view"}}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	request := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := request("/orders/42"); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+	}
+	if permission != "res-1#view" {
+		t.Errorf("expected permission on the resource ID, got %q", permission)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("expected 1 Protection API lookup, got %d", n)
+	}
+
+	// Unregistered URIs are cached too, and rejected without a Keycloak evaluation
+	request("/orders/unknown")
+	if code := request("/orders/unknown"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unregistered URI, got %d", code)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("expected 2 Protection API lookups, got %d", n)
+	}
+
+	if n := am.InvalidateResources(); n != 2 {
+		t.Errorf("expected 2 invalidated entries, got %d", n)
+	}
+	request("/orders/42")
+	if n := atomic.LoadInt32(&lookups); n != 3 {
+		t.Errorf("expected a new lookup after invalidation, got %d lookups", n)
+	}
+}
+
+func TestURIResolverRequiresSecret(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{Rules: []Rule{{Prefix: "/orders", Resolver: "uri"}}}
+	if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil {
+		t.Error("expected error when the uri resolver is used without keycloakClientSecret")
+	}
+}
+
+func TestResourceTypeRule(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/authz/protection/resource_set"):
+			query = req.URL.RawQuery
+			if req.URL.Query().Get("type") != "urn:myapp:resources:order" {
+				_, _ = rw.Write([]byte(`[]`))
+				return
+			}
+			_, _ = rw.Write([]byte(`[{"_id":"order-42","name":"Order 42","type":"urn:myapp:resources:order","uris":["/orders/42"]}]`))
+		default:
+			_ = req.ParseForm()
+			if req.PostForm.Get("grant_type") == "client_credentials" {
+				_, _ = rw.Write([]byte(`{"access_token":"pat","expires_in":300}`))
+				return
+			}
+			if req.PostForm.Get("permission") != "/order-42#delete" {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = rw.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:          srv.URL + "/realms/demo" + tokenEndpointSuffix,
+		KeycloakClientSecret: "secret",
+		Rules:                []Rule{{Prefix: "/orders", ResourceType: "urn:myapp:resources:order"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "http://gateway/orders/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d (lookup query %q)", recorder.Code, query)
+	}
+
+	if _, err := compileRule(Rule{Prefix: "/orders", Resolver: "static", Resource: "order", ResourceType: "urn:x"}, 3, 4); err == nil {
+		t.Error("expected error for resourceType with a non-uri resolver")
+	}
+}
+
+func TestResourceSetCacheBounded(t *testing.T) {
+	rc := newResourceSetCache(time.Minute)
+	rc.maxEntries = 3
+	ctx := context.Background()
+	found := func() (resourceSet, bool, error) { return resourceSet{ID: "res"}, true, nil }
+	missing := func() (resourceSet, bool, error) { return resourceSet{}, false, nil }
+
+	_, _, _ = rc.get(ctx, "/registered", found)
+	for i := 0; i < 10; i++ {
+		_, _, _ = rc.get(ctx, fmt.Sprintf("/sprayed/%d", i), missing)
+	}
+	if n := len(rc.entries); n > rc.maxEntries {
+		t.Errorf("expected at most %d entries, got %d", rc.maxEntries, n)
+	}
+	// Unregistered URIs are evicted first, so sprayed paths do not push out registered ones
+	if _, ok := rc.entries["/registered"]; !ok {
+		t.Error("expected the registered resource to stay cached")
+	}
+}
+
+func TestResourceSetCacheDetachedFetch(t *testing.T) {
+	rc := newResourceSetCache(time.Minute)
+	release := make(chan struct{})
+	fetch := func() (resourceSet, bool, error) {
+		<-release
+		return resourceSet{ID: "res"}, true, nil
+	}
+
+	// The caller that started the lookup gives up, the lookup still completes for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := rc.get(ctx, "/orders/42", fetch); err != context.Canceled {
+		t.Errorf("expected the cancelled caller to stop waiting, got %v", err)
+	}
+	close(release)
+	rs, found, err := rc.get(context.Background(), "/orders/42", fetch)
+	if err != nil || !found || rs.ID != "res" {
+		t.Errorf("expected the shared lookup to succeed, got %+v %v %v", rs, found, err)
+	}
+}
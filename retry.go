@@ -0,0 +1,156 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Retry defaults
+const (
+	defaultRetryBackoff       = 50 * time.Millisecond
+	defaultRetryBudgetPercent = 10
+	defaultRetryBudgetWindow  = 10 * time.Second
+)
+
+// RetryConfig retries Keycloak calls that failed with a 5xx or a network error. Retries are limited by a
+// budget shared across requests, so retries during a Keycloak brownout cannot multiply the load.
+type RetryConfig struct {
+	MaxRetries    int    `json:"maxRetries,omitempty"`    // retries per evaluation (0 disables retries)
+	Backoff       string `json:"backoff,omitempty"`       // delay before each retry (default "50ms")
+	BudgetPercent int    `json:"budgetPercent,omitempty"` // share of evaluations that may retry within a window (default 10)
+	BudgetWindow  string `json:"budgetWindow,omitempty"`  // budget accounting window (default "10s")
+	MinRetries    int    `json:"minRetries,omitempty"`    // retries allowed per window regardless of traffic (default 0)
+}
+
+// retrier retries failed Keycloak evaluations within a shared retry budget
+type retrier struct {
+	maxRetries int
+	backoff    time.Duration
+	budget     *retryBudget
+}
+
+// retryBudget allows retries for at most percent of the requests seen in the current window
+type retryBudget struct {
+	percent    int
+	minRetries int
+	window     time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// newRetrier builds a retrier from config; it returns nil when retries are disabled
+func newRetrier(config RetryConfig) (*retrier, error) {
+	if config.MaxRetries < 0 || config.BudgetPercent < 0 || config.MinRetries < 0 {
+		return nil, fmt.Errorf("retry.maxRetries, retry.budgetPercent and retry.minRetries must not be negative")
+	}
+	if config.BudgetPercent > 100 {
+		return nil, fmt.Errorf("retry.budgetPercent must not exceed 100")
+	}
+	backoff, err := parseDurationOrDefault(config.Backoff, defaultRetryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("retry.backoff: %w", err)
+	}
+	window, err := parseDurationOrDefault(config.BudgetWindow, defaultRetryBudgetWindow)
+	if err != nil {
+		return nil, fmt.Errorf("retry.budgetWindow: %w", err)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("retry.budgetWindow must be positive")
+	}
+	if config.MaxRetries == 0 {
+		return nil, nil
+	}
+
+	percent := config.BudgetPercent
+	if percent == 0 {
+		percent = defaultRetryBudgetPercent
+	}
+	return &retrier{
+		maxRetries: config.MaxRetries,
+		backoff:    backoff,
+		budget:     &retryBudget{percent: percent, minRetries: config.MinRetries, window: window, now: time.Now},
+	}, nil
+}
+
+// rollLocked starts a new accounting window once the current one has elapsed
+func (b *retryBudget) rollLocked() {
+	now := b.now()
+	if now.Sub(b.start) >= b.window {
+		b.start = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// request records an evaluation
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	b.requests++
+}
+
+// withdraw takes one retry from the budget, if any is left
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	if b.retries >= b.minRetries+b.requests*b.percent/100 {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// retryable reports whether an evaluation failed in a way worth retrying: a 5xx or a network error.
+// Timeouts and cancellations are not retried, the caller's deadline is already spent.
+func retryable(result *keycloakResult, err error) bool {
+	if err != nil {
+		return failureMode(err) == failureNetwork
+	}
+	return result.status >= http.StatusInternalServerError
+}
+
+// evaluateRetrying is evaluate with retries, when enabled
+func (am *AuthMiddleware) evaluateRetrying(ctx context.Context, accessToken, permission, audience, endpoint string, claims map[string][]string) (*keycloakResult, error) {
+	return am.retrying(ctx, permission, func() (*keycloakResult, error) {
+		return am.evaluate(ctx, accessToken, permission, audience, endpoint, claims)
+	})
+}
+
+// retrying calls evaluate with retries, when enabled; permission labels the call in logs and events
+func (am *AuthMiddleware) retrying(ctx context.Context, permission string, evaluate func() (*keycloakResult, error)) (*keycloakResult, error) {
+	if am.retrier == nil {
+		return evaluate()
+	}
+	am.retrier.budget.request()
+	for attempt := 0; ; attempt++ {
+		result, err := evaluate()
+		if !retryable(result, err) || attempt == am.retrier.maxRetries {
+			return result, err
+		}
+		if !am.retrier.budget.withdraw() {
+			am.log(logWarn, "⚠️  [RETRY] Retry budget exhausted, not retrying", permission)
+			if am.diagnostics != nil {
+				am.diagnostics.recordEvent(eventRetryBudgetExhausted, permission)
+			}
+			return result, err
+		}
+		am.logf(logWarn, "⚠️  [RETRY] Retrying Keycloak call for %s (retry %d)\n", permission, attempt+1)
+		if am.diagnostics != nil {
+			am.diagnostics.recordEvent(eventRetry, fmt.Sprintf("%s (retry %d)", permission, attempt+1))
+		}
+		select {
+		case <-time.After(am.retrier.backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
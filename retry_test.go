@@ -0,0 +1,97 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyKeycloakStub answers the first failures calls with 503, then grants every request
+func newFlakyKeycloakStub(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"rpt"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetryRecoversFrom5xx(t *testing.T) {
+	srv, calls := newFlakyKeycloakStub(t, 1)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, Retry: RetryConfig{MaxRetries: 2, Backoff: "1ms", MinRetries: 1}}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || atomic.LoadInt32(calls) != 2 {
+		t.Errorf("expected 200 after one retry, got %d after %d calls", recorder.Code, atomic.LoadInt32(calls))
+	}
+}
+
+func TestRetryBudgetLimitsBrownoutLoad(t *testing.T) {
+	srv, calls := newFlakyKeycloakStub(t, 1000)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, Retry: RetryConfig{MaxRetries: 3, Backoff: "1ms", BudgetPercent: 10}}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// 20 evaluations at 10% allow 2 retries in total, not 3 per request
+	if got := atomic.LoadInt32(calls); got != 22 {
+		t.Errorf("expected 22 Keycloak calls, got %d", got)
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	budget := &retryBudget{percent: 50, window: time.Second, now: func() time.Time { return now }}
+
+	budget.request()
+	budget.request()
+	if !budget.withdraw() || budget.withdraw() {
+		t.Fatal("expected exactly one retry for two requests at 50%")
+	}
+
+	now = now.Add(time.Second)
+	if budget.withdraw() {
+		t.Error("expected the budget to reset with the window")
+	}
+	budget.request()
+	budget.request()
+	if !budget.withdraw() {
+		t.Error("expected a retry in the new window")
+	}
+}
+
+func TestRetryNotOnClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden} {
+		if retryable(&keycloakResult{status: status}, nil) {
+			t.Errorf("status %d must not be retried", status)
+		}
+	}
+	if retryable(nil, context.DeadlineExceeded) || retryable(nil, context.Canceled) {
+		t.Error("timeouts and cancellations must not be retried")
+	}
+}
@@ -0,0 +1,118 @@
+package authztraefikgateway
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ruleIndex finds the first rule matching a request without testing every rule. Rules are stored in a
+// radix tree under the literal path prefix all their matches start with: the rule prefix, or the
+// leading literal segments of a policy-enforcer pattern. A lookup walks the tree along the request path
+// and only tests the rules stored on the way, so its cost depends on the path, not on the rule count.
+type ruleIndex struct {
+	rules []*compiledRule
+	root  *radixNode
+}
+
+// radixNode is a node of the rule tree; the path leading to it is the concatenation of the labels
+type radixNode struct {
+	label    string
+	children map[byte]*radixNode
+	rules    []int // indexes of the rules stored here, in configuration order
+}
+
+// newRuleIndex builds the index of rules
+func newRuleIndex(rules []*compiledRule) *ruleIndex {
+	ri := &ruleIndex{rules: rules, root: &radixNode{}}
+	for i, rule := range rules {
+		ri.root.insert(rule.literalPrefix(), i)
+	}
+	return ri
+}
+
+// literalPrefix returns a prefix of every path the rule matches
+func (cr *compiledRule) literalPrefix() string {
+	if cr.pattern == nil {
+		return cr.prefix
+	}
+	if cr.pattern.suffix != "" {
+		return ""
+	}
+	var literal []string
+	for _, segment := range cr.pattern.segments {
+		if strings.HasPrefix(segment, "{") {
+			break
+		}
+		literal = append(literal, segment)
+	}
+	if len(literal) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(literal, "/")
+}
+
+// insert stores rule index under key, splitting edges as needed
+func (n *radixNode) insert(key string, index int) {
+	for key != "" {
+		child := n.children[key[0]]
+		if child == nil {
+			if n.children == nil {
+				n.children = map[byte]*radixNode{}
+			}
+			n.children[key[0]] = &radixNode{label: key, rules: []int{index}}
+			return
+		}
+		common := 0
+		for common < len(key) && common < len(child.label) && key[common] == child.label[common] {
+			common++
+		}
+		if common < len(child.label) {
+			split := &radixNode{label: child.label[:common], children: map[byte]*radixNode{child.label[common]: child}}
+			child.label = child.label[common:]
+			n.children[key[0]] = split
+			child = split
+		}
+		key, n = key[common:], child
+	}
+	n.rules = append(n.rules, index)
+}
+
+// first returns the first rule, in configuration order, matching the request
+func (ri *ruleIndex) first(req *http.Request) *compiledRule {
+	path := req.URL.Path
+	if !strings.HasPrefix(path, "/") {
+		// Patterns match relative paths too; not worth indexing
+		for _, rule := range ri.rules {
+			if rule.matches(req) {
+				return rule
+			}
+		}
+		return nil
+	}
+
+	best := len(ri.rules)
+	n := ri.root
+	for {
+		for _, i := range n.rules {
+			if i >= best {
+				break
+			}
+			if ri.rules[i].matches(req) {
+				best = i
+				break
+			}
+		}
+		if path == "" {
+			break
+		}
+		child := n.children[path[0]]
+		if child == nil || !strings.HasPrefix(path, child.label) {
+			break
+		}
+		path, n = path[len(child.label):], child
+	}
+	if best == len(ri.rules) {
+		return nil
+	}
+	return ri.rules[best]
+}
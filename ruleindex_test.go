@@ -0,0 +1,107 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// linearRuleFor is the reference the index must agree with
+func linearRuleFor(rules []*compiledRule, req *http.Request) *compiledRule {
+	for _, rule := range rules {
+		if rule.matches(req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func TestRuleIndex(t *testing.T) {
+	rules := []*compiledRule{
+		{name: "orders-write", prefix: "/orders", methods: map[string]bool{http.MethodPost: true}},
+		{name: "order-items", pattern: newPathPattern("/orders/{id}/items")},
+		{name: "orders", prefix: "/orders"},
+		{name: "ord", prefix: "/ord"},
+		{name: "apple", prefix: "/apple"},
+		{name: "api-safe", prefix: "/api", methods: map[string]bool{methodClassSafe: true}},
+		{name: "api-v1", prefix: "/api/v1/"},
+		{name: "users", pattern: newPathPattern("/api/v1/users/{id}")},
+		{name: "images", pattern: newPathPattern("/*.png")},
+		{name: "any-tenant", pattern: newPathPattern("/{tenant}/reports/*")},
+		{name: "default", prefix: ""},
+	}
+	index := newRuleIndex(rules)
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/orders/1"},
+		{http.MethodGet, "/orders/1/items"},
+		{http.MethodGet, "/orders"},
+		{http.MethodGet, "/ordinal"},
+		{http.MethodGet, "/apples"},
+		{http.MethodGet, "/ap"},
+		{http.MethodGet, "/api/v1/users/7"},
+		{http.MethodDelete, "/api/v1/users/7"},
+		{http.MethodDelete, "/api/v2/users"},
+		{http.MethodGet, "/logo.png"},
+		{http.MethodGet, "/orders/logo.png"},
+		{http.MethodGet, "/acme/reports/2024"},
+		{http.MethodGet, "/"},
+		{http.MethodGet, "/unknown"},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, "http://gateway"+r.path, nil)
+		expected, got := linearRuleFor(rules, req), index.first(req)
+		if got != expected {
+			t.Errorf("%s %s: expected rule %q, got %q", r.method, r.path, expected.name, got.name)
+		}
+	}
+
+	// Paths without a leading slash are matched too
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/", nil)
+	req.URL.Path = "acme/reports/1"
+	if got := index.first(req); got != rules[9] {
+		t.Errorf("relative path: expected rule %q, got %v", rules[9].name, got)
+	}
+}
+
+// largeRuleSet returns n prefix and pattern rules, and a request matching one of the last ones
+func largeRuleSet(n int) ([]*compiledRule, *http.Request) {
+	rules := make([]*compiledRule, 0, n+1)
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			rules = append(rules, &compiledRule{name: fmt.Sprint(i), prefix: fmt.Sprintf("/service-%d/", i)})
+		} else {
+			rules = append(rules, &compiledRule{name: fmt.Sprint(i), pattern: newPathPattern(fmt.Sprintf("/service-%d/items/{id}", i))})
+		}
+	}
+	rules = append(rules, &compiledRule{name: "default"})
+	return rules, httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://gateway/service-%d/items/42", n-1), nil)
+}
+
+func BenchmarkRuleFor(b *testing.B) {
+	for _, n := range []int{100, 5000} {
+		rules, req := largeRuleSet(n)
+		b.Run(fmt.Sprintf("index-%d", n), func(b *testing.B) {
+			index := newRuleIndex(rules)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if index.first(req) != rules[n-1] {
+					b.Fatal("unexpected rule")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("linear-%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if linearRuleFor(rules, req) != rules[n-1] {
+					b.Fatal("unexpected rule")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Resolver names usable in Rule.Resolver
+const (
+	resolverStatic    = "static"
+	resolverSegments  = "segments"
+	resolverTemplate  = "template"
+	resolverMethod    = "method"
+	resolverGraphQL   = "graphql"
+	resolverGRPC      = "grpc"
+	resolverMultipart = "multipart"
+	resolverPath      = "path"
+)
+
+// Rule selects a PermissionResolver for requests matching a path prefix and, optionally, methods
+type Rule struct {
+	Name          string            `json:"name,omitempty"`          // used in logs; defaults to the prefix
+	Prefix        string            `json:"prefix,omitempty"`        // e.g. "/graphql"
+	Methods       []string          `json:"methods,omitempty"`       // empty matches all methods
+	Resolver      string            `json:"resolver,omitempty"`      // static, segments, template, method, graphql, grpc, multipart, uri, path
+	Resource      string            `json:"resource,omitempty"`      // fixed resource (static, template, method, graphql); multipart: may use {field}
+	Scope         string            `json:"scope,omitempty"`         // fixed scope (static, template)
+	Template      string            `json:"template,omitempty"`      // e.g. "/api/{version}/{resource}/{scope}"
+	ResourceIndex int               `json:"resourceIndex,omitempty"` // segments resolver
+	ScopeIndex    int               `json:"scopeIndex,omitempty"`    // segments resolver
+	MethodScopes  map[string]string `json:"methodScopes,omitempty"`  // method resolver: GET -> view; graphql: mutation -> manage
+	SafeScope     string            `json:"safeScope,omitempty"`     // scope required for GET/HEAD/OPTIONS, e.g. "view"
+	MutatingScope string            `json:"mutatingScope,omitempty"` // scope required for every other method, e.g. "manage"
+	// ExchangeAudience and ExchangeScopes override tokenExchange.audience/scopes for this rule
+	ExchangeAudience string   `json:"exchangeAudience,omitempty"`
+	ExchangeScopes   []string `json:"exchangeScopes,omitempty"`
+	// ResourceType restricts the uri resolver to resources of this Keycloak type, e.g. "urn:myapp:resources:order"
+	ResourceType string `json:"resourceType,omitempty"`
+	// MaxTokenAge requires the user to have authenticated (auth_time, else iat) within this duration, e.g. "10m"
+	MaxTokenAge string `json:"maxTokenAge,omitempty"`
+	// FormField is the multipart/form-data field read by the multipart resolver, e.g. "projectId",
+	// within the first FormMaxBytes of the body (default 64 KiB)
+	FormField    string `json:"formField,omitempty"`
+	FormMaxBytes int64  `json:"formMaxBytes,omitempty"`
+	// KeycloakClientId and KeycloakURL override the audience and token endpoint of permission
+	// evaluations for this rule, for services whose permissions live in another Keycloak client
+	KeycloakClientId string `json:"keycloakClientId,omitempty"`
+	KeycloakURL      string `json:"keycloakURL,omitempty"`
+	// GrantedScopesHeader names a response header listing the granted "resource#scope" permissions, e.g.
+	// "X-Granted-Scopes", for same-origin frontends adapting their UI
+	GrantedScopesHeader string `json:"grantedScopesHeader,omitempty"`
+	// AcceptScopes overrides the scope by the media types of the Accept header, e.g. text/csv -> export
+	AcceptScopes map[string]string `json:"acceptScopes,omitempty"`
+	// FallbackScope grants the request when Keycloak does not know the resource but the token's "scope"
+	// claim contains this OAuth scope, e.g. "orders:read", while migrating from scope-based authorization
+	FallbackScope string `json:"fallbackScope,omitempty"`
+	// Conditions deny the request or require extra scopes depending on expressions over the request
+	// and token claims, evaluated in order once the permission is resolved
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+	// InheritDepth lets permissions on ancestors of a "/"-separated resource grant it, down to this many
+	// segments: with 2, "projects/acme/repos/api" is also granted by "projects/acme/repos" and "projects/acme"
+	InheritDepth int `json:"inheritDepth,omitempty"`
+	// Bulk authorizes every item listed in the JSON body, e.g. the IDs of POST /orders/bulk-delete
+	Bulk BulkConfig `json:"bulk,omitempty"`
+}
+
+// Method classes usable in Rule.Methods
+const (
+	methodClassSafe     = "SAFE"
+	methodClassMutating = "MUTATING"
+)
+
+// isSafeMethod reports whether the method is read-only (GET, HEAD, OPTIONS)
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// compiledRule is a Rule with its matcher and resolver prepared at load time
+type compiledRule struct {
+	name        string
+	prefix      string
+	methods     map[string]bool
+	resolver    PermissionResolver
+	lookup      *resourceLookup // set for the uri resolver, bound to the middleware by New
+	readsBody   bool            // the resolver reads the request body (graphql, bulk)
+	pattern     *pathPattern    // matches instead of prefix, for policy-enforcer paths
+	enforcement ruleEnforcement
+
+	exchangeAudience string
+	exchangeScopes   []string
+	maxTokenAge      time.Duration // 0: any age
+	audience         string        // overrides the audience, if set
+	endpoint         string        // overrides the Keycloak token endpoint, if set
+	scopesHeader     string        // response header listing the granted scopes, if set
+	fallbackScope    string        // OAuth scope granting unregistered resources, if set
+	conditions       []compiledCondition
+	inheritDepth     int        // ancestors with at least this many segments grant the resource; 0: no inheritance
+	bulk             *bulkItems // item IDs of bulk requests, if set
+}
+
+// matches reports whether the rule applies to the request
+func (cr *compiledRule) matches(req *http.Request) bool {
+	if cr.pattern != nil {
+		if !cr.pattern.match(req.URL.Path) {
+			return false
+		}
+	} else if !strings.HasPrefix(req.URL.Path, cr.prefix) {
+		return false
+	}
+	if len(cr.methods) == 0 || cr.methods[req.Method] {
+		return true
+	}
+	if isSafeMethod(req.Method) {
+		return cr.methods[methodClassSafe]
+	}
+	return cr.methods[methodClassMutating]
+}
+
+// compileRule validates a Rule and builds its resolver
+func compileRule(rule Rule, defaultResourceIndex, defaultScopeIndex int) (*compiledRule, error) {
+	cr := &compiledRule{
+		name:             rule.Name,
+		prefix:           rule.Prefix,
+		exchangeAudience: rule.ExchangeAudience,
+		exchangeScopes:   rule.ExchangeScopes,
+		audience:         strings.TrimSpace(rule.KeycloakClientId),
+		endpoint:         strings.TrimSpace(rule.KeycloakURL),
+		scopesHeader:     http.CanonicalHeaderKey(strings.TrimSpace(rule.GrantedScopesHeader)),
+		fallbackScope:    strings.TrimSpace(rule.FallbackScope),
+	}
+	if cr.name == "" {
+		cr.name = rule.Prefix
+	}
+	maxTokenAge, err := parseDurationOrDefault(rule.MaxTokenAge, 0)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: maxTokenAge: %w", cr.name, err)
+	}
+	cr.maxTokenAge = maxTokenAge
+	if rule.InheritDepth < 0 {
+		return nil, fmt.Errorf("rule %q: inheritDepth must not be negative", cr.name)
+	}
+	cr.inheritDepth = rule.InheritDepth
+	if cr.bulk, err = newBulkItems(rule.Bulk); err != nil {
+		return nil, fmt.Errorf("rule %q: %w", cr.name, err)
+	}
+	if cr.bulk != nil {
+		if cr.inheritDepth > 0 {
+			return nil, fmt.Errorf("rule %q: bulk cannot be combined with inheritDepth", cr.name)
+		}
+		cr.readsBody = true
+	}
+	if cr.conditions, err = compileConditions(rule.Conditions); err != nil {
+		return nil, fmt.Errorf("rule %q: %w", cr.name, err)
+	}
+	if cr.endpoint != "" {
+		if u, err := url.Parse(cr.endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("rule %q: keycloakURL must be an absolute http(s) URL", cr.name)
+		}
+	}
+	if len(rule.Methods) > 0 {
+		cr.methods = make(map[string]bool, len(rule.Methods))
+		for _, method := range rule.Methods {
+			cr.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	resolver := strings.ToLower(rule.Resolver)
+	if rule.ResourceType != "" {
+		if resolver != "" && resolver != resolverURI {
+			return nil, fmt.Errorf("rule %q: resourceType requires the %s resolver", cr.name, resolverURI)
+		}
+		resolver = resolverURI
+	}
+
+	switch resolver {
+	case resolverStatic:
+		if rule.Resource == "" {
+			return nil, fmt.Errorf("rule %q: static resolver requires resource", cr.name)
+		}
+		cr.resolver = StaticResolver{Permission: Permission{Resource: rule.Resource, Scope: rule.Scope}}
+	case "", resolverSegments:
+		resourceIndex, scopeIndex := rule.ResourceIndex, rule.ScopeIndex
+		if resourceIndex <= 0 {
+			resourceIndex = defaultResourceIndex
+		}
+		if scopeIndex <= 0 {
+			scopeIndex = defaultScopeIndex
+		}
+		cr.resolver = SegmentResolver{ResourceIndex: resourceIndex, ScopeIndex: scopeIndex}
+	case resolverTemplate:
+		if rule.Template == "" {
+			return nil, fmt.Errorf("rule %q: template resolver requires template", cr.name)
+		}
+		if rule.Resource == "" && !strings.Contains(rule.Template, "{resource}") {
+			return nil, fmt.Errorf("rule %q: template must capture {resource} or set resource", cr.name)
+		}
+		cr.resolver = PathTemplateResolver{Template: rule.Template, Resource: rule.Resource, Scope: rule.Scope}
+	case resolverMethod:
+		if rule.Resource == "" {
+			return nil, fmt.Errorf("rule %q: method resolver requires resource", cr.name)
+		}
+		cr.resolver = MethodResolver{Resource: rule.Resource, MethodScopes: upperKeys(rule.MethodScopes)}
+	case resolverGraphQL:
+		if rule.Resource == "" {
+			return nil, fmt.Errorf("rule %q: graphql resolver requires resource", cr.name)
+		}
+		cr.resolver = GraphQLResolver{Resource: rule.Resource, OperationScopes: rule.MethodScopes}
+		cr.readsBody = true
+	case resolverGRPC:
+		cr.resolver = GRPCResolver{}
+	case resolverPath:
+		cr.resolver = PathResolver{MethodScopes: upperKeys(rule.MethodScopes)}
+	case resolverMultipart:
+		if rule.FormField == "" {
+			return nil, fmt.Errorf("rule %q: multipart resolver requires formField", cr.name)
+		}
+		if rule.Resource == "" {
+			return nil, fmt.Errorf("rule %q: multipart resolver requires resource", cr.name)
+		}
+		if rule.FormMaxBytes < 0 {
+			return nil, fmt.Errorf("rule %q: formMaxBytes must not be negative", cr.name)
+		}
+		cr.resolver = MultipartResolver{Field: rule.FormField, Resource: rule.Resource, Scope: rule.Scope, MaxBytes: rule.FormMaxBytes}
+	case resolverURI:
+		cr.lookup = &resourceLookup{}
+		cr.resolver = uriResolver{lookup: cr.lookup, resourceType: rule.ResourceType, methodScopes: upperKeys(rule.MethodScopes)}
+	default:
+		return nil, fmt.Errorf("rule %q: unknown resolver %q", cr.name, rule.Resolver)
+	}
+
+	if rule.SafeScope != "" || rule.MutatingScope != "" {
+		cr.resolver = MethodClassResolver{Base: cr.resolver, SafeScope: rule.SafeScope, MutatingScope: rule.MutatingScope}
+	}
+	if len(rule.AcceptScopes) > 0 {
+		mediaScopes := make(map[string]string, len(rule.AcceptScopes))
+		for mediaType, scope := range rule.AcceptScopes {
+			parsed, _, err := mime.ParseMediaType(mediaType)
+			if err != nil || scope == "" {
+				return nil, fmt.Errorf("rule %q: acceptScopes: %q must map a media type to a scope", cr.name, mediaType)
+			}
+			mediaScopes[parsed] = scope
+		}
+		cr.resolver = AcceptScopeResolver{Base: cr.resolver, MediaScopes: mediaScopes}
+	}
+	return cr, nil
+}
+
+// upperKeys returns a copy of m with upper-cased keys (HTTP methods)
+func upperKeys(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToUpper(k)] = v
+	}
+	return out
+}
+
+// compileRules builds the ordered rule list: static permissions first, then configured rules, then
+// the policy enforcer paths, then a catch-all segment rule using the global indexes
+func compileRules(config *Config, resourceIndex, scopeIndex int) ([]*compiledRule, error) {
+	rules := make([]*compiledRule, 0, len(config.StaticPermissions)+len(config.Rules)+1)
+	for _, sp := range config.StaticPermissions {
+		rules = append(rules, &compiledRule{
+			name:     "static:" + sp.Prefix,
+			prefix:   sp.Prefix,
+			resolver: StaticResolver{Permission: Permission{Resource: sp.Resource, Scope: sp.Scope}},
+		})
+	}
+	for _, rule := range config.Rules {
+		cr, err := compileRule(rule, resourceIndex, scopeIndex)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, cr)
+	}
+	enforcerRules, err := loadPolicyEnforcer(config.PolicyEnforcerFile)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, enforcerRules...)
+	rules = append(rules, &compiledRule{
+		name:     "default",
+		resolver: SegmentResolver{ResourceIndex: resourceIndex, ScopeIndex: scopeIndex},
+	})
+	return rules, nil
+}
+
+// resolvePermission finds the first matching rule and resolves the request's permission with it
+func (am *AuthMiddleware) resolvePermission(req *http.Request) (Permission, *compiledRule, error) {
+	target, scope := am.downgradeMethod(req)
+	rule := am.ruleFor(target)
+	if rule == nil {
+		// Unreachable: the default rule matches every request
+		return Permission{}, nil, errPathTooShort
+	}
+	permission, err := rule.resolver.Resolve(target)
+	// Resolvers may replace the body after reading it
+	req.Body = target.Body
+	if err == nil && scope != "" {
+		permission.Scope = scope
+	}
+	return permission, rule, err
+}
+
+// ruleFor returns the first rule matching the request
+func (am *AuthMiddleware) ruleFor(req *http.Request) *compiledRule {
+	rc := am.runtimeFor(req.Context())
+	if rc.ruleIndex != nil {
+		return rc.ruleIndex.first(req)
+	}
+	for _, rule := range rc.rules {
+		if rule.matches(req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// downgradeMethod returns the request to resolve the permission from: HEAD is resolved as GET when
+// headAsGet is set, and non-CORS OPTIONS requests as GET with optionsScope replacing the scope.
+func (am *AuthMiddleware) downgradeMethod(req *http.Request) (*http.Request, string) {
+	switch {
+	case req.Method == http.MethodHead && am.headAsGet:
+		return withMethod(req, http.MethodGet), ""
+	case req.Method == http.MethodOptions && am.optionsScope != "" && req.Header.Get("Access-Control-Request-Method") == "":
+		return withMethod(req, http.MethodGet), am.optionsScope
+	}
+	return req, ""
+}
+
+// withMethod returns a shallow copy of req with a different method
+func withMethod(req *http.Request, method string) *http.Request {
+	r := req.WithContext(req.Context())
+	r.Method = method
+	return r
+}
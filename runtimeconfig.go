@@ -0,0 +1,210 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runtimeKey carries the runtimeConfig a request is authorized with
+const runtimeKey contextKey = "runtime"
+
+// runtimeConfig is the configuration requests are authorized with: rules, Keycloak endpoints and what
+// is derived from them. It is never modified once published; Reload builds a new one and swaps it in
+// atomically, so a request never observes a half-applied configuration and reads take no lock.
+type runtimeConfig struct {
+	keycloakUrl      string
+	keycloakClientId string
+	rules            []*compiledRule
+	ruleIndex        *ruleIndex // rules, indexed by path prefix
+	ruleWarnings     []RuleWarning
+	denyRules        []compiledDenyRule
+	bypass           []compiledBypass
+	statusMappings   []StatusMapping
+	audienceByHost   map[string]string
+	permissionFormat permissionFormat
+	parseRPTGrants   bool   // the RPT is decoded for its permissions (includeResourceName or grantedScopesHeader)
+	config           Config // effective configuration, for compliance snapshots
+	configHash       string // short hash of config, see BuildInfo
+}
+
+// ruleIndexes returns the path segment indexes of the default rule
+func ruleIndexes(config *Config) (int, int) {
+	resourceIndex, scopeIndex := config.ResourceIndex, config.ScopeIndex
+	if resourceIndex <= 0 {
+		resourceIndex = 3
+	}
+	if scopeIndex <= 0 {
+		scopeIndex = 4
+	}
+	return resourceIndex, scopeIndex
+}
+
+// newRuntimeConfig compiles the runtime part of a configuration
+func newRuntimeConfig(config *Config) (*runtimeConfig, error) {
+	resourceIndex, scopeIndex := ruleIndexes(config)
+	rules, err := compileRules(config, resourceIndex, scopeIndex)
+	if err != nil {
+		return nil, fmt.Errorf("rules: %w", err)
+	}
+	denyRules, err := compileDenyRules(config.DenyRules, config.EntryPointHeader)
+	if err != nil {
+		return nil, err
+	}
+	bypass, err := compileBypasses(config.Bypass)
+	if err != nil {
+		return nil, err
+	}
+
+	separator := config.PermissionSeparator
+	if separator == "" {
+		separator = "#"
+	}
+	audienceByHost := make(map[string]string, len(config.AudienceByHost))
+	for host, clientID := range config.AudienceByHost {
+		audienceByHost[strings.ToLower(strings.TrimSpace(host))] = clientID
+	}
+
+	limits, err := newPathLimits(config.MaxPathLength, config.MaxPathSegments)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &runtimeConfig{
+		keycloakUrl:      config.KeycloakURL,
+		keycloakClientId: config.KeycloakClientId,
+		rules:            rules,
+		ruleIndex:        newRuleIndex(rules),
+		ruleWarnings:     lintRules(rules, limits.maxSegments),
+		denyRules:        denyRules,
+		bypass:           bypass,
+		statusMappings:   config.StatusMappings,
+		audienceByHost:   audienceByHost,
+		permissionFormat: permissionFormat{
+			separator:        separator,
+			omitLeadingSlash: config.OmitLeadingSlash,
+			scopeless:        config.ScopelessPermissions,
+		},
+		parseRPTGrants: config.IncludeResourceName,
+		config:         *config,
+		configHash:     configHash(*config),
+	}
+	for _, rule := range rules {
+		if rule.scopesHeader != "" {
+			rc.parseRPTGrants = true
+		}
+	}
+	return rc, nil
+}
+
+// current returns the latest runtime configuration
+func (am *AuthMiddleware) current() *runtimeConfig {
+	if rc, ok := am.runtime.Load().(*runtimeConfig); ok {
+		return rc
+	}
+	return &runtimeConfig{}
+}
+
+// runtimeFor returns the runtime configuration the request of ctx started with, so a reload in the
+// middle of a request does not affect it, or else the latest one
+func (am *AuthMiddleware) runtimeFor(ctx context.Context) *runtimeConfig {
+	if rc, ok := ctx.Value(runtimeKey).(*runtimeConfig); ok {
+		return rc
+	}
+	return am.current()
+}
+
+// withRuntime pins the latest runtime configuration to the request
+func (am *AuthMiddleware) withRuntime(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), runtimeKey, am.current()))
+}
+
+// reloadable returns the active configuration with the settings Reload applies taken from next, so
+// snapshots and the config hash describe what the middleware actually runs
+func reloadable(active, next Config) Config {
+	merged := active
+	merged.Rules = next.Rules
+	merged.StaticPermissions = next.StaticPermissions
+	merged.PolicyEnforcerFile = next.PolicyEnforcerFile
+	merged.ResourceIndex, merged.ScopeIndex = next.ResourceIndex, next.ScopeIndex
+	merged.DenyRules = next.DenyRules
+	merged.Bypass = next.Bypass
+	merged.StatusMappings = next.StatusMappings
+	merged.KeycloakURL, merged.Keycloak.URL = next.KeycloakURL, next.Keycloak.URL
+	merged.KeycloakClientId, merged.Keycloak.ClientID = next.KeycloakClientId, next.Keycloak.ClientID
+	merged.AudienceByHost = next.AudienceByHost
+	merged.PermissionSeparator = next.PermissionSeparator
+	merged.OmitLeadingSlash = next.OmitLeadingSlash
+	merged.ScopelessPermissions = next.ScopelessPermissions
+	merged.IncludeResourceName = next.IncludeResourceName
+	return merged
+}
+
+// keycloakBinding names the state New derived from the Keycloak URL and client ID, which a reload
+// changing them would leave pointing at the previous ones, or returns ""
+func (am *AuthMiddleware) keycloakBinding() string {
+	switch {
+	case am.serviceTokens != nil:
+		return "the service token of keycloakClientSecret"
+	case am.keycloakAddress != nil:
+		return "issuerOverride and internalURL"
+	case am.current().config.Share:
+		return "the Keycloak state shared with other instances"
+	}
+	return ""
+}
+
+// Reload applies a new configuration to the running middleware without dropping its caches and
+// connections. Rules, deny rules, bypass entries, status mappings, the Keycloak URL and client ID, audiences and the
+// permission format take effect for requests started afterwards; every other setting keeps the value
+// the middleware was created with, and changes to them are logged and ignored. The Keycloak URL and
+// client ID can only change when no client secret, issuerOverride, internalURL or share is used, as
+// those are bound to them when the middleware is created. An invalid configuration is rejected and
+// the current one kept.
+func (am *AuthMiddleware) Reload(config *Config) error {
+	if config == nil {
+		return fmt.Errorf("nil config provided")
+	}
+	structured, deprecations, err := config.withStructuredKeys()
+	if err != nil {
+		return err
+	}
+	logDeprecations(am.name, deprecations, *config)
+	config, err = structured.withProfile()
+	if err != nil {
+		return err
+	}
+	if errs := config.validate(); len(errs) > 0 {
+		return errs[0]
+	}
+	active := am.current().config
+	merged := reloadable(active, *config)
+	if merged.KeycloakURL != active.KeycloakURL || merged.KeycloakClientId != active.KeycloakClientId {
+		if binding := am.keycloakBinding(); binding != "" {
+			return fmt.Errorf("keycloakURL and keycloakClientId are bound to %s and can only be changed by recreating the middleware", binding)
+		}
+	}
+	rc, err := newRuntimeConfig(&merged)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rc.rules {
+		if rule.lookup == nil {
+			continue
+		}
+		if am.resources == nil {
+			return fmt.Errorf("rule %q: the %s resolver can only be enabled by recreating the middleware", rule.name, resolverURI)
+		}
+		rule.lookup.find = am.lookupResource
+	}
+	am.runtime.Store(rc)
+	fmt.Printf("🔧 [RELOAD] %s now running config %s\n", am.name, rc.configHash)
+	if configHash(*config) != rc.configHash {
+		fmt.Printf("⚠️  [RELOAD] %s: settings other than rules, deny rules, bypass, status mappings, Keycloak URL and client ID, audiences and the permission format changed and are ignored until the middleware is recreated\n", am.name)
+	}
+	logRuleWarnings(am.name, rc.ruleWarnings)
+	logBypasses(am.name, rc.bypass, time.Now())
+	return nil
+}
@@ -0,0 +1,123 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL: "http://keycloak/realms/demo/protocol/openid-connect/token",
+		Rules:       []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "view"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+	before := am.BuildInfo().ConfigHash
+
+	// A request pinned before the reload keeps the configuration it started with
+	pinned := am.withRuntime(httptest.NewRequest(http.MethodGet, "http://gateway/reports/monthly", nil))
+
+	reloaded := *config
+	reloaded.Rules = []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "export"}}
+	reloaded.DenyRules = []DenyRule{{Name: "no-admin", Prefix: "/admin"}}
+	if err := am.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if permission, _, err := am.resolvePermission(pinned); err != nil || permission.Scope != "view" {
+		t.Errorf("expected the pinned request to keep scope view, got %+v (%v)", permission, err)
+	}
+	fresh := am.withRuntime(httptest.NewRequest(http.MethodGet, "http://gateway/reports/monthly", nil))
+	if permission, _, err := am.resolvePermission(fresh); err != nil || permission.Scope != "export" {
+		t.Errorf("expected a new request to use scope export, got %+v (%v)", permission, err)
+	}
+	if _, denied := am.matchDenyRule(httptest.NewRequest(http.MethodGet, "http://gateway/admin", nil), nil); !denied {
+		t.Error("expected the reloaded deny rule to apply")
+	}
+	if after := am.BuildInfo().ConfigHash; after == before {
+		t.Error("expected the config hash to change with the reload")
+	}
+
+	// Settings only applied by New keep their values, and the snapshot and hash describe those
+	ignored := reloaded
+	ignored.DryRun = true
+	ignored.LogLevel = "debug"
+	hash := am.BuildInfo().ConfigHash
+	if err := am.Reload(&ignored); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot := am.Snapshot(); snapshot.Config.DryRun || snapshot.Config.LogLevel != "" {
+		t.Errorf("expected the snapshot to keep the settings New applied, got dryRun=%v logLevel=%q", snapshot.Config.DryRun, snapshot.Config.LogLevel)
+	}
+	if after := am.BuildInfo().ConfigHash; after != hash {
+		t.Errorf("expected the config hash to ignore settings Reload does not apply, got %s instead of %s", after, hash)
+	}
+
+	// An invalid configuration is rejected and the current one kept
+	broken := reloaded
+	broken.Rules = []Rule{{Prefix: "/x", Resolver: "nope"}}
+	if err := am.Reload(&broken); err == nil {
+		t.Fatal("expected an error for an unknown resolver")
+	}
+	if permission, _, _ := am.resolvePermission(httptest.NewRequest(http.MethodGet, "http://gateway/reports/monthly", nil)); permission.Scope != "export" {
+		t.Errorf("expected the previous configuration to stay, got %+v", permission)
+	}
+
+	// The uri resolver needs state only set up when the middleware is created
+	uri := reloaded
+	uri.KeycloakClientSecret = "secret"
+	uri.Rules = []Rule{{Prefix: "/orders", Resolver: "uri"}}
+	if err := am.Reload(&uri); err == nil {
+		t.Error("expected an error when enabling the uri resolver by reload")
+	}
+
+	// Without state bound to it, the Keycloak endpoint follows the reload
+	moved := reloaded
+	moved.KeycloakURL = "http://keycloak-2/realms/demo/protocol/openid-connect/token"
+	if err := am.Reload(&moved); err != nil || am.current().keycloakUrl != moved.KeycloakURL {
+		t.Errorf("expected the Keycloak URL to be reloaded, got %q (%v)", am.current().keycloakUrl, err)
+	}
+}
+
+func TestReloadBoundKeycloakEndpoint(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:          "http://keycloak/realms/demo/protocol/openid-connect/token",
+		KeycloakClientId:     "gateway",
+		KeycloakClientSecret: "secret",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := New(ctx, next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := handler.(*AuthMiddleware)
+
+	// The service token would still be fetched from the previous realm with the previous client
+	for _, change := range []func(c *Config){
+		func(c *Config) { c.KeycloakURL = "http://keycloak/realms/other/protocol/openid-connect/token" },
+		func(c *Config) { c.KeycloakClientId = "other-gateway" },
+	} {
+		reloaded := *config
+		change(&reloaded)
+		if err := am.Reload(&reloaded); err == nil {
+			t.Errorf("expected the reload of %s/%s to be rejected", reloaded.KeycloakURL, reloaded.KeycloakClientId)
+		}
+	}
+	if rc := am.current(); rc.keycloakUrl != config.KeycloakURL || rc.keycloakClientId != "gateway" {
+		t.Errorf("expected the previous Keycloak endpoint to stay, got %s/%s", rc.keycloakUrl, rc.keycloakClientId)
+	}
+
+	reloaded := *config
+	reloaded.Rules = []Rule{{Prefix: "/reports", Resolver: "static", Resource: "report", Scope: "view"}}
+	if err := am.Reload(&reloaded); err != nil {
+		t.Errorf("expected a reload keeping the Keycloak endpoint to succeed, got %v", err)
+	}
+}
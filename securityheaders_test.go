@@ -0,0 +1,92 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+	})
+	config := &Config{
+		KeycloakURL: "http://keycloak.invalid/token",
+		Admin:       AdminConfig{Path: "/.authz", Token: "admin-secret"},
+		SecurityHeaders: SecurityHeadersConfig{
+			Enabled: true,
+			HSTS:    "max-age=31536000; includeSubDomains",
+			Headers: map[string]string{"X-Frame-Options": "DENY"},
+		},
+		Bypass: []BypassEntry{{Prefix: "/public/", Until: "2999-01-01"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		tls    bool
+		header map[string]string
+		hsts   bool
+		own    bool
+	}{
+		{"denial over TLS", "/api/v1/user/get", true, nil, true, true},
+		{"denial behind a TLS proxy", "/api/v1/user/get", false, map[string]string{"X-Forwarded-Proto": "https"}, true, true},
+		{"denial over plain HTTP", "/api/v1/user/get", false, nil, false, true},
+		{"admin endpoint", "/.authz/version", true, map[string]string{"Authorization": "Bearer admin-secret"}, true, true},
+		{"upstream response", "/public/page", true, nil, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+			if test.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			header := recorder.Header()
+			if got := header.Get("Strict-Transport-Security") != ""; got != test.hsts {
+				t.Errorf("expected HSTS %v, got %q", test.hsts, header.Get("Strict-Transport-Security"))
+			}
+			if !test.own {
+				if header.Get("Cache-Control") != "max-age=60" || header.Get("X-Frame-Options") != "" {
+					t.Errorf("expected the upstream headers untouched, got %v", header)
+				}
+				return
+			}
+			for name, expected := range map[string]string{"Cache-Control": "no-store", "Pragma": "no-cache", "X-Content-Type-Options": "nosniff", "X-Frame-Options": "DENY"} {
+				if got := header.Get(name); got != expected {
+					t.Errorf("expected %s: %s, got %q", name, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersValidation(t *testing.T) {
+	tests := []struct {
+		config SecurityHeadersConfig
+		valid  bool
+	}{
+		{SecurityHeadersConfig{}, true},
+		{SecurityHeadersConfig{HSTS: "max-age=63072000; includeSubDomains; preload"}, true},
+		{SecurityHeadersConfig{Headers: map[string]string{"Content-Security-Policy": "default-src 'none'"}}, true},
+		{SecurityHeadersConfig{HSTS: "1 year"}, false},
+		{SecurityHeadersConfig{Headers: map[string]string{"X-Bad: header": "x"}}, false},
+		{SecurityHeadersConfig{Headers: map[string]string{"X-Split": "a\r\nSet-Cookie: x"}}, false},
+	}
+	for _, test := range tests {
+		_, err := newSecurityHeaders(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.config, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceTokenRefreshRatio is the share of a service token's lifetime after which it is refreshed
+const serviceTokenRefreshRatio = 0.8
+
+// serviceTokenTimeout bounds a service token request, which never depends on the caller's context
+const serviceTokenTimeout = 30 * time.Second
+
+// serviceTokenManager caches the plugin's own client_credentials token and refreshes it before it expires.
+// It is used by features that call Keycloak on the plugin's behalf (e.g. the Protection API).
+type serviceTokenManager struct {
+	client       *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	refreshAt time.Time
+	timer     *time.Timer
+	stopped   bool
+	inflight  *tokenRefresh // the running token request, if any
+}
+
+// tokenRefresh is a token request shared by every caller waiting for it
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// newServiceTokenManager creates a token manager for the given client credentials
+func newServiceTokenManager(client *http.Client, tokenURL, clientID, clientSecret string) *serviceTokenManager {
+	return &serviceTokenManager{
+		client:       client,
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
+}
+
+// Token returns a valid service token. A token due for refresh is still returned while it is valid,
+// and renewed in the background; callers only wait when there is no valid token, and then share a
+// single request.
+func (m *serviceTokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+	if m.token != "" && now.Before(m.expiresAt) {
+		if !now.Before(m.refreshAt) {
+			m.refreshLocked()
+		}
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+	refresh := m.refreshLocked()
+	m.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if refresh.err != nil {
+		return "", refresh.err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, nil
+}
+
+// stop cancels the background refresh
+func (m *serviceTokenManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// refreshLocked starts a token request unless one is running, and returns it. m.mu must be held; the
+// request runs without it, so callers holding a valid token never wait for it.
+func (m *serviceTokenManager) refreshLocked() *tokenRefresh {
+	if m.inflight != nil {
+		return m.inflight
+	}
+	refresh := &tokenRefresh{done: make(chan struct{})}
+	m.inflight = refresh
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceTokenTimeout)
+		token, lifetime, err := m.fetch(ctx)
+		cancel()
+
+		m.mu.Lock()
+		if err == nil {
+			m.storeLocked(token, lifetime)
+		} else {
+			// A failed refresh is not fatal while the current token is still valid
+			fmt.Println("⚠️  [SERVICE-TOKEN] Refresh failed:", err)
+		}
+		refresh.err = err
+		m.inflight = nil
+		m.mu.Unlock()
+		close(refresh.done)
+	}()
+	return refresh
+}
+
+// fetch requests a new token with the client credentials and returns it with its lifetime
+func (m *serviceTokenManager) fetch(ctx context.Context) (string, time.Duration, error) {
+	formData := url.Values{}
+	formData.Set("grant_type", "client_credentials")
+	formData.Set("client_id", m.clientID)
+	formData.Set("client_secret", m.clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.tokenURL, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("creating service token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("requesting service token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := readLimited(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("service token request failed with status %d: %s", resp.StatusCode, parseKeycloakError(body).Error)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", 0, fmt.Errorf("decoding service token response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", 0, fmt.Errorf("service token response has no access_token")
+	}
+
+	lifetime := time.Duration(tokenResp.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = time.Minute
+	}
+	return tokenResp.AccessToken, lifetime, nil
+}
+
+// storeLocked caches a new token and schedules the next proactive refresh. m.mu must be held.
+func (m *serviceTokenManager) storeLocked(token string, lifetime time.Duration) {
+	now := time.Now()
+	m.token = token
+	m.expiresAt = now.Add(lifetime)
+	m.refreshAt = now.Add(time.Duration(float64(lifetime) * serviceTokenRefreshRatio))
+	fmt.Println("🔑 [SERVICE-TOKEN] Obtained service token, expires in", lifetime)
+
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	if !m.stopped {
+		m.timer = time.AfterFunc(m.refreshAt.Sub(now), m.backgroundRefresh)
+	}
+}
+
+// backgroundRefresh proactively renews the token so callers never wait for a fetch
+func (m *serviceTokenManager) backgroundRefresh() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.stopped {
+		m.refreshLocked()
+	}
+}
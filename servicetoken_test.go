@@ -0,0 +1,132 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServiceTokenCaching(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("client_secret") != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(rw, `{"access_token":"token-%d","expires_in":300}`, n)
+	}))
+	defer srv.Close()
+
+	manager := newServiceTokenManager(srv.Client(), srv.URL, "gateway", "secret")
+	defer manager.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tok, err := manager.Token(context.Background()); err != nil || tok != "token-1" {
+				t.Errorf("expected token-1, got %q (%v)", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected a single token request, got %d", n)
+	}
+}
+
+func TestServiceTokenProactiveRefresh(t *testing.T) {
+	var calls int32
+	refreshed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 2 {
+			defer close(refreshed)
+		}
+		// A one second lifetime is refreshed in the background after 800ms
+		fmt.Fprintf(rw, `{"access_token":"token-%d","expires_in":1}`, n)
+	}))
+	defer srv.Close()
+
+	manager := newServiceTokenManager(srv.Client(), srv.URL, "gateway", "secret")
+	defer manager.stop()
+
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-refreshed
+
+	// The token is stored once the response is read, outside the handler
+	token := "token-1"
+	for deadline := time.Now().Add(time.Second); token == "token-1" && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		manager.mu.Lock()
+		token = manager.token
+		manager.mu.Unlock()
+	}
+	if token != "token-2" {
+		t.Errorf("expected background refresh to store token-2, got %q", token)
+	}
+}
+
+func TestServiceTokenServedDuringRefresh(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 2 {
+			<-release
+		}
+		fmt.Fprintf(rw, `{"access_token":"token-%d","expires_in":300}`, n)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	manager := newServiceTokenManager(srv.Client(), srv.URL, "gateway", "secret")
+	defer manager.stop()
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past its refresh time but still valid: the stalled refresh must not hold up callers
+	manager.mu.Lock()
+	manager.refreshAt = time.Now().Add(-time.Second)
+	manager.mu.Unlock()
+
+	done := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			token, _ := manager.Token(context.Background())
+			done <- token
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case token := <-done:
+			if token != "token-1" {
+				t.Errorf("expected the current token-1 during the refresh, got %q", token)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Token blocked on the running refresh")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n > 2 {
+		t.Errorf("expected a single refresh request, got %d requests", n)
+	}
+}
+
+func TestServiceTokenError(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusUnauthorized, `{"error":"unauthorized_client"}`)
+	manager := newServiceTokenManager(srv.Client(), srv.URL, "gateway", "wrong")
+	if _, err := manager.Token(context.Background()); err == nil {
+		t.Error("expected error for rejected client credentials")
+	}
+}
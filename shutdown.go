@@ -0,0 +1,43 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+)
+
+// requestContext derives the context for outbound calls made on behalf of a request.
+// It is cancelled when the client disconnects or when the middleware instance is shut down.
+func (am *AuthMiddleware) requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-am.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// onShutdown registers a function to run once the middleware context passed to New is done,
+// e.g. to flush buffered audit records or metrics
+func (am *AuthMiddleware) onShutdown(hook func()) {
+	am.shutdownMu.Lock()
+	defer am.shutdownMu.Unlock()
+	am.shutdownHooks = append(am.shutdownHooks, hook)
+}
+
+// watchShutdown waits for the middleware context and runs the registered hooks in reverse order
+func (am *AuthMiddleware) watchShutdown() {
+	<-am.ctx.Done()
+	fmt.Println("🔧 [SHUTDOWN] Middleware context done:", am.ctx.Err())
+
+	am.shutdownMu.Lock()
+	hooks := am.shutdownHooks
+	am.shutdownHooks = nil
+	am.shutdownMu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
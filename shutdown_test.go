@@ -0,0 +1,102 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newBlockingKeycloakStub starts a fake Keycloak that never answers until the outbound request is cancelled
+func newBlockingKeycloakStub(t *testing.T) (*httptest.Server, <-chan struct{}) {
+	t.Helper()
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// The server only notices a closed connection once the request body has been consumed
+		_, _ = io.Copy(io.Discard, req.Body)
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-req.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func TestClientDisconnectCancelsKeycloakCall(t *testing.T) {
+	srv, received := newBlockingKeycloakStub(t)
+
+	nextCalled := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { nextCalled = true })
+	handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	<-received
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeHTTP did not return after client disconnect")
+	}
+	if nextCalled {
+		t.Error("next handler must not be called for a cancelled request")
+	}
+}
+
+func TestShutdownCancelsInFlightCalls(t *testing.T) {
+	srv, received := newBlockingKeycloakStub(t)
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(ctx, next, &Config{KeycloakURL: srv.URL}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hookCalled := make(chan struct{})
+	handler.(*AuthMiddleware).onShutdown(func() { close(hookCalled) })
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(recorder, req)
+		close(done)
+	}()
+
+	<-received
+	shutdown()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeHTTP did not return after shutdown")
+	}
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	select {
+	case <-hookCalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown hook was not called")
+	}
+}
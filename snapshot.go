@@ -0,0 +1,188 @@
+package authztraefikgateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// snapshotAlgorithm is the signature algorithm of compliance snapshots
+const snapshotAlgorithm = "HS256"
+
+// redacted replaces secrets in the configuration of a compliance snapshot
+const redacted = "[redacted]"
+
+// errNoSigningKey is returned when a snapshot is requested without admin.signingKey
+var errNoSigningKey = errors.New("admin.signingKey is not configured")
+
+// ComplianceSnapshot is what the gateway was enforcing at a point in time
+type ComplianceSnapshot struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Middleware  string         `json:"middleware"`
+	Version     string         `json:"version"`
+	ConfigHash  string         `json:"configHash"`
+	Config      Config         `json:"config"` // effective configuration (profile applied), secrets redacted
+	Rules       []SnapshotRule `json:"rules"`  // permission rules in evaluation order
+	Cache       *CacheStats    `json:"cache,omitempty"`
+}
+
+// SnapshotRule describes one effective permission rule
+type SnapshotRule struct {
+	Name     string   `json:"name"`
+	Prefix   string   `json:"prefix"`
+	Methods  []string `json:"methods,omitempty"`
+	Resolver string   `json:"resolver"`
+}
+
+// CacheStats describes the decision cache
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	Subjects   int    `json:"subjects"`
+	MaxEntries int    `json:"maxEntries"`
+	TTL        string `json:"ttl"`
+}
+
+// SignedSnapshot is a ComplianceSnapshot with an HMAC-SHA256 signature over its exact JSON bytes
+type SignedSnapshot struct {
+	Snapshot  json.RawMessage `json:"snapshot"`
+	Algorithm string          `json:"algorithm"`
+	Signature string          `json:"signature"` // base64url HMAC-SHA256 of Snapshot, keyed with admin.signingKey
+}
+
+// stats returns the current statistics of the cache
+func (dc *decisionCache) stats() CacheStats {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return CacheStats{Entries: len(dc.entries), Subjects: len(dc.bySubject), MaxEntries: dc.maxEntries, TTL: dc.ttl.String()}
+}
+
+// redactConfig returns a copy of config without secrets
+func redactConfig(config Config) Config {
+	if config.KeycloakClientSecret != "" {
+		config.KeycloakClientSecret = redacted
+	}
+	if config.Keycloak.ClientSecret != "" {
+		config.Keycloak.ClientSecret = redacted
+	}
+	if config.Admin.Token != "" {
+		config.Admin.Token = redacted
+	}
+	if config.Admin.SigningKey != "" {
+		config.Admin.SigningKey = redacted
+	}
+	if config.Pseudonym.Key != "" {
+		config.Pseudonym.Key = redacted
+	}
+	if len(config.Pseudonym.Routes) > 0 {
+		routes := make([]PseudonymRoute, len(config.Pseudonym.Routes))
+		for i, route := range config.Pseudonym.Routes {
+			route.Key = redacted
+			routes[i] = route
+		}
+		config.Pseudonym.Routes = routes
+	}
+	if config.SubjectHash.Salt != "" {
+		config.SubjectHash.Salt = redacted
+	}
+	if config.ForwardAuth.Secret != "" {
+		config.ForwardAuth.Secret = redacted
+	}
+	if config.RequestFlags.Secret != "" {
+		config.RequestFlags.Secret = redacted
+	}
+	if len(config.DenialMirror.Headers) > 0 {
+		headers := make(map[string]string, len(config.DenialMirror.Headers))
+		for name := range config.DenialMirror.Headers {
+			headers[name] = redacted
+		}
+		config.DenialMirror.Headers = headers
+	}
+	if len(config.Enrichment.Headers) > 0 {
+		headers := make(map[string]string, len(config.Enrichment.Headers))
+		for name := range config.Enrichment.Headers {
+			headers[name] = redacted
+		}
+		config.Enrichment.Headers = headers
+	}
+	return config
+}
+
+// Snapshot returns the currently effective configuration, rules and cache statistics
+func (am *AuthMiddleware) Snapshot() ComplianceSnapshot {
+	rc := am.current()
+	snapshot := ComplianceSnapshot{
+		GeneratedAt: time.Now().UTC(),
+		Middleware:  am.name,
+		Version:     Version,
+		ConfigHash:  rc.configHash,
+		Config:      redactConfig(rc.config),
+		Rules:       make([]SnapshotRule, 0, len(rc.rules)),
+	}
+	for _, rule := range rc.rules {
+		sr := SnapshotRule{Name: rule.name, Prefix: rule.prefix, Resolver: resolverTypeName(rule.resolver)}
+		for method := range rule.methods {
+			sr.Methods = append(sr.Methods, method)
+		}
+		sort.Strings(sr.Methods)
+		snapshot.Rules = append(snapshot.Rules, sr)
+	}
+	if am.cache != nil {
+		stats := am.cache.stats()
+		snapshot.Cache = &stats
+	}
+	return snapshot
+}
+
+// resolverTypeName names the type of a resolver. Yaegi synthesizes the reflect types of interpreted
+// structs without names, so reflection cannot be used for this when running inside Traefik.
+func resolverTypeName(resolver PermissionResolver) string {
+	switch resolver.(type) {
+	case StaticResolver:
+		return "StaticResolver"
+	case SegmentResolver:
+		return "SegmentResolver"
+	case PathTemplateResolver:
+		return "PathTemplateResolver"
+	case MethodResolver:
+		return "MethodResolver"
+	case GraphQLResolver:
+		return "GraphQLResolver"
+	case GRPCResolver:
+		return "GRPCResolver"
+	case MultipartResolver:
+		return "MultipartResolver"
+	case MethodClassResolver:
+		return "MethodClassResolver"
+	case uriResolver:
+		return "uriResolver"
+	}
+	return "custom"
+}
+
+// SignedSnapshot returns the compliance snapshot signed with admin.signingKey
+func (am *AuthMiddleware) SignedSnapshot() (SignedSnapshot, error) {
+	if am.admin.SigningKey == "" {
+		return SignedSnapshot{}, errNoSigningKey
+	}
+	raw, err := json.Marshal(am.Snapshot())
+	if err != nil {
+		return SignedSnapshot{}, err
+	}
+	return SignedSnapshot{Snapshot: raw, Algorithm: snapshotAlgorithm, Signature: signSnapshot(raw, am.admin.SigningKey)}, nil
+}
+
+// VerifySnapshot reports whether a signed snapshot was produced with key and left unmodified
+func VerifySnapshot(signed SignedSnapshot, key string) bool {
+	return signed.Algorithm == snapshotAlgorithm && hmac.Equal([]byte(signed.Signature), []byte(signSnapshot(signed.Snapshot, key)))
+}
+
+// signSnapshot computes the base64url HMAC-SHA256 of raw
+func signSnapshot(raw []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(raw)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
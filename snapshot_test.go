@@ -0,0 +1,141 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestComplianceSnapshot(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL:          "http://keycloak/token",
+		KeycloakClientSecret: "client-secret",
+		Rules:                []Rule{{Name: "orders-write", Prefix: "/orders", Methods: []string{"post", "put"}, Resolver: "static", Resource: "order", Scope: "manage"}},
+		Cache:                CacheConfig{Enabled: true},
+		Admin:                AdminConfig{Path: "/.authz", Token: "admin-secret", SigningKey: "signing-key"},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/.authz/snapshot", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, secret := range []string{"client-secret", "admin-secret", "signing-key"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("snapshot leaks secret %q", secret)
+		}
+	}
+
+	var signed SignedSnapshot
+	if err := json.Unmarshal(recorder.Body.Bytes(), &signed); err != nil {
+		t.Fatal(err)
+	}
+	if !VerifySnapshot(signed, "signing-key") {
+		t.Error("expected the signature to verify")
+	}
+	if VerifySnapshot(signed, "other-key") {
+		t.Error("expected the signature not to verify with another key")
+	}
+
+	var snapshot ComplianceSnapshot
+	if err := json.Unmarshal(signed.Snapshot, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Config.KeycloakURL != "http://keycloak/token" || snapshot.Cache == nil || snapshot.Cache.MaxEntries != defaultCacheMaxEntries {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+	if len(snapshot.Rules) != 2 || snapshot.Rules[0].Name != "orders-write" || strings.Join(snapshot.Rules[0].Methods, ",") != "POST,PUT" || snapshot.Rules[1].Resolver != "SegmentResolver" {
+		t.Errorf("unexpected rules: %+v", snapshot.Rules)
+	}
+
+	tampered := signed
+	tampered.Snapshot = json.RawMessage(strings.Replace(string(signed.Snapshot), "orders-write", "orders-read", 1))
+	if VerifySnapshot(tampered, "signing-key") {
+		t.Error("expected a modified snapshot not to verify")
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := Config{
+		KeycloakClientSecret: "client-secret",
+		RequestFlags:         RequestFlagsConfig{Secret: "flags-secret"},
+		ForwardAuth:          ForwardAuthConfig{Secret: "forward-secret"},
+		SubjectHash:          SubjectHashConfig{Salt: "subject-salt"},
+		Enrichment:           EnrichmentConfig{URL: "http://accounts/{subject}", Headers: map[string]string{"X-Api-Key": "enrichment-key"}},
+	}
+	out, err := json.Marshal(redactConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"client-secret", "flags-secret", "subject-salt", "enrichment-key", "forward-secret"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("redacted config leaks secret %q: %s", secret, out)
+		}
+	}
+	if migrated, err := migratedConfigJSON(config); err != nil || strings.Contains(migrated, "enrichment-key") {
+		t.Errorf("expected the migrated configuration without secrets, got %s (%v)", migrated, err)
+	}
+	if config.RequestFlags.Secret != "flags-secret" || config.Enrichment.Headers["X-Api-Key"] != "enrichment-key" {
+		t.Error("redactConfig must not modify its argument")
+	}
+}
+
+func TestNewLogsNoSecrets(t *testing.T) {
+	config := &Config{
+		KeycloakURL:          "http://keycloak.invalid/realms/demo/protocol/openid-connect/token",
+		KeycloakClientSecret: "client-secret",
+		Admin:                AdminConfig{Path: "/.authz", Token: "admin-token", SigningKey: "snapshot-key"},
+		Pseudonym:            PseudonymConfig{Header: "X-Authz-Pseudonym", Key: "pseudonym-key"},
+		SubjectHash:          SubjectHashConfig{Salt: "subject-salt"},
+		RequestFlags:         RequestFlagsConfig{Secret: "flags-secret"},
+		DenialMirror:         DenialMirrorConfig{URL: "http://review.invalid/denials", Headers: map[string]string{"X-Api-Key": "mirror-key"}},
+		Enrichment:           EnrichmentConfig{URL: "http://accounts.invalid/{subject}", Headers: map[string]string{"X-Api-Key": "enrichment-key"}},
+	}
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	os.Stdout = w
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), config, "AuthMiddleware")
+	cancel()
+	os.Stdout = stdout
+	w.Close()
+	logged := <-output
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"client-secret", "admin-token", "snapshot-key", "pseudonym-key", "subject-salt", "flags-secret", "mirror-key", "enrichment-key"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("New logged the secret %q", secret)
+		}
+	}
+}
+
+func TestComplianceSnapshotRequiresSigningKey(t *testing.T) {
+	am := &AuthMiddleware{}
+	if _, err := am.SignedSnapshot(); err != errNoSigningKey {
+		t.Errorf("expected errNoSigningKey, got %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package authztraefikgateway
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authorization backends selectable with authzBackend
+const (
+	authzBackendKeycloak = "keycloak"
+	authzBackendStatic   = "static"
+)
+
+// backendStatic identifies the local policy file backend in a Decision
+const backendStatic = "static"
+
+// staticPolicyReloadInterval is how often the policy file is checked for changes
+const staticPolicyReloadInterval = time.Second
+
+// StaticPolicyConfig configures the static backend, which evaluates permissions from a local policy
+// file instead of Keycloak. Token signatures are verified with the issuer's public keys; without them
+// the backend only starts with InsecureSkipVerify, as anyone could then mint a token with any role.
+// Verified tokens must also be access tokens issued by Issuer for Audience, so the ID tokens of the
+// realm and the access tokens of its other clients are not accepted.
+type StaticPolicyConfig struct {
+	File               string `json:"file,omitempty"`               // JSON StaticPolicy, re-read when it changes
+	PublicKeyFile      string `json:"publicKeyFile,omitempty"`      // PEM public keys or certificates of the token issuer
+	Issuer             string `json:"issuer,omitempty"`             // expected "iss", e.g. https://sso.example.com/realms/acme
+	Audience           string `json:"audience,omitempty"`           // expected "aud" or "azp", e.g. gateway
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"` // accept unverified tokens, for development only
+}
+
+// StaticPolicy is the content of the static policy file. Grants are "resource#scope" strings where
+// either part may be "*", and a bare "resource" grants all of its scopes.
+type StaticPolicy struct {
+	Subjects map[string][]string `json:"subjects,omitempty"` // "sub" or preferred_username -> grants
+	Roles    map[string][]string `json:"roles,omitempty"`    // realm role or "<client>:<role>" -> grants
+}
+
+// policyGrant is a parsed grant of a StaticPolicy
+type policyGrant struct {
+	resource string
+	scope    string
+}
+
+// matches reports whether the grant covers permission
+func (g policyGrant) matches(permission Permission) bool {
+	return (g.resource == "*" || g.resource == permission.Resource) && (g.scope == "*" || g.scope == permission.Scope)
+}
+
+// compiledPolicy is a parsed StaticPolicy
+type compiledPolicy struct {
+	subjects map[string][]policyGrant
+	roles    map[string][]policyGrant
+}
+
+// staticPolicy evaluates permissions against the policy file, reloading it when its mtime changes
+type staticPolicy struct {
+	file     string
+	keys     []crypto.PublicKey // nil when signatures are not verified
+	issuer   string             // "" accepts any "iss"
+	audience string             // "" accepts any "aud" and "azp"
+
+	mu        sync.Mutex
+	policy    *compiledPolicy
+	fileMod   time.Time
+	checkedAt time.Time
+}
+
+// newStaticPolicy builds the static backend; it returns nil unless backend is "static"
+func newStaticPolicy(backend string, config StaticPolicyConfig) (*staticPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case "", authzBackendKeycloak:
+		return nil, nil
+	case authzBackendStatic:
+	default:
+		return nil, fmt.Errorf("invalid authzBackend %q", backend)
+	}
+	if config.File == "" {
+		return nil, fmt.Errorf("authzBackend %q requires staticPolicy.file", authzBackendStatic)
+	}
+	sp := &staticPolicy{file: config.File, issuer: strings.TrimRight(config.Issuer, "/"), audience: config.Audience}
+	switch {
+	case config.PublicKeyFile != "" && (sp.issuer == "" || sp.audience == ""):
+		return nil, fmt.Errorf("staticPolicy.publicKeyFile requires staticPolicy.issuer and staticPolicy.audience")
+	case config.PublicKeyFile != "":
+		data, err := os.ReadFile(config.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("staticPolicy.publicKeyFile: %w", err)
+		}
+		if sp.keys, err = parsePublicKeys(data); err != nil {
+			return nil, fmt.Errorf("staticPolicy.publicKeyFile: %w", err)
+		}
+	case !config.InsecureSkipVerify:
+		return nil, fmt.Errorf("authzBackend %q requires staticPolicy.publicKeyFile to verify tokens (or insecureSkipVerify for development)", authzBackendStatic)
+	}
+	if err := sp.reload(); err != nil {
+		return nil, fmt.Errorf("staticPolicy.file: %w", err)
+	}
+	return sp, nil
+}
+
+// compileStaticPolicy validates and parses a policy
+func compileStaticPolicy(policy StaticPolicy) (*compiledPolicy, error) {
+	subjects, err := compilePolicyGrants("subjects", policy.Subjects)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := compilePolicyGrants("roles", policy.Roles)
+	if err != nil {
+		return nil, err
+	}
+	return &compiledPolicy{subjects: subjects, roles: roles}, nil
+}
+
+// compilePolicyGrants parses the grants of each subject or role
+func compilePolicyGrants(kind string, entries map[string][]string) (map[string][]policyGrant, error) {
+	compiled := make(map[string][]policyGrant, len(entries))
+	for name, grants := range entries {
+		for _, grant := range grants {
+			parsed, err := parsePolicyGrant(grant)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", kind, name, err)
+			}
+			compiled[name] = append(compiled[name], parsed)
+		}
+	}
+	return compiled, nil
+}
+
+// parsePolicyGrant parses "resource#scope", "resource" or "*"
+func parsePolicyGrant(grant string) (policyGrant, error) {
+	resource, scope := strings.TrimSpace(grant), "*"
+	if i := strings.Index(resource, "#"); i >= 0 {
+		resource, scope = strings.TrimSpace(resource[:i]), strings.TrimSpace(resource[i+1:])
+	}
+	if resource == "" || scope == "" {
+		return policyGrant{}, fmt.Errorf("invalid grant %q", grant)
+	}
+	return policyGrant{resource: resource, scope: scope}, nil
+}
+
+// reload re-reads the policy file when it changed. A broken file keeps the previous policy.
+func (sp *staticPolicy) reload() error {
+	info, err := os.Stat(sp.file)
+	if err != nil {
+		return err
+	}
+	if sp.policy != nil && info.ModTime().Equal(sp.fileMod) {
+		return nil
+	}
+	data, err := os.ReadFile(sp.file)
+	if err != nil {
+		return err
+	}
+	var policy StaticPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	compiled, err := compileStaticPolicy(policy)
+	if err != nil {
+		return err
+	}
+	sp.policy = compiled
+	sp.fileMod = info.ModTime()
+	return nil
+}
+
+// acceptsClaims checks that accessToken is a Keycloak access token ("typ" Bearer) issued by the
+// expected issuer for the expected audience, named in "aud" or as the authorized party "azp"
+func (sp *staticPolicy) acceptsClaims(accessToken string) (string, error) {
+	var claims struct {
+		Type            string          `json:"typ"`
+		Issuer          string          `json:"iss"`
+		Audience        json.RawMessage `json:"aud"`
+		AuthorizedParty string          `json:"azp"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return ReasonMalformedToken, err
+	}
+	if claims.Type != "Bearer" {
+		return ReasonWrongTokenType, fmt.Errorf("token type %q is not Bearer", claims.Type)
+	}
+	if sp.issuer != "" && strings.TrimRight(claims.Issuer, "/") != sp.issuer {
+		return ReasonInvalidToken, fmt.Errorf("issuer %q is not accepted", claims.Issuer)
+	}
+	if sp.audience == "" || claims.AuthorizedParty == sp.audience {
+		return "", nil
+	}
+	// "aud" is a string or an array of strings
+	var audiences []string
+	if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+		var audience string
+		_ = json.Unmarshal(claims.Audience, &audience)
+		audiences = []string{audience}
+	}
+	for _, audience := range audiences {
+		if audience == sp.audience {
+			return "", nil
+		}
+	}
+	return ReasonInvalidToken, fmt.Errorf("token is not issued for audience %q", sp.audience)
+}
+
+// allows reports whether any of the subjects or roles is granted permission
+func (sp *staticPolicy) allows(subjects, roles []string, permission Permission) bool {
+	sp.mu.Lock()
+	if now := time.Now(); now.Sub(sp.checkedAt) >= staticPolicyReloadInterval {
+		sp.checkedAt = now
+		if err := sp.reload(); err != nil {
+			fmt.Println("⚠️  [STATIC-POLICY] Could not reload policy file, keeping the previous policy:", err)
+		}
+	}
+	policy := sp.policy
+	sp.mu.Unlock()
+
+	for _, subject := range subjects {
+		if anyGrantMatches(policy.subjects[subject], permission) {
+			return true
+		}
+	}
+	for _, role := range roles {
+		if anyGrantMatches(policy.roles[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyGrantMatches reports whether any of grants covers permission
+func anyGrantMatches(grants []policyGrant, permission Permission) bool {
+	for _, grant := range grants {
+		if grant.matches(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenUsername returns the "preferred_username" claim of a JWT access token, or ""
+func tokenUsername(accessToken string) string {
+	var claims struct {
+		Username string `json:"preferred_username"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return ""
+	}
+	return claims.Username
+}
+
+// authorizeStatic decides the resolved permission from the static policy, without Keycloak
+func (am *AuthMiddleware) authorizeStatic(accessToken string, decision Decision) Decision {
+	decision.Backend = backendStatic
+	var subjects, roles []string
+	if decision.claims != nil {
+		subjects, roles = decision.claims["user"], decision.claims["groups"]
+	} else {
+		if am.staticPolicy.keys != nil {
+			if err := verifyJWTSignature(accessToken, am.staticPolicy.keys); err != nil {
+				am.log(logError, "❌ [STATIC-POLICY] Rejected token:", err)
+				decision.deny(ReasonInvalidToken, http.StatusUnauthorized)
+				return decision
+			}
+		}
+		if expiry := tokenExpiry(accessToken); expiry.IsZero() || !time.Now().Before(expiry) {
+			am.log(logError, "❌ [STATIC-POLICY] Token is expired or not a JWT")
+			decision.deny(rejectedTokenReason(&decision, accessToken), http.StatusUnauthorized)
+			return decision
+		}
+		if reason, err := am.staticPolicy.acceptsClaims(accessToken); err != nil {
+			am.log(logError, "❌ [STATIC-POLICY] Rejected token:", err)
+			decision.deny(reason, http.StatusUnauthorized)
+			return decision
+		}
+		for _, subject := range []string{tokenSubject(accessToken), tokenUsername(accessToken)} {
+			if subject != "" {
+				subjects = append(subjects, subject)
+			}
+		}
+		roles = tokenRoles(accessToken)
+	}
+	if !am.staticPolicy.allows(subjects, roles, decision.Permission) {
+		am.logf(logError, "❌ [STATIC-POLICY] No grant for %s#%s\n", decision.Permission.Resource, decision.Permission.Scope)
+		decision.deny(ReasonAccessDenied, http.StatusForbidden)
+		return decision
+	}
+	decision.Allowed = true
+	decision.Reason = ReasonGranted
+	return decision
+}
@@ -0,0 +1,185 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signedJWT returns an ES256 JWT of claims signed with key
+func signedJWT(t *testing.T, key *ecdsa.PrivateKey, claims string) string {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writePublicKey writes the PEM public key of key to a file and returns its path
+func writePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "issuer.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestStaticPolicyBackend(t *testing.T) {
+	issuer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attacker, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy := func(policy string, mod time.Time) {
+		if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(`{"subjects":{"alice":["user#get"]},"roles":{"ops":["order"],"gateway:admin":["*"]}}`, time.Now().Add(-time.Hour))
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		AuthzBackend:     "static",
+		StaticPolicy:     StaticPolicyConfig{File: file, PublicKeyFile: writePublicKey(t, issuer), Issuer: "https://sso.example.com/realms/acme", Audience: "gateway"},
+		DenyReasonHeader: "X-Authz-Reason",
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	const accepted = `"typ":"Bearer","iss":"https://sso.example.com/realms/acme","aud":["gateway","account"]`
+	claims := func(body string) string {
+		return signedJWT(t, issuer, fmt.Sprintf(`{"exp":%d,%s,%s}`, exp, accepted, body))
+	}
+	alice := claims(`"sub":"1f0c","preferred_username":"alice"`)
+	ops := claims(`"sub":"bob","realm_access":{"roles":["ops"]}`)
+	admin := claims(`"sub":"carol","resource_access":{"gateway":{"roles":["admin"]}}`)
+	expired := signedJWT(t, issuer, `{"sub":"alice","exp":1,`+accepted+`}`)
+	authorizedParty := signedJWT(t, issuer, fmt.Sprintf(`{"exp":%d,"typ":"Bearer","iss":"https://sso.example.com/realms/acme","aud":"account","azp":"gateway","sub":"carol","resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+	otherAudience := signedJWT(t, issuer, fmt.Sprintf(`{"exp":%d,"typ":"Bearer","iss":"https://sso.example.com/realms/acme","aud":"billing","azp":"billing","resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+	otherRealm := signedJWT(t, issuer, fmt.Sprintf(`{"exp":%d,"typ":"Bearer","iss":"https://sso.example.com/realms/other","aud":"gateway","resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+	idToken := signedJWT(t, issuer, fmt.Sprintf(`{"exp":%d,"typ":"ID","iss":"https://sso.example.com/realms/acme","aud":"gateway","resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+	forgedAdmin := jwtWithClaims(fmt.Sprintf(`{"exp":%d,"resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+	otherIssuer := signedJWT(t, attacker, fmt.Sprintf(`{"exp":%d,"resource_access":{"gateway":{"roles":["admin"]}}}`, exp))
+
+	serveStatic := func(accessToken, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	tests := []struct {
+		name        string
+		accessToken string
+		path        string
+		expected    int
+		reason      string
+	}{
+		{"subject grant", alice, "/api/v1/user/get", http.StatusOK, ""},
+		{"subject other scope", alice, "/api/v1/user/delete", http.StatusForbidden, ReasonAccessDenied},
+		{"role resource grant", ops, "/api/v1/order/cancel", http.StatusOK, ""},
+		{"role other resource", ops, "/api/v1/user/get", http.StatusForbidden, ReasonAccessDenied},
+		{"client role wildcard", admin, "/api/v1/anything/at-all", http.StatusOK, ""},
+		{"expired token", expired, "/api/v1/user/get", http.StatusUnauthorized, ReasonExpiredToken},
+		{"unsigned token", forgedAdmin, "/api/v1/anything/at-all", http.StatusUnauthorized, ReasonInvalidToken},
+		{"token of another issuer", otherIssuer, "/api/v1/anything/at-all", http.StatusUnauthorized, ReasonInvalidToken},
+		{"audience as authorized party", authorizedParty, "/api/v1/anything/at-all", http.StatusOK, ""},
+		{"token for another audience", otherAudience, "/api/v1/anything/at-all", http.StatusUnauthorized, ReasonInvalidToken},
+		{"token of another realm", otherRealm, "/api/v1/anything/at-all", http.StatusUnauthorized, ReasonInvalidToken},
+		{"id token", idToken, "/api/v1/anything/at-all", http.StatusUnauthorized, ReasonWrongTokenType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveStatic(test.accessToken, test.path)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if reason := recorder.Header().Get("X-Authz-Reason"); reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+		})
+	}
+
+	// Changes are picked up without a restart; broken files keep the previous policy
+	am := handler.(*AuthMiddleware)
+	writePolicy(`{"subjects":{"alice":["user#delete"]}}`, time.Now())
+	am.staticPolicy.checkedAt = time.Time{}
+	if recorder := serveStatic(alice, "/api/v1/user/delete"); recorder.Code != http.StatusOK {
+		t.Errorf("expected the reloaded policy to grant user#delete, got %d", recorder.Code)
+	}
+	writePolicy(`{"subjects":`, time.Now().Add(time.Minute))
+	am.staticPolicy.checkedAt = time.Time{}
+	if recorder := serveStatic(alice, "/api/v1/user/delete"); recorder.Code != http.StatusOK {
+		t.Errorf("expected a broken file to keep the previous policy, got %d", recorder.Code)
+	}
+}
+
+func TestStaticPolicyValidation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(file, []byte(`{"roles":{"ops":["#get"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(t.TempDir(), "valid.json")
+	if err := os.WriteFile(valid, []byte(`{"roles":{"ops":["order#get"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := writePublicKey(t, signer)
+	for _, config := range []Config{
+		{AuthzBackend: "opa"},
+		{AuthzBackend: "static"},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: file + ".missing"}},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: file, InsecureSkipVerify: true}},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid}},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid, PublicKeyFile: valid, Issuer: "https://sso.example.com/realms/acme", Audience: "gateway"}},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid, PublicKeyFile: key, Audience: "gateway"}},
+		{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid, PublicKeyFile: key, Issuer: "https://sso.example.com/realms/acme"}},
+	} {
+		if errs := config.validate(); len(errs) == 0 {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+	insecure := Config{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid, InsecureSkipVerify: true}}
+	if errs := insecure.validate(); len(errs) != 0 {
+		t.Errorf("expected insecureSkipVerify to allow unverified tokens, got %v", errs)
+	}
+	verified := Config{AuthzBackend: "static", StaticPolicy: StaticPolicyConfig{File: valid, PublicKeyFile: key, Issuer: "https://sso.example.com/realms/acme", Audience: "gateway"}}
+	if errs := verified.validate(); len(errs) != 0 {
+		t.Errorf("expected a verified configuration to be valid, got %v", errs)
+	}
+}
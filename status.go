@@ -0,0 +1,73 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Failure modes that can be used in StatusMapping.Error when Keycloak could not be reached at all
+const (
+	failureNetwork  = "network"
+	failureTimeout  = "timeout"
+	failureCanceled = "canceled" // the client went away or the middleware is shutting down
+)
+
+// StatusMapping maps a Keycloak response (or failure mode) to the status code returned to the client
+type StatusMapping struct {
+	KeycloakStatus int    `json:"keycloakStatus,omitempty"` // e.g. 400; 0 matches any status
+	Error          string `json:"error,omitempty"`          // e.g. "invalid_resource", "network", "timeout"; empty matches any error
+	Status         int    `json:"status,omitempty"`         // e.g. 404
+}
+
+// keycloakError is the OAuth2 error payload returned by the Keycloak token endpoint
+type keycloakError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// parseKeycloakError extracts the OAuth2 error code from a Keycloak response body, if any
+func parseKeycloakError(body []byte) keycloakError {
+	var kcErr keycloakError
+	_ = decodeFields(body, map[string]fieldDecoder{
+		"error":             decodeString(&kcErr.Error),
+		"error_description": decodeString(&kcErr.ErrorDescription),
+	})
+	return kcErr
+}
+
+// failureMode classifies a transport error returned while calling Keycloak
+func failureMode(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return failureCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return failureTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return failureTimeout
+	}
+	return failureNetwork
+}
+
+// mapStatus returns the client-facing status for a Keycloak status/error pair.
+// The first matching mapping wins; fallback is used when nothing matches.
+func (rc *runtimeConfig) mapStatus(keycloakStatus int, errorCode string, fallback int) int {
+	for _, m := range rc.statusMappings {
+		if m.KeycloakStatus != 0 && m.KeycloakStatus != keycloakStatus {
+			continue
+		}
+		if m.Error != "" && m.Error != errorCode {
+			continue
+		}
+		return m.Status
+	}
+	return fallback
+}
+
+// writeStatus writes a plain error response for the given status code
+func writeStatus(w http.ResponseWriter, status int) {
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,91 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newKeycloakStub starts a fake Keycloak token endpoint answering with the given status and body
+func newKeycloakStub(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_, _ = rw.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// serve runs a single request through a middleware built from config and returns the recorded response
+func serve(t *testing.T, config *Config, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestStatusMapping(t *testing.T) {
+	mappings := []StatusMapping{
+		{KeycloakStatus: http.StatusBadRequest, Error: "invalid_resource", Status: http.StatusNotFound},
+		{KeycloakStatus: http.StatusForbidden, Status: http.StatusForbidden},
+		{KeycloakStatus: http.StatusServiceUnavailable, Status: http.StatusServiceUnavailable},
+		{Error: failureNetwork, Status: http.StatusBadGateway},
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected int
+	}{
+		{"granted", http.StatusOK, `{"access_token":"rpt"}`, http.StatusOK},
+		{"invalid resource", http.StatusBadRequest, `{"error":"invalid_resource"}`, http.StatusNotFound},
+		{"other bad request", http.StatusBadRequest, `{"error":"invalid_scope"}`, http.StatusUnauthorized},
+		{"access denied", http.StatusForbidden, `{"error":"access_denied"}`, http.StatusForbidden},
+		{"unavailable", http.StatusServiceUnavailable, ``, http.StatusServiceUnavailable},
+		{"unmapped", http.StatusInternalServerError, ``, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newKeycloakStub(t, test.status, test.body)
+			recorder := serve(t, &Config{KeycloakURL: srv.URL, StatusMappings: mappings}, "/api/v1/user/get")
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestStatusMappingNetworkFailure(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, "")
+	srv.Close()
+
+	config := &Config{
+		KeycloakURL:    srv.URL,
+		StatusMappings: []StatusMapping{{Error: failureNetwork, Status: http.StatusBadGateway}},
+	}
+	recorder := serve(t, config, "/api/v1/user/get")
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("expected %d, got %d", http.StatusBadGateway, recorder.Code)
+	}
+}
+
+func TestStatusMappingInvalidConfig(t *testing.T) {
+	config := &Config{StatusMappings: []StatusMapping{{KeycloakStatus: 400, Status: 42}}}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := New(context.Background(), next, config, "AuthMiddleware"); err == nil {
+		t.Error("expected error for invalid status")
+	}
+}
@@ -0,0 +1,35 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// checkStrictPath rejects paths that the backend may parse differently from this middleware. The
+// permission is derived from the decoded path, so any disagreement about segment boundaries is a bypass:
+// encoded slashes and backslashes, backslashes, null bytes, double encoding and dot segments.
+func checkStrictPath(req *http.Request) error {
+	raw := strings.ToLower(req.URL.EscapedPath())
+	for _, encoded := range []string{"%2f", "%5c", "%00"} {
+		if strings.Contains(raw, encoded) {
+			return fmt.Errorf("path contains encoded character %q", strings.ToUpper(encoded))
+		}
+	}
+
+	path := req.URL.Path
+	switch {
+	case strings.ContainsRune(path, 0):
+		return fmt.Errorf("path contains a null byte")
+	case strings.Contains(path, "\\"):
+		return fmt.Errorf("path contains a backslash")
+	case strings.Contains(path, "%"):
+		return fmt.Errorf("path contains a double-encoded character")
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("path contains a dot segment")
+		}
+	}
+	return nil
+}
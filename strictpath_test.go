@@ -0,0 +1,71 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pathConfusionVectors are request targets that proxies, routers and application servers are known to
+// split into segments differently
+var pathConfusionVectors = []string{
+	"/api/v1/user%2Fadmin/get",
+	"/api/v1/user%2fadmin/get",
+	"/api/v1/user%5Cadmin/get",
+	"/api/v1/user\\admin/get",
+	"/api/v1/user%00/get",
+	"/api/v1/user%252Fadmin/get",
+	"/api/v1/public/../admin/delete",
+	"/api/v1/./user/get",
+	"/api/v1/public/%2e%2e/admin/delete",
+	"/api/v1/public/.%2e/admin/delete",
+}
+
+func TestStrictPathsRejectsConfusionVectors(t *testing.T) {
+	keycloakCalled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keycloakCalled = true
+		_, _ = rw.Write([]byte(`{"access_token":"rpt"}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL, StrictPaths: true}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range pathConfusionVectors {
+		t.Run(target, func(t *testing.T) {
+			keycloakCalled = false
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+target, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("expected %d, got %d", http.StatusBadRequest, recorder.Code)
+			}
+			if keycloakCalled {
+				t.Error("Keycloak must not be called for a rejected path")
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get?redirect=%2Fhome", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected clean path to be allowed, got %d", recorder.Code)
+	}
+}
+
+func TestCheckStrictPathAllowsCleanPaths(t *testing.T) {
+	for _, target := range []string{"/", "/api/v1/user/get", "/api/v1/user/get/", "/files/report.v2.pdf", "/a/..b/c"} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway"+target, nil)
+		if err := checkStrictPath(req); err != nil {
+			t.Errorf("%s: unexpected error: %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package authztraefikgateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SubjectHashConfig replaces the subject fingerprint of audit records and recent denials with a salted
+// hash. Plain fingerprints are unsalted SHA-256 digests that can be reversed by hashing known user names
+// or emails; salted hashes can only be correlated by whoever holds the salt.
+type SubjectHashConfig struct {
+	Salt string `json:"salt,omitempty"` // secret mixed into every hash; enables the mode
+	// Rotation derives a new hash per period, e.g. "720h": a subject's records correlate within a
+	// period but not across periods. Periods are aligned on the Unix epoch. Default: never rotate.
+	Rotation string `json:"rotation,omitempty"`
+}
+
+// subjectHasher computes salted subject hashes
+type subjectHasher struct {
+	salt     []byte
+	rotation time.Duration
+}
+
+// newSubjectHasher builds the subject hasher; it returns nil when no salt is configured
+func newSubjectHasher(config SubjectHashConfig) (*subjectHasher, error) {
+	if config.Salt == "" {
+		if config.Rotation != "" {
+			return nil, fmt.Errorf("subjectHash.rotation requires subjectHash.salt")
+		}
+		return nil, nil
+	}
+	rotation, err := parseDurationOrDefault(config.Rotation, 0)
+	if err != nil {
+		return nil, fmt.Errorf("subjectHash.rotation: %w", err)
+	}
+	return &subjectHasher{salt: []byte(config.Salt), rotation: rotation}, nil
+}
+
+// hash returns the base64url HMAC-SHA256 of subject keyed by the salt, for the period containing at
+func (sh *subjectHasher) hash(subject string, at time.Time) string {
+	mac := hmac.New(sha256.New, sh.salt)
+	if sh.rotation > 0 {
+		mac.Write([]byte(strconv.FormatInt(at.UnixNano()/int64(sh.rotation), 10)))
+		mac.Write([]byte{0})
+	}
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// recordedSubject returns the subject identifier written to audit records and recent denials: the
+// salted hash of the subject when subjectHash is configured, otherwise its fingerprint
+func (am *AuthMiddleware) recordedSubject(d Decision, at time.Time) string {
+	if am.subjectHasher == nil || d.subject == "" {
+		return d.SubjectFingerprint
+	}
+	return am.subjectHasher.hash(d.subject, at)
+}
@@ -0,0 +1,67 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubjectHash(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	config := &Config{KeycloakURL: srv.URL, AuditFile: auditFile, SubjectHash: SubjectHashConfig{Salt: "pepper"}}
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []string{"alice", "alice", "bob"} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+jwtWithClaims(`{"sub":"`+subject+`"}`))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	file, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := ReadAuditRecords(file)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("unexpected audit records %+v (%v)", records, err)
+	}
+	if records[0].SubjectFingerprint == "" || records[0].SubjectFingerprint == SubjectFingerprint("alice") {
+		t.Errorf("expected a salted subject hash, got %q", records[0].SubjectFingerprint)
+	}
+	if records[0].SubjectFingerprint != records[1].SubjectFingerprint || records[0].SubjectFingerprint == records[2].SubjectFingerprint {
+		t.Errorf("expected hashes to correlate per subject, got %+v", records)
+	}
+
+	other, _ := newSubjectHasher(SubjectHashConfig{Salt: "salt"})
+	if other.hash("alice", time.Now()) == records[0].SubjectFingerprint {
+		t.Error("expected the hash to depend on the salt")
+	}
+}
+
+func TestSubjectHashRotation(t *testing.T) {
+	sh, err := newSubjectHasher(SubjectHashConfig{Salt: "pepper", Rotation: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	if sh.hash("alice", day) != sh.hash("alice", day.Add(23*time.Hour)) {
+		t.Error("expected the hash to be stable within a period")
+	}
+	if sh.hash("alice", day) == sh.hash("alice", day.Add(24*time.Hour)) {
+		t.Error("expected the hash to change with the period")
+	}
+
+	for _, invalid := range []SubjectHashConfig{{Rotation: "24h"}, {Salt: "pepper", Rotation: "daily"}, {Salt: "pepper", Rotation: "-1h"}} {
+		if _, err := newSubjectHasher(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
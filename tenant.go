@@ -0,0 +1,82 @@
+package authztraefikgateway
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultTenantClaim is the claim Keycloak Organizations adds to tokens
+const defaultTenantClaim = "organization"
+
+// TenantConfig rejects tokens of another tenant locally, before any Keycloak call. The tenant of the
+// request is the host "<tenant>.<HostSuffix>", which Header must agree with when present, or else
+// Header; requests whose tenant cannot be determined are rejected.
+type TenantConfig struct {
+	Header     string `json:"header,omitempty"`     // e.g. "X-Tenant"
+	HostSuffix string `json:"hostSuffix,omitempty"` // e.g. "example.com" for acme.example.com
+	Claim      string `json:"claim,omitempty"`      // token claim listing the user's tenants, dotted for nested claims (default "organization")
+}
+
+// tenantCheck is the prepared TenantConfig
+type tenantCheck struct {
+	header     string
+	hostSuffix string
+	claim      []string
+}
+
+// newTenantCheck prepares the tenant check; it returns nil when no tenant source is configured
+func newTenantCheck(config TenantConfig) *tenantCheck {
+	header := http.CanonicalHeaderKey(strings.TrimSpace(config.Header))
+	hostSuffix := strings.ToLower(strings.Trim(strings.TrimSpace(config.HostSuffix), "."))
+	if header == "" && hostSuffix == "" {
+		return nil
+	}
+	claim := strings.TrimSpace(config.Claim)
+	if claim == "" {
+		claim = defaultTenantClaim
+	}
+	return &tenantCheck{header: header, hostSuffix: hostSuffix, claim: strings.Split(claim, ".")}
+}
+
+// tenant returns the tenant the request is addressed to. With a host suffix the host decides and the
+// header, which the client controls, must agree with it. ok is false when the tenant cannot be
+// determined or the sources disagree.
+func (tc *tenantCheck) tenant(req *http.Request) (tenant string, ok bool) {
+	var header string
+	if tc.header != "" {
+		header = strings.TrimSpace(req.Header.Get(tc.header))
+	}
+	if tc.hostSuffix == "" {
+		return header, header != ""
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	tenant = strings.TrimSuffix(host, "."+tc.hostSuffix)
+	if tenant == host || tenant == "" || strings.Contains(tenant, ".") {
+		return "", false
+	}
+	if header != "" && !strings.EqualFold(header, tenant) {
+		return "", false
+	}
+	return tenant, true
+}
+
+// tokenTenants returns the tenants listed in the token claim. The claim may be a string, an array of
+// strings, or an object keyed by tenant (Keycloak organizations with attributes).
+func (tc *tenantCheck) tokenTenants(accessToken string) []string {
+	return tokenClaimValues(accessToken, tc.claim)
+}
+
+// allows reports whether accessToken belongs to tenant
+func (tc *tenantCheck) allows(accessToken, tenant string) bool {
+	for _, t := range tc.tokenTenants(accessToken) {
+		if strings.EqualFold(t, tenant) {
+			return true
+		}
+	}
+	return false
+}
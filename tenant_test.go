@@ -0,0 +1,86 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantCheck(t *testing.T) {
+	keycloakCalls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keycloakCalls++
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{KeycloakURL: srv.URL, Tenant: TenantConfig{Header: "X-Tenant", HostSuffix: "example.com"}}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := jwtWithClaims(`{"sub":"alice","organization":["acme"]}`)
+	acmeWithAttributes := jwtWithClaims(`{"sub":"alice","organization":{"acme":{"id":"42"}}}`)
+	globex := jwtWithClaims(`{"sub":"bob","organization":"globex"}`)
+	noOrg := jwtWithClaims(`{"sub":"carol"}`)
+
+	tests := []struct {
+		name        string
+		host        string
+		tenant      string
+		accessToken string
+		expected    int
+	}{
+		{"host match", "acme.example.com", "", acme, http.StatusOK},
+		{"host match with attributes", "ACME.example.com:8443", "", acmeWithAttributes, http.StatusOK},
+		{"host mismatch", "acme.example.com", "", globex, http.StatusForbidden},
+		{"header agrees with host", "acme.example.com", "ACME", acme, http.StatusOK},
+		{"header disagrees with host", "acme.example.com", "globex", globex, http.StatusForbidden},
+		{"no tenant claim", "acme.example.com", "", noOrg, http.StatusForbidden},
+		{"no tenant resolved", "gateway", "", noOrg, http.StatusForbidden},
+		{"header without tenant host", "gateway", "globex", globex, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keycloakCalls = 0
+			req := httptest.NewRequest(http.MethodGet, "http://"+test.host+"/api/v1/user/get", nil)
+			req.Header.Set("Authorization", "Bearer "+test.accessToken)
+			if test.tenant != "" {
+				req.Header.Set("X-Tenant", test.tenant)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			if test.expected == http.StatusForbidden && keycloakCalls != 0 {
+				t.Error("Keycloak must not be called for a tenant mismatch")
+			}
+		})
+	}
+}
+
+func TestTenantHeaderOnly(t *testing.T) {
+	tc := newTenantCheck(TenantConfig{Header: "X-Tenant"})
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/", nil)
+	if _, ok := tc.tenant(req); ok {
+		t.Error("expected a request without the header to have no tenant")
+	}
+	req.Header.Set("X-Tenant", "acme")
+	if tenant, ok := tc.tenant(req); !ok || tenant != "acme" {
+		t.Errorf("expected tenant acme from the header, got %q", tenant)
+	}
+}
+
+func TestTenantNestedClaim(t *testing.T) {
+	tc := newTenantCheck(TenantConfig{Header: "X-Tenant", Claim: "attributes.tenant"})
+	if !tc.allows(jwtWithClaims(`{"attributes":{"tenant":["acme","initech"]}}`), "initech") {
+		t.Error("expected nested claim to match")
+	}
+	if tc.allows(jwtWithClaims(`{"attributes":"acme"}`), "acme") {
+		t.Error("expected a non-object parent claim not to match")
+	}
+}
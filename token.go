@@ -0,0 +1,249 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Token source types usable in TokenSource.Type
+const (
+	tokenSourceBearer = "bearer" // "Authorization: Bearer <token>" (or Name: "Bearer <token>")
+	tokenSourceHeader = "header" // raw token in a custom header
+	tokenSourceCookie = "cookie"
+	tokenSourceQuery  = "query"
+)
+
+// TokenSource configures one place the access token may be read from
+type TokenSource struct {
+	Type string `json:"type,omitempty"` // "bearer", "header", "cookie" or "query"
+	Name string `json:"name,omitempty"` // header, cookie or query parameter name
+}
+
+// TokenExtractor extracts the access token from an incoming request
+type TokenExtractor interface {
+	// Extract returns the raw token and whether one was found
+	Extract(req *http.Request) (string, bool)
+}
+
+// TokenRemover is implemented by extractors that can remove the token they extract from a request,
+// so that it does not reach the upstream when another token is forwarded instead
+type TokenRemover interface {
+	Remove(req *http.Request)
+}
+
+// BearerTokenExtractor reads a "Bearer <token>" value from a header (Authorization by default)
+type BearerTokenExtractor struct {
+	Header string
+}
+
+// Extract implements TokenExtractor
+func (e BearerTokenExtractor) Extract(req *http.Request) (string, bool) {
+	name := e.Header
+	if name == "" {
+		name = "Authorization"
+	}
+	value := strings.TrimSpace(req.Header.Get(name))
+	if len(value) < 7 || !strings.EqualFold(value[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(value[7:])
+	return token, token != ""
+}
+
+// Remove implements TokenRemover
+func (e BearerTokenExtractor) Remove(req *http.Request) {
+	name := e.Header
+	if name == "" {
+		name = "Authorization"
+	}
+	req.Header.Del(name)
+}
+
+// HeaderTokenExtractor reads the raw token from a custom header
+type HeaderTokenExtractor struct {
+	Header string
+}
+
+// Extract implements TokenExtractor
+func (e HeaderTokenExtractor) Extract(req *http.Request) (string, bool) {
+	token := strings.TrimSpace(req.Header.Get(e.Header))
+	return token, token != ""
+}
+
+// Remove implements TokenRemover
+func (e HeaderTokenExtractor) Remove(req *http.Request) {
+	req.Header.Del(e.Header)
+}
+
+// CookieTokenExtractor reads the token from a cookie
+type CookieTokenExtractor struct {
+	Cookie string
+}
+
+// Extract implements TokenExtractor
+func (e CookieTokenExtractor) Extract(req *http.Request) (string, bool) {
+	cookie, err := req.Cookie(e.Cookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// Remove implements TokenRemover, keeping the other cookies
+func (e CookieTokenExtractor) Remove(req *http.Request) {
+	var kept []string
+	for _, cookie := range req.Cookies() {
+		if cookie.Name != e.Cookie {
+			kept = append(kept, cookie.String())
+		}
+	}
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// QueryTokenExtractor reads the token from a query parameter
+type QueryTokenExtractor struct {
+	Param string
+}
+
+// Extract implements TokenExtractor
+func (e QueryTokenExtractor) Extract(req *http.Request) (string, bool) {
+	token := req.URL.Query().Get(e.Param)
+	return token, token != ""
+}
+
+// Remove implements TokenRemover, keeping the other query parameters
+func (e QueryTokenExtractor) Remove(req *http.Request) {
+	query := req.URL.Query()
+	query.Del(e.Param)
+	req.URL.RawQuery = query.Encode()
+	req.RequestURI = req.URL.RequestURI()
+}
+
+// newTokenExtractors builds the ordered extractor chain from config; the default is the Authorization bearer header
+func newTokenExtractors(sources []TokenSource) ([]TokenExtractor, error) {
+	if len(sources) == 0 {
+		return []TokenExtractor{BearerTokenExtractor{}}, nil
+	}
+
+	extractors := make([]TokenExtractor, 0, len(sources))
+	for _, source := range sources {
+		switch strings.ToLower(source.Type) {
+		case tokenSourceBearer:
+			extractors = append(extractors, BearerTokenExtractor{Header: source.Name})
+		case tokenSourceHeader:
+			if source.Name == "" {
+				return nil, fmt.Errorf("token source %q requires a name", source.Type)
+			}
+			extractors = append(extractors, HeaderTokenExtractor{Header: source.Name})
+		case tokenSourceCookie:
+			if source.Name == "" {
+				return nil, fmt.Errorf("token source %q requires a name", source.Type)
+			}
+			extractors = append(extractors, CookieTokenExtractor{Cookie: source.Name})
+		case tokenSourceQuery:
+			if source.Name == "" {
+				return nil, fmt.Errorf("token source %q requires a name", source.Type)
+			}
+			extractors = append(extractors, QueryTokenExtractor{Param: source.Name})
+		default:
+			return nil, fmt.Errorf("unknown token source type %q", source.Type)
+		}
+	}
+	return extractors, nil
+}
+
+// tokenQueryParams returns the query parameters the extractors read tokens from
+func tokenQueryParams(extractors []TokenExtractor) []string {
+	var params []string
+	for _, extractor := range extractors {
+		if e, ok := extractor.(QueryTokenExtractor); ok {
+			params = append(params, e.Param)
+		}
+	}
+	return params
+}
+
+// tokenHeaders returns the request headers the extractors read tokens from
+func tokenHeaders(extractors []TokenExtractor) []string {
+	var headers []string
+	for _, extractor := range extractors {
+		switch e := extractor.(type) {
+		case BearerTokenExtractor:
+			if e.Header == "" {
+				headers = append(headers, "Authorization")
+			} else {
+				headers = append(headers, e.Header)
+			}
+		case HeaderTokenExtractor:
+			headers = append(headers, e.Header)
+		}
+	}
+	return headers
+}
+
+// extractToken returns the first token found by the configured extractors, and the extractor that found it
+func (am *AuthMiddleware) extractToken(req *http.Request) (string, TokenExtractor, bool) {
+	for _, extractor := range am.tokenExtractors {
+		if token, ok := extractor.Extract(req); ok {
+			return token, extractor, true
+		}
+	}
+	return "", nil, false
+}
+
+// malformedCredentials reports whether the request carries a bearer header of the configured sources
+// that holds no usable token, e.g. "Authorization: Basic ..." or an empty "Bearer"
+func (am *AuthMiddleware) malformedCredentials(req *http.Request) bool {
+	for _, extractor := range am.tokenExtractors {
+		if bearer, ok := extractor.(BearerTokenExtractor); ok {
+			name := bearer.Header
+			if name == "" {
+				name = "Authorization"
+			}
+			if strings.TrimSpace(req.Header.Get(name)) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tokenMalformed reports whether a JWT-shaped token (one containing dots) has a header or payload that
+// does not decode. Opaque tokens are left to Keycloak.
+func tokenMalformed(accessToken string) bool {
+	if !strings.Contains(accessToken, ".") {
+		return false
+	}
+	var header, claims struct{}
+	return decodeJWTHeader(accessToken, &header) != nil || decodeJWTPayload(accessToken, &claims) != nil
+}
+
+// tokenExpired reports whether a JWT access token has an "exp" claim that is not after now
+func tokenExpired(accessToken string, now time.Time) bool {
+	expiry := tokenExpiry(accessToken)
+	return !expiry.IsZero() && !now.Before(expiry)
+}
+
+// bearerChallenge renders an RFC 6750 challenge; missing tokens get one without error code
+func bearerChallenge(errorCode, description string) string {
+	if errorCode == "" {
+		return "Bearer"
+	}
+	return fmt.Sprintf(`Bearer error="%s", error_description="%s"`, errorCode, description)
+}
+
+// rejectedTokenReason refines the reason of a token rejected by Keycloak or the static policy:
+// expired_token when its "exp" has passed, invalid_token otherwise. It also sets the challenge.
+func rejectedTokenReason(decision *Decision, accessToken string) string {
+	if tokenExpired(accessToken, time.Now()) {
+		decision.challenge = bearerChallenge("invalid_token", "The access token expired")
+		return ReasonExpiredToken
+	}
+	decision.challenge = bearerChallenge("invalid_token", "The access token is invalid")
+	return ReasonInvalidToken
+}
@@ -0,0 +1,143 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	sources := []TokenSource{
+		{Type: "header", Name: "X-Api-Token"},
+		{Type: "cookie", Name: "access_token"},
+		{Type: "query", Name: "token"},
+		{Type: "bearer"},
+	}
+	extractors, err := newTokenExtractors(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := &AuthMiddleware{tokenExtractors: extractors}
+
+	tests := []struct {
+		name     string
+		prepare  func(req *http.Request)
+		expected string
+	}{
+		{"custom header", func(req *http.Request) { req.Header.Set("X-Api-Token", "from-header") }, "from-header"},
+		{"cookie", func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "access_token", Value: "from-cookie"}) }, "from-cookie"},
+		{"query", func(req *http.Request) { req.URL.RawQuery = "token=from-query" }, "from-query"},
+		{"bearer", func(req *http.Request) { req.Header.Set("Authorization", "bearer from-bearer") }, "from-bearer"},
+		{"order", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer from-bearer")
+			req.AddCookie(&http.Cookie{Name: "access_token", Value: "from-cookie"})
+		}, "from-cookie"},
+		{"basic auth is ignored", func(req *http.Request) { req.Header.Set("Authorization", "Basic dXNlcjpwYXNz") }, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			test.prepare(req)
+			got, source, ok := am.extractToken(req)
+			if got != test.expected || ok != (test.expected != "") {
+				t.Errorf("expected %q, got %q (%v)", test.expected, got, ok)
+			}
+			if !ok {
+				return
+			}
+			source.(TokenRemover).Remove(req)
+			if token, found := source.Extract(req); found {
+				t.Errorf("expected the token to be removed, still found %q", token)
+			}
+		})
+	}
+}
+
+func TestTokenSourceValidation(t *testing.T) {
+	for _, sources := range [][]TokenSource{
+		{{Type: "cookie"}},
+		{{Type: "carrier-pigeon", Name: "x"}},
+	} {
+		if _, err := newTokenExtractors(sources); err == nil {
+			t.Errorf("expected error for %+v", sources)
+		}
+	}
+}
+
+func TestTokenForwardedAsBearer(t *testing.T) {
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	config := &Config{KeycloakURL: srv.URL, TokenSources: []TokenSource{{Type: "header", Name: "X-Api-Token"}}}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+	req.Header.Set("X-Api-Token", "opaque")
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || authorization != "Bearer opaque" {
+		t.Errorf("expected token forwarded as bearer, got %d / %q", recorder.Code, authorization)
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	rejected := newKeycloakStub(t, http.StatusUnauthorized, `{"error":"invalid_grant"}`)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(context.Background(), next, &Config{KeycloakURL: rejected.URL, DenyReasonHeader: "X-Authz-Reason"}, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		reason        string
+		challenge     string
+	}{
+		{"no token", "", ReasonMissingToken, "Bearer"},
+		{"not a bearer credential", "Basic YWxpY2U6c2VjcmV0", ReasonMalformedToken, `Bearer error="invalid_request", error_description="The Authorization header must hold a Bearer token"`},
+		{"undecodable JWT", "Bearer eyJhbGciOi.not-json.sig", ReasonMalformedToken, `Bearer error="invalid_token", error_description="The access token is malformed"`},
+		{"expired token", "Bearer " + token, ReasonExpiredToken, `Bearer error="invalid_token", error_description="The access token expired"`},
+		{"rejected opaque token", "Bearer opaque", ReasonInvalidToken, `Bearer error="invalid_token", error_description="The access token is invalid"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", recorder.Code)
+			}
+			if reason := recorder.Header().Get("X-Authz-Reason"); reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+			if challenge := recorder.Header().Get("WWW-Authenticate"); challenge != test.challenge {
+				t.Errorf("expected challenge %q, got %q", test.challenge, challenge)
+			}
+		})
+	}
+
+	var metrics strings.Builder
+	if err := handler.(*AuthMiddleware).WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []string{`reason="missing_token"} 1`, `reason="malformed_token"} 2`, `reason="expired_token"} 1`, `reason="invalid_token"} 1`} {
+		if !strings.Contains(metrics.String(), sample) {
+			t.Errorf("expected %q in metrics:\n%s", sample, metrics.String())
+		}
+	}
+}
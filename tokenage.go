@@ -0,0 +1,31 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"time"
+)
+
+// tokenAuthTime returns when the user authenticated: the "auth_time" claim, else "iat". It returns the
+// zero time for opaque tokens or tokens carrying neither claim.
+func tokenAuthTime(accessToken string) time.Time {
+	var claims struct {
+		AuthTime int64 `json:"auth_time"`
+		IssuedAt int64 `json:"iat"`
+	}
+	if err := decodeJWTPayload(accessToken, &claims); err != nil {
+		return time.Time{}
+	}
+	switch {
+	case claims.AuthTime > 0:
+		return time.Unix(claims.AuthTime, 0)
+	case claims.IssuedAt > 0:
+		return time.Unix(claims.IssuedAt, 0)
+	}
+	return time.Time{}
+}
+
+// stepUpChallenge renders the RFC 9470 challenge asking the client to re-authenticate within maxAge
+func stepUpChallenge(maxAge time.Duration) string {
+	return fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age="%d"`,
+		int64(maxAge/time.Second))
+}
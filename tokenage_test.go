@@ -0,0 +1,63 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRuleMaxTokenAge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	config := &Config{
+		KeycloakURL: srv.URL,
+		Rules:       []Rule{{Prefix: "/admin", Resolver: "static", Resource: "admin", Scope: "manage", MaxTokenAge: "5m"}},
+	}
+	handler, err := New(context.Background(), next, config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	fresh := jwtWithClaims(fmt.Sprintf(`{"iat":%d}`, now.Add(-time.Minute).Unix()))
+	reauthenticated := jwtWithClaims(fmt.Sprintf(`{"iat":%d,"auth_time":%d}`, now.Add(-time.Hour).Unix(), now.Add(-time.Minute).Unix()))
+	refreshed := jwtWithClaims(fmt.Sprintf(`{"iat":%d,"auth_time":%d}`, now.Add(-time.Minute).Unix(), now.Add(-time.Hour).Unix()))
+	stale := jwtWithClaims(fmt.Sprintf(`{"iat":%d}`, now.Add(-time.Hour).Unix()))
+
+	tests := []struct {
+		name        string
+		path        string
+		accessToken string
+		expected    int
+	}{
+		{"fresh iat", "/admin/users", fresh, http.StatusOK},
+		{"recent auth_time", "/admin/users", reauthenticated, http.StatusOK},
+		{"refreshed token of an old session", "/admin/users", refreshed, http.StatusUnauthorized},
+		{"stale", "/admin/users", stale, http.StatusUnauthorized},
+		{"opaque token", "/admin/users", token, http.StatusUnauthorized},
+		{"rule without limit", "/api/v1/user/get", stale, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway"+test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.accessToken)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, recorder.Code)
+			}
+			challenge := recorder.Header().Get("WWW-Authenticate")
+			if test.expected == http.StatusUnauthorized && (!strings.Contains(challenge, "insufficient_user_authentication") || !strings.Contains(challenge, `max_age="300"`)) {
+				t.Errorf("expected a step-up challenge, got %q", challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Token type policies
+const (
+	tokenTypeOff     = "off"     // default: any token is sent to Keycloak
+	tokenTypeWarn    = "warn"    // log tokens that are not access tokens
+	tokenTypeEnforce = "enforce" // reject tokens that are not access tokens
+)
+
+// Token uses detected by classifyToken
+const (
+	tokenUseAccess  = "access"
+	tokenUseID      = "id"
+	tokenUseRefresh = "refresh"
+	tokenUseOther   = "other"
+	tokenUseOpaque  = "opaque" // not a JWT; Keycloak decides
+)
+
+// defaultAccessTokenTypes are the "typ" values of access tokens: Keycloak's payload claim and RFC 9068's header
+var defaultAccessTokenTypes = []string{"Bearer", "at+jwt", "application/at+jwt"}
+
+// TokenTypeConfig checks that the presented token is an access token and not an ID or refresh token
+type TokenTypeConfig struct {
+	Policy       string   `json:"policy,omitempty"`       // "off" (default), "warn" or "enforce"
+	AllowedTypes []string `json:"allowedTypes,omitempty"` // "typ" values accepted as access tokens (default Bearer, at+jwt, application/at+jwt)
+}
+
+// tokenTypeCheck is the prepared TokenTypeConfig
+type tokenTypeCheck struct {
+	enforce bool
+	allowed map[string]bool
+}
+
+// newTokenTypeCheck prepares the token type check; it returns nil when the policy is off
+func newTokenTypeCheck(config TokenTypeConfig) (*tokenTypeCheck, error) {
+	tc := &tokenTypeCheck{}
+	switch strings.ToLower(config.Policy) {
+	case "", tokenTypeOff:
+		return nil, nil
+	case tokenTypeWarn:
+	case tokenTypeEnforce:
+		tc.enforce = true
+	default:
+		return nil, fmt.Errorf("invalid tokenType.policy %q (expected off, warn or enforce)", config.Policy)
+	}
+	types := config.AllowedTypes
+	if len(types) == 0 {
+		types = defaultAccessTokenTypes
+	}
+	tc.allowed = make(map[string]bool, len(types))
+	for _, typ := range types {
+		tc.allowed[strings.ToLower(typ)] = true
+	}
+	return tc, nil
+}
+
+// classify guesses what kind of token accessToken is from its header "typ", its "typ" or "token_use"
+// claim, and finally the claims only ID tokens carry
+func (tc *tokenTypeCheck) classify(accessToken string) string {
+	var header struct {
+		Type string `json:"typ"`
+	}
+	var claims struct {
+		Type     string `json:"typ"`
+		TokenUse string `json:"token_use"`
+		AtHash   string `json:"at_hash"`
+		Nonce    string `json:"nonce"`
+	}
+	if decodeJWTHeader(accessToken, &header) != nil || decodeJWTPayload(accessToken, &claims) != nil {
+		return tokenUseOpaque
+	}
+
+	if tc.allowed[strings.ToLower(header.Type)] {
+		return tokenUseAccess
+	}
+	switch typ := strings.ToLower(claims.Type); {
+	case typ == "":
+	case tc.allowed[typ]:
+		return tokenUseAccess
+	case typ == "id" || typ == "idtoken":
+		return tokenUseID
+	case typ == "refresh" || typ == "offline":
+		return tokenUseRefresh
+	default:
+		return tokenUseOther
+	}
+	switch strings.ToLower(claims.TokenUse) {
+	case "access":
+		return tokenUseAccess
+	case "id":
+		return tokenUseID
+	case "refresh":
+		return tokenUseRefresh
+	}
+	if claims.AtHash != "" || claims.Nonce != "" {
+		return tokenUseID
+	}
+	return tokenUseAccess
+}
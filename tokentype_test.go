@@ -0,0 +1,74 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// jwtWithHeader builds an unsigned compact JWT with the given JSON header and claims
+func jwtWithHeader(header, claims string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestClassifyToken(t *testing.T) {
+	tc, err := newTokenTypeCheck(TokenTypeConfig{Policy: tokenTypeEnforce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"keycloak access token", jwtWithClaims(`{"typ":"Bearer","azp":"app"}`), tokenUseAccess},
+		{"keycloak id token", jwtWithClaims(`{"typ":"ID","azp":"app","nonce":"n"}`), tokenUseID},
+		{"keycloak refresh token", jwtWithClaims(`{"typ":"Refresh"}`), tokenUseRefresh},
+		{"keycloak offline token", jwtWithClaims(`{"typ":"Offline"}`), tokenUseRefresh},
+		{"logout token", jwtWithClaims(`{"typ":"Logout"}`), tokenUseOther},
+		{"rfc 9068 header", jwtWithHeader(`{"alg":"RS256","typ":"at+jwt"}`, `{"sub":"a"}`), tokenUseAccess},
+		{"token_use access", jwtWithClaims(`{"token_use":"access"}`), tokenUseAccess},
+		{"token_use id", jwtWithClaims(`{"token_use":"id"}`), tokenUseID},
+		{"at_hash heuristic", jwtWithClaims(`{"sub":"a","at_hash":"x"}`), tokenUseID},
+		{"no evidence", jwtWithClaims(`{"sub":"a"}`), tokenUseAccess},
+		{"opaque", "not-a-jwt", tokenUseOpaque},
+	}
+	for _, test := range tests {
+		if got := tc.classify(test.token); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTokenTypePolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	idToken := jwtWithClaims(`{"typ":"ID"}`)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	for policy, expected := range map[string]int{
+		tokenTypeOff:     http.StatusOK,
+		tokenTypeWarn:    http.StatusOK,
+		tokenTypeEnforce: http.StatusUnauthorized,
+	} {
+		handler, err := New(context.Background(), next, &Config{KeycloakURL: srv.URL, TokenType: TokenTypeConfig{Policy: policy}}, "AuthMiddleware")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer "+idToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != expected {
+			t.Errorf("policy %q: expected %d, got %d", policy, expected, recorder.Code)
+		}
+	}
+
+	if _, err := newTokenTypeCheck(TokenTypeConfig{Policy: "strict"}); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
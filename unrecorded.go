@@ -0,0 +1,66 @@
+package authztraefikgateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RecordExclusion keeps matching requests out of metrics, latency tracking, recent denials and the
+// audit file, e.g. liveness probes and CORS preflights that would skew decision rates and inflate audit
+// storage. They are still authorized (or exempted) and logged as usual.
+type RecordExclusion struct {
+	Prefix    string   `json:"prefix,omitempty"`    // whole path segments, matched against the path with dot segments resolved, e.g. "/healthz"
+	Methods   []string `json:"methods,omitempty"`   // empty matches all methods; SAFE / MUTATING classes allowed
+	Preflight bool     `json:"preflight,omitempty"` // only CORS preflights (OPTIONS with Access-Control-Request-Method)
+}
+
+// compiledRecordExclusion is a RecordExclusion with its method matcher prepared at load time
+type compiledRecordExclusion struct {
+	prefix    string
+	methods   *compiledRule // method matcher (empty prefix)
+	preflight bool
+}
+
+// compileRecordExclusions validates the record exclusions
+func compileRecordExclusions(exclusions []RecordExclusion) ([]compiledRecordExclusion, error) {
+	compiled := make([]compiledRecordExclusion, 0, len(exclusions))
+	for i, exclusion := range exclusions {
+		if exclusion.Prefix == "" && len(exclusion.Methods) == 0 && !exclusion.Preflight {
+			return nil, fmt.Errorf("excludeFromRecords[%d]: prefix, methods or preflight is required", i)
+		}
+		ce := compiledRecordExclusion{prefix: exclusion.Prefix, methods: &compiledRule{}, preflight: exclusion.Preflight}
+		if len(exclusion.Methods) > 0 {
+			ce.methods.methods = make(map[string]bool, len(exclusion.Methods))
+			for _, method := range exclusion.Methods {
+				ce.methods.methods[strings.ToUpper(method)] = true
+			}
+		}
+		compiled = append(compiled, ce)
+	}
+	return compiled, nil
+}
+
+// matches reports whether the exclusion applies to the request. Only the cleaned path is matched, so
+// "/healthz/../admin" cannot be used to keep a request out of the audit file.
+func (ce compiledRecordExclusion) matches(req *http.Request) bool {
+	if !pathWithinPrefix(cleanPath(req.URL.Path), ce.prefix) || !ce.methods.matches(req) {
+		return false
+	}
+	return !ce.preflight || req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// pathWithinPrefix reports whether path is prefix or below it, so "/healthz" does not cover "/healthzadmin"
+func pathWithinPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// unrecorded reports whether the decision for the request is kept out of metrics and audit
+func (am *AuthMiddleware) unrecorded(req *http.Request) bool {
+	for _, exclusion := range am.recordExclusions {
+		if exclusion.matches(req) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package authztraefikgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExcludeFromRecords(t *testing.T) {
+	srv := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	config := &Config{
+		KeycloakURL: srv.URL,
+		AuditFile:   auditFile,
+		Rules:       []Rule{{Prefix: "/healthz", Resolver: "static", Resource: "health"}},
+		ExcludeFromRecords: []RecordExclusion{
+			{Prefix: "/healthz", Methods: []string{"SAFE"}},
+			{Preflight: true},
+		},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), config, "AuthMiddleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, path string, preflight bool) int {
+		req := httptest.NewRequest(method, "http://gateway"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if code := send(http.MethodGet, "/healthz", false); code != http.StatusOK {
+		t.Fatalf("expected excluded requests to still be authorized, got %d", code)
+	}
+	send(http.MethodOptions, "/api/v1/user/get", true)
+	send(http.MethodGet, "/healthz/../api/v1/user/get", false) // recorded: the cleaned path is not excluded
+	send(http.MethodPost, "/healthz", false)                   // recorded: not a safe method
+	send(http.MethodOptions, "/api/v1/user/get", false)        // recorded: not a preflight
+	send(http.MethodGet, "/healthzadmin/user/get", false)      // recorded: not below the prefix
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("expected 4 audit records, got %d:\n%s", lines, data)
+	}
+	var metrics strings.Builder
+	if err := handler.(*AuthMiddleware).WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if sample := `authz_decisions_total{reason="granted"} 4`; !strings.Contains(metrics.String(), sample) {
+		t.Errorf("expected %q in metrics:\n%s", sample, metrics.String())
+	}
+
+	if _, err := compileRecordExclusions([]RecordExclusion{{}}); err == nil {
+		t.Error("expected an error for an exclusion without matcher")
+	}
+}
@@ -0,0 +1,379 @@
+package authztraefikgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// validate checks the cross-field rules of a config and returns every problem found
+func (c *Config) validate() []error {
+	var errs []error
+
+	for _, m := range c.StatusMappings {
+		if m.Status < 100 || m.Status > 599 {
+			errs = append(errs, fmt.Errorf("invalid status %d in statusMappings", m.Status))
+		}
+	}
+
+	switch c.ResponseMode {
+	case responseModeRPT, responseModeDecision, responseModePermissions:
+	default:
+		errs = append(errs, fmt.Errorf("invalid responseMode %q", c.ResponseMode))
+	}
+	if c.IncludeResourceName && c.ResponseMode == responseModeDecision {
+		errs = append(errs, fmt.Errorf("includeResourceName cannot be used with responseMode %q", responseModeDecision))
+	}
+
+	if c.TokenExchange.Enabled && c.KeycloakClientSecret == "" {
+		errs = append(errs, fmt.Errorf("tokenExchange requires keycloakClientSecret"))
+	}
+	if c.ForwardAuth.Enabled && c.KeycloakClientSecret == "" {
+		errs = append(errs, fmt.Errorf("forwardAuth requires keycloakClientSecret"))
+	}
+	if _, err := newForwardAuth(c.ForwardAuth); err != nil {
+		errs = append(errs, fmt.Errorf("forwardAuth: %w", err))
+	}
+	if c.UMATicketMode && c.KeycloakClientSecret == "" {
+		errs = append(errs, fmt.Errorf("umaTicketMode requires keycloakClientSecret"))
+	}
+	for _, rule := range c.Rules {
+		if (strings.EqualFold(rule.Resolver, resolverURI) || rule.ResourceType != "") && c.KeycloakClientSecret == "" {
+			errs = append(errs, fmt.Errorf("the %s resolver requires keycloakClientSecret", resolverURI))
+			break
+		}
+	}
+	for _, rule := range c.Rules {
+		if rule.Bulk.Field != "" && strings.EqualFold(c.AuthzBackend, authzBackendStatic) {
+			errs = append(errs, fmt.Errorf("bulk rules require the Keycloak backend"))
+			break
+		}
+	}
+	for _, rule := range c.Rules {
+		// Several accepted types require several scopes at once, which static grants never match
+		if len(rule.AcceptScopes) > 0 && strings.EqualFold(c.AuthzBackend, authzBackendStatic) {
+			errs = append(errs, fmt.Errorf("acceptScopes rules require the Keycloak backend"))
+			break
+		}
+	}
+	if _, err := parseDurationOrDefault(c.ResourceCacheTTL, defaultResourceCacheTTL); err != nil {
+		errs = append(errs, fmt.Errorf("resourceCacheTTL: %w", err))
+	}
+	if _, err := parseDurationOrDefault(c.TicketCacheTTL, defaultTicketCacheTTL); err != nil {
+		errs = append(errs, fmt.Errorf("ticketCacheTTL: %w", err))
+	}
+	if _, err := parseCIDRs(c.RequestTimeoutTrustedIPs); err != nil {
+		errs = append(errs, fmt.Errorf("requestTimeoutTrustedIPs: %w", err))
+	}
+	if _, err := parseCIDRs(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trustedProxies: %w", err))
+	}
+	if _, err := compileRules(c, 3, 4); err != nil {
+		errs = append(errs, fmt.Errorf("rules: %w", err))
+	}
+	if _, err := newTokenExtractors(c.TokenSources); err != nil {
+		errs = append(errs, fmt.Errorf("tokenSources: %w", err))
+	}
+
+	if _, err := newCoalescer(c.Coalescing); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newDecisionCache(c.Cache); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := compileEntryPoints(c.EntryPointHeader, c.EntryPoints); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newBreakGlass(c.BreakGlass); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newPathLimits(c.MaxPathLength, c.MaxPathSegments); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newEndpointTransports(c.VerifyTLS, c.TLSEndpoints); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newSubjectHasher(c.SubjectHash); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newSecurityHeaders(c.SecurityHeaders); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newDenialMirror(c.DenialMirror, nil, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newPseudonymizer(c.Pseudonym); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newKeycloakAddress(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newEnricher(c.Enrichment, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newRequestFlagsCheck(c.RequestFlags); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newStaticPolicy(c.AuthzBackend, c.StaticPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newLatencyTracker(c.Latency); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newBodyBuffer(c.BodyBuffer); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newTokenTypeCheck(c.TokenType); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := compileDenyRules(c.DenyRules, c.EntryPointHeader); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := compileBypasses(c.Bypass); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newRetrier(c.Retry); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := compileFastPaths(c.FastPaths); err != nil {
+		errs = append(errs, fmt.Errorf("fastPaths: %w", err))
+	}
+	if _, err := compileRecordExclusions(c.ExcludeFromRecords); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Admin.Path != "" && c.Admin.Token == "" {
+		errs = append(errs, fmt.Errorf("admin.path requires admin.token"))
+	}
+	if c.Admin.Path != "" && !strings.HasPrefix(c.Admin.Path, "/") {
+		errs = append(errs, fmt.Errorf("admin.path must start with \"/\""))
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range profileNames(c.Profiles) {
+		if _, err := parseLogLevel(c.Profiles[name].LogLevel); err != nil {
+			errs = append(errs, fmt.Errorf("profiles.%s: %w", name, err))
+		}
+	}
+	if c.Environment != "" {
+		if _, ok := c.Profiles[c.Environment]; !ok {
+			errs = append(errs, fmt.Errorf("environment %q has no profile", c.Environment))
+		}
+	}
+
+	return errs
+}
+
+// ConfigSchema returns a JSON Schema (draft 2020-12) describing Config, generated from its json tags
+func ConfigSchema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "authztraefikgateway plugin configuration"
+	return schema
+}
+
+// schemaFor builds the schema of a single Go type
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+			properties[name] = schemaFor(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
+
+// jsonFieldName returns the JSON name of an exported struct field, or "" if it is not serialized
+func jsonFieldName(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// ValidateConfig checks a decoded configuration document (e.g. parsed from YAML or JSON) against
+// ConfigSchema and the cross-field rules enforced by New. It returns every problem found.
+func ValidateConfig(doc map[string]interface{}) []error {
+	errs := validateValue("", doc, ConfigSchema())
+	if len(errs) > 0 {
+		return errs
+	}
+
+	config, err := decodeConfig(doc)
+	if err != nil {
+		return []error{err}
+	}
+	config, _, err = config.withStructuredKeys()
+	if err != nil {
+		return []error{err}
+	}
+	return config.validate()
+}
+
+// decodeConfig converts a decoded configuration document to a Config with the defaults of CreateConfig
+func decodeConfig(doc map[string]interface{}) (*Config, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	config := CreateConfig()
+	if err := json.Unmarshal(raw, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validateValue checks value against schema; path is used in error messages
+func validateValue(path string, value interface{}, schema map[string]interface{}) []error {
+	where := path
+	if where == "" {
+		where = "config"
+	}
+
+	switch schema["type"] {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []error{fmt.Errorf("%s: expected a string, got %T", where, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []error{fmt.Errorf("%s: expected a boolean, got %T", where, value)}
+		}
+	case "integer":
+		switch v := value.(type) {
+		case int, int64:
+		case float64:
+			if v != float64(int64(v)) {
+				return []error{fmt.Errorf("%s: expected an integer, got %v", where, v)}
+			}
+		default:
+			return []error{fmt.Errorf("%s: expected an integer, got %T", where, value)}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []error{fmt.Errorf("%s: expected a list, got %T", where, value)}
+		}
+		var errs []error
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			errs = append(errs, validateValue(fmt.Sprintf("%s[%d]", path, i), item, itemSchema)...)
+		}
+		return errs
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []error{fmt.Errorf("%s: expected a mapping, got %T", where, value)}
+		}
+		return validateObject(path, obj, schema)
+	}
+	return nil
+}
+
+// validateObject checks the keys of a mapping, reporting unknown keys with a suggestion when possible
+func validateObject(path string, obj map[string]interface{}, schema map[string]interface{}) []error {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, _ := schema["additionalProperties"].(map[string]interface{})
+
+	var errs []error
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			errs = append(errs, validateValue(keyPath, obj[key], propSchema)...)
+			continue
+		}
+		if additional != nil {
+			errs = append(errs, validateValue(keyPath, obj[key], additional)...)
+			continue
+		}
+		if suggestion := suggestKey(key, properties); suggestion != "" {
+			errs = append(errs, fmt.Errorf("%s: unknown key (did you mean %q?)", keyPath, suggestion))
+		} else {
+			errs = append(errs, fmt.Errorf("%s: unknown key", keyPath))
+		}
+	}
+	return errs
+}
+
+// suggestKey returns the known key closest to an unknown one (case-insensitive match or edit distance <= 2)
+func suggestKey(key string, properties map[string]interface{}) string {
+	best, bestDistance := "", 3
+	for candidate := range properties {
+		if strings.EqualFold(candidate, key) {
+			return candidate
+		}
+		if d := editDistance(strings.ToLower(key), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package authztraefikgateway
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	properties := schema["properties"].(map[string]interface{})
+	for _, key := range []string{"keycloakURL", "staticPermissions", "statusMappings", "rules", "audienceByHost"} {
+		if _, ok := properties[key]; !ok {
+			t.Errorf("schema is missing property %q", key)
+		}
+	}
+
+	rules := properties["rules"].(map[string]interface{})
+	items := rules["items"].(map[string]interface{})
+	if items["type"] != "object" || items["additionalProperties"] != false {
+		t.Errorf("expected rules items to be closed objects, got %v", items)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := map[string]interface{}{
+		"keycloakURL":      "http://keycloak/realms/demo/protocol/openid-connect/token",
+		"keycloakClientId": "gateway",
+		"resourceIndex":    int64(3),
+		"staticPermissions": []interface{}{
+			map[string]interface{}{"prefix": "/health", "resource": "system", "scope": "read"},
+		},
+		"audienceByHost": map[string]interface{}{"api.foo.com": "foo-api"},
+	}
+	if errs := ValidateConfig(valid); len(errs) != 0 {
+		t.Errorf("expected valid config, got %v", errs)
+	}
+
+	tests := []struct {
+		name     string
+		doc      map[string]interface{}
+		expected string
+	}{
+		{"typo", map[string]interface{}{"keycloakUrl": "x"}, `keycloakUrl: unknown key (did you mean "keycloakURL"?)`},
+		{"nested typo", map[string]interface{}{"rules": []interface{}{map[string]interface{}{"prefx": "/a"}}}, `rules[0].prefx: unknown key (did you mean "prefix"?)`},
+		{"wrong type", map[string]interface{}{"scopeIndex": "four"}, "scopeIndex: expected an integer"},
+		{"cross field", map[string]interface{}{"umaTicketMode": true}, "umaTicketMode requires keycloakClientSecret"},
+		{"invalid rule", map[string]interface{}{"rules": []interface{}{map[string]interface{}{"prefix": "/a", "resolver": "nope"}}}, `unknown resolver "nope"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateConfig(test.doc)
+			if len(errs) == 0 || !strings.Contains(errs[0].Error(), test.expected) {
+				t.Errorf("expected error containing %q, got %v", test.expected, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+//go:build yaegi
+// +build yaegi
+
+package authztraefikgateway
+
+// Loads the plugin with the Yaegi interpreter the way Traefik does. Run with:
+//
+//	go install github.com/traefik/yaegi/cmd/yaegi@latest
+//	go test -tags yaegi -run Yaegi -v .
+//
+// The yaegi CLI only exposes the standard library, like Traefik, so any import or language feature
+// it cannot interpret fails here instead of at plugin load time.
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yaegiMain = `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/momayyez/authztraefikgateway"
+)
+
+func main() {
+	config := authztraefikgateway.CreateConfig()
+	config.KeycloakURL = os.Args[1]
+	config.KeycloakClientId = "gateway"
+	config.Cache = authztraefikgateway.CacheConfig{Enabled: true, TTL: "1m"}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := authztraefikgateway.New(context.Background(), next, config, "yaegi")
+	if err != nil {
+		fmt.Println("ERROR", err)
+		os.Exit(1)
+	}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api/v1/user/get", nil)
+		req.Header.Set("Authorization", "Bearer " + os.Args[2])
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		fmt.Println("STATUS", recorder.Code)
+	}
+}
+`
+
+func TestYaegiLoad(t *testing.T) {
+	yaegi, err := exec.LookPath("yaegi")
+	if err != nil {
+		t.Skip("yaegi is not installed")
+	}
+	source, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Traefik resolves plugins from a GOPATH layout
+	gopath := t.TempDir()
+	pkg := filepath.Join(gopath, "src", "github.com", "momayyez", "authztraefikgateway")
+	if err := os.MkdirAll(filepath.Dir(pkg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(source, pkg); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(gopath, "main.go")
+	if err := os.WriteFile(main, []byte(yaegiMain), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keycloak := newKeycloakStub(t, http.StatusOK, `{"result":true}`)
+	cmd := exec.Command(yaegi, "run", main, keycloak.URL, token)
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("yaegi could not run the plugin: %v\n%s", err, out)
+	}
+	if n := strings.Count(string(out), "STATUS 200"); n != 2 {
+		t.Errorf("expected 2 allowed requests, got output:\n%s", out)
+	}
+}
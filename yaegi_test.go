@@ -0,0 +1,48 @@
+package authztraefikgateway
+
+import (
+	"go/parser"
+	gotoken "go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// yaegiUnsupported lists standard packages Yaegi (as embedded in Traefik) cannot interpret
+var yaegiUnsupported = map[string]bool{
+	"C":           true,
+	"unsafe":      true,
+	"syscall":     true,
+	"plugin":      true,
+	"runtime/cgo": true,
+}
+
+// TestYaegiImports guards plugin loading in Traefik: the plugin sources may only import standard
+// packages Yaegi exports, since Traefik interprets them without vendoring or cgo. The full
+// interpreter run lives in yaegi_interp_test.go behind the yaegi build tag.
+func TestYaegiImports(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := gotoken.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+				t.Errorf("%s imports %s: the plugin may only use the standard library", name, path)
+			}
+			if yaegiUnsupported[path] {
+				t.Errorf("%s imports %s, which Yaegi cannot interpret", name, path)
+			}
+		}
+	}
+}